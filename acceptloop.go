@@ -0,0 +1,107 @@
+package pt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"time"
+)
+
+// Bounds on how long AcceptLoop waits after a temporary error from Accept.
+// The wait starts at the minimum and doubles with each consecutive error.
+const (
+	acceptBackoffMin = 5 * time.Millisecond
+	acceptBackoffMax = 1 * time.Second
+)
+
+// Accept connections from ln and call handler for each one in its own
+// goroutine, until ln is closed. The connection is closed when handler
+// returns, if handler has not already closed it.
+//
+//	ln, err := pt.ListenSocks("tcp", "127.0.0.1:0")
+//	if err != nil {
+//		pt.CmethodError(methodName, err.Error())
+//		continue
+//	}
+//	go pt.AcceptLoop(ln, 0, func(conn net.Conn) {
+//		handleSocks(conn.(*pt.SocksConn))
+//	})
+//
+// If Accept returns a temporary error, AcceptLoop waits before trying again,
+// starting at 5 ms and doubling up to 1 s while errors continue. Running out of
+// file descriptors is treated as a temporary error, and also emits a LOG
+// warning, at most every 10 s; see also ReserveFD. A panic in handler is
+// recovered and logged, with its stack, in LOG SEVERITY=error lines, and closes
+// the connection, without affecting other connections. (Panics in the other
+// goroutines that the package starts, such as those relaying data, are handled
+// the same way.) If maxHandlers is greater than zero, at most
+// that many handlers run at once; AcceptLoop does not accept another
+// connection until one of them returns.
+//
+// Returns nil when ln is closed, or the first non-temporary error from Accept.
+// ln is closed in either case.
+func AcceptLoop(ln net.Listener, maxHandlers int, handler func(net.Conn)) error {
+	defer ln.Close()
+	startReserveFD()
+	var sem chan struct{}
+	if maxHandlers > 0 {
+		sem = make(chan struct{}, maxHandlers)
+	}
+	for {
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		conn, err := acceptRetry(ln)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			runHandler(conn, handler)
+		}()
+	}
+}
+
+// Accept a connection from ln, waiting and trying again after temporary
+// errors, as described for AcceptLoop.
+func acceptRetry(ln net.Listener) (net.Conn, error) {
+	var backoff time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err == nil {
+			return conn, nil
+		}
+		exhausted := checkFDExhausted(err, "accept")
+		if exhausted {
+			shedWithReserveFD(ln)
+		}
+		if e, ok := err.(net.Error); exhausted || ok && e.Temporary() && !errors.Is(err, net.ErrClosed) {
+			if backoff == 0 {
+				backoff = acceptBackoffMin
+			} else if backoff *= 2; backoff > acceptBackoffMax {
+				backoff = acceptBackoffMax
+			}
+			time.Sleep(backoff)
+			continue
+		}
+		return nil, err
+	}
+}
+
+// Call handler with conn, recovering and logging a panic, and close conn
+// afterward.
+func runHandler(conn net.Conn, handler func(net.Conn)) {
+	defer conn.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(fmt.Sprintf("connection handler for %s", SafeAddr(conn.RemoteAddr())), r, debug.Stack())
+		}
+	}()
+	handler(conn)
+}
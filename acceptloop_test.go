@@ -0,0 +1,191 @@
+package pt
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// scriptListener is a net.Listener whose Accept returns the results in script,
+// in order, and then blocks until Close is called, after which it returns an
+// error wrapping net.ErrClosed.
+type scriptListener struct {
+	mu     sync.Mutex
+	script []acceptResult
+	closed chan struct{}
+	once   sync.Once
+}
+
+type acceptResult struct {
+	c   net.Conn
+	err error
+}
+
+func newScriptListener(script ...acceptResult) *scriptListener {
+	return &scriptListener{script: script, closed: make(chan struct{})}
+}
+
+func (ln *scriptListener) Accept() (net.Conn, error) {
+	ln.mu.Lock()
+	if len(ln.script) > 0 {
+		r := ln.script[0]
+		ln.script = ln.script[1:]
+		ln.mu.Unlock()
+		return r.c, r.err
+	}
+	ln.mu.Unlock()
+	<-ln.closed
+	return nil, &net.OpError{Op: "accept", Net: "tcp", Err: net.ErrClosed}
+}
+
+func (ln *scriptListener) Close() error {
+	ln.once.Do(func() { close(ln.closed) })
+	return nil
+}
+
+func (ln *scriptListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0}
+}
+
+// A net.Conn that records whether it has been closed.
+type closeRecorderConn struct {
+	net.Conn
+	closed int32
+}
+
+func (c *closeRecorderConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *closeRecorderConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+}
+
+func TestAcceptLoopErrors(t *testing.T) {
+	// Temporary errors are retried; a closed listener returns nil.
+	c := &closeRecorderConn{}
+	ln := newScriptListener(
+		acceptResult{nil, &netError{"temp", true}},
+		acceptResult{nil, &netError{"temp", true}},
+		acceptResult{c, nil},
+	)
+	handled := make(chan net.Conn)
+	done := make(chan error)
+	go func() {
+		done <- AcceptLoop(ln, 0, func(conn net.Conn) {
+			handled <- conn
+		})
+	}()
+	if conn := <-handled; conn != c {
+		t.Errorf("handler got %v (expected %v)", conn, c)
+	}
+	ln.Close()
+	if err := <-done; err != nil {
+		t.Errorf("AcceptLoop returned %v after Close", err)
+	}
+	// The handler's goroutine closes the connection after the handler
+	// returns, which may be after AcceptLoop returns.
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&c.closed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("connection not closed after handler returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A non-temporary error is returned.
+	ln = newScriptListener(acceptResult{nil, io.ErrUnexpectedEOF})
+	err := AcceptLoop(ln, 0, func(conn net.Conn) {})
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("AcceptLoop returned %v (expected %v)", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestAcceptLoopPanic(t *testing.T) {
+	var buf bytes.Buffer
+	saved := Stdout
+	Stdout = &buf
+	defer func() { Stdout = saved }()
+
+	c1, c2 := &closeRecorderConn{}, &closeRecorderConn{}
+	ln := newScriptListener(acceptResult{c1, nil}, acceptResult{c2, nil})
+	handled := make(chan net.Conn, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go AcceptLoop(ln, 1, func(conn net.Conn) {
+		defer wg.Done()
+		handled <- conn
+		if conn == c1 {
+			panic("oops")
+		}
+	})
+	wg.Wait()
+	ln.Close()
+	// The panic did not stop the second connection from being handled.
+	if conn := <-handled; conn != c1 {
+		t.Errorf("first handler got %v", conn)
+	}
+	if conn := <-handled; conn != c2 {
+		t.Errorf("second handler got %v", conn)
+	}
+	// Wait for the deferred closes, which run after wg.Done and after the
+	// panic is logged.
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&c1.closed) == 0 || atomic.LoadInt32(&c2.closed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("connections not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.Contains(buf.String(), `LOG SEVERITY=error MESSAGE="panic in connection handler for [scrubbed]: oops"`) {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestAcceptLoopMaxHandlers(t *testing.T) {
+	var script []acceptResult
+	for i := 0; i < 10; i++ {
+		script = append(script, acceptResult{&closeRecorderConn{}, nil})
+	}
+	ln := newScriptListener(script...)
+	defer ln.Close()
+
+	const maxHandlers = 3
+	var active, maxActive int32
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	go AcceptLoop(ln, maxHandlers, func(conn net.Conn) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&active, -1)
+	})
+
+	for i := 0; i < maxHandlers; i++ {
+		<-started
+	}
+	select {
+	case <-started:
+		t.Fatalf("more than %d handlers started", maxHandlers)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	for i := maxHandlers; i < 10; i++ {
+		<-started
+	}
+	if m := atomic.LoadInt32(&maxActive); m > maxHandlers {
+		t.Errorf("%d handlers ran at once (max %d)", m, maxHandlers)
+	}
+}
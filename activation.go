@@ -0,0 +1,186 @@
+package pt
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Support for systemd socket activation (sd_listen_fds(3)). A service manager
+// may open a transport's listening sockets itself, for example to bind port 443
+// without giving the transport root privileges, and pass them to the transport
+// as file descriptors starting at 3, described by the environment variables
+// LISTEN_PID, LISTEN_FDS, and LISTEN_FDNAMES. Name each socket after the method
+// it is for, with FileDescriptorName= in the socket unit:
+//
+//	[Socket]
+//	ListenStream=443
+//	FileDescriptorName=obfs4
+//
+// Bindaddr.Listen, and so RunServer, uses a passed socket whose name is the
+// bindaddr's method name in place of opening a new one, regardless of the
+// bindaddr's address.
+
+// The first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+var activation struct {
+	sync.Mutex
+	loaded    bool
+	listeners map[string][]net.Listener
+	err       error
+}
+
+// Return the listening sockets passed by socket activation, keyed by their
+// names from LISTEN_FDNAMES, or "unknown" for sockets without a name, as
+// systemd does. Returns an empty map if there are none. The environment is
+// read on the first call, and the variables are then unset so that they are
+// not inherited by child processes. Sockets that have been taken by
+// Bindaddr.Listen are not included.
+func ActivationListeners() (map[string][]net.Listener, error) {
+	activation.Lock()
+	defer activation.Unlock()
+	loadActivationListeners()
+	result := make(map[string][]net.Listener)
+	for name, lns := range activation.listeners {
+		result[name] = append([]net.Listener(nil), lns...)
+	}
+	return result, activation.err
+}
+
+// Remove and return a socket-activated listener named name, or nil if there is
+// none. Problems with the passed sockets are not returned, but are logged when
+// the environment is first read.
+func takeActivationListener(name string) net.Listener {
+	activation.Lock()
+	defer activation.Unlock()
+	loadActivationListeners()
+	lns := activation.listeners[name]
+	if len(lns) == 0 {
+		return nil
+	}
+	activation.listeners[name] = lns[1:]
+	return lns[0]
+}
+
+// Like takeActivationListener, but return an error if the listener is not a
+// *net.TCPListener.
+func takeActivationTCPListener(name string) (*net.TCPListener, error) {
+	aln := takeActivationListener(name)
+	if aln == nil {
+		return nil, nil
+	}
+	ln, ok := aln.(*net.TCPListener)
+	if !ok {
+		aln.Close()
+		return nil, fmt.Errorf("socket passed for %s is not a TCP listener", name)
+	}
+	return ln, nil
+}
+
+// Read the socket activation environment, once, and log a warning if there is a
+// problem. activation must be locked.
+func loadActivationListeners() {
+	if activation.loaded {
+		return
+	}
+	readActivationListeners()
+	if activation.err != nil {
+		Log(LogSeverityWarning, activation.err.Error())
+	}
+}
+
+// Do the work of loadActivationListeners.
+func readActivationListeners() {
+	activation.loaded = true
+	activation.listeners = make(map[string][]net.Listener)
+	n, names, err := parseListenFDs(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), os.Getpid())
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err == nil && n == 0 {
+		// Not socket-activated; perhaps started by Restart.
+		n, names = parseInheritedFDs(os.Getenv(inheritedFDsEnv))
+	}
+	os.Unsetenv(inheritedFDsEnv)
+	if err != nil {
+		activation.err = err
+		return
+	}
+	files := make([]*os.File, n)
+	for i := range files {
+		fd := listenFDsStart + i
+		files[i] = os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+	}
+	activation.listeners, activation.err = activationListeners(files, names)
+}
+
+// Parse the socket activation environment variables. Returns the number of
+// passed file descriptors and their names. Returns 0 if the variables are unset
+// or are meant for a different process than pid.
+func parseListenFDs(listenPID, listenFDs, listenFDNames string, pid int) (int, []string, error) {
+	if listenPID == "" || listenFDs == "" {
+		return 0, nil, nil
+	}
+	p, err := strconv.Atoi(listenPID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot parse LISTEN_PID %q: %s", listenPID, err.Error())
+	}
+	if p != pid {
+		return 0, nil, nil
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 0 {
+		return 0, nil, fmt.Errorf("cannot parse LISTEN_FDS %q", listenFDs)
+	}
+	names := make([]string, n)
+	var given []string
+	if listenFDNames != "" {
+		given = strings.Split(listenFDNames, ":")
+	}
+	for i := range names {
+		if i < len(given) && given[i] != "" {
+			names[i] = given[i]
+		} else {
+			names[i] = "unknown"
+		}
+	}
+	return n, names, nil
+}
+
+// Make a listener from each of files, which are closed, and group them by the
+// corresponding names. The first error is returned, along with the listeners
+// that could be made.
+func activationListeners(files []*os.File, names []string) (map[string][]net.Listener, error) {
+	listeners := make(map[string][]net.Listener)
+	var firstErr error
+	for i, f := range files {
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("socket activation file descriptor %s: %s", f.Name(), err.Error())
+			}
+			continue
+		}
+		listeners[names[i]] = append(listeners[names[i]], ln)
+	}
+	return listeners, firstErr
+}
+
+// The environment variable in which Restart passes the names of the listeners
+// it passes, separated by ':', in the order of their file descriptors.
+const inheritedFDsEnv = "PT_INHERITED_FDNAMES"
+
+// Parse the value of inheritedFDsEnv. Returns the number of file descriptors
+// and their names.
+func parseInheritedFDs(s string) (int, []string) {
+	if s == "" {
+		return 0, nil
+	}
+	names := strings.Split(s, ":")
+	return len(names), names
+}
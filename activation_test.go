@@ -0,0 +1,103 @@
+package pt
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestParseListenFDs(t *testing.T) {
+	tests := []struct {
+		pid, fds, names string
+		n               int
+		expected        []string
+	}{
+		{"", "", "", 0, nil},
+		{"100", "2", "", 0, nil},
+		{"1234", "0", "", 0, []string{}},
+		{"1234", "2", "", 2, []string{"unknown", "unknown"}},
+		{"1234", "3", "obfs4::meek", 3, []string{"obfs4", "unknown", "meek"}},
+		{"1234", "1", "obfs4:extra", 1, []string{"obfs4"}},
+	}
+	for _, test := range tests {
+		n, names, err := parseListenFDs(test.pid, test.fds, test.names, 1234)
+		if err != nil {
+			t.Errorf("%q %q %q: unexpected error %v", test.pid, test.fds, test.names, err)
+			continue
+		}
+		if n != test.n || !stringSlicesEqual(names, test.expected) {
+			t.Errorf("%q %q %q → %d %q (expected %d %q)", test.pid, test.fds, test.names, n, names, test.n, test.expected)
+		}
+	}
+
+	for _, test := range [][2]string{{"x", "1"}, {"1234", "x"}, {"1234", "-1"}} {
+		_, _, err := parseListenFDs(test[0], test[1], "", 1234)
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", test)
+		}
+	}
+}
+
+// Replace the socket activation state with listeners, as if they had been
+// passed by the service manager, and return a function that restores it.
+func setActivationListeners(listeners map[string][]net.Listener) func() {
+	activation.Lock()
+	defer activation.Unlock()
+	savedLoaded, savedListeners, savedErr := activation.loaded, activation.listeners, activation.err
+	activation.loaded = true
+	activation.listeners = listeners
+	activation.err = nil
+	return func() {
+		activation.Lock()
+		defer activation.Unlock()
+		activation.loaded, activation.listeners, activation.err = savedLoaded, savedListeners, savedErr
+	}
+}
+
+func TestActivationListeners(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket activation is not supported on Windows")
+	}
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notSocket, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := activationListeners([]*os.File{f, notSocket}, []string{"foo", "bar"})
+	if err == nil {
+		t.Error("non-socket file descriptor unexpectedly succeeded")
+	}
+	if len(listeners["foo"]) != 1 || len(listeners["bar"]) != 0 {
+		t.Fatalf("unexpected listeners %v", listeners)
+	}
+	aln := listeners["foo"][0]
+	defer aln.Close()
+	if aln.Addr().String() != ln.Addr().String() {
+		t.Errorf("listener address %v, expected %v", aln.Addr(), ln.Addr())
+	}
+
+	// Bindaddr.Listen uses the passed listener for its method, once.
+	defer setActivationListeners(listeners)()
+	bindaddr := Bindaddr{MethodName: "foo", Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1}}
+	got, err := bindaddr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != aln {
+		t.Errorf("got listener on %v, expected the activated one on %v", got.Addr(), aln.Addr())
+	}
+	remaining, _ := ActivationListeners()
+	if len(remaining["foo"]) != 0 {
+		t.Errorf("listener not taken: %v", remaining)
+	}
+}
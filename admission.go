@@ -0,0 +1,188 @@
+package pt
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdmissionControl limits the connections accepted by server listeners, to
+// protect a bridge against connection floods. Connections over a limit are
+// closed as soon as they are accepted, before any data is read from them. Any
+// limit that is zero is not enforced.
+//
+// Wrap applies an AdmissionControl to a listener. Listeners opened by
+// RunServer, RunServerStandalone, and ServeTransports are wrapped with
+// DefaultAdmissionControl, if it is not nil:
+//
+//	pt.DefaultAdmissionControl = &pt.AdmissionControl{
+//		MaxConns:      1000,
+//		MaxConnsPerIP: 20,
+//		Rate:          50,
+//		Burst:         100,
+//	}
+//
+// The limits are shared by all listeners wrapped by the same AdmissionControl.
+// The fields must not be changed after the first call to Wrap.
+type AdmissionControl struct {
+	// Used atomically; first so that it is 64-bit aligned on 32-bit
+	// platforms.
+	rejected uint64
+
+	// The maximum number of connections open at once.
+	MaxConns int
+	// The maximum number of connections open at once from a single source
+	// IP address.
+	MaxConnsPerIP int
+	// The long-term maximum number of connections accepted per second, and
+	// the number that may be accepted in a burst above that rate. Burst is
+	// treated as 1 if Rate is set and Burst is less than 1.
+	Rate  float64
+	Burst int
+
+	mu    sync.Mutex
+	conns int
+	perIP map[string]int
+	// Token bucket state for Rate and Burst.
+	tokens   float64
+	lastFill time.Time
+
+	// Replaceable for testing.
+	now func() time.Time
+}
+
+// If DefaultAdmissionControl is not nil, listeners opened by RunServer,
+// RunServerStandalone, and ServeTransports are wrapped with it.
+var DefaultAdmissionControl *AdmissionControl
+
+// Return a listener whose Accept returns only those connections from ln that are
+// within ac's limits. Closing an accepted connection frees its place in the
+// limits. If ac is nil, ln is returned unchanged.
+func (ac *AdmissionControl) Wrap(ln net.Listener) net.Listener {
+	if ac == nil {
+		return ln
+	}
+	return &admissionListener{Listener: ln, ac: ac}
+}
+
+// Return the number of connections that have been closed for being over a
+// limit.
+func (ac *AdmissionControl) Rejected() uint64 {
+	return atomic.LoadUint64(&ac.rejected)
+}
+
+// Try to admit a connection from ip. Returns true, having counted the
+// connection, if it is within the limits.
+func (ac *AdmissionControl) admit(ip string) bool {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.MaxConns > 0 && ac.conns >= ac.MaxConns {
+		return false
+	}
+	if ac.MaxConnsPerIP > 0 && ac.perIP[ip] >= ac.MaxConnsPerIP {
+		return false
+	}
+	if ac.Rate > 0 && !ac.takeToken() {
+		return false
+	}
+	ac.conns++
+	if ac.perIP == nil {
+		ac.perIP = make(map[string]int)
+	}
+	ac.perIP[ip]++
+	return true
+}
+
+// Refill the token bucket for the time elapsed since the last refill, and take
+// a token if one is available. ac.mu must be held.
+func (ac *AdmissionControl) takeToken() bool {
+	now := time.Now
+	if ac.now != nil {
+		now = ac.now
+	}
+	t := now()
+	burst := float64(ac.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if ac.lastFill.IsZero() {
+		ac.tokens = burst
+	} else {
+		ac.tokens += t.Sub(ac.lastFill).Seconds() * ac.Rate
+		if ac.tokens > burst {
+			ac.tokens = burst
+		}
+	}
+	ac.lastFill = t
+	if ac.tokens < 1 {
+		return false
+	}
+	ac.tokens--
+	return true
+}
+
+func (ac *AdmissionControl) release(ip string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.conns--
+	ac.perIP[ip]--
+	if ac.perIP[ip] == 0 {
+		delete(ac.perIP, ip)
+	}
+}
+
+// Return the IP address part of addr, or the whole of addr.String() if it has
+// none.
+func addrIP(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+type admissionListener struct {
+	net.Listener
+	ac *AdmissionControl
+}
+
+func (ln *admissionListener) Accept() (net.Conn, error) {
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := addrIP(c.RemoteAddr())
+		if ln.ac.admit(ip) {
+			return &admissionConn{Conn: c, ac: ln.ac, ip: ip}, nil
+		}
+		atomic.AddUint64(&ln.ac.rejected, 1)
+		c.Close()
+	}
+}
+
+type admissionConn struct {
+	net.Conn
+	ac   *AdmissionControl
+	ip   string
+	once sync.Once
+}
+
+func (c *admissionConn) Close() error {
+	c.once.Do(func() {
+		c.ac.release(c.ip)
+	})
+	return c.Conn.Close()
+}
+
+// Half-close the underlying conn, if it supports it.
+func (c *admissionConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
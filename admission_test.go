@@ -0,0 +1,124 @@
+package pt
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A closeRecorderConn with a configurable remote address.
+type remoteAddrConn struct {
+	closeRecorderConn
+	addr net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func newRemoteAddrConn(ip string) *remoteAddrConn {
+	return &remoteAddrConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+}
+
+func TestAdmissionControlLimits(t *testing.T) {
+	ac := &AdmissionControl{MaxConns: 3, MaxConnsPerIP: 2}
+	if !ac.admit("192.0.2.1") || !ac.admit("192.0.2.1") {
+		t.Fatal("first two connections from one IP not admitted")
+	}
+	if ac.admit("192.0.2.1") {
+		t.Error("third connection from one IP admitted")
+	}
+	if !ac.admit("192.0.2.2") {
+		t.Error("connection from another IP not admitted")
+	}
+	if ac.admit("192.0.2.3") {
+		t.Error("connection over MaxConns admitted")
+	}
+	ac.release("192.0.2.1")
+	if !ac.admit("192.0.2.1") {
+		t.Error("connection not admitted after release")
+	}
+	ac.release("192.0.2.1")
+	ac.release("192.0.2.1")
+	ac.release("192.0.2.2")
+	if len(ac.perIP) != 0 || ac.conns != 0 {
+		t.Errorf("counts not zero after releasing everything: %d %v", ac.conns, ac.perIP)
+	}
+}
+
+func TestAdmissionControlRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ac := &AdmissionControl{Rate: 2, Burst: 3}
+	ac.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		if !ac.admit("192.0.2.1") {
+			t.Fatalf("connection %d of burst not admitted", i)
+		}
+	}
+	if ac.admit("192.0.2.1") {
+		t.Error("connection beyond burst admitted")
+	}
+	// At 2 per second, a token is available after 500 ms.
+	now = now.Add(400 * time.Millisecond)
+	if ac.admit("192.0.2.1") {
+		t.Error("connection admitted before a token was available")
+	}
+	now = now.Add(100 * time.Millisecond)
+	if !ac.admit("192.0.2.1") {
+		t.Error("connection not admitted after refill")
+	}
+	// The bucket does not fill beyond Burst.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !ac.admit("192.0.2.1") {
+			t.Fatalf("connection %d after long idle not admitted", i)
+		}
+	}
+	if ac.admit("192.0.2.1") {
+		t.Error("connection beyond burst after long idle admitted")
+	}
+}
+
+func TestAdmissionControlWrap(t *testing.T) {
+	var nilAC *AdmissionControl
+	ln := newScriptListener()
+	if nilAC.Wrap(ln) != net.Listener(ln) {
+		t.Error("nil AdmissionControl did not return listener unchanged")
+	}
+
+	c1 := newRemoteAddrConn("192.0.2.1")
+	c2 := newRemoteAddrConn("192.0.2.1")
+	c3 := newRemoteAddrConn("192.0.2.2")
+	ac := &AdmissionControl{MaxConnsPerIP: 1}
+	wln := ac.Wrap(newScriptListener(acceptResult{c1, nil}, acceptResult{c2, nil}, acceptResult{c3, nil}))
+	defer wln.Close()
+
+	conn, err := wln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr() != c1.addr {
+		t.Errorf("first Accept got %v", conn.RemoteAddr())
+	}
+	// c2 is rejected, having the same IP as c1.
+	conn2, err := wln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn2.RemoteAddr() != c3.addr {
+		t.Errorf("second Accept got %v", conn2.RemoteAddr())
+	}
+	if atomic.LoadInt32(&c2.closed) == 0 {
+		t.Error("rejected connection not closed")
+	}
+	if ac.Rejected() != 1 {
+		t.Errorf("Rejected() = %d", ac.Rejected())
+	}
+
+	conn.Close()
+	conn.Close()
+	if ac.conns != 1 || ac.perIP["192.0.2.1"] != 0 {
+		t.Errorf("unexpected counts after Close: %d %v", ac.conns, ac.perIP)
+	}
+}
@@ -112,7 +112,7 @@ func parseClientParameters(s string) (args Args, err error) {
 // the transport. Colons, semicolons, equal signs and backslashes must be
 // escaped with a backslash."
 // Example: scramblesuit:key=banana;automata:rule=110;automata:depth=3
-func parseServerTransportOptions(s string) (opts map[string]Args, err error) {
+func ParseServerTransportOptions(s string) (opts map[string]Args, err error) {
 	opts = make(map[string]Args)
 	if len(s) == 0 {
 		return
@@ -176,6 +176,30 @@ func parseServerTransportOptions(s string) (opts map[string]Args, err error) {
 	return opts, nil
 }
 
+// Escape backslashes, and all the bytes that are in special, with a backslash,
+// as in SOCKS client parameters (special "=;"), SMETHOD ARGS (special "=,"),
+// and TOR_PT_SERVER_TRANSPORT_OPTIONS (special ":;="). For any s and special,
+// Unescape(Escape(s, special)) returns s.
+func Escape(s, special string) string {
+	return backslashEscape(s, []byte(special))
+}
+
+// Remove the backslash escapes made by Escape from s. Returns an error if s
+// ends in an unescaped backslash.
+func Unescape(s string) (string, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			if i >= len(s) {
+				return "", fmt.Errorf("nothing following final escape in %q", s)
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String(), nil
+}
+
 // Escape backslashes and all the bytes that are in set.
 func backslashEscape(s string, set []byte) string {
 	var buf bytes.Buffer
@@ -188,6 +212,34 @@ func backslashEscape(s string, set []byte) string {
 	return buf.String()
 }
 
+// Encode a name–value mapping so that it is suitable to be split between a
+// SOCKS username and password; the inverse of parseClientParameters. The
+// output is sorted by key.
+//
+// "First the '<Key>=<Value>' formatted arguments MUST be escaped, such that all
+// backslash, equal sign, and semicolon characters are escaped with a
+// backslash."
+func EncodeClientParameters(args Args) string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	escape := func(s string) string {
+		return backslashEscape(s, []byte{'=', ';'})
+	}
+
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range args[key] {
+			pairs = append(pairs, escape(key)+"="+escape(value))
+		}
+	}
+
+	return strings.Join(pairs, ";")
+}
+
 // Encode a name–value mapping so that it is suitable to go in the ARGS option
 // of an SMETHOD line. The output is sorted by key. The "ARGS:" prefix is not
 // added.
@@ -217,3 +269,35 @@ func encodeSmethodArgs(args Args) string {
 
 	return strings.Join(pairs, ",")
 }
+
+// Encode a transport–name–value mapping in the format of
+// TOR_PT_SERVER_TRANSPORT_OPTIONS; the inverse of ParseServerTransportOptions.
+// The output is sorted by method name and then by key.
+func EncodeServerTransportOptions(opts map[string]Args) string {
+	methodNames := make([]string, 0, len(opts))
+	for methodName := range opts {
+		methodNames = append(methodNames, methodName)
+	}
+	sort.Strings(methodNames)
+
+	escape := func(s string) string {
+		return backslashEscape(s, []byte{':', ';', '='})
+	}
+
+	var pairs []string
+	for _, methodName := range methodNames {
+		args := opts[methodName]
+		keys := make([]string, 0, len(args))
+		for key := range args {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, value := range args[key] {
+				pairs = append(pairs, escape(methodName)+":"+escape(key)+"="+escape(value))
+			}
+		}
+	}
+
+	return strings.Join(pairs, ";")
+}
@@ -317,14 +317,14 @@ func TestParseServerTransportOptions(t *testing.T) {
 	}
 
 	for _, input := range badTests {
-		_, err := parseServerTransportOptions(input)
+		_, err := ParseServerTransportOptions(input)
 		if err == nil {
 			t.Errorf("%q unexpectedly succeeded", input)
 		}
 	}
 
 	for _, test := range goodTests {
-		opts, err := parseServerTransportOptions(test.input)
+		opts, err := ParseServerTransportOptions(test.input)
 		if err != nil {
 			t.Errorf("%q unexpectedly returned an error: %s", test.input, err)
 		}
@@ -334,6 +334,28 @@ func TestParseServerTransportOptions(t *testing.T) {
 	}
 }
 
+func FuzzParseServerTransportOptions(f *testing.F) {
+	f.Add("t:k=v")
+	f.Add("trebuchet:secret=nou;trebuchet:cache=/tmp/cache;ballista:secret=yes")
+	f.Add(`t\:1:k\=1=v\;1`)
+	f.Fuzz(func(t *testing.T, s string) {
+		opts, err := ParseServerTransportOptions(s)
+		if err != nil {
+			return
+		}
+		for methodName, args := range opts {
+			if methodName == "" {
+				t.Errorf("%q → empty method name", s)
+			}
+			for key := range args {
+				if key == "" {
+					t.Errorf("%q → empty key", s)
+				}
+			}
+		}
+	})
+}
+
 func TestEncodeSmethodArgs(t *testing.T) {
 	tests := [...]struct {
 		args     Args
@@ -372,3 +394,84 @@ func TestEncodeSmethodArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeServerTransportOptions(t *testing.T) {
+	tests := [...]struct {
+		opts     map[string]Args
+		expected string
+	}{
+		{
+			nil,
+			"",
+		},
+		{
+			map[string]Args{"t": {}},
+			"",
+		},
+		{
+			map[string]Args{
+				"trebuchet": {"secret": []string{"nou"}, "cache": []string{"/tmp/cache"}},
+				"ballista":  {"secret": []string{"yes"}},
+			},
+			"ballista:secret=yes;trebuchet:cache=/tmp/cache;trebuchet:secret=nou",
+		},
+		{
+			map[string]Args{
+				"t:1": {"k=1": []string{"v:1", "v=2", "v;3", "v\\4"}},
+			},
+			"t\\:1:k\\=1=v\\:1;t\\:1:k\\=1=v\\=2;t\\:1:k\\=1=v\\;3;t\\:1:k\\=1=v\\\\4",
+		},
+	}
+
+	for _, test := range tests {
+		encoded := EncodeServerTransportOptions(test.opts)
+		if encoded != test.expected {
+			t.Errorf("%q → %q (expected %q)", test.opts, encoded, test.expected)
+		}
+		opts, err := ParseServerTransportOptions(encoded)
+		if err != nil {
+			t.Errorf("%q unexpectedly returned an error: %s", encoded, err)
+			continue
+		}
+		for methodName, args := range test.opts {
+			if len(args) > 0 && !argsEqual(opts[methodName], args) {
+				t.Errorf("%q did not round-trip: got %q", test.opts, opts)
+			}
+		}
+	}
+}
+
+func TestEncodeClientParameters(t *testing.T) {
+	tests := [...]struct {
+		args     Args
+		expected string
+	}{
+		{
+			nil,
+			"",
+		},
+		{
+			Args{"j": []string{"v1", "v2"}, "k": []string{"v1"}},
+			"j=v1;j=v2;k=v1",
+		},
+		{
+			Args{"=;\\": []string{"=", ";", "\\", ","}},
+			"\\=\\;\\\\=\\=;\\=\\;\\\\=\\;;\\=\\;\\\\=\\\\;\\=\\;\\\\=,",
+		},
+	}
+
+	for _, test := range tests {
+		encoded := EncodeClientParameters(test.args)
+		if encoded != test.expected {
+			t.Errorf("%q → %q (expected %q)", test.args, encoded, test.expected)
+		}
+		args, err := parseClientParameters(encoded)
+		if err != nil {
+			t.Errorf("%q unexpectedly returned an error: %s", encoded, err)
+			continue
+		}
+		if len(test.args) > 0 && !argsEqual(args, test.args) {
+			t.Errorf("%q did not round-trip: got %q", test.args, args)
+		}
+	}
+}
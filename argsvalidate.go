@@ -0,0 +1,159 @@
+package pt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ArgRule describes one key that a transport understands in its Args.
+type ArgRule struct {
+	Key string
+	// If true, the key must be present.
+	Required bool
+	// If true, the key may be given more than once; otherwise it must have
+	// at most one value.
+	Repeated bool
+	// If not nil, called on each value of the key, returning an error if
+	// the value is not acceptable. CheckBase64, CheckPort, CheckInteger, and
+	// CheckOneOf make common checks.
+	Check func(value string) error
+}
+
+// ArgsValidator checks the Args of a connection, usually the ones that tor
+// sends over SOCKS from a bridge line, against a declaration of the keys that a
+// transport understands:
+//
+//	var obfsArgs = pt.ArgsValidator{Rules: []pt.ArgRule{
+//		{Key: "cert", Required: true, Check: pt.CheckBase64},
+//		{Key: "iat-mode", Check: pt.CheckInteger(0, 2)},
+//	}}
+//
+//	func dial(network, address string, args pt.Args) (net.Conn, error) {
+//		err := obfsArgs.Validate(args)
+//		if err != nil {
+//			return nil, err
+//		}
+//		...
+//	}
+//
+// RunClient rejects the SOCKS request of a connection whose Dialer returns the
+// resulting *ArgsError with SocksRepConnectionNotAllowed (see
+// SocksReplyForError), so that tor reports a configuration problem rather than
+// a network failure.
+type ArgsValidator struct {
+	Rules []ArgRule
+	// If true, keys with no rule are ignored; otherwise they are errors.
+	AllowUnknown bool
+}
+
+// ArgsError is the error returned by ArgsValidator.Validate. Its message names
+// the offending key, but not its value, which may be a secret.
+type ArgsError struct {
+	Key string
+	Err error
+}
+
+func (e *ArgsError) Error() string {
+	return fmt.Sprintf("arg %q: %s", e.Key, e.Err.Error())
+}
+
+func (e *ArgsError) Unwrap() error {
+	return e.Err
+}
+
+// Check args against the rules of v. Returns an *ArgsError describing the
+// first problem found, or nil if there is none. Keys are checked in the order of
+// v.Rules, then unknown keys in sorted order.
+func (v *ArgsValidator) Validate(args Args) error {
+	known := make(map[string]bool, len(v.Rules))
+	for _, rule := range v.Rules {
+		known[rule.Key] = true
+		values, ok := args[rule.Key]
+		if !ok || len(values) == 0 {
+			if rule.Required {
+				return &ArgsError{Key: rule.Key, Err: fmt.Errorf("missing")}
+			}
+			continue
+		}
+		if len(values) > 1 && !rule.Repeated {
+			return &ArgsError{Key: rule.Key, Err: fmt.Errorf("given %d times", len(values))}
+		}
+		if rule.Check == nil {
+			continue
+		}
+		for _, value := range values {
+			err := rule.Check(value)
+			if err != nil {
+				return &ArgsError{Key: rule.Key, Err: err}
+			}
+		}
+	}
+	if v.AllowUnknown {
+		return nil
+	}
+	var unknown []string
+	for key := range args {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &ArgsError{Key: unknown[0], Err: fmt.Errorf("unknown")}
+	}
+	return nil
+}
+
+// Return an error unless value is base64, in the standard or URL-safe
+// alphabet, with or without padding.
+func CheckBase64(value string) error {
+	encodings := []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding,
+		base64.URLEncoding, base64.RawURLEncoding,
+	}
+	for _, enc := range encodings {
+		if _, err := enc.DecodeString(value); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("not base64")
+}
+
+// Return an error unless value is a decimal port number from 1 to 65535.
+func CheckPort(value string) error {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || port == 0 {
+		return fmt.Errorf("not a port number")
+	}
+	return nil
+}
+
+// Return a check that value is a decimal integer between min and max
+// inclusive.
+func CheckInteger(min, max int64) func(string) error {
+	return func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("not an integer")
+		}
+		if n < min || n > max {
+			return fmt.Errorf("not between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+// Return a check that value is one of values.
+func CheckOneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("not one of %s", strings.Join(values, ", "))
+	}
+}
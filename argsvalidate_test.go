@@ -0,0 +1,56 @@
+package pt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestArgsValidatorValidate(t *testing.T) {
+	v := ArgsValidator{Rules: []ArgRule{
+		{Key: "cert", Required: true, Check: CheckBase64},
+		{Key: "port", Check: CheckPort},
+		{Key: "iat-mode", Check: CheckInteger(0, 2)},
+		{Key: "mode", Check: CheckOneOf("a", "b")},
+		{Key: "peer", Repeated: true},
+	}}
+	good := []Args{
+		{"cert": []string{"AAAA"}},
+		{"cert": []string{"AAA"}, "port": []string{"65535"}, "iat-mode": []string{"0"}},
+		{"cert": []string{"-_8"}, "mode": []string{"b"}, "peer": []string{"x", "y"}},
+	}
+	for _, args := range good {
+		if err := v.Validate(args); err != nil {
+			t.Errorf("%q unexpectedly failed: %s", args, err)
+		}
+	}
+	bad := []struct {
+		args Args
+		key  string
+	}{
+		{Args{}, "cert"},
+		{Args{"cert": []string{"not base64!"}}, "cert"},
+		{Args{"cert": []string{"AAAA", "AAAA"}}, "cert"},
+		{Args{"cert": []string{"AAAA"}, "port": []string{"0"}}, "port"},
+		{Args{"cert": []string{"AAAA"}, "port": []string{"65536"}}, "port"},
+		{Args{"cert": []string{"AAAA"}, "iat-mode": []string{"3"}}, "iat-mode"},
+		{Args{"cert": []string{"AAAA"}, "iat-mode": []string{"x"}}, "iat-mode"},
+		{Args{"cert": []string{"AAAA"}, "mode": []string{"c"}}, "mode"},
+		{Args{"cert": []string{"AAAA"}, "zzz": []string{""}, "yyy": []string{""}}, "yyy"},
+	}
+	for _, test := range bad {
+		err := v.Validate(test.args)
+		var argsErr *ArgsError
+		if !errors.As(err, &argsErr) {
+			t.Errorf("%q unexpectedly returned %v", test.args, err)
+			continue
+		}
+		if argsErr.Key != test.key {
+			t.Errorf("%q: error was for key %q, expected %q", test.args, argsErr.Key, test.key)
+		}
+	}
+
+	v.AllowUnknown = true
+	if err := v.Validate(Args{"cert": []string{"AAAA"}, "zzz": []string{""}}); err != nil {
+		t.Errorf("unknown key with AllowUnknown failed: %s", err)
+	}
+}
@@ -0,0 +1,216 @@
+package pt
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// The auth cookie file is read afresh for each extended ORPort connection,
+// because tor may regenerate the cookie while the transport is running, for
+// example when tor is restarted (https://bugs.torproject.org/15240). To avoid
+// reading the file on every connection when it has not changed, the parsed
+// cookie is cached along with the file's modification time and size, and used
+// as long as those are unchanged. Callers get a copy of the cached cookie,
+// which they should wipe when done with it.
+
+type cachedAuthCookie struct {
+	modTime time.Time
+	size    int64
+	cookie  []byte
+}
+
+var authCookieCache struct {
+	sync.Mutex
+	entries map[string]cachedAuthCookie
+}
+
+// Return a copy of the cookie from the named auth cookie file, from the cache
+// if the file appears not to have changed since it was last read. If reload is
+// true, always read the file.
+func loadAuthCookie(filename string, reload bool) ([]byte, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	authCookieCache.Lock()
+	defer authCookieCache.Unlock()
+	entry, ok := authCookieCache.entries[filename]
+	if ok && !reload && entry.modTime.Equal(fi.ModTime()) && entry.size == fi.Size() {
+		return append([]byte(nil), entry.cookie...), nil
+	}
+
+	forgetAuthCookie(filename)
+	err = checkAuthCookiePermissions(filename, fi)
+	if err != nil {
+		return nil, err
+	}
+	cookie, err := readAuthCookieFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if authCookieCache.entries == nil {
+		authCookieCache.entries = make(map[string]cachedAuthCookie)
+	}
+	authCookieCache.entries[filename] = cachedAuthCookie{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+		cookie:  cookie,
+	}
+	return append([]byte(nil), cookie...), nil
+}
+
+// Wipe and remove the cache entry for filename, if any. authCookieCache must be
+// locked.
+func forgetAuthCookie(filename string) {
+	if entry, ok := authCookieCache.entries[filename]; ok {
+		wipe(entry.cookie)
+		delete(authCookieCache.entries, filename)
+	}
+}
+
+// Wipe the auth cookie that is cached in memory for info.AuthCookiePath, if
+// any, and the cookies held by the functions that AuthCookieFromFD and
+// AuthCookieFromEnv return, to reduce the time the ExtORPort credential spends
+// in memory (and in core dumps). Call it when the transport will make no more
+// OR connections, or periodically. The nonces and hashes of each
+// authentication are wiped when it finishes. DialOr still works after Zeroize
+// with AuthCookiePath, but must read the cookie file again; the functions from
+// AuthCookieFromFD and AuthCookieFromEnv return an error after Zeroize, because
+// they have no way to get the cookie again.
+func (info *ServerInfo) Zeroize() {
+	heldAuthCookies.Lock()
+	for _, held := range heldAuthCookies.cookies {
+		held.wipe()
+	}
+	heldAuthCookies.cookies = nil
+	heldAuthCookies.Unlock()
+
+	if info.AuthCookiePath == "" {
+		return
+	}
+	authCookieCache.Lock()
+	defer authCookieCache.Unlock()
+	forgetAuthCookie(info.AuthCookiePath)
+}
+
+// If not nil, ServerSetup puts AuthCookieSource in ServerInfo.AuthCookie, and
+// does not require TOR_PT_AUTH_COOKIE_FILE with TOR_PT_EXTENDED_SERVER_PORT.
+// This is for sandboxed or containerized transports that cannot read tor's data
+// directory, but are given the cookie in some other way, as by
+// AuthCookieFromFD or AuthCookieFromEnv. Set it before ServerSetup.
+var AuthCookieSource func() ([]byte, error)
+
+// A cookie held by a function from authCookieFunc, until Zeroize wipes it.
+type heldAuthCookie struct {
+	sync.Mutex
+	cookie []byte
+}
+
+func (held *heldAuthCookie) wipe() {
+	held.Lock()
+	defer held.Unlock()
+	wipe(held.cookie)
+	held.cookie = nil
+}
+
+// The cookies held by functions from authCookieFunc that Zeroize has not yet
+// wiped.
+var heldAuthCookies struct {
+	sync.Mutex
+	cookies []*heldAuthCookie
+}
+
+// Return a function for ServerInfo.AuthCookie or AuthCookieSource that returns
+// copies of cookie, or an error after Zeroize has wiped it.
+func authCookieFunc(cookie []byte) func() ([]byte, error) {
+	held := &heldAuthCookie{cookie: cookie}
+	heldAuthCookies.Lock()
+	heldAuthCookies.cookies = append(heldAuthCookies.cookies, held)
+	heldAuthCookies.Unlock()
+	return func() ([]byte, error) {
+		held.Lock()
+		defer held.Unlock()
+		if held.cookie == nil {
+			return nil, errors.New("auth cookie has been wiped")
+		}
+		return append([]byte(nil), held.cookie...), nil
+	}
+}
+
+// Read the contents of an auth cookie file from the file descriptor fd, such as
+// one opened by a parent process that can read tor's data directory, and close
+// it; fd must not also belong to an *os.File, which would close it again.
+// Returns a function for ServerInfo.AuthCookie or AuthCookieSource that
+// returns the cookie. Because fd is read only once, a cookie that tor
+// regenerates later is not seen.
+func AuthCookieFromFD(fd uintptr) (func() ([]byte, error), error) {
+	f := os.NewFile(fd, "auth cookie")
+	if f == nil {
+		return nil, fmt.Errorf("invalid auth cookie file descriptor %d", fd)
+	}
+	defer f.Close()
+	cookie, err := readAuthCookie(f)
+	if err != nil {
+		return nil, err
+	}
+	return authCookieFunc(cookie), nil
+}
+
+// Read the 32-byte auth cookie, in hexadecimal, from the environment variable
+// name, and unset the variable so that child processes do not inherit it.
+// Returns a function for ServerInfo.AuthCookie or AuthCookieSource that returns
+// the cookie.
+func AuthCookieFromEnv(name string) (func() ([]byte, error), error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("no %s environment variable", name)
+	}
+	os.Unsetenv(name)
+	cookie, err := hex.DecodeString(value)
+	if err != nil || len(cookie) != 32 {
+		wipe(cookie)
+		return nil, fmt.Errorf("%s is not 32 bytes in hexadecimal", name)
+	}
+	return authCookieFunc(cookie), nil
+}
+
+// Return true if info has a way to get the auth cookie.
+func (info *ServerInfo) hasAuthCookie() bool {
+	return info.AuthCookie != nil || info.AuthCookiePath != ""
+}
+
+// Return a copy of the auth cookie of info, from info.AuthCookie if it is set,
+// or else from the file info.AuthCookiePath as loadAuthCookie does.
+func (info *ServerInfo) loadAuthCookie(reload bool) ([]byte, error) {
+	if info.AuthCookie == nil {
+		cookie, err := loadAuthCookie(info.AuthCookiePath, reload)
+		if err != nil {
+			return nil, fmt.Errorf("error reading TOR_PT_AUTH_COOKIE_FILE %q: %s", info.AuthCookiePath, err.Error())
+		}
+		return cookie, nil
+	}
+	cookie, err := info.AuthCookie()
+	if err != nil {
+		return nil, fmt.Errorf("error getting auth cookie: %s", err.Error())
+	}
+	if len(cookie) != 32 {
+		wipe(cookie)
+		return nil, fmt.Errorf("auth cookie is %d bytes, not 32", len(cookie))
+	}
+	return cookie, nil
+}
+
+// Overwrite b with zeroes.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	// Keep the stores from being optimized away.
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,11 @@
+//go:build !plan9
+// +build !plan9
+
+package pt
+
+import "syscall"
+
+func openReadOnlyFD(filename string) (int, error) {
+	fd, err := syscall.Open(filename, syscall.O_RDONLY, 0)
+	return int(fd), err
+}
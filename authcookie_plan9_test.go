@@ -0,0 +1,7 @@
+package pt
+
+import "syscall"
+
+func openReadOnlyFD(filename string) (int, error) {
+	return syscall.Open(filename, syscall.O_RDONLY)
+}
@@ -0,0 +1,279 @@
+package pt
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestAuthCookie(t *testing.T, filename string, cookie []byte, modTime time.Time) {
+	data := append([]byte("! Extended ORPort Auth Cookie !\x0a"), cookie...)
+	err := ioutil.WriteFile(filename, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(filename, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadAuthCookie(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookie")
+	cookieA := bytes.Repeat([]byte{'A'}, 32)
+	cookieB := bytes.Repeat([]byte{'B'}, 32)
+	modTime := time.Unix(1000000000, 0)
+
+	writeTestAuthCookie(t, filename, cookieA, modTime)
+	cookie, err := loadAuthCookie(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cookie, cookieA) {
+		t.Errorf("got %q, expected %q", cookie, cookieA)
+	}
+
+	// Rewritten without a change in modification time: the cached cookie
+	// is returned unless reload is set.
+	writeTestAuthCookie(t, filename, cookieB, modTime)
+	cookie, err = loadAuthCookie(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cookie, cookieA) {
+		t.Errorf("got %q, expected cached %q", cookie, cookieA)
+	}
+	cookie, err = loadAuthCookie(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cookie, cookieB) {
+		t.Errorf("got %q after reload, expected %q", cookie, cookieB)
+	}
+
+	// Rewritten with a new modification time, as when tor restarts.
+	writeTestAuthCookie(t, filename, cookieA, modTime.Add(time.Second))
+	cookie, err = loadAuthCookie(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cookie, cookieA) {
+		t.Errorf("got %q after change, expected %q", cookie, cookieA)
+	}
+
+	os.Remove(filename)
+	_, err = loadAuthCookie(filename, false)
+	if err == nil {
+		t.Error("missing file unexpectedly succeeded")
+	}
+}
+
+// Authentication succeeds even when the cached cookie is stale in a way that
+// the modification time does not reveal.
+func TestExtOrPortAuthenticateStaleCookie(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookie")
+	cookieA := bytes.Repeat([]byte{'A'}, 32)
+	cookieB := bytes.Repeat([]byte{'B'}, 32)
+	modTime := time.Unix(1000000000, 0)
+	writeTestAuthCookie(t, filename, cookieA, modTime)
+	_, err := loadAuthCookie(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestAuthCookie(t, filename, cookieB, modTime)
+
+	upstreamR, upstreamW := io.Pipe()
+	downstreamR, downstreamW := io.Pipe()
+	go simulateServerExtOrPortAuth(upstreamR, downstreamW, cookieB)
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{downstreamR, upstreamW}
+	err = extOrPortAuthenticate(rw, &ServerInfo{AuthCookiePath: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerInfoZeroize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, filename, bytes.Repeat([]byte{'A'}, 32), time.Unix(1000000000, 0))
+	cookie, err := loadAuthCookie(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authCookieCache.Lock()
+	cached := authCookieCache.entries[filename].cookie
+	authCookieCache.Unlock()
+	// The caller's copy is independent of the cache.
+	wipe(cookie)
+	if !bytes.Equal(cached, bytes.Repeat([]byte{'A'}, 32)) {
+		t.Errorf("cached cookie changed by wiping a copy: %q", cached)
+	}
+
+	info := &ServerInfo{AuthCookiePath: filename}
+	info.Zeroize()
+	if !bytes.Equal(cached, make([]byte, 32)) {
+		t.Errorf("cached cookie not wiped: %q", cached)
+	}
+	authCookieCache.Lock()
+	_, ok := authCookieCache.entries[filename]
+	authCookieCache.Unlock()
+	if ok {
+		t.Error("cache entry not removed")
+	}
+	// Zeroize with no cookie is harmless.
+	(&ServerInfo{}).Zeroize()
+}
+
+// A server that sends back the client's nonce as its own is rejected, even
+// with a correct hash.
+func TestExtOrPortAuthenticateReflectedNonce(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookie")
+	cookie := bytes.Repeat([]byte{'A'}, 32)
+	writeTestAuthCookie(t, filename, cookie, time.Unix(1000000000, 0))
+
+	upstreamR, upstreamW := io.Pipe()
+	downstreamR, downstreamW := io.Pipe()
+	go func() {
+		defer downstreamW.Close()
+		downstreamW.Write([]byte{1, 0})
+		buf := make([]byte, 1+32)
+		_, err := io.ReadFull(upstreamR, buf)
+		if err != nil {
+			return
+		}
+		clientNonce := buf[1:]
+		downstreamW.Write(computeServerHash(cookie, clientNonce, clientNonce))
+		downstreamW.Write(clientNonce)
+		io.Copy(ioutil.Discard, upstreamR)
+	}()
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{downstreamR, upstreamW}
+	err := extOrPortAuthenticate(rw, &ServerInfo{AuthCookiePath: filename})
+	upstreamW.Close()
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("reflected nonce: got error %v", err)
+	}
+}
+
+func TestExtOrPortAuthReader(t *testing.T) {
+	r := &extOrPortAuthReader{r: bytes.NewReader(make([]byte, 100)), n: 10}
+	n, err := io.ReadFull(r, make([]byte, 20))
+	if n != 10 || err != errExtOrPortAuthTooLong {
+		t.Errorf("got %d, %v (expected 10, %v)", n, err, errExtOrPortAuthTooLong)
+	}
+}
+
+func TestAuthCookieSources(t *testing.T) {
+	cookie := bytes.Repeat([]byte{'C'}, 32)
+
+	t.Setenv("TEST_COOKIE", "00")
+	if _, err := AuthCookieFromEnv("TEST_COOKIE"); err == nil {
+		t.Error("short cookie in environment unexpectedly succeeded")
+	}
+	t.Setenv("TEST_COOKIE", "43434343434343434343434343434343434343434343434343434343434343434343")
+	if _, err := AuthCookieFromEnv("TEST_COOKIE"); err == nil {
+		t.Error("long cookie in environment unexpectedly succeeded")
+	}
+	t.Setenv("TEST_COOKIE", strings.Repeat("43", 32))
+	fromEnv, err := AuthCookieFromEnv("TEST_COOKIE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := os.LookupEnv("TEST_COOKIE"); ok {
+		t.Error("cookie variable not unset")
+	}
+
+	// Open the descriptor without an *os.File, whose finalizer would close
+	// it again after AuthCookieFromFD has.
+	filename := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, filename, cookie, time.Now())
+	fd, err := openReadOnlyFD(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromFD, err := AuthCookieFromFD(uintptr(fd))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range []func() ([]byte, error){fromEnv, fromFD} {
+		got, err := source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, cookie) {
+			t.Errorf("got cookie %q (expected %q)", got, cookie)
+		}
+		// Wiping what is returned does not affect later calls.
+		wipe(got)
+
+		upstreamR, upstreamW := io.Pipe()
+		downstreamR, downstreamW := io.Pipe()
+		go simulateServerExtOrPortAuth(upstreamR, downstreamW, cookie)
+		rw := struct {
+			io.Reader
+			io.Writer
+		}{downstreamR, upstreamW}
+		err = extOrPortAuthenticate(rw, &ServerInfo{AuthCookie: source})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Zeroize wipes the cookies held by the sources.
+	var held [][]byte
+	heldAuthCookies.Lock()
+	for _, h := range heldAuthCookies.cookies {
+		held = append(held, h.cookie)
+	}
+	heldAuthCookies.Unlock()
+	if len(held) < 2 {
+		t.Fatalf("%d held cookies", len(held))
+	}
+	(&ServerInfo{AuthCookie: fromEnv}).Zeroize()
+	for _, b := range held {
+		if !bytes.Equal(b, make([]byte, len(b))) {
+			t.Errorf("held cookie not wiped: %q", b)
+		}
+	}
+	for _, source := range []func() ([]byte, error){fromEnv, fromFD} {
+		if _, err := source(); err == nil {
+			t.Error("source unexpectedly succeeded after Zeroize")
+		}
+	}
+}
+
+// With AuthCookieSource, ServerSetup does not need TOR_PT_AUTH_COOKIE_FILE.
+func TestServerSetupAuthCookieSource(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_SERVER_TRANSPORTS", "foo")
+	os.Setenv("TOR_PT_SERVER_BINDADDR", "foo-127.0.0.1:0")
+	os.Setenv("TOR_PT_ORPORT", "127.0.0.1:9001")
+	os.Setenv("TOR_PT_EXTENDED_SERVER_PORT", "127.0.0.1:9002")
+	if _, err := ServerSetup(nil); err == nil {
+		t.Fatal("setup without a cookie unexpectedly succeeded")
+	}
+
+	AuthCookieSource = authCookieFunc(make([]byte, 32))
+	defer func() { AuthCookieSource = nil }()
+	info, err := ServerSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AuthCookie == nil || !info.hasAuthCookie() {
+		t.Error("AuthCookie not set from AuthCookieSource")
+	}
+}
@@ -0,0 +1,133 @@
+package pt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// If PersistAutoPorts is true, a bindaddr with port 0 (an "auto" port) is given
+// the same port every time the transport is started, rather than a new random
+// port. The first time, the port assigned by the operating system is saved in a
+// file in TOR_PT_STATE_LOCATION; later, that port is tried first. If it cannot
+// be bound, a new port is assigned and saved, and a warning is logged. This
+// keeps published bridge lines valid across restarts for operators who use
+// auto ports.
+//
+// PersistAutoPorts affects Bindaddr.Listen, Bindaddr.ListenPacket, RunServer,
+// and ServeTransports.
+var PersistAutoPorts bool
+
+// The name of the file in TOR_PT_STATE_LOCATION that stores auto ports.
+const autoPortsFilename = "pt_auto_ports.json"
+
+// Serializes access to the auto ports file.
+var autoPortsLock sync.Mutex
+
+// Open a TCP listener on the bind address, observing PersistAutoPorts and
+// ListenTCPOptions. If a socket named after the bindaddr's method was passed by
+// socket activation (see ActivationListeners), it is returned instead, and the
+// address, PersistAutoPorts, and ListenTCPOptions are ignored.
+func (bindaddr Bindaddr) Listen() (*net.TCPListener, error) {
+	if ln, err := takeActivationTCPListener(bindaddr.MethodName); ln != nil || err != nil {
+		return ln, err
+	}
+	var ln *net.TCPListener
+	err := listenAutoPort("tcp", bindaddr.MethodName, bindaddr.Addr, func(addr *net.TCPAddr) (net.Addr, error) {
+		var err error
+		ln, err = listenTCPOptions("tcp", addr, &ListenTCPOptions)
+		if err != nil {
+			return nil, err
+		}
+		return ln.Addr(), nil
+	})
+	return ln, err
+}
+
+// Call listen with addr, unless PersistAutoPorts is set and addr has port 0, in
+// which case call it with the port saved for network and methodName (if any),
+// and save the port that listen reports having bound. listen must return the
+// local address of the socket it opened, and must close any socket it opened
+// if it returns an error.
+func listenAutoPort(network, methodName string, addr *net.TCPAddr, listen func(*net.TCPAddr) (net.Addr, error)) error {
+	if !PersistAutoPorts || addr == nil || addr.Port != 0 {
+		_, err := listen(addr)
+		return err
+	}
+
+	dir, err := MakeStateDir()
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, autoPortsFilename)
+	key := network + " " + methodName
+
+	autoPortsLock.Lock()
+	defer autoPortsLock.Unlock()
+
+	ports, err := readAutoPorts(filename)
+	if err != nil {
+		Log(LogSeverityWarning, fmt.Sprintf("cannot read saved ports: %s", err.Error()))
+		ports = make(map[string]int)
+	}
+	if port, ok := ports[key]; ok {
+		a := *addr
+		a.Port = port
+		_, err := listen(&a)
+		if err == nil {
+			return nil
+		}
+		LogMethod(methodName, LogSeverityWarning, fmt.Sprintf("cannot reuse port %d for %s: %s", port, methodName, err.Error()))
+	}
+
+	local, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	var port int
+	switch local := local.(type) {
+	case *net.TCPAddr:
+		port = local.Port
+	case *net.UDPAddr:
+		port = local.Port
+	default:
+		return nil
+	}
+	ports[key] = port
+	err = writeAutoPorts(filename, ports)
+	if err != nil {
+		LogMethod(methodName, LogSeverityWarning, fmt.Sprintf("cannot save port for %s: %s", methodName, err.Error()))
+	}
+	return nil
+}
+
+// Read the map of saved ports from filename. A missing file is treated as an
+// empty map.
+func readAutoPorts(filename string) (map[string]int, error) {
+	ports := make(map[string]int)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return ports, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &ports)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	return ports, nil
+}
+
+// Write the map of saved ports to filename, replacing it atomically.
+func writeAutoPorts(filename string, ports map[string]int) error {
+	data, err := json.Marshal(ports)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
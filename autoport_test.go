@@ -0,0 +1,97 @@
+package pt
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPersistAutoPorts(t *testing.T) {
+	saved := PersistAutoPorts
+	PersistAutoPorts = true
+	defer func() { PersistAutoPorts = saved }()
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+	t.Setenv("TOR_PT_STATE_LOCATION", t.TempDir())
+
+	bindaddr := Bindaddr{MethodName: "alpha", Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}}
+	ln, err := bindaddr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	// The same port is reused.
+	ln, err = bindaddr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := ln.Addr().(*net.TCPAddr).Port; p != port {
+		t.Errorf("got port %d after restart, expected %d", p, port)
+	}
+
+	// If the saved port is busy, a new one is assigned and saved.
+	ln2, err := bindaddr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPort := ln2.Addr().(*net.TCPAddr).Port
+	if newPort == port {
+		t.Errorf("got busy port %d", port)
+	}
+	if !strings.Contains(buf.String(), "LOG SEVERITY=warning") {
+		t.Errorf("no warning logged: %q", buf.String())
+	}
+	ln.Close()
+	ln2.Close()
+	ln, err = bindaddr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := ln.Addr().(*net.TCPAddr).Port; p != newPort {
+		t.Errorf("got port %d after reassignment, expected %d", p, newPort)
+	}
+	ln.Close()
+
+	// UDP ports are saved separately, and a port other than 0 is used as is.
+	pconn, err := bindaddr.ListenPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpPort := pconn.LocalAddr().(*net.UDPAddr).Port
+	pconn.Close()
+	pconn, err = bindaddr.ListenPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := pconn.LocalAddr().(*net.UDPAddr).Port; p != udpPort {
+		t.Errorf("got UDP port %d after restart, expected %d", p, udpPort)
+	}
+	pconn.Close()
+}
+
+func TestPersistAutoPortsDisabled(t *testing.T) {
+	saved := PersistAutoPorts
+	PersistAutoPorts = false
+	defer func() { PersistAutoPorts = saved }()
+	dir := t.TempDir()
+	t.Setenv("TOR_PT_STATE_LOCATION", dir)
+
+	bindaddr := Bindaddr{MethodName: "alpha", Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}}
+	ln, err := bindaddr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	ports, err := readAutoPorts(dir + "/" + autoPortsFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 0 {
+		t.Errorf("ports saved with PersistAutoPorts off: %v", ports)
+	}
+}
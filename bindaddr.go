@@ -0,0 +1,110 @@
+package pt
+
+import (
+	"fmt"
+	"net"
+)
+
+// Return an error if any two of bindaddrs would try to listen on the same port
+// of the same address. Addresses with port 0 never conflict, because each gets
+// its own port from the operating system. The IPv4 wildcard address 0.0.0.0
+// conflicts with every IPv4 address on the same port, and the IPv6 wildcard
+// address :: conflicts with every address on the same port, because a listener
+// on :: also accepts IPv4 connections.
+//
+// ServerSetup does not call CheckBindaddrConflicts; call it before opening
+// listeners to report a clearer error than the "address already in use" that
+// net.Listen would return:
+//
+//	err := pt.CheckBindaddrConflicts(ptInfo.Bindaddrs)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+func CheckBindaddrConflicts(bindaddrs []Bindaddr) error {
+	for i := range bindaddrs {
+		for j := i + 1; j < len(bindaddrs); j++ {
+			a, b := bindaddrs[i], bindaddrs[j]
+			if addrsConflict(a.Addr, b.Addr) {
+				return fmt.Errorf("bindaddr %s-%s conflicts with %s-%s",
+					a.MethodName, a.Addr, b.MethodName, b.Addr)
+			}
+		}
+	}
+	return nil
+}
+
+func addrsConflict(a, b *net.TCPAddr) bool {
+	if a == nil || b == nil || a.Port == 0 || a.Port != b.Port {
+		return false
+	}
+	// :: covers everything.
+	if isIPv6Unspecified(a.IP) || isIPv6Unspecified(b.IP) {
+		return true
+	}
+	aIs4, bIs4 := a.IP.To4() != nil, b.IP.To4() != nil
+	if aIs4 != bIs4 {
+		return false
+	}
+	if a.IP.IsUnspecified() || b.IP.IsUnspecified() {
+		return true
+	}
+	return a.IP.Equal(b.IP) && a.Zone == b.Zone
+}
+
+func isIPv6Unspecified(ip net.IP) bool {
+	return ip.To4() == nil && ip.IsUnspecified()
+}
+
+// If addr is a wildcard address (0.0.0.0 or ::), return the addresses, with
+// addr's port, of the network interfaces it covers, for use in SMETHOD lines,
+// because tor needs a routable address there. 0.0.0.0 stands for the IPv4
+// addresses of the interfaces, and :: for both the IPv4 and IPv6 addresses.
+// Loopback and link-local addresses are omitted. If addr is not a wildcard
+// address, return a slice containing only addr.
+//
+// Pass the address of an open listener, so that a port 0 bindaddr is expanded
+// with the port that was actually assigned:
+//
+//	ln, err := net.ListenTCP("tcp", bindaddr.Addr)
+//	...
+//	addrs, err := pt.ExpandWildcardAddr(ln.Addr().(*net.TCPAddr))
+//	...
+//	for _, addr := range addrs {
+//		pt.Smethod(bindaddr.MethodName, addr)
+//	}
+func ExpandWildcardAddr(addr *net.TCPAddr) ([]*net.TCPAddr, error) {
+	if !addr.IP.IsUnspecified() {
+		return []*net.TCPAddr{addr}, nil
+	}
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	return expandWildcardAddr(addr, ifAddrs), nil
+}
+
+// The engine of ExpandWildcardAddr, with the list of interface addresses as a
+// parameter.
+func expandWildcardAddr(addr *net.TCPAddr, ifAddrs []net.Addr) []*net.TCPAddr {
+	wantIPv6 := isIPv6Unspecified(addr.IP)
+	var result []*net.TCPAddr
+	for _, ifAddr := range ifAddrs {
+		var ip net.IP
+		switch ifAddr := ifAddr.(type) {
+		case *net.IPNet:
+			ip = ifAddr.IP
+		case *net.IPAddr:
+			ip = ifAddr.IP
+		default:
+			continue
+		}
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			continue
+		}
+		if ip.To4() == nil && !wantIPv6 {
+			continue
+		}
+		result = append(result, &net.TCPAddr{IP: ip, Port: addr.Port})
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package pt
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCheckBindaddrConflicts(t *testing.T) {
+	badTests := [...][]string{
+		{"1.2.3.4:9999", "1.2.3.4:9999"},
+		{"0.0.0.0:9999", "1.2.3.4:9999"},
+		{"[::]:9999", "1.2.3.4:9999"},
+		{"[::]:9999", "[1::2]:9999"},
+		{"[1::2]:9999", "[1::2]:9999"},
+		{"1.2.3.4:1111", "2.3.4.5:2222", "[::]:1111"},
+	}
+	goodTests := [...][]string{
+		{},
+		{"1.2.3.4:9999"},
+		{"1.2.3.4:9999", "1.2.3.4:9998"},
+		{"1.2.3.4:9999", "2.3.4.5:9999"},
+		{"0.0.0.0:9999", "[1::2]:9999"},
+		{"0.0.0.0:0", "[::]:0", "1.2.3.4:0"},
+		{"[fe80::1%eth0]:9999", "[fe80::1%eth1]:9999"},
+	}
+	makeBindaddrs := func(addrs []string) []Bindaddr {
+		var bindaddrs []Bindaddr
+		for i, s := range addrs {
+			addr, err := ParseAddrPort(s)
+			if err != nil {
+				panic(err)
+			}
+			bindaddrs = append(bindaddrs, Bindaddr{MethodName: string(rune('a' + i)), Addr: addr})
+		}
+		return bindaddrs
+	}
+	for _, test := range badTests {
+		if err := CheckBindaddrConflicts(makeBindaddrs(test)); err == nil {
+			t.Errorf("%q unexpectedly succeeded", test)
+		}
+	}
+	for _, test := range goodTests {
+		if err := CheckBindaddrConflicts(makeBindaddrs(test)); err != nil {
+			t.Errorf("%q unexpectedly returned an error: %s", test, err)
+		}
+	}
+}
+
+func TestExpandWildcardAddr(t *testing.T) {
+	ifAddrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+		&net.IPNet{IP: net.ParseIP("192.0.2.1"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPAddr{IP: net.ParseIP("198.51.100.1")},
+	}
+	tests := [...]struct {
+		input    string
+		expected []string
+	}{
+		{"0.0.0.0:9999", []string{"192.0.2.1:9999", "198.51.100.1:9999"}},
+		{"[::]:9999", []string{"192.0.2.1:9999", "[2001:db8::1]:9999", "198.51.100.1:9999"}},
+	}
+	for _, test := range tests {
+		addr, _ := ParseAddrPort(test.input)
+		output := expandWildcardAddr(addr, ifAddrs)
+		var strs []string
+		for _, a := range output {
+			strs = append(strs, a.String())
+		}
+		if !stringSlicesEqual(strs, test.expected) {
+			t.Errorf("%q → %q (expected %q)", test.input, strs, test.expected)
+		}
+	}
+
+	// A non-wildcard address is returned unchanged.
+	addr, _ := ParseAddrPort("192.0.2.1:9999")
+	output, err := ExpandWildcardAddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) != 1 || output[0] != addr {
+		t.Errorf("%v → %v", addr, output)
+	}
+}
@@ -0,0 +1,73 @@
+package pt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Return a torrc Bridge line for a bridge that runs methodName at addr, in the
+// form that clients paste into their configuration:
+//
+//	Bridge obfs4 192.0.2.1:443 0123456789ABCDEF0123456789ABCDEF01234567 cert=... iat-mode=0
+//
+// fingerprint is the bridge's relay identity fingerprint, 40 hexadecimal
+// digits, optionally with spaces between groups; if it is "", the line has no
+// fingerprint. args are the transport's per-bridge arguments, such as the
+// ServerArgs of its listener, as in an SMETHOD line's ARGS; they are written
+// sorted by key. Keys and values may not contain whitespace, and keys may not
+// contain '=', because tor has no way to escape them in a Bridge line.
+func BridgeLine(methodName string, addr *net.TCPAddr, fingerprint string, args Args) (string, error) {
+	if methodName == "" || !keywordIsSafe(methodName) {
+		return "", fmt.Errorf("invalid method name %q", methodName)
+	}
+	if addr == nil {
+		return "", fmt.Errorf("missing bridge address")
+	}
+	parts := []string{"Bridge", methodName, addr.String()}
+	if fingerprint != "" {
+		fp := strings.ToUpper(strings.Replace(fingerprint, " ", "", -1))
+		if b, err := hex.DecodeString(fp); err != nil || len(b) != 20 {
+			return "", fmt.Errorf("invalid fingerprint %q", fingerprint)
+		}
+		parts = append(parts, fp)
+	}
+
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, "= \t\r\n") {
+			return "", fmt.Errorf("invalid bridge line argument name %q", key)
+		}
+		for _, value := range args[key] {
+			if strings.ContainsAny(value, " \t\r\n") {
+				return "", fmt.Errorf("invalid value %q for bridge line argument %q", value, key)
+			}
+			parts = append(parts, key+"="+value)
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// Write line, as returned by BridgeLine, to the file <methodName>_bridgeline.txt
+// in the state directory, with a comment explaining its use, in the manner of
+// obfs4proxy's obfs4_bridgeline.txt. The file is replaced atomically.
+func WriteBridgeLine(methodName, line string) error {
+	if methodName == "" || !keywordIsSafe(methodName) {
+		return fmt.Errorf("invalid method name %q", methodName)
+	}
+	text := fmt.Sprintf(`# %s torrc client bridge line
+#
+# This file is an automatically generated bridge line based on the current
+# %s configuration. Any changes will be overwritten when the transport
+# is started again.
+
+%s
+`, methodName, methodName, line)
+	return WriteStateFile(methodName+"_bridgeline.txt", []byte(text))
+}
@@ -0,0 +1,69 @@
+package pt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBridgeLine(t *testing.T) {
+	tests := []struct {
+		methodName  string
+		addr        string
+		fingerprint string
+		args        Args
+		expected    string
+	}{
+		{"obfs4", "192.0.2.1:443", "0123456789abcdef0123456789abcdef01234567",
+			Args{"iat-mode": []string{"0"}, "cert": []string{"AbC+/="}},
+			"Bridge obfs4 192.0.2.1:443 0123456789ABCDEF0123456789ABCDEF01234567 cert=AbC+/= iat-mode=0"},
+		{"foo", "[2001:db8::1]:9001", "0123 4567 89AB CDEF 0123 4567 89AB CDEF 0123 4567", nil,
+			"Bridge foo [2001:db8::1]:9001 0123456789ABCDEF0123456789ABCDEF01234567"},
+		{"foo", "192.0.2.1:443", "", Args{"a": []string{"1", "2"}},
+			"Bridge foo 192.0.2.1:443 a=1 a=2"},
+	}
+	for _, test := range tests {
+		line, err := BridgeLine(test.methodName, mustTCPAddr(test.addr), test.fingerprint, test.args)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expected, err)
+			continue
+		}
+		if line != test.expected {
+			t.Errorf("got %q, expected %q", line, test.expected)
+		}
+	}
+
+	bad := []struct {
+		methodName  string
+		fingerprint string
+		args        Args
+	}{
+		{"", "", nil},
+		{"foo bar", "", nil},
+		{"foo", "0123", nil},
+		{"foo", "", Args{"a b": []string{"1"}}},
+		{"foo", "", Args{"a=b": []string{"1"}}},
+		{"foo", "", Args{"a": []string{"1 2"}}},
+	}
+	for _, test := range bad {
+		_, err := BridgeLine(test.methodName, mustTCPAddr("192.0.2.1:443"), test.fingerprint, test.args)
+		if err == nil {
+			t.Errorf("%q %q %q unexpectedly succeeded", test.methodName, test.fingerprint, test.args)
+		}
+	}
+}
+
+func TestWriteBridgeLine(t *testing.T) {
+	t.Setenv("TOR_PT_STATE_LOCATION", t.TempDir())
+	line := "Bridge foo 192.0.2.1:443 a=1"
+	err := WriteBridgeLine("foo", line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadStateFile("foo_bridgeline.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# ") || !strings.HasSuffix(string(data), "\n"+line+"\n") {
+		t.Errorf("unexpected contents %q", data)
+	}
+}
@@ -0,0 +1,132 @@
+package pt
+
+import (
+	"fmt"
+	"net"
+)
+
+// Dialer makes outgoing connections for one client transport method.
+type Dialer interface {
+	// Connect to the transport server at address, with per-connection
+	// arguments args (for tor, those from the bridge line), and return a
+	// connection that carries unobfuscated data.
+	Dial(network, address string, args Args) (net.Conn, error)
+}
+
+// DialerFunc is an adapter to allow the use of an ordinary function as a
+// Dialer.
+type DialerFunc func(network, address string, args Args) (net.Conn, error)
+
+// Call f(network, address, args).
+func (f DialerFunc) Dial(network, address string, args Args) (net.Conn, error) {
+	return f(network, address, args)
+}
+
+// Run a client transport. RunClient calls ClientSetup, then, for each method
+// name requested by tor, opens a SOCKS listener on an ephemeral loopback port
+// if there is a Dialer for the method in dialers, and emits CMETHOD or
+// CMETHOD-ERROR accordingly, followed by CMETHODS DONE. For each SOCKS
+// connection, it dials the requested target with the method's Dialer, passing
+// the per-connection arguments decoded from the SOCKS username and password,
+// and relays data between the two connections until both sides are closed.
+// Finally it waits for a signal to shut down, as HandleShutdownSignals does.
+//
+//	func main() {
+//		err := pt.RunClient(map[string]pt.Dialer{
+//			"foo": pt.DialerFunc(dialFoo),
+//		})
+//		if err != nil {
+//			os.Exit(1)
+//		}
+//	}
+//
+// If tor asks for an upstream proxy, RunClient emits PROXY-ERROR and returns an
+// error; transports that support proxies must do their own setup. Returns an
+// error if setup fails; otherwise, returns when shutdown is complete.
+func RunClient(dialers map[string]Dialer) error {
+	err := runClient(DefaultShutdownManager, dialers)
+	if err != nil {
+		return err
+	}
+	HandleReloadSignals(DefaultShutdownManager)
+	HandleDiagnosticsSignals(DefaultShutdownManager)
+	HandleShutdownSignals(DefaultShutdownManager, 0)
+	return nil
+}
+
+// Do the setup part of RunClient, with listeners and connections tracked by m.
+func runClient(m *ShutdownManager, dialers map[string]Dialer) error {
+	info, err := ClientSetup(nil)
+	if err != nil {
+		return err
+	}
+	if info.ProxyURL != nil {
+		return ProxyError(fmt.Sprintf("proxy %s is not supported", info.ProxyURL))
+	}
+	openClientListeners(m, info.MethodNames, dialers, nil)
+	return nil
+}
+
+// For each of methodNames that has an entry in dialers, open a SOCKS listener
+// tracked by m and emit a CMETHOD line. For others, emit a CMETHOD-ERROR line
+// with the message from errs, or with ReasonUnknownMethod. Finally emit
+// CMETHODS DONE.
+func openClientListeners(m *ShutdownManager, methodNames []string, dialers map[string]Dialer, errs map[string]error) {
+	mux := ClientMux{
+		listen: func(methodName string) (*SocksListener, error) {
+			return listenSocksTracked(m, methodName)
+		},
+		errs: errs,
+	}
+	for methodName, d := range dialers {
+		d := d
+		mux.Handle(methodName, func(conn *SocksConn) {
+			clientHandler(conn, methodName, d)
+		})
+	}
+	mux.Listen(methodNames)
+}
+
+// Open a SOCKS listener on an ephemeral loopback port, tracked by m, with its
+// MethodName set to methodName.
+func listenSocksTracked(m *ShutdownManager, methodName string) (*SocksListener, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	tln, err := m.TrackListener(ln)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	sln := NewSocksListener(tln)
+	sln.MethodName = methodName
+	sln.Access = DefaultSocksAccess
+	return sln, nil
+}
+
+func clientHandler(conn *SocksConn, methodName string, d Dialer) error {
+	defer conn.Close()
+	ctx, cancel := newConnContext(methodName, conn.RemoteAddr(), conn.Req.Args, conn.accepted)
+	defer cancel()
+	ContextPTTrace(ctx).gotSocksRequest(&conn.Req)
+	release := acquireHandshake()
+	remote, err := dialContext(ctx, d, "tcp", conn.Req.Target, conn.Req.Args)
+	release()
+	if err != nil {
+		if methodLogEnabled(methodName, LogSeverityDebug) {
+			LogMethod(methodName, LogSeverityDebug, fmt.Sprintf("cannot dial %s: %s", SafeAddrString(conn.Req.Target), err.Error()))
+		}
+		conn.RejectReason(SocksReplyForError(err))
+		return err
+	}
+	defer remote.Close()
+	err = conn.Grant(nil)
+	if err != nil {
+		return err
+	}
+
+	relayTraced(ctx, conn, remote)
+
+	return nil
+}
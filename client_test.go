@@ -0,0 +1,138 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunClient(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "foo,bogus")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	argsChan := make(chan Args, 1)
+	err := runClient(m, map[string]Dialer{
+		"foo": DialerFunc(func(network, address string, args Args) (net.Conn, error) {
+			argsChan <- args
+			return net.Dial(network, address)
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "CMETHOD-ERROR bogus unknown-method: no such method\n") ||
+		!strings.HasSuffix(buf.String(), "CMETHODS DONE\n") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "CMETHOD foo socks5 "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = socks5Connect(conn, echo.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+	if args := <-argsChan; len(args) != 0 {
+		t.Errorf("unexpected args %q", args)
+	}
+}
+
+func TestRunClientDialError(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "foo")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := runClient(m, map[string]Dialer{
+		"foo": DialerFunc(func(network, address string, args Args) (net.Conn, error) {
+			return nil, fmt.Errorf("dial failed")
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "CMETHOD foo socks5 "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = socks5Connect(conn, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1})
+	if err == nil || err.Error() != "SOCKS reply code 0x01" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRunClientProxy(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "foo")
+	os.Setenv("TOR_PT_PROXY", "http://127.0.0.1:8080")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := runClient(m, map[string]Dialer{})
+	if err == nil {
+		t.Fatal("proxy unexpectedly succeeded")
+	}
+	if !strings.HasSuffix(buf.String(), "PROXY-ERROR proxy http://127.0.0.1:8080 is not supported\n") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+// A half-close by the remote side passes through the tracked connection of
+// RunClient to the SOCKS client, which still gets to write.
+func TestRunClientHalfClose(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	server, received := startHalfClosingServer(t)
+	defer server.Close()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "foo")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := runClient(m, map[string]Dialer{
+		"foo": DialerFunc(func(network, address string, args Args) (net.Conn, error) {
+			return net.Dial(network, address)
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", findMethodAddr(t, buf.String(), "CMETHOD foo socks5 "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = socks5Connect(conn, server.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHalfClose(t, conn, received)
+}
@@ -0,0 +1,103 @@
+package pt
+
+import (
+	"net"
+)
+
+// ClientMux opens a SOCKS listener for each method of a client transport,
+// reports them to tor, and passes each SOCKS request to the method's handler.
+// It is for programs that want to handle SOCKS requests themselves (for
+// example, to reject some targets or to use Grant with a specific address),
+// rather than only provide a Dialer as with RunClient. Register a handler for
+// each supported method with Handle, then pass the method names from
+// ClientSetup to Listen:
+//
+//	var mux pt.ClientMux
+//	mux.Handle("foo", func(conn *pt.SocksConn) {
+//		remote, err := dialFoo(conn.Req.Target, conn.Req.Args)
+//		if err != nil {
+//			conn.Reject()
+//			return
+//		}
+//		defer remote.Close()
+//		err = conn.Grant(nil)
+//		if err != nil {
+//			return
+//		}
+//		go io.Copy(remote, conn)
+//		io.Copy(conn, remote)
+//	})
+//	listeners := mux.Listen(ptInfo.MethodNames)
+//
+// The zero value is an empty ClientMux ready to use.
+type ClientMux struct {
+	handlers map[string]func(*SocksConn)
+	// If not nil, used in place of listening on an ephemeral loopback port.
+	listen func(methodName string) (*SocksListener, error)
+	// Messages for CMETHOD-ERROR lines, for methods without handlers.
+	errs map[string]error
+}
+
+// Register handler as the handler for methodName, replacing any previous one.
+// The handler is called in its own goroutine, after the SOCKS request has been
+// read, and must call Grant or Reject on the connection. The connection is
+// closed after the handler returns.
+func (mux *ClientMux) Handle(methodName string, handler func(conn *SocksConn)) {
+	if mux.handlers == nil {
+		mux.handlers = make(map[string]func(*SocksConn))
+	}
+	mux.handlers[methodName] = handler
+}
+
+// For each of methodNames that has a handler, open a SOCKS listener on an
+// ephemeral loopback port and emit a CMETHOD line; emit a CMETHOD-ERROR line
+// if there is no handler for the method or if the listener cannot be opened.
+// Finally, emit CMETHODS DONE. Accepted SOCKS requests are passed to the
+// handlers until the listeners are closed.
+//
+// Returns the listeners that were opened, in the order of methodNames. Closing
+// them is the caller's responsibility.
+func (mux *ClientMux) Listen(methodNames []string) []*SocksListener {
+	var listeners []*SocksListener
+	for _, methodName := range methodNames {
+		handler, ok := mux.handlers[methodName]
+		if !ok {
+			if err, ok := mux.errs[methodName]; ok {
+				CmethodError(methodName, err.Error())
+			} else {
+				CmethodErrorReason(methodName, ReasonUnknownMethod, "no such method")
+			}
+			continue
+		}
+		var ln *SocksListener
+		var err error
+		if mux.listen != nil {
+			ln, err = mux.listen(methodName)
+		} else {
+			ln, err = listenSocksLoopback(methodName)
+		}
+		if err != nil {
+			CmethodErrorReason(methodName, ReasonBindFailed, err.Error())
+			continue
+		}
+		go AcceptLoop(ln, 0, func(conn net.Conn) {
+			handler(conn.(*SocksConn))
+		})
+		Cmethod(methodName, ln.Version(), ln.Addr())
+		listeners = append(listeners, ln)
+	}
+	CmethodsDone()
+	return listeners
+}
+
+// Open a SOCKS listener on an ephemeral loopback port, with its MethodName set
+// to methodName.
+func listenSocksLoopback(methodName string) (*SocksListener, error) {
+	ln, err := ListenSocks("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	ln.MethodName = methodName
+	ln.Access = DefaultSocksAccess
+	return ln, nil
+}
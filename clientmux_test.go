@@ -0,0 +1,68 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestClientMux(t *testing.T) {
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	targets := make(chan string, 1)
+	var mux ClientMux
+	mux.Handle("alpha", func(conn *SocksConn) {
+		targets <- conn.Req.Target
+		remote, err := net.Dial("tcp", conn.Req.Target)
+		if err != nil {
+			conn.Reject()
+			return
+		}
+		defer remote.Close()
+		err = conn.Grant(nil)
+		if err != nil {
+			return
+		}
+		copyLoop(context.Background(), conn, remote)
+	})
+	listeners := mux.Listen([]string{"alpha", "beta"})
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+	if len(listeners) != 1 {
+		t.Fatalf("got %d listeners", len(listeners))
+	}
+	expected := fmt.Sprintf("CMETHOD alpha socks5 %s\n", listeners[0].Addr()) +
+		"CMETHOD-ERROR beta unknown-method: no such method\n" +
+		"CMETHODS DONE\n"
+	if buf.String() != expected {
+		t.Errorf("got output %q, expected %q", buf.String(), expected)
+	}
+	if listeners[0].MethodName != "alpha" {
+		t.Errorf("listener has MethodName %q", listeners[0].MethodName)
+	}
+
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = socks5Connect(conn, echo.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := <-targets; target != echo.Addr().String() {
+		t.Errorf("handler got target %q, expected %q", target, echo.Addr())
+	}
+	checkEcho(t, conn)
+}
@@ -0,0 +1,102 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// ConnInfo describes the client connection that a context.Context belongs to.
+// RunClient, RunServer, ServeTransports, and the standalone modes make a
+// context for each connection they accept, carrying a ConnInfo, and cancel it
+// when the connection is finished. The context is passed to DialContext, if the
+// method's Dialer is a ContextDialer, and to DialOrContext.
+type ConnInfo struct {
+	// The transport method name.
+	MethodName string
+	// The address of the client that connected: for a client transport, the
+	// SOCKS client (usually tor); for a server transport, the remote
+	// transport client.
+	RemoteAddr net.Addr
+	// Per-connection arguments, as from the SOCKS username and password of
+	// a client connection. nil for server connections.
+	Args Args
+	// When the connection was accepted, before any SOCKS handshake.
+	Accepted time.Time
+}
+
+type connInfoKey struct{}
+
+// Return a copy of ctx that carries info.
+func ContextWithConnInfo(ctx context.Context, info *ConnInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+// Return the ConnInfo carried by ctx, if any.
+func ConnInfoFromContext(ctx context.Context) (*ConnInfo, bool) {
+	info, ok := ctx.Value(connInfoKey{}).(*ConnInfo)
+	return info, ok
+}
+
+// If not nil, ConnContext is called with the context of each connection
+// accepted by RunClient, RunServer, ServeTransports, and the standalone modes,
+// and the context it returns is used in its place. It may be used to attach a
+// PTTrace, or other values, to every connection. The ConnInfo of the
+// connection is available from ctx, and ctx is done when the connection is
+// finished.
+var ConnContext func(ctx context.Context) context.Context
+
+// Make the context for a connection accepted at the time accepted. The caller
+// must call the returned cancel function when the connection is finished. The
+// context passed to ConnContext is already cancelable, so that what
+// ConnContext attaches can watch for the end of the connection.
+func newConnContext(methodName string, remoteAddr net.Addr, args Args, accepted time.Time) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ContextWithConnInfo(context.Background(), &ConnInfo{
+		MethodName: methodName,
+		RemoteAddr: remoteAddr,
+		Args:       args,
+		Accepted:   accepted,
+	}))
+	if ConnContext != nil {
+		ctx = ConnContext(ctx)
+	}
+	return ctx, cancel
+}
+
+// ContextDialer may be implemented by a Dialer that can stop dialing when a
+// context is canceled, or that wants the ConnInfo of the connection it dials
+// for. If a Dialer is a ContextDialer, DialContext is called in place of Dial.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string, args Args) (net.Conn, error)
+}
+
+// Dial with d, using DialContext if d is a ContextDialer.
+func dialContext(ctx context.Context, d Dialer, network, address string, args Args) (net.Conn, error) {
+	if cd, ok := d.(ContextDialer); ok {
+		return cd.DialContext(ctx, network, address, args)
+	}
+	return d.Dial(network, address, args)
+}
+
+// Interrupt any blocked reads and writes on conn, by setting a deadline in the
+// past, if ctx is done before stop is called. stop waits for the watching
+// goroutine to finish and returns true if conn was interrupted, in which case
+// conn's deadline is no longer usable.
+func interruptOnDone(ctx context.Context, conn net.Conn) (stop func() bool) {
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		defer recoverPanic("connection interrupter", func() { interrupted <- false })
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-interrupted
+	}
+}
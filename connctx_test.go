@@ -0,0 +1,175 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConnInfoFromContext(t *testing.T) {
+	_, ok := ConnInfoFromContext(context.Background())
+	if ok {
+		t.Error("ConnInfo in empty context")
+	}
+
+	info := &ConnInfo{MethodName: "foo", Args: Args{"k": []string{"v"}}}
+	got, ok := ConnInfoFromContext(ContextWithConnInfo(context.Background(), info))
+	if !ok || got != info {
+		t.Errorf("got %v %v, expected %v", got, ok, info)
+	}
+}
+
+type contextDialerFunc func(ctx context.Context, network, address string, args Args) (net.Conn, error)
+
+func (f contextDialerFunc) Dial(network, address string, args Args) (net.Conn, error) {
+	panic("Dial called on a ContextDialer")
+}
+
+func (f contextDialerFunc) DialContext(ctx context.Context, network, address string, args Args) (net.Conn, error) {
+	return f(ctx, network, address, args)
+}
+
+func TestRunClientContextDialer(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "foo")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	ctxChan := make(chan context.Context, 1)
+	err := runClient(m, map[string]Dialer{
+		"foo": contextDialerFunc(func(ctx context.Context, network, address string, args Args) (net.Conn, error) {
+			ctxChan <- ctx
+			return net.Dial(network, address)
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "CMETHOD foo socks5 "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = socks5Connect(conn, echo.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+
+	ctx := <-ctxChan
+	info, ok := ConnInfoFromContext(ctx)
+	if !ok {
+		t.Fatal("no ConnInfo in context")
+	}
+	if info.MethodName != "foo" {
+		t.Errorf("MethodName %q, expected %q", info.MethodName, "foo")
+	}
+	if info.Accepted.IsZero() || info.Accepted.After(time.Now()) {
+		t.Errorf("Accepted %v", info.Accepted)
+	}
+	if !tcpAddrsEqual(info.RemoteAddr.(*net.TCPAddr), conn.LocalAddr().(*net.TCPAddr)) {
+		t.Errorf("RemoteAddr %v, expected %v", info.RemoteAddr, conn.LocalAddr())
+	}
+
+	// The context is canceled once the connection is closed.
+	if ctx.Err() != nil {
+		t.Errorf("context canceled while connection is open: %v", ctx.Err())
+	}
+	conn.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("context not canceled after connection closed")
+	}
+}
+
+func TestNewConnContext(t *testing.T) {
+	var got context.Context
+	ConnContext = func(ctx context.Context) context.Context {
+		got = ctx
+		return ctx
+	}
+	defer func() { ConnContext = nil }()
+	_, cancel := newConnContext("foo", nil, nil, time.Now())
+	if got == nil {
+		t.Fatal("ConnContext not called")
+	}
+	// The context passed to ConnContext ends with the connection.
+	cancel()
+	select {
+	case <-got.Done():
+	default:
+		t.Error("context passed to ConnContext not canceled")
+	}
+}
+
+func TestDialOrContextCanceled(t *testing.T) {
+	// An ExtORPort that accepts connections but never sends anything.
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	err = ioutil.WriteFile(cookiePath, append([]byte("! Extended ORPort Auth Cookie !\x0a"), make([]byte, 32)...), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &ServerInfo{
+		ExtendedOrAddr: ln.Addr().(*net.TCPAddr),
+		AuthCookiePath: cookiePath,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	begin := time.Now()
+	_, err = DialOrContext(ctx, info, "", "foo")
+	if err != context.Canceled {
+		t.Errorf("got error %v, expected %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(begin); elapsed > 4*time.Second {
+		t.Errorf("DialOrContext took %v after cancellation", elapsed)
+	}
+}
+
+func TestInterruptOnDone(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	stop := interruptOnDone(context.Background(), c1)
+	if stop() {
+		t.Error("interrupted with a context that is never done")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop = interruptOnDone(ctx, c1)
+	cancel()
+	_, err := c1.Read(make([]byte, 1))
+	if err == nil {
+		t.Error("read not interrupted")
+	}
+	if !stop() {
+		t.Error("interruption not reported")
+	}
+}
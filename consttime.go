@@ -0,0 +1,25 @@
+package pt
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// Return true iff a and b are equal, taking time that does not depend on their
+// contents. Unlike subtle.ConstantTimeCompare, it does not return early when
+// the lengths differ, so it does not reveal the length of a secret to someone
+// who can try guesses of different lengths. Use it for comparing credentials
+// such as auth cookies, hashes derived from them, and SOCKS passwords; the
+// library does so itself.
+func SecretEqual(a, b []byte) bool {
+	ha := sha256.Sum256(a)
+	hb := sha256.Sum256(b)
+	defer wipe(ha[:])
+	defer wipe(hb[:])
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// Like SecretEqual, for strings.
+func SecretEqualString(a, b string) bool {
+	return SecretEqual([]byte(a), []byte(b))
+}
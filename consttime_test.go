@@ -0,0 +1,107 @@
+package pt
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestSecretEqual(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"", "", true},
+		{"secret", "secret", true},
+		{"secret", "secreT", false},
+		{"secret", "secret2", false},
+		{"", "secret", false},
+	}
+	for _, test := range tests {
+		if SecretEqualString(test.a, test.b) != test.expected {
+			t.Errorf("SecretEqualString(%q, %q) != %v", test.a, test.b, test.expected)
+		}
+		if SecretEqual([]byte(test.a), []byte(test.b)) != test.expected {
+			t.Errorf("SecretEqual(%q, %q) != %v", test.a, test.b, test.expected)
+		}
+	}
+}
+
+// The functions that handle credentials, by file.
+var credentialFuncs = map[string][]string{
+	"pt.go":          {"ParseAuthCookie", "extOrPortAuthenticate"},
+	"socks.go":       {"socksAuthStatic"},
+	"socksaccess.go": {"checkCredentials"},
+}
+
+// Names of variables and fields that hold credentials.
+var credentialNames = map[string]bool{
+	"authCookie": true, "cookie": true,
+	"clientHash": true, "serverHash": true, "expectedServerHash": true,
+	"uname": true, "passwd": true, "username": true, "password": true,
+	"Username": true, "Password": true,
+}
+
+// Return true if e names a credential, possibly converted to a string.
+func isCredentialExpr(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return credentialNames[e.Name]
+	case *ast.SelectorExpr:
+		return credentialNames[e.Sel.Name]
+	case *ast.ParenExpr:
+		return isCredentialExpr(e.X)
+	case *ast.CallExpr:
+		if fun, ok := e.Fun.(*ast.Ident); ok && fun.Name == "string" && len(e.Args) == 1 {
+			return isCredentialExpr(e.Args[0])
+		}
+	}
+	return false
+}
+
+// Credentials are never compared in a way whose time depends on their
+// contents: not with bytes.Equal and the like, and not with == on strings.
+func TestCredentialComparisons(t *testing.T) {
+	variableTime := map[string]bool{
+		"bytes.Equal": true, "bytes.Compare": true, "bytes.HasPrefix": true,
+		"strings.Compare": true, "strings.EqualFold": true,
+		"reflect.DeepEqual": true,
+	}
+	fset := token.NewFileSet()
+	for filename, funcs := range credentialFuncs {
+		f, err := parser.ParseFile(fset, filename, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[string]bool)
+		for _, name := range funcs {
+			want[name] = true
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || !want[fn.Name.Name] {
+				continue
+			}
+			delete(want, fn.Name.Name)
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.CallExpr:
+					if sel, ok := n.Fun.(*ast.SelectorExpr); ok {
+						if pkg, ok := sel.X.(*ast.Ident); ok && variableTime[pkg.Name+"."+sel.Sel.Name] {
+							t.Errorf("%s: %s in %s", fset.Position(n.Pos()), pkg.Name+"."+sel.Sel.Name, fn.Name.Name)
+						}
+					}
+				case *ast.BinaryExpr:
+					if (n.Op == token.EQL || n.Op == token.NEQ) && (isCredentialExpr(n.X) || isCredentialExpr(n.Y)) {
+						t.Errorf("%s: %s comparison of a credential in %s", fset.Position(n.Pos()), n.Op, fn.Name.Name)
+					}
+				}
+				return true
+			})
+		}
+		for name := range want {
+			t.Errorf("%s: no function %s to audit", filename, name)
+		}
+	}
+}
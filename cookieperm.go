@@ -0,0 +1,58 @@
+package pt
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// AuthCookiePolicy says what to do when the auth cookie file named by
+// TOR_PT_AUTH_COOKIE_FILE has unsafe permissions. Anyone who can read the
+// cookie can authenticate to tor's extended ORPort and so spoof the client
+// addresses that tor records.
+type AuthCookiePolicy int
+
+const (
+	// Do not check the file's permissions.
+	AuthCookieIgnore AuthCookiePolicy = iota
+	// Emit a LOG warning if the file is unsafe, but use it anyway.
+	AuthCookieWarn
+	// Refuse to use a file that is unsafe. DialOr fails with an error.
+	AuthCookieStrict
+)
+
+// The policy applied each time the auth cookie file is read. A file is unsafe
+// if it is readable or writable by its group or by others, or (on Unix) if it is
+// owned by a user other than the user running the transport or root.
+// Permissions are not checked on Windows.
+var AuthCookiePermissions = AuthCookieIgnore
+
+// Check the permissions of the auth cookie file, whose FileInfo is fi,
+// according to AuthCookiePermissions. Returns an error only under
+// AuthCookieStrict.
+func checkAuthCookiePermissions(filename string, fi os.FileInfo) error {
+	if AuthCookiePermissions == AuthCookieIgnore || runtime.GOOS == "windows" {
+		return nil
+	}
+	problem := authCookieProblem(fi)
+	if problem == "" {
+		return nil
+	}
+	msg := fmt.Sprintf("auth cookie file %q %s", filename, problem)
+	if AuthCookiePermissions == AuthCookieStrict {
+		return fmt.Errorf("%s", msg)
+	}
+	Log(LogSeverityWarning, msg)
+	return nil
+}
+
+// Return a description of what is unsafe about the file, or "" if nothing is.
+func authCookieProblem(fi os.FileInfo) string {
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Sprintf("is accessible by group or others (mode %#o)", perm)
+	}
+	if uid, ok := fileOwner(fi); ok && uid != 0 && uid != os.Getuid() {
+		return fmt.Sprintf("is owned by another user (uid %d)", uid)
+	}
+	return ""
+}
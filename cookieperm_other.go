@@ -0,0 +1,13 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package pt
+
+import (
+	"os"
+)
+
+// File ownership is not checked on this platform.
+func fileOwner(fi os.FileInfo) (int, bool) {
+	return 0, false
+}
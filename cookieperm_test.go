@@ -0,0 +1,63 @@
+package pt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthCookiePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on Windows")
+	}
+	saved := AuthCookiePermissions
+	defer func() { AuthCookiePermissions = saved }()
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	filename := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, filename, bytes.Repeat([]byte{'A'}, 32), time.Unix(1000000000, 0))
+	err := os.Chmod(filename, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AuthCookiePermissions = AuthCookieIgnore
+	_, err = loadAuthCookie(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output with AuthCookieIgnore: %q", buf.String())
+	}
+
+	AuthCookiePermissions = AuthCookieWarn
+	_, err = loadAuthCookie(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "LOG SEVERITY=warning") || !strings.Contains(buf.String(), "group or others") {
+		t.Errorf("no warning with AuthCookieWarn: %q", buf.String())
+	}
+
+	AuthCookiePermissions = AuthCookieStrict
+	_, err = loadAuthCookie(filename, true)
+	if err == nil {
+		t.Error("unsafe file accepted with AuthCookieStrict")
+	}
+
+	err = os.Chmod(filename, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadAuthCookie(filename, true)
+	if err != nil {
+		t.Errorf("safe file rejected with AuthCookieStrict: %s", err)
+	}
+}
@@ -0,0 +1,133 @@
+package pt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// When the package was initialized, for Diagnostics.Uptime.
+var processStart = time.Now()
+
+// The name of the state file written by DumpDiagnostics.
+const diagnosticsFilename = "diagnostics.json"
+
+// Diagnostics is a snapshot of the state of a running transport, for debugging
+// a live bridge without attaching a debugger. It encodes as JSON.
+type Diagnostics struct {
+	Time time.Time `json:"time"`
+	// The time since the process started, as a time.Duration string.
+	Uptime string `json:"uptime"`
+	// The number of connections being handled under the ShutdownManager.
+	ActiveConns int `json:"active_conns"`
+	// The number of goroutines copying data between connections.
+	RelayGoroutines int64         `json:"relay_goroutines"`
+	Methods         []MethodStats `json:"methods"`
+	// The TOR_PT_* environment variables. The value of
+	// TOR_PT_SERVER_TRANSPORT_OPTIONS, which may hold secrets, is scrubbed.
+	Environment map[string]string `json:"environment"`
+	// The library's settings, by the name of their package variable.
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// Return a snapshot of the state of the process, with the connections of m.
+func TakeDiagnostics(m *ShutdownManager) *Diagnostics {
+	now := time.Now()
+	methods := Stats()
+	sort.Slice(methods, func(i, j int) bool { return methods[i].MethodName < methods[j].MethodName })
+	d := &Diagnostics{
+		Time:            now,
+		Uptime:          now.Sub(processStart).Round(time.Second).String(),
+		ActiveConns:     m.ActiveConns(),
+		RelayGoroutines: atomic.LoadInt64(&copyLoopGoroutines),
+		Methods:         methods,
+		Environment:     make(map[string]string),
+		Settings: map[string]interface{}{
+			"RelayBufferSize":           RelayBufferSize,
+			"RelayUpstreamBufferSize":   RelayUpstreamBufferSize,
+			"RelayDownstreamBufferSize": RelayDownstreamBufferSize,
+			"MaxConcurrentHandshakes":   MaxConcurrentHandshakes,
+			"ORPoolSize":                ORPoolSize,
+			"ListenersPerBindaddr":      ListenersPerBindaddr,
+			"ListenTCPOptions":          ListenTCPOptions,
+			"SafeLogging":               SafeLogging,
+			"AcceptedConnTimeouts":      AcceptedConnTimeouts,
+			"RemoteConnTimeouts":        RemoteConnTimeouts,
+			"PerConnRateLimit":          PerConnRateLimit,
+			"DefaultWorkerPool":         DefaultWorkerPool != nil,
+			"DefaultAdmissionControl":   DefaultAdmissionControl != nil,
+		},
+	}
+	for _, kv := range ptEnviron() {
+		if !strings.HasPrefix(kv, "TOR_PT_") {
+			continue
+		}
+		key, value := kv, ""
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			key, value = kv[:i], kv[i+1:]
+		}
+		if key == "TOR_PT_SERVER_TRANSPORT_OPTIONS" && value != "" {
+			value = scrubbed
+		}
+		d.Environment[key] = value
+	}
+	return d
+}
+
+// Write a snapshot from TakeDiagnostics(m) as JSON to the file
+// "diagnostics.json" in the state directory, replacing any earlier one, and log
+// its name. If there is no state directory, log the JSON itself in a LOG line
+// instead.
+func DumpDiagnostics(m *ShutdownManager) error {
+	d := TakeDiagnostics(m)
+	if getenv("TOR_PT_STATE_LOCATION") == "" {
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		Log(LogSeverityNotice, "diagnostics: "+string(data))
+		return nil
+	}
+	err := WriteStateJSON(diagnosticsFilename, d)
+	if err != nil {
+		Log(LogSeverityWarning, "cannot write diagnostics: "+err.Error())
+		return err
+	}
+	filename, _ := stateFilePath(diagnosticsFilename)
+	Log(LogSeverityNotice, fmt.Sprintf("wrote diagnostics to %s", filename))
+	return nil
+}
+
+// Call DumpDiagnostics(m) on each SIGUSR1, until m begins to shut down, instead
+// of exiting as a SIGUSR1 otherwise would. Returns immediately. On platforms
+// without SIGUSR1, such as Windows, it does nothing. RunClient, RunServer,
+// RunClientStandalone, RunServerStandalone, and ServeTransports call
+// HandleDiagnosticsSignals(DefaultShutdownManager).
+func HandleDiagnosticsSignals(m *ShutdownManager) {
+	if len(diagnosticsSignals) == 0 {
+		return
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, diagnosticsSignals...)
+	go func() {
+		defer signal.Stop(sigChan)
+		handleDiagnosticsSignals(m, sigChan)
+	}()
+}
+
+func handleDiagnosticsSignals(m *ShutdownManager, sigChan <-chan os.Signal) {
+	closed := m.closed()
+	for {
+		select {
+		case <-sigChan:
+			DumpDiagnostics(m)
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTakeDiagnostics(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_SERVER_TRANSPORT_OPTIONS", "foo:secret=hunter2")
+	os.Setenv("OTHER", "x")
+	m := new(ShutdownManager)
+	d := TakeDiagnostics(m)
+	expected := map[string]string{
+		"TOR_PT_MANAGED_TRANSPORT_VER":    "1",
+		"TOR_PT_SERVER_TRANSPORT_OPTIONS": "[scrubbed]",
+	}
+	if len(d.Environment) != len(expected) {
+		t.Errorf("got environment %q (expected %q)", d.Environment, expected)
+	}
+	for key, value := range expected {
+		if d.Environment[key] != value {
+			t.Errorf("%s: got %q (expected %q)", key, d.Environment[key], value)
+		}
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Errorf("secret in diagnostics %s", data)
+	}
+}
+
+func TestDumpDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	os.Clearenv()
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+
+	// With no state directory, the dump goes to the log.
+	err := DumpDiagnostics(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), `LOG SEVERITY=notice MESSAGE="diagnostics: {`) {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	t.Setenv("TOR_PT_STATE_LOCATION", t.TempDir())
+	buf.Reset()
+	err = DumpDiagnostics(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d Diagnostics
+	err = ReadStateJSON(diagnosticsFilename, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Uptime == "" || d.Settings == nil {
+		t.Errorf("incomplete diagnostics %+v", d)
+	}
+	if !strings.Contains(buf.String(), "wrote diagnostics to ") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
@@ -0,0 +1,190 @@
+package pt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// If ReportAllEnvErrors is true, ClientSetup and ServerSetup do not stop at the
+// first problem they find in the environment, but check every TOR_PT_*
+// variable, emit a single ENV-ERROR line describing all the problems, and
+// return them as an EnvErrors. This helps an operator fix several torrc
+// mistakes at once. (A missing or unsupported TOR_PT_MANAGED_TRANSPORT_VER still
+// stops setup immediately, and an invalid TOR_PT_PROXY is still returned
+// separately by ClientSetup, for the caller to report with ProxyError.)
+var ReportAllEnvErrors bool
+
+// EnvErrors is the error returned by ClientSetup and ServerSetup when
+// ReportAllEnvErrors is set and there are problems with the environment. It
+// contains a description of each problem.
+type EnvErrors []string
+
+// Return the descriptions of the problems, separated by "; ".
+func (errs EnvErrors) Error() string {
+	return strings.Join(errs, "; ")
+}
+
+// Accumulates environment problems during setup. When not collecting all
+// problems, each problem is emitted as an ENV-ERROR immediately and returned as
+// an error, so that setup stops.
+type envChecker struct {
+	all  bool
+	errs EnvErrors
+	// The options of the setup, which may be nil.
+	o *setupOptions
+}
+
+func newEnvChecker(o *setupOptions) *envChecker {
+	return &envChecker{all: ReportAllEnvErrors, o: o}
+}
+
+// Record a problem with the environment. Returns a non-nil error if setup
+// should stop now.
+func (c *envChecker) problem(msg string) error {
+	if !c.all {
+		return c.o.envError(msg)
+	}
+	c.errs = append(c.errs, msg)
+	return nil
+}
+
+// Get the value of a required environment variable, recording a problem if it
+// is not set.
+func (c *envChecker) getenvRequired(key string) (string, error) {
+	value := c.o.getenv(key)
+	if value == "" {
+		return "", c.problem("no " + key + " environment variable")
+	}
+	return value, nil
+}
+
+// Return nil if no problems were recorded. Otherwise, emit an ENV-ERROR listing
+// all of them and return them as an EnvErrors.
+func (c *envChecker) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	c.o.emit(Event{Keyword: "ENV-ERROR", Message: c.errs.Error(), LineArgs: []string{c.errs.Error()}})
+	return c.errs
+}
+
+// If StrictEnv is true, ClientSetup and ServerSetup emit a LOG warning for
+// each environment variable whose name begins with "TOR_PT_" but that is not
+// defined by pt-spec.txt (for example, a misspelled TOR_PT_SERVER_BINDADR), and
+// for each defined variable whose value has surrounding whitespace or is
+// otherwise malformed in a way that setup would silently accept. Such mistakes
+// may otherwise result only in missing transports.
+var StrictEnv bool
+
+// The environment variables defined by pt-spec.txt.
+var knownEnvVars = []string{
+	"TOR_PT_MANAGED_TRANSPORT_VER",
+	"TOR_PT_STATE_LOCATION",
+	"TOR_PT_EXIT_ON_STDIN_CLOSE",
+	"TOR_PT_OUTBOUND_BIND_ADDRESS_V4",
+	"TOR_PT_OUTBOUND_BIND_ADDRESS_V6",
+	"TOR_PT_CLIENT_TRANSPORTS",
+	"TOR_PT_PROXY",
+	"TOR_PT_SERVER_TRANSPORTS",
+	"TOR_PT_SERVER_TRANSPORT_OPTIONS",
+	"TOR_PT_SERVER_BINDADDR",
+	"TOR_PT_ORPORT",
+	"TOR_PT_EXTENDED_SERVER_PORT",
+	"TOR_PT_AUTH_COOKIE_FILE",
+	envFileVar,
+}
+
+// If StrictEnv or WithStrictValidation is set, log a warning for each problem
+// found by strictEnvProblems in the environment of the setup.
+func checkStrictEnv(o *setupOptions) {
+	if !o.strictEnv() {
+		return
+	}
+	for _, msg := range strictEnvProblems(o.ptEnviron()) {
+		o.log(LogSeverityWarning, msg)
+	}
+}
+
+// Return a description of each unknown or malformed TOR_PT_* variable in env,
+// a list of "key=value" strings as returned by os.Environ.
+func strictEnvProblems(env []string) []string {
+	var problems []string
+	for _, kv := range env {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			continue
+		}
+		key, value := kv[:i], kv[i+1:]
+		if !strings.HasPrefix(key, "TOR_PT_") {
+			continue
+		}
+		if !isKnownEnvVar(key) {
+			msg := fmt.Sprintf("unknown environment variable %s", key)
+			if suggestion := closestEnvVar(key); suggestion != "" {
+				msg += fmt.Sprintf(" (did you mean %s?)", suggestion)
+			}
+			problems = append(problems, msg)
+			continue
+		}
+		if strings.TrimSpace(value) != value {
+			problems = append(problems, fmt.Sprintf("%s has leading or trailing whitespace: %q", key, value))
+			continue
+		}
+		if key == "TOR_PT_EXIT_ON_STDIN_CLOSE" && value != "" && value != "0" && value != "1" {
+			problems = append(problems, fmt.Sprintf("%s should be 0 or 1, not %q", key, value))
+		}
+	}
+	return problems
+}
+
+func isKnownEnvVar(key string) bool {
+	for _, known := range knownEnvVars {
+		if key == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the known variable name closest to key, if it is within an edit
+// distance of 2, or else "".
+func closestEnvVar(key string) string {
+	best, bestDist := "", 3
+	for _, known := range knownEnvVars {
+		if d := editDistance(key, known); d < bestDist {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+// Return the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,153 @@
+package pt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestServerSetupReportAllEnvErrors(t *testing.T) {
+	saved := ReportAllEnvErrors
+	defer func() { ReportAllEnvErrors = saved }()
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	t.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	t.Setenv("TOR_PT_SERVER_TRANSPORTS", "")
+	t.Setenv("TOR_PT_SERVER_BINDADDR", "alpha-bogus")
+	t.Setenv("TOR_PT_SERVER_TRANSPORT_OPTIONS", "")
+	t.Setenv("TOR_PT_ORPORT", "")
+	t.Setenv("TOR_PT_EXTENDED_SERVER_PORT", "127.0.0.1:bogus")
+	t.Setenv("TOR_PT_AUTH_COOKIE_FILE", "")
+
+	// By default, only the first problem is reported.
+	ReportAllEnvErrors = false
+	_, err := ServerSetup(nil)
+	if err == nil {
+		t.Fatal("bad environment unexpectedly succeeded")
+	}
+	if _, ok := err.(EnvErrors); ok {
+		t.Errorf("got EnvErrors with ReportAllEnvErrors unset: %v", err)
+	}
+	if n := strings.Count(buf.String(), "ENV-ERROR"); n != 1 {
+		t.Errorf("%d ENV-ERROR lines: %q", n, buf.String())
+	}
+
+	buf.Reset()
+	ReportAllEnvErrors = true
+	_, err = ServerSetup(nil)
+	errs, ok := err.(EnvErrors)
+	if !ok {
+		t.Fatalf("got %T %v, expected EnvErrors", err, err)
+	}
+	for _, s := range []string{
+		"TOR_PT_SERVER_BINDADDR",
+		"TOR_PT_SERVER_TRANSPORTS",
+		"TOR_PT_AUTH_COOKIE_FILE",
+		"TOR_PT_EXTENDED_SERVER_PORT",
+	} {
+		if !strings.Contains(errs.Error(), s) {
+			t.Errorf("no problem mentioning %s in %q", s, errs.Error())
+		}
+	}
+	if len(errs) != 4 {
+		t.Errorf("got %d problems: %q", len(errs), errs)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[0] != "VERSION 1" || lines[1] != "ENV-ERROR "+errs.Error() {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	// A good environment still succeeds.
+	buf.Reset()
+	t.Setenv("TOR_PT_SERVER_TRANSPORTS", "alpha")
+	t.Setenv("TOR_PT_SERVER_BINDADDR", "alpha-127.0.0.1:0")
+	t.Setenv("TOR_PT_ORPORT", "127.0.0.1:9001")
+	t.Setenv("TOR_PT_EXTENDED_SERVER_PORT", "")
+	info, err := ServerSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Bindaddrs) != 1 || info.OrAddr == nil {
+		t.Errorf("unexpected ServerInfo %+v", info)
+	}
+}
+
+func TestClientSetupReportAllEnvErrors(t *testing.T) {
+	saved := ReportAllEnvErrors
+	ReportAllEnvErrors = true
+	defer func() { ReportAllEnvErrors = saved }()
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	t.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	t.Setenv("TOR_PT_CLIENT_TRANSPORTS", "")
+	t.Setenv("TOR_PT_PROXY", "")
+	_, err := ClientSetup(nil)
+	errs, ok := err.(EnvErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("got %T %v, expected EnvErrors of length 1", err, err)
+	}
+	if !strings.Contains(buf.String(), "ENV-ERROR no TOR_PT_CLIENT_TRANSPORTS environment variable") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestStrictEnvProblems(t *testing.T) {
+	env := []string{
+		"HOME=/root",
+		"TOR_PT_SERVER_BINDADDR=alpha-127.0.0.1:0",
+		"TOR_PT_SERVER_BINDADR=alpha-127.0.0.1:0",
+		"TOR_PT_FOO=bar",
+		"TOR_PT_ORPORT= 127.0.0.1:9001",
+		"TOR_PT_EXIT_ON_STDIN_CLOSE=yes",
+		"TOR_PT_STATE_LOCATION=/var/lib/tor/pt_state",
+	}
+	expected := []string{
+		"unknown environment variable TOR_PT_SERVER_BINDADR (did you mean TOR_PT_SERVER_BINDADDR?)",
+		"unknown environment variable TOR_PT_FOO",
+		`TOR_PT_ORPORT has leading or trailing whitespace: " 127.0.0.1:9001"`,
+		`TOR_PT_EXIT_ON_STDIN_CLOSE should be 0 or 1, not "yes"`,
+	}
+	problems := strictEnvProblems(env)
+	if !stringSlicesEqual(problems, expected) {
+		t.Errorf("got %q, expected %q", problems, expected)
+	}
+}
+
+func TestStrictEnvSetup(t *testing.T) {
+	saved := StrictEnv
+	defer func() { StrictEnv = saved }()
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	t.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	t.Setenv("TOR_PT_CLIENT_TRANSPORTS", "alpha")
+	t.Setenv("TOR_PT_PROXY", "")
+	t.Setenv("TOR_PT_CLIENT_TRANSPORT", "alpha")
+
+	StrictEnv = false
+	_, err := ClientSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "LOG") {
+		t.Errorf("unexpected warning with StrictEnv unset: %q", buf.String())
+	}
+
+	buf.Reset()
+	StrictEnv = true
+	_, err = ClientSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "LOG SEVERITY=warning MESSAGE=\"unknown environment variable TOR_PT_CLIENT_TRANSPORT (did you mean TOR_PT_CLIENT_TRANSPORTS?)\"") {
+		t.Errorf("no warning with StrictEnv set: %q", buf.String())
+	}
+}
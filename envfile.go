@@ -0,0 +1,103 @@
+package pt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// If TOR_PT_ENV_FILE is set, it names a file of further TOR_PT_* variables,
+// for container setups that can mount a file more easily than they can set
+// environment variables. The file has the format of "docker run --env-file":
+// one KEY=VALUE per line, with no quoting; blank lines and lines beginning with
+// "#" are ignored. Only TOR_PT_* variables may be set in the file. A variable
+// set in the process environment takes precedence over one in the file. The
+// file is read once, the first time a variable is looked up; later changes to
+// it are not seen.
+const envFileVar = "TOR_PT_ENV_FILE"
+
+// The contents of the file named by TOR_PT_ENV_FILE, kept until the variable
+// changes.
+var envFile struct {
+	sync.Mutex
+	name    string
+	environ []string
+	err     error
+}
+
+// Return the variables in the file named by TOR_PT_ENV_FILE, as "key=value"
+// strings, or nil if it is not set. Returns an error if the file cannot be read
+// or parsed.
+func envFileEnviron() ([]string, error) {
+	name := os.Getenv(envFileVar)
+	if name == "" {
+		return nil, nil
+	}
+	envFile.Lock()
+	defer envFile.Unlock()
+	if name == envFile.name {
+		return envFile.environ, envFile.err
+	}
+	envFile.name = name
+	f, err := os.Open(name)
+	if err != nil {
+		envFile.environ, envFile.err = nil, err
+	} else {
+		envFile.environ, envFile.err = parseEnvFile(f)
+		f.Close()
+	}
+	return envFile.environ, envFile.err
+}
+
+// Parse the lines of an environment file.
+func parseEnvFile(r io.Reader) ([]string, error) {
+	var environ []string
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimLeft(s.Text(), " \t")
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		if !strings.HasPrefix(line[:i], "TOR_PT_") {
+			return nil, fmt.Errorf("line %d: %s is not a TOR_PT_* variable", n, line[:i])
+		}
+		environ = append(environ, line)
+	}
+	return environ, s.Err()
+}
+
+// Return an ENV-ERROR if TOR_PT_ENV_FILE is set but the file cannot be read. The
+// file is not read if WithEnviron is given.
+func checkEnvFile(o *setupOptions) error {
+	if o != nil && o.haveEnviron {
+		return nil
+	}
+	_, err := envFileEnviron()
+	if err != nil {
+		return o.envError(fmt.Sprintf("cannot read %s: %s", envFileVar, err.Error()))
+	}
+	return nil
+}
+
+// Return the environment that getenv reads, as "key=value" strings: the
+// process environment followed by the variables from TOR_PT_ENV_FILE that it
+// does not set.
+func ptEnviron() []string {
+	environ := os.Environ()
+	fileEnviron, _ := envFileEnviron()
+	for _, kv := range fileEnviron {
+		key := kv[:strings.IndexByte(kv, '=')]
+		if _, ok := os.LookupEnv(key); !ok {
+			environ = append(environ, kv)
+		}
+	}
+	return environ
+}
@@ -0,0 +1,69 @@
+package pt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	environ, err := parseEnvFile(strings.NewReader("# comment\n\nTOR_PT_ORPORT=127.0.0.1:9001\r\n  TOR_PT_SERVER_TRANSPORT_OPTIONS=foo:a=b=c\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSlicesEqual(environ, []string{"TOR_PT_ORPORT=127.0.0.1:9001", "TOR_PT_SERVER_TRANSPORT_OPTIONS=foo:a=b=c"}) {
+		t.Errorf("got %q", environ)
+	}
+	for _, input := range []string{"TOR_PT_ORPORT\n", "=value\n", "PATH=/bin\n", "TOR_PT_ORPORT=127.0.0.1:9001\nHOME=/root\n"} {
+		_, err := parseEnvFile(strings.NewReader(input))
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestEnvFile(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	filename := filepath.Join(t.TempDir(), "pt.env")
+	err := ioutil.WriteFile(filename, []byte("TOR_PT_MANAGED_TRANSPORT_VER=1\nTOR_PT_CLIENT_TRANSPORTS=foo,bar\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Clearenv()
+	os.Setenv("TOR_PT_ENV_FILE", filename)
+	// The process environment takes precedence.
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "baz")
+
+	if !Managed() {
+		t.Error("!Managed() with TOR_PT_MANAGED_TRANSPORT_VER in the file")
+	}
+	info, err := ClientSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSlicesEqual(info.MethodNames, []string{"baz"}) {
+		t.Errorf("got method names %q (expected [baz])", info.MethodNames)
+	}
+
+	// The file is not read again.
+	err = os.Remove(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Managed() {
+		t.Error("!Managed() after the file was removed")
+	}
+
+	os.Setenv("TOR_PT_ENV_FILE", filename+".missing")
+	_, err = ClientSetup(nil)
+	if err == nil {
+		t.Error("setup with a missing env file unexpectedly succeeded")
+	}
+	if !strings.HasPrefix(buf.String(), "VERSION 1\n") || !strings.Contains(buf.String(), "ENV-ERROR cannot read TOR_PT_ENV_FILE: ") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
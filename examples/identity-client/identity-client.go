@@ -0,0 +1,37 @@
+// Identity pluggable transport client, which passes traffic through unchanged.
+// It does the same as dummy-client, but is built on pt.RunClient, which does
+// the setup, SOCKS handling, relaying, and shutdown that dummy-client does by
+// hand. Works only as a managed proxy.
+//
+// Usage (in torrc):
+//
+//	UseBridges 1
+//	Bridge identity X.X.X.X:YYYY
+//	ClientTransportPlugin identity exec identity-client
+//
+// Because this transport doesn't do anything to the traffic, you can use the
+// ORPort of any ordinary bridge (or relay that has DirPort set) in the bridge
+// line; it doesn't have to declare support for the identity transport.
+package main
+
+import (
+	"net"
+	"os"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Connect directly to the bridge; a real transport would obfuscate the
+// connection here, using args from the bridge line.
+func dial(network, address string, args pt.Args) (net.Conn, error) {
+	return net.Dial(network, address)
+}
+
+func main() {
+	err := pt.RunClient(map[string]pt.Dialer{
+		"identity": pt.DialerFunc(dial),
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+}
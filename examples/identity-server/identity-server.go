@@ -0,0 +1,35 @@
+// Identity pluggable transport server, which passes traffic through unchanged.
+// It does the same as dummy-server, but is built on pt.RunServer, which does
+// the setup, listening, connecting to the ORPort, relaying, and shutdown that
+// dummy-server does by hand. Works only as a managed proxy.
+//
+// Usage (in torrc):
+//
+//	BridgeRelay 1
+//	ORPort 9001
+//	ExtORPort 6669
+//	ServerTransportPlugin identity exec identity-server
+//	ServerTransportListenAddr identity 0.0.0.0:4444
+package main
+
+import (
+	"net"
+	"os"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Return the connection unchanged; a real transport would remove its
+// obfuscation here.
+func unwrap(conn net.Conn) (net.Conn, error) {
+	return conn, nil
+}
+
+func main() {
+	err := pt.RunServer(map[string]func(net.Conn) (net.Conn, error){
+		"identity": unwrap,
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,70 @@
+package pt
+
+import (
+	"fmt"
+	"sync"
+)
+
+// If LogExtOrPortDeny is true, the first time the extended ORPort answers DENY
+// for a transport, DialOr emits a LOG warning with the ExtOrPortDenyError and
+// its Hint, so that an operator sees the likely cause without having to
+// inspect the error returned to the program.
+var LogExtOrPortDeny bool
+
+// The transport method names for which a DENY has been logged.
+var loggedExtOrPortDeny sync.Map
+
+// ExtOrPortDenyError is the error returned by DialOr when the extended ORPort
+// answers DENY to the USERADDR and TRANSPORT commands. tor does not say which
+// command it rejected; Command is the one that more likely caused it, "USERADDR"
+// or "TRANSPORT", or "" if neither was sent.
+type ExtOrPortDenyError struct {
+	UserAddr  string
+	Transport string
+	Command   string
+}
+
+// Return an *ExtOrPortDenyError for a DENY answering the given USERADDR and
+// TRANSPORT (either of which may be "", if not sent).
+func newExtOrPortDenyError(addr, methodName string) *ExtOrPortDenyError {
+	e := &ExtOrPortDenyError{UserAddr: addr, Transport: methodName}
+	if addr != "" {
+		if _, err := ParseAddrPort(addr); err != nil || methodName == "" {
+			e.Command = "USERADDR"
+			return e
+		}
+	}
+	if methodName != "" {
+		e.Command = "TRANSPORT"
+	}
+	return e
+}
+
+func (e *ExtOrPortDenyError) Error() string {
+	if e.Command == "" {
+		return "server returned DENY after our DONE"
+	}
+	return fmt.Sprintf("server returned DENY after our USERADDR and DONE (probably for %s)", e.Command)
+}
+
+// Return a suggestion of what to check to fix the cause of the DENY.
+func (e *ExtOrPortDenyError) Hint() string {
+	switch e.Command {
+	case "USERADDR":
+		return fmt.Sprintf("the client address %q may not be an IP address and port that tor accepts", e.UserAddr)
+	case "TRANSPORT":
+		return fmt.Sprintf("check that the transport name %q matches a ServerTransportPlugin line in torrc", e.Transport)
+	}
+	return "check the tor log for why the extended ORPort connection was refused"
+}
+
+// If LogExtOrPortDeny is set, log e and its hint, once per transport.
+func logExtOrPortDeny(e *ExtOrPortDenyError) {
+	if !LogExtOrPortDeny {
+		return
+	}
+	if _, logged := loggedExtOrPortDeny.LoadOrStore(e.Transport, true); logged {
+		return
+	}
+	LogMethod(e.Transport, LogSeverityWarning, fmt.Sprintf("extended ORPort: %s; %s", e.Error(), e.Hint()))
+}
@@ -0,0 +1,61 @@
+package pt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExtOrPortDenyError(t *testing.T) {
+	tests := []struct {
+		addr, methodName string
+		command          string
+	}{
+		{"", "", ""},
+		{"127.0.0.1:40000", "", "USERADDR"},
+		{"127.0.0.1:40000", "alpha", "TRANSPORT"},
+		{"", "alpha", "TRANSPORT"},
+		{"bogus", "alpha", "USERADDR"},
+	}
+	for _, test := range tests {
+		var buf mockSetMetadataBuf
+		err := extOrPortSendCommand(&buf.ReadBuf, extOrCmdDeny, []byte{})
+		if err != nil {
+			panic(err)
+		}
+		err = extOrPortSetMetadata(&buf, test.addr, test.methodName)
+		var denyErr *ExtOrPortDenyError
+		if !errors.As(err, &denyErr) {
+			t.Errorf("%q %q: got %v, expected *ExtOrPortDenyError", test.addr, test.methodName, err)
+			continue
+		}
+		if denyErr.Command != test.command {
+			t.Errorf("%q %q: Command %q, expected %q", test.addr, test.methodName, denyErr.Command, test.command)
+		}
+	}
+}
+
+func TestLogExtOrPortDeny(t *testing.T) {
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+	defer func() { LogExtOrPortDeny = false }()
+	loggedExtOrPortDeny.Delete("logdenytest")
+
+	e := newExtOrPortDenyError("127.0.0.1:40000", "logdenytest")
+	logExtOrPortDeny(e)
+	if buf.Len() != 0 {
+		t.Errorf("logged with LogExtOrPortDeny unset: %q", buf.String())
+	}
+	LogExtOrPortDeny = true
+	logExtOrPortDeny(e)
+	logExtOrPortDeny(e)
+	if n := strings.Count(buf.String(), "LOG "); n != 1 {
+		t.Errorf("%d LOG lines, expected 1: %q", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "ServerTransportPlugin") {
+		t.Errorf("no hint in %q", buf.String())
+	}
+}
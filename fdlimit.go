@@ -0,0 +1,103 @@
+package pt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// If ReserveFD is true, AcceptLoop (and so RunClient and RunServer) holds one
+// file descriptor in reserve. When Accept fails because the process is out of
+// file descriptors, the connection waiting to be accepted cannot be taken from
+// the listen queue, and its client waits until it times out. With the reserve,
+// the reserve descriptor is closed, so that the waiting connection can be
+// accepted and closed at once, and then the reserve is opened again. Set
+// ReserveFD before starting any accept loop.
+var ReserveFD bool
+
+// The reserved descriptor of ReserveFD.
+var reserveFD struct {
+	once sync.Once
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// How often to log that file descriptors are exhausted, at most.
+const fdExhaustedLogInterval = 10 * time.Second
+
+// The time of the last log of exhaustion, in Unix nanoseconds.
+var fdExhaustedLogged int64
+
+// Return true if err means that the process or system is out of file
+// descriptors (EMFILE or ENFILE).
+func isFDExhausted(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	for _, e := range fdExhaustedErrnos {
+		if errno == e {
+			return true
+		}
+	}
+	return false
+}
+
+// If err means that file descriptors are exhausted, log a warning with the
+// limit, unless one was logged recently, and return true. what describes the
+// operation that failed.
+func checkFDExhausted(err error, what string) bool {
+	if !isFDExhausted(err) {
+		return false
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&fdExhaustedLogged)
+	if now-last < int64(fdExhaustedLogInterval) || !atomic.CompareAndSwapInt64(&fdExhaustedLogged, last, now) {
+		return true
+	}
+	msg := fmt.Sprintf("%s: out of file descriptors: %s", what, err.Error())
+	if limit := fdLimit(); limit > 0 {
+		msg += fmt.Sprintf(" (limit %d)", limit)
+	}
+	Log(LogSeverityWarning, msg)
+	return true
+}
+
+// Open the reserve descriptor, if ReserveFD is set and it is not open.
+func openReserveFD() {
+	if !ReserveFD {
+		return
+	}
+	reserveFD.mu.Lock()
+	defer reserveFD.mu.Unlock()
+	if reserveFD.f == nil {
+		reserveFD.f, _ = os.Open(os.DevNull)
+	}
+}
+
+// Open the reserve descriptor the first time an accept loop starts.
+func startReserveFD() {
+	reserveFD.once.Do(openReserveFD)
+}
+
+// Free the reserve descriptor, accept one connection from ln and close it, and
+// take the reserve again. Does nothing if there is no reserve.
+func shedWithReserveFD(ln net.Listener) {
+	reserveFD.mu.Lock()
+	f := reserveFD.f
+	reserveFD.f = nil
+	reserveFD.mu.Unlock()
+	if f == nil {
+		return
+	}
+	f.Close()
+	if conn, err := ln.Accept(); err == nil {
+		conn.Close()
+	}
+	openReserveFD()
+}
@@ -0,0 +1,12 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package pt
+
+import "syscall"
+
+var fdExhaustedErrnos []syscall.Errno
+
+func fdLimit() uint64 {
+	return 0
+}
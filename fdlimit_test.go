@@ -0,0 +1,67 @@
+package pt
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckFDExhausted(t *testing.T) {
+	if len(fdExhaustedErrnos) == 0 {
+		t.Skip("no file descriptor exhaustion errors on this platform")
+	}
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+	fdExhaustedLogged = 0
+
+	err := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", fdExhaustedErrnos[0])}
+	if !checkFDExhausted(err, "accept") || !checkFDExhausted(err, "accept") {
+		t.Errorf("%v not recognized", err)
+	}
+	if n := strings.Count(buf.String(), "LOG "); n != 1 {
+		t.Errorf("%d LOG lines, expected 1: %q", n, buf.String())
+	}
+	if checkFDExhausted(errors.New("other"), "accept") {
+		t.Errorf("other error recognized")
+	}
+}
+
+func TestShedWithReserveFD(t *testing.T) {
+	defer func() { ReserveFD = false }()
+	ReserveFD = true
+	openReserveFD()
+	reserveFD.mu.Lock()
+	if reserveFD.f == nil {
+		t.Fatal("reserve not opened")
+	}
+	reserveFD.mu.Unlock()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	shedWithReserveFD(ln)
+	// The waiting connection was accepted and closed.
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(c); err != nil {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	reserveFD.mu.Lock()
+	if reserveFD.f == nil {
+		t.Error("reserve not reopened")
+	}
+	reserveFD.mu.Unlock()
+}
@@ -0,0 +1,18 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package pt
+
+import "syscall"
+
+// The errors that mean the process or system is out of file descriptors.
+var fdExhaustedErrnos = []syscall.Errno{syscall.EMFILE, syscall.ENFILE}
+
+// Return the soft limit on open file descriptors, or 0 if it is unknown.
+func fdLimit() uint64 {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0
+	}
+	return uint64(rl.Cur)
+}
@@ -0,0 +1,14 @@
+package pt
+
+import "syscall"
+
+// The errors that mean the process is out of sockets. Package syscall does not
+// define WSAEMFILE.
+var fdExhaustedErrnos = []syscall.Errno{
+	10024, // WSAEMFILE
+}
+
+// Windows has no limit on open file descriptors as such.
+func fdLimit() uint64 {
+	return 0
+}
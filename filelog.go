@@ -0,0 +1,148 @@
+package pt
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// FileLogger is an append-only log file in the state directory, for debug
+// output that would be too much for tor's LOG lines. It is an io.Writer, so it
+// may be used with the log package:
+//
+//	fl, err := pt.OpenStateLog("debug.log")
+//	if err != nil {
+//		...
+//	}
+//	defer fl.Close()
+//	logger := log.New(fl, "", log.LstdFlags)
+//
+// or as the Writer of a MethodLogging. The file is readable and writable only
+// by its owner. When it grows larger than MaxSize, or is older than MaxAge, it
+// is rotated: "debug.log" becomes "debug.log.1", "debug.log.1" becomes
+// "debug.log.2", and so on, keeping at most MaxBackups old files. Set the
+// fields before the first Write. A FileLogger may be used by several goroutines
+// at once.
+type FileLogger struct {
+	// The size in bytes at which the file is rotated. If not positive, 10
+	// MiB.
+	MaxSize int64
+	// If positive, the file is also rotated when it has been open this long.
+	MaxAge time.Duration
+	// The number of rotated files to keep. If negative, none; if zero, 3.
+	MaxBackups int
+
+	filename string
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	opened   time.Time
+}
+
+// Open the log file called name in the state directory (see MakeStateDir),
+// appending to it if it exists.
+func OpenStateLog(name string) (*FileLogger, error) {
+	filename, err := stateFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	l := &FileLogger{filename: filename}
+	err = l.open()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Open l.filename for appending, with mode 0600 even if it already existed with
+// a broader one.
+func (l *FileLogger) open() error {
+	f, err := os.OpenFile(l.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if fi.Mode().Perm()&0077 != 0 && runtime.GOOS != "windows" {
+		err = f.Chmod(0600)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	l.f = f
+	l.size = fi.Size()
+	l.opened = time.Now()
+	return nil
+}
+
+// Write p to the file, rotating it first if p would make it larger than
+// MaxSize or if it is older than MaxAge.
+func (l *FileLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+	maxSize := l.MaxSize
+	if maxSize <= 0 {
+		maxSize = 10 << 20
+	}
+	if (l.size > 0 && l.size+int64(len(p)) > maxSize) ||
+		(l.MaxAge > 0 && time.Since(l.opened) >= l.MaxAge) {
+		err := l.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Close the current file, shift the old ones along, and open a new one.
+func (l *FileLogger) rotate() error {
+	err := l.f.Close()
+	l.f = nil
+	if err != nil {
+		return err
+	}
+	backups := l.MaxBackups
+	if backups == 0 {
+		backups = 3
+	}
+	if backups < 0 {
+		err = os.Remove(l.filename)
+	} else {
+		os.Remove(backupName(l.filename, backups))
+		for i := backups - 1; i >= 1; i-- {
+			os.Rename(backupName(l.filename, i), backupName(l.filename, i+1))
+		}
+		err = os.Rename(l.filename, backupName(l.filename, 1))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+func backupName(filename string, i int) string {
+	return fmt.Sprintf("%s.%d", filename, i)
+}
+
+// Close the file. Later Writes return an error.
+func (l *FileLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,79 @@
+package pt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFileLogger(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TOR_PT_STATE_LOCATION", dir)
+	filename := filepath.Join(dir, "debug.log")
+	// An existing file with a broad mode is narrowed.
+	err := ioutil.WriteFile(filename, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := OpenStateLog("debug.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.MaxSize = 10
+	l.MaxBackups = 2
+	for i := 0; i < 4; i++ {
+		_, err := fmt.Fprintf(l, "line %d\n", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Write([]byte("x")); err == nil {
+		t.Error("Write after Close unexpectedly succeeded")
+	}
+
+	// Each line is 7 bytes, so each file holds one line, and only the last
+	// three are kept.
+	for name, expected := range map[string]string{
+		"debug.log":   "line 3\n",
+		"debug.log.1": "line 2\n",
+		"debug.log.2": "line 1\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != expected {
+			t.Errorf("%s: got %q (expected %q)", name, data, expected)
+		}
+	}
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Errorf("too many backups kept: %v", err)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("mode %v, expected 0600", fi.Mode().Perm())
+	}
+}
+
+func TestLogMethodWriter(t *testing.T) {
+	var buf strings.Builder
+	SetMethodLogging("file", MethodLogging{Level: LogSeverityDebug, Writer: &buf})
+	defer SetMethodLogging("file", MethodLogging{})
+	LogMethod("file", LogSeverityDebug, "hello")
+	if !strings.HasSuffix(buf.String(), " [debug] hello\n") {
+		t.Errorf("unexpected line %q", buf.String())
+	}
+}
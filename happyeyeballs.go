@@ -0,0 +1,157 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// How long to wait for a connection attempt before starting the next one in
+// parallel, as recommended in RFC 8305 section 5.
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// Return addrs if it is not empty, or else a slice containing only addr (which
+// may be nil).
+func addrsOrDefault(addrs []*net.TCPAddr, addr *net.TCPAddr) []*net.TCPAddr {
+	if len(addrs) > 0 {
+		return addrs
+	}
+	return []*net.TCPAddr{addr}
+}
+
+// Reorder addrs so that address families alternate, beginning with the family
+// of the first address, while keeping the relative order within each family
+// (RFC 8305 section 4).
+func interleaveAddrFamilies(addrs []*net.TCPAddr) []*net.TCPAddr {
+	if len(addrs) == 0 {
+		return nil
+	}
+	isV4 := func(addr *net.TCPAddr) bool {
+		return addr.IP.To4() != nil
+	}
+	var first, second []*net.TCPAddr
+	for _, addr := range addrs {
+		if isV4(addr) == isV4(addrs[0]) {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+	result := make([]*net.TCPAddr, 0, len(addrs))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			result = append(result, first[0])
+			first = first[1:]
+		}
+		if len(second) > 0 {
+			result = append(result, second[0])
+			second = second[1:]
+		}
+	}
+	return result
+}
+
+// Connect to one of addrs, giving up if ctx is done. With more than one,
+// connection attempts are raced in the manner of RFC 8305 ("Happy Eyeballs"):
+// address families are interleaved, and a new attempt is started whenever the
+// previous one fails or has not succeeded within connectionAttemptDelay. The
+// first connection to succeed is returned and the others are abandoned. If
+// every attempt fails, the error from the first one is returned. The options of
+// opts that must be set before connecting (see TCPOptions) are set on each
+// socket; the caller applies the others.
+func dialTCPAddrs(ctx context.Context, addrs []*net.TCPAddr, opts *TCPOptions) (*net.TCPConn, error) {
+	var d net.Dialer
+	if opts.hasControl() {
+		d.Control = opts.control
+	}
+	dial := func(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+		if addr == nil {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.AddrError{Err: "missing address"}}
+		}
+		ContextPTTrace(ctx).orDialStart(addr)
+		return d.DialContext(ctx, "tcp", addr.String())
+	}
+	var c net.Conn
+	var err error
+	if len(addrs) == 1 {
+		c, err = dial(ctx, addrs[0])
+	} else {
+		c, err = raceDials(ctx, interleaveAddrFamilies(addrs), connectionAttemptDelay, dial)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.(*net.TCPConn), nil
+}
+
+// The engine of dialTCPAddrs, with the dial function and attempt delay as
+// parameters.
+func raceDials(ctx context.Context, addrs []*net.TCPAddr, delay time.Duration, dial func(context.Context, *net.TCPAddr) (net.Conn, error)) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no addresses", Addr: ""}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result)
+	start := func(addr *net.TCPAddr) {
+		go func() {
+			defer recoverPanic("dial", func() {
+				select {
+				case results <- result{nil, fmt.Errorf("panic dialing %s", addr)}:
+				case <-ctx.Done():
+				}
+			})
+			c, err := dial(ctx, addr)
+			select {
+			case results <- result{c, err}:
+			case <-ctx.Done():
+				// Another attempt already won.
+				if c != nil {
+					c.Close()
+				}
+			}
+		}()
+	}
+
+	start(addrs[0])
+	next := 1
+	pending := 1
+	var firstErr error
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for pending > 0 {
+		var startNext bool
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.c, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			startNext = true
+		case <-timer.C:
+			startNext = true
+		}
+		if startNext && next < len(addrs) {
+			start(addrs[next])
+			next++
+			pending++
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(delay)
+		}
+	}
+	return nil, firstErr
+}
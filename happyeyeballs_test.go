@@ -0,0 +1,174 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustTCPAddr(s string) *net.TCPAddr {
+	addr, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func TestInterleaveAddrFamilies(t *testing.T) {
+	addrs := []*net.TCPAddr{
+		mustTCPAddr("[2001:db8::1]:9001"),
+		mustTCPAddr("[2001:db8::2]:9001"),
+		mustTCPAddr("[2001:db8::3]:9001"),
+		mustTCPAddr("192.0.2.1:9001"),
+	}
+	expected := []string{
+		"[2001:db8::1]:9001",
+		"192.0.2.1:9001",
+		"[2001:db8::2]:9001",
+		"[2001:db8::3]:9001",
+	}
+	result := interleaveAddrFamilies(addrs)
+	if len(result) != len(expected) {
+		t.Fatalf("got %v, expected %v", result, expected)
+	}
+	for i := range result {
+		if result[i].String() != expected[i] {
+			t.Fatalf("got %v, expected %v", result, expected)
+		}
+	}
+}
+
+func TestRaceDialsBrokenFamily(t *testing.T) {
+	v6 := mustTCPAddr("[2001:db8::1]:9001")
+	v4 := mustTCPAddr("192.0.2.1:9001")
+
+	// The IPv6 attempt hangs until canceled; the IPv4 attempt succeeds after
+	// the attempt delay.
+	var mu sync.Mutex
+	var canceled bool
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	dial := func(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+		if addr == v6 {
+			<-ctx.Done()
+			mu.Lock()
+			canceled = true
+			mu.Unlock()
+			return nil, ctx.Err()
+		}
+		return c1, nil
+	}
+	begin := time.Now()
+	c, err := raceDials(context.Background(), []*net.TCPAddr{v6, v4}, 50*time.Millisecond, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != c1 {
+		t.Errorf("got %v, expected %v", c, c1)
+	}
+	if elapsed := time.Since(begin); elapsed < 50*time.Millisecond {
+		t.Errorf("second attempt started after %v, before the attempt delay", elapsed)
+	}
+	c.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := canceled
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("losing attempt not canceled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRaceDialsFailFast(t *testing.T) {
+	v6 := mustTCPAddr("[2001:db8::1]:9001")
+	v4 := mustTCPAddr("192.0.2.1:9001")
+
+	// When the first attempt fails immediately, the next starts without
+	// waiting for the attempt delay.
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	dial := func(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+		if addr == v6 {
+			return nil, errors.New("network unreachable")
+		}
+		return c1, nil
+	}
+	begin := time.Now()
+	c, err := raceDials(context.Background(), []*net.TCPAddr{v6, v4}, time.Hour, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if elapsed := time.Since(begin); elapsed > 10*time.Second {
+		t.Errorf("second attempt waited %v", elapsed)
+	}
+}
+
+func TestRaceDialsAllFail(t *testing.T) {
+	first := errors.New("first")
+	dial := func(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+		if addr.IP.To4() == nil {
+			return nil, first
+		}
+		return nil, errors.New("second")
+	}
+	_, err := raceDials(context.Background(), []*net.TCPAddr{
+		mustTCPAddr("[2001:db8::1]:9001"),
+		mustTCPAddr("192.0.2.1:9001"),
+	}, time.Hour, dial)
+	if err != first {
+		t.Errorf("got error %v, expected %v", err, first)
+	}
+
+	_, err = raceDials(context.Background(), nil, time.Hour, dial)
+	if err == nil {
+		t.Error("no error with no addresses")
+	}
+}
+
+func TestDialOrMultipleAddrs(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	// The first address refuses connections.
+	refused, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedAddr := refused.Addr().(*net.TCPAddr)
+	refused.Close()
+
+	info := &ServerInfo{
+		OrAddr:  refusedAddr,
+		OrAddrs: []*net.TCPAddr{refusedAddr, ln.Addr().(*net.TCPAddr)},
+	}
+	c, err := DialOr(info, "", "he")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !tcpAddrsEqual(c.RemoteAddr().(*net.TCPAddr), ln.Addr().(*net.TCPAddr)) {
+		t.Errorf("connected to %v, expected %v", c.RemoteAddr(), ln.Addr())
+	}
+}
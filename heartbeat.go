@@ -0,0 +1,68 @@
+package pt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Start emitting a heartbeat LOG line, at notice severity, every interval,
+// in the manner of tor's heartbeat messages. Each line summarizes the active
+// connections tracked by m, the total bytes relayed, and the per-method
+// counters from Stats. The heartbeat stops when m is shut down or when the
+// returned stop function is called, whichever is first.
+//
+//	ptInfo, err = pt.ServerSetup(nil)
+//	...
+//	pt.SmethodsDone()
+//	pt.StartHeartbeat(pt.DefaultShutdownManager, 6*time.Hour)
+//	pt.HandleShutdownSignals(pt.DefaultShutdownManager, 0)
+func StartHeartbeat(m *ShutdownManager, interval time.Duration) (stop func()) {
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	closed := m.closed()
+	go func() {
+		defer close(done)
+		defer recoverPanic("heartbeat", nil)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				Log(LogSeverityNotice, heartbeatMessage(interval, m.ActiveConns(), Stats()))
+			case <-closed:
+				return
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopCh) })
+		<-done
+	}
+}
+
+// Format a heartbeat message from a count of active connections and a snapshot
+// of the method counters.
+func heartbeatMessage(interval time.Duration, activeConns int, methods []MethodStats) string {
+	var sent, received uint64
+	var perMethod []string
+	for _, s := range methods {
+		sent += s.BytesSent
+		received += s.BytesReceived
+		name := s.MethodName
+		if name == "" {
+			name = "(unknown)"
+		}
+		perMethod = append(perMethod, fmt.Sprintf("%s: %d accepted, %d active, %d OR dials, %d OR dial failures, %d OR auth failures",
+			name, s.ConnsAccepted, s.ConnsActive, s.OrConnsDialed, s.OrDialFailures, s.OrAuthFailures))
+	}
+	msg := fmt.Sprintf("heartbeat (every %s): %d active connections; %d bytes sent, %d bytes received", interval, activeConns, sent, received)
+	if len(perMethod) > 0 {
+		msg += "; " + strings.Join(perMethod, "; ")
+	}
+	return msg
+}
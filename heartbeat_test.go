@@ -0,0 +1,73 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A bytes.Buffer that may be written and read concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHeartbeatMessage(t *testing.T) {
+	msg := heartbeatMessage(time.Hour, 2, []MethodStats{
+		{MethodName: "", ConnsAccepted: 1},
+		{MethodName: "foo", ConnsAccepted: 5, ConnsActive: 2, OrConnsDialed: 4, OrDialFailures: 1, BytesSent: 100, BytesReceived: 2000},
+	})
+	expected := "heartbeat (every 1h0m0s): 2 active connections; 100 bytes sent, 2000 bytes received; " +
+		"(unknown): 1 accepted, 0 active, 0 OR dials, 0 OR dial failures, 0 OR auth failures; " +
+		"foo: 5 accepted, 2 active, 4 OR dials, 1 OR dial failures, 0 OR auth failures"
+	if msg != expected {
+		t.Errorf("got %q, expected %q", msg, expected)
+	}
+	// The message must be usable in a LOG line.
+	if !argIsSafe(msg) {
+		t.Errorf("unsafe message %q", msg)
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	var buf lockedBuffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	m := new(ShutdownManager)
+	stop := StartHeartbeat(m, 10*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), "LOG SEVERITY=notice MESSAGE=\"heartbeat ") {
+		if time.Now().After(deadline) {
+			t.Fatalf("no heartbeat in %q", buf.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Shutting down m stops the heartbeat; stop then returns promptly, and
+	// may be called more than once.
+	m.Shutdown(context.Background())
+	stop()
+	stop()
+	n := len(buf.String())
+	time.Sleep(50 * time.Millisecond)
+	if len(buf.String()) != n {
+		t.Error("heartbeat continued after shutdown")
+	}
+}
@@ -0,0 +1,145 @@
+package pt
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Functions for formatting and parsing pluggable transports protocol lines, for
+// tests that check what a transport emits and for programs that read it, such
+// as package ptmgr.
+
+// Return the protocol line made of keyword and args, separated by spaces,
+// without a trailing newline. Returns an error, rather than panicking as the
+// emitting functions do, if the keyword is empty or if there are forbidden
+// bytes in the keyword or the args (pt-spec.txt 2.2.1).
+//
+// For any keyword and args for which FormatLine succeeds, ParseLine of the
+// result returns keyword and args again, provided that no arg contains a space
+// except inside a CString (as made by EncodeCString), and that a double quote
+// at the beginning of an arg, or just after its first '=', begins a CString
+// that extends to the end of the arg.
+func FormatLine(keyword string, args ...string) (string, error) {
+	if keyword == "" {
+		return "", fmt.Errorf("empty keyword")
+	}
+	err := checkLine(keyword, args)
+	if err != nil {
+		return "", err
+	}
+	return string(appendLine(nil, keyword, args...)), nil
+}
+
+// Split a protocol line (without its terminating newline) into its keyword and
+// args. Args are separated by single spaces, except that a CString, a double
+// quoted string with backslash escapes, at the beginning of an arg or just
+// after its first '=' is kept whole, spaces included, and is not decoded. For
+// example,
+//
+//	LOG SEVERITY=notice MESSAGE="hello world"
+//
+// has keyword "LOG" and args "SEVERITY=notice" and `MESSAGE="hello world"`.
+// Returns an error if the keyword is empty or contains forbidden bytes, or if a
+// CString is not terminated or is followed by something other than a space.
+func ParseLine(line string) (keyword string, args []string, err error) {
+	i := 0
+	for i < len(line) && line[i] != ' ' {
+		i++
+	}
+	keyword = line[:i]
+	if keyword == "" || !keywordIsSafe(keyword) {
+		return "", nil, fmt.Errorf("bad keyword in %q", line)
+	}
+	for i < len(line) {
+		// Skip the separating space.
+		i++
+		begin := i
+		firstEquals := -1
+		for i < len(line) && line[i] != ' ' {
+			if line[i] == '"' && (i == begin || i-1 == firstEquals) {
+				_, n, err := decodeCStringPrefix(line[i:])
+				if err != nil {
+					return "", nil, fmt.Errorf("bad CString in %q: %s", line, err.Error())
+				}
+				i += n
+				if i < len(line) && line[i] != ' ' {
+					return "", nil, fmt.Errorf("garbage after CString in %q", line)
+				}
+				break
+			}
+			if line[i] == '=' && firstEquals == -1 {
+				firstEquals = i
+			}
+			i++
+		}
+		args = append(args, line[begin:i])
+	}
+	return keyword, args, nil
+}
+
+// Encode s as a CString, in the manner of the MESSAGE of a LOG line. The
+// result is a valid protocol line arg, and DecodeCString returns s from it.
+func EncodeCString(s string) string {
+	return encodeCString(s)
+}
+
+// Decode the CString (control-spec.txt section 2.1.1) s, including its
+// enclosing double quotes. Returns an error if s is not exactly one CString.
+func DecodeCString(s string) (string, error) {
+	decoded, n, err := decodeCStringPrefix(s)
+	if err != nil {
+		return "", err
+	}
+	if n != len(s) {
+		return "", fmt.Errorf("garbage after CString")
+	}
+	return decoded, nil
+}
+
+// Decode the CString at the beginning of s. Returns the decoded string and the
+// number of bytes of s it occupied, including the quotation marks.
+func decodeCStringPrefix(s string) (string, int, error) {
+	if len(s) == 0 || s[0] != '"' {
+		return "", 0, fmt.Errorf("missing start quote")
+	}
+	var buf bytes.Buffer
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			return buf.String(), i + 1, nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", 0, fmt.Errorf("nothing following final escape")
+			}
+			switch c = s[i]; c {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			case '"', '\\', '\'':
+				buf.WriteByte(c)
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				// Up to three octal digits.
+				number := 0
+				j := i
+				for ; j < len(s) && j < i+3 && '0' <= s[j] && s[j] <= '7'; j++ {
+					number = number*8 + int(s[j]-'0')
+				}
+				if number > 255 {
+					return "", 0, fmt.Errorf("invalid octal escape")
+				}
+				buf.WriteByte(byte(number))
+				i = j - 1
+			default:
+				return "", 0, fmt.Errorf("unknown escape \\%c", c)
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("missing end quote")
+}
@@ -0,0 +1,128 @@
+package pt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatParseLineRoundtrip(t *testing.T) {
+	tests := [...]struct {
+		keyword string
+		args    []string
+	}{
+		{"CMETHODS", []string{"DONE"}},
+		{"VERSION", []string{"1"}},
+		{"SMETHODS", nil},
+		{"CMETHOD", []string{"foo", "socks5", "127.0.0.1:1080"}},
+		{"SMETHOD", []string{"foo", "[::]:4444", `ARGS:k=v\,1`}},
+		{"LOG", []string{"SEVERITY=notice", `MESSAGE="hello world"`}},
+		{"LOG", []string{"SEVERITY=notice", "MESSAGE=" + EncodeCString("a \"quoted\" \\ \n line\x00\xff")}},
+		{"STATUS", []string{"TRANSPORT=foo", `ADDRESS="192.0.2.1:443"`, "a=b=c"}},
+		{"X", []string{""}},
+		{"X", []string{"", "a", ""}},
+		{"X", []string{EncodeCString(" leading space")}},
+	}
+	for _, test := range tests {
+		line, err := FormatLine(test.keyword, test.args...)
+		if err != nil {
+			t.Errorf("%q %q unexpectedly returned an error: %s", test.keyword, test.args, err)
+			continue
+		}
+		keyword, args, err := ParseLine(line)
+		if err != nil {
+			t.Errorf("%q unexpectedly returned an error: %s", line, err)
+			continue
+		}
+		if keyword != test.keyword || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%q → %q %q (expected %q %q)", line, keyword, args, test.keyword, test.args)
+		}
+	}
+
+	badFormatTests := [...]struct {
+		keyword string
+		args    []string
+	}{
+		{"", nil},
+		{"KEY WORD", nil},
+		{"LOG", []string{"new\nline"}},
+		{"LOG", []string{"nul\x00"}},
+		{"LOG", []string{"\xff"}},
+	}
+	for _, test := range badFormatTests {
+		_, err := FormatLine(test.keyword, test.args...)
+		if err == nil {
+			t.Errorf("%q %q unexpectedly succeeded", test.keyword, test.args)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	badTests := [...]string{
+		"",
+		" VERSION 1",
+		"KEY\x00WORD a",
+		`LOG MESSAGE="unterminated`,
+		`LOG MESSAGE="x"garbage`,
+		`LOG MESSAGE="bad escape \q"`,
+		`LOG "x"y`,
+	}
+	for _, line := range badTests {
+		_, _, err := ParseLine(line)
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", line)
+		}
+	}
+	// A quote not at the beginning or after the first '=' is ordinary.
+	keyword, args, err := ParseLine(`X a"b k=v="w x`)
+	if err != nil || keyword != "X" || !reflect.DeepEqual(args, []string{`a"b`, `k=v="w`, "x"}) {
+		t.Errorf("got %q %q %v", keyword, args, err)
+	}
+}
+
+func TestEscapeUnescape(t *testing.T) {
+	tests := [...]struct {
+		s, special, escaped string
+	}{
+		{"", "=;", ""},
+		{"abc", "=;", "abc"},
+		{`a=b;c\d`, "=;", `a\=b\;c\\d`},
+		{`a=b,c`, "=,", `a\=b\,c`},
+		{`a:b;c=d`, ":;=", `a\:b\;c\=d`},
+		{`\\`, "", `\\\\`},
+	}
+	for _, test := range tests {
+		escaped := Escape(test.s, test.special)
+		if escaped != test.escaped {
+			t.Errorf("%q %q → %q (expected %q)", test.s, test.special, escaped, test.escaped)
+		}
+		s, err := Unescape(escaped)
+		if err != nil || s != test.s {
+			t.Errorf("%q → %q %v (expected %q)", escaped, s, err, test.s)
+		}
+	}
+	if _, err := Unescape(`abc\`); err == nil {
+		t.Error("trailing backslash unexpectedly succeeded")
+	}
+}
+
+func TestCStringRoundtrip(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	for _, s := range []string{"", "hello", string(all), "\"\\\"", "\x00\x01\x07\x08\x10"} {
+		encoded := EncodeCString(s)
+		if !argIsSafe(encoded) {
+			t.Errorf("%q → unsafe %q", s, encoded)
+		}
+		decoded, err := DecodeCString(encoded)
+		if err != nil || decoded != s {
+			t.Errorf("%q → %q → %q %v", s, encoded, decoded, err)
+		}
+	}
+	for _, s := range []string{"", "x", `"x`, `"x"y`, `"\8"`} {
+		if _, err := DecodeCString(s); err == nil {
+			t.Errorf("%q unexpectedly succeeded", s)
+		}
+	}
+}
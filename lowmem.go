@@ -0,0 +1,55 @@
+package pt
+
+import "sync"
+
+// If MaxConcurrentHandshakes is positive, RunClient and RunServer run at most
+// this many transport handshakes at once: calls to a client Dialer, and calls to
+// a server's unwrap function. Connections beyond the limit wait for a
+// handshake to finish before starting theirs. Handshakes are often the most
+// memory-hungry part of a connection, so the limit caps memory use during a
+// burst of connections. Set it before accepting connections; it is read once,
+// at the first handshake.
+var MaxConcurrentHandshakes int
+
+// A semaphore of MaxConcurrentHandshakes slots, made at the first handshake.
+var handshakeSlots struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+// Wait for a handshake slot, if MaxConcurrentHandshakes is set. Returns a
+// function that frees the slot.
+func acquireHandshake() func() {
+	handshakeSlots.once.Do(func() {
+		if MaxConcurrentHandshakes > 0 {
+			handshakeSlots.ch = make(chan struct{}, MaxConcurrentHandshakes)
+		}
+	})
+	ch := handshakeSlots.ch
+	if ch == nil {
+		return func() {}
+	}
+	ch <- struct{}{}
+	return func() { <-ch }
+}
+
+// The settings of UseLowMemoryProfile.
+const (
+	lowMemoryRelayBufferSize = 4 * 1024
+	lowMemoryHandshakes      = 4
+)
+
+// Configure the package for a device with little memory, such as an OpenWrt
+// router or a phone, trading some throughput and connection latency for a
+// smaller footprint: relay buffers are 4 KiB rather than 32 KiB (see
+// RelayBufferSize), at most 4 handshakes run at once (see
+// MaxConcurrentHandshakes), and no pool of ORPort connections is kept (see
+// ORPoolSize). Call it before RunClient or RunServer, for example from an init
+// function. Settings may be adjusted individually afterward.
+func UseLowMemoryProfile() {
+	RelayBufferSize = lowMemoryRelayBufferSize
+	RelayUpstreamBufferSize = 0
+	RelayDownstreamBufferSize = 0
+	MaxConcurrentHandshakes = lowMemoryHandshakes
+	ORPoolSize = 0
+}
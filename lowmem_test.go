@@ -0,0 +1,64 @@
+package pt
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireHandshake(t *testing.T) {
+	reset := func() {
+		handshakeSlots.once = sync.Once{}
+		handshakeSlots.ch = nil
+	}
+	defer func() {
+		MaxConcurrentHandshakes = 0
+		reset()
+	}()
+
+	// No limit.
+	reset()
+	MaxConcurrentHandshakes = 0
+	for i := 0; i < 10; i++ {
+		acquireHandshake()
+	}
+
+	reset()
+	MaxConcurrentHandshakes = 2
+	release1 := acquireHandshake()
+	release2 := acquireHandshake()
+	acquired := make(chan func())
+	go func() {
+		acquired <- acquireHandshake()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("third handshake started while two were running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release1()
+	select {
+	case release3 := <-acquired:
+		release3()
+	case <-time.After(5 * time.Second):
+		t.Fatal("third handshake did not start after a slot was freed")
+	}
+	release2()
+}
+
+func TestUseLowMemoryProfile(t *testing.T) {
+	defer func() {
+		RelayBufferSize = 0
+		MaxConcurrentHandshakes = 0
+	}()
+	ORPoolSize = 5
+	UseLowMemoryProfile()
+	if RelayBufferSize != lowMemoryRelayBufferSize || MaxConcurrentHandshakes != lowMemoryHandshakes || ORPoolSize != 0 {
+		t.Errorf("RelayBufferSize %d, MaxConcurrentHandshakes %d, ORPoolSize %d", RelayBufferSize, MaxConcurrentHandshakes, ORPoolSize)
+	}
+	if buf := relayBuffer(0); len(buf) != lowMemoryRelayBufferSize {
+		t.Errorf("relay buffer of %d bytes", len(buf))
+	} else {
+		releaseRelayBuffer(buf)
+	}
+}
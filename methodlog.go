@@ -0,0 +1,94 @@
+package pt
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// MethodLogging configures the LOG lines that the library emits about one
+// transport method, so that an operator running several transports in one
+// process can, for example, see debug lines for only one of them:
+//
+//	pt.SetMethodLogging("webtunnel", pt.MethodLogging{
+//		Level:  pt.LogSeverityDebug,
+//		Prefix: "webtunnel: ",
+//	})
+type MethodLogging struct {
+	// The least severe level of lines that are emitted, one of
+	// LogSeverityError, LogSeverityWarning, LogSeverityNotice,
+	// LogSeverityInfo, or LogSeverityDebug. If unset, LogSeverityInfo, so
+	// that the per-connection debug lines of RunClient, RunServer, and the
+	// other loops are not emitted.
+	Level logSeverity
+	// A string prepended to the message of each line.
+	Prefix string
+	// If not nil, lines are written here, each with a timestamp and
+	// severity, rather than as LOG lines to tor. A FileLogger from
+	// OpenStateLog keeps chatty debug output out of tor's log.
+	Writer io.Writer
+}
+
+var methodLogging struct {
+	mu      sync.RWMutex
+	methods map[string]MethodLogging
+}
+
+// Set the logging configuration for methodName, replacing any earlier one.
+func SetMethodLogging(methodName string, config MethodLogging) {
+	methodLogging.mu.Lock()
+	defer methodLogging.mu.Unlock()
+	if methodLogging.methods == nil {
+		methodLogging.methods = make(map[string]MethodLogging)
+	}
+	methodLogging.methods[methodName] = config
+}
+
+// Return the logging configuration for methodName.
+func methodLoggingFor(methodName string) MethodLogging {
+	methodLogging.mu.RLock()
+	defer methodLogging.mu.RUnlock()
+	return methodLogging.methods[methodName]
+}
+
+// Return the verbosity of s: 0 for LogSeverityError up to 4 for
+// LogSeverityDebug.
+func (s logSeverity) rank() int {
+	switch s {
+	case LogSeverityError:
+		return 0
+	case LogSeverityWarning:
+		return 1
+	case LogSeverityNotice:
+		return 2
+	case LogSeverityInfo:
+		return 3
+	}
+	return 4
+}
+
+// Like Log, but for a line about methodName: the line is emitted only if
+// severity is at least the Level set for methodName with SetMethodLogging,
+// and its message begins with the method's Prefix.
+func LogMethod(methodName string, severity logSeverity, message string) {
+	if !methodLogEnabled(methodName, severity) {
+		return
+	}
+	config := methodLoggingFor(methodName)
+	if config.Writer != nil {
+		fmt.Fprintf(config.Writer, "%s [%s] %s%s\n", time.Now().Format(time.RFC3339), severity.string, config.Prefix, message)
+		return
+	}
+	Log(severity, config.Prefix+message)
+}
+
+// Return true iff LogMethod would emit a line of severity for methodName, so
+// that callers can skip formatting a message that would not be emitted.
+func methodLogEnabled(methodName string, severity logSeverity) bool {
+	level := methodLoggingFor(methodName).Level
+	if level == (logSeverity{}) {
+		level = LogSeverityInfo
+	}
+	return severity.rank() <= level.rank()
+}
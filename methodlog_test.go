@@ -0,0 +1,28 @@
+package pt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLogMethod(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	defer SetMethodLogging("loud", MethodLogging{})
+	SetMethodLogging("loud", MethodLogging{Level: LogSeverityDebug, Prefix: "loud: "})
+	SetMethodLogging("quiet", MethodLogging{Level: LogSeverityWarning})
+	defer SetMethodLogging("quiet", MethodLogging{})
+
+	LogMethod("loud", LogSeverityDebug, "a")
+	LogMethod("quiet", LogSeverityNotice, "b")
+	LogMethod("quiet", LogSeverityError, "c")
+	LogMethod("other", LogSeverityDebug, "d")
+	LogMethod("other", LogSeverityInfo, "e")
+
+	expected := "LOG SEVERITY=debug MESSAGE=\"loud: a\"\n" +
+		"LOG SEVERITY=error MESSAGE=\"c\"\n" +
+		"LOG SEVERITY=info MESSAGE=\"e\"\n"
+	if buf.String() != expected {
+		t.Errorf("got %q (expected %q)", buf.String(), expected)
+	}
+}
@@ -0,0 +1,98 @@
+package pt
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Counters of library activity, kept separately for each transport method name.
+// Events whose method name is not known to the library (for example,
+// connections accepted by a SocksListener whose MethodName is unset) are
+// counted under the empty method name "".
+
+// MethodStats is a snapshot of the counters for one method name, as returned
+// by Stats.
+type MethodStats struct {
+	MethodName string
+	// Number of client connections accepted (after a successful SOCKS
+	// handshake, in the case of a SocksListener).
+	ConnsAccepted uint64
+	// Number of accepted connections that have not yet been closed.
+	ConnsActive int64
+	// Number of successful calls to DialOr.
+	OrConnsDialed uint64
+	// Number of calls to DialOr that failed to make a TCP connection to
+	// the ORPort or extended ORPort.
+	OrDialFailures uint64
+	// Number of calls to DialOr that connected to the extended ORPort but
+	// failed authentication or were denied by the server.
+	OrAuthFailures uint64
+	// Number of bytes relayed from accepted connections to the other side,
+	// and back, by RunClient, RunServer, ServeTransports, and the
+	// standalone modes. Bytes are counted when a connection is finished.
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+type methodCounters struct {
+	connsAccepted  uint64
+	connsActive    int64
+	orConnsDialed  uint64
+	orDialFailures uint64
+	orAuthFailures uint64
+	bytesSent      uint64
+	bytesReceived  uint64
+}
+
+var stats struct {
+	sync.Mutex
+	methods map[string]*methodCounters
+}
+
+// Return the counters for methodName, creating them if necessary.
+func statsFor(methodName string) *methodCounters {
+	stats.Lock()
+	defer stats.Unlock()
+	if stats.methods == nil {
+		stats.methods = make(map[string]*methodCounters)
+	}
+	c, ok := stats.methods[methodName]
+	if !ok {
+		c = new(methodCounters)
+		stats.methods[methodName] = c
+	}
+	return c
+}
+
+// Return a snapshot of the library's counters, one element per method name,
+// sorted by method name. The counters are cumulative over the life of the
+// process, apart from ConnsActive.
+func Stats() []MethodStats {
+	stats.Lock()
+	defer stats.Unlock()
+	result := make([]MethodStats, 0, len(stats.methods))
+	for methodName, c := range stats.methods {
+		result = append(result, MethodStats{
+			MethodName:     methodName,
+			ConnsAccepted:  atomic.LoadUint64(&c.connsAccepted),
+			ConnsActive:    atomic.LoadInt64(&c.connsActive),
+			OrConnsDialed:  atomic.LoadUint64(&c.orConnsDialed),
+			OrDialFailures: atomic.LoadUint64(&c.orDialFailures),
+			OrAuthFailures: atomic.LoadUint64(&c.orAuthFailures),
+			BytesSent:      atomic.LoadUint64(&c.bytesSent),
+			BytesReceived:  atomic.LoadUint64(&c.bytesReceived),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].MethodName < result[j].MethodName
+	})
+	return result
+}
+
+// Return the number of goroutines currently copying data between accepted
+// connections and the connections made for them, two for each relayed
+// connection.
+func CopyLoopGoroutines() int64 {
+	return atomic.LoadInt64(&copyLoopGoroutines)
+}
@@ -0,0 +1,75 @@
+package pt
+
+import (
+	"net"
+	"testing"
+)
+
+func statsForMethod(methodName string) MethodStats {
+	for _, s := range Stats() {
+		if s.MethodName == methodName {
+			return s
+		}
+	}
+	return MethodStats{MethodName: methodName}
+}
+
+func TestStatsAcceptSocks(t *testing.T) {
+	const methodName = "teststatsacceptsocks"
+
+	c1, c2 := net.Pipe()
+	go func() {
+		// VER = 05, NMETHODS = 01, METHODS = [00]
+		c2.Write([]byte("\x05\x01\x00"))
+		c2.Read(make([]byte, 2))
+		// VER = 05, CMD = 01, RSV = 00, ATYPE = 01, DST.ADDR = 127.0.0.1, DST.PORT = 9050
+		c2.Write([]byte("\x05\x01\x00\x01\x7f\x00\x00\x01\x23\x5a"))
+	}()
+	ln := NewSocksListener(&fakeListener{c: &ignoreDeadlineConn{c1}, err: nil})
+	ln.MethodName = methodName
+	before := statsForMethod(methodName)
+	conn, err := ln.AcceptSocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := statsForMethod(methodName)
+	if s.ConnsAccepted != before.ConnsAccepted+1 || s.ConnsActive != 1 {
+		t.Errorf("after accept: %+v", s)
+	}
+	conn.Close()
+	// A second Close must not decrement the count again.
+	conn.Close()
+	s = statsForMethod(methodName)
+	if s.ConnsAccepted != before.ConnsAccepted+1 || s.ConnsActive != 0 {
+		t.Errorf("after close: %+v", s)
+	}
+}
+
+func TestStatsDialOr(t *testing.T) {
+	const methodName = "teststatsdialor"
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &ServerInfo{OrAddr: ln.Addr().(*net.TCPAddr)}
+	before := statsForMethod(methodName)
+	s, err := DialOr(info, "", methodName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	ln.Close()
+	_, err = DialOr(info, "", methodName)
+	if err == nil {
+		t.Fatal("DialOr to a closed port unexpectedly succeeded")
+	}
+
+	stats := statsForMethod(methodName)
+	if stats.OrConnsDialed != before.OrConnsDialed+1 ||
+		stats.OrDialFailures != before.OrDialFailures+1 ||
+		stats.OrAuthFailures != before.OrAuthFailures {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,206 @@
+package pt
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// The type of the value of an Option.
+type OptionType int
+
+const (
+	// Any string.
+	OptionString OptionType = iota
+	// A decimal integer.
+	OptionInteger
+	// A boolean, as accepted by strconv.ParseBool, such as "0", "1",
+	// "false", or "true".
+	OptionBoolean
+	// Base64, as checked by CheckBase64.
+	OptionBase64
+)
+
+func (t OptionType) String() string {
+	switch t {
+	case OptionString:
+		return "string"
+	case OptionInteger:
+		return "integer"
+	case OptionBoolean:
+		return "boolean"
+	case OptionBase64:
+		return "base64"
+	}
+	return fmt.Sprintf("OptionType(%d)", int(t))
+}
+
+// Return an error unless value has type t.
+func (t OptionType) check(value string) error {
+	switch t {
+	case OptionInteger:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("not an integer")
+		}
+	case OptionBoolean:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("not a boolean")
+		}
+	case OptionBase64:
+		return CheckBase64(value)
+	}
+	return nil
+}
+
+// Option describes one option of a transport, either a server option from
+// TOR_PT_SERVER_TRANSPORT_OPTIONS or a per-connection client argument sent
+// over SOCKS.
+type Option struct {
+	Name string
+	Type OptionType
+	// The value used when the option is not given, or "" for none.
+	Default string
+	// If true, the option must be given (a Default is then never used).
+	Required bool
+	// A short description for error messages, such as "inter-arrival
+	// timing mode".
+	Description string
+	// If not nil, called on each value after its type is checked, as with
+	// ArgRule.Check.
+	Check func(value string) error
+	// If true, the option's value is published to clients in the SMETHOD
+	// ARGS of a server (see OptionSchema.ServerArgs).
+	Public bool
+}
+
+// OptionSchema declares the options that a transport understands. Unlike an
+// ArgsValidator, it knows their types and defaults, so that a transport can
+// leave parsing and defaulting to the library:
+//
+//	var obfsServerOptions = pt.OptionSchema{Options: []pt.Option{
+//		{Name: "cert", Type: pt.OptionBase64, Required: true, Public: true},
+//		{Name: "iat-mode", Type: pt.OptionInteger, Default: "0",
+//			Check: pt.CheckInteger(0, 2), Public: true,
+//			Description: "inter-arrival timing mode"},
+//	}}
+//
+// A Transport that implements OptionSchemer has its options handled by
+// ServeTransports.
+type OptionSchema struct {
+	Options []Option
+	// If true, options not in Options are passed through unchecked;
+	// otherwise they are errors.
+	AllowUnknown bool
+}
+
+// OptionSchemer may be implemented by a Transport that declares its options.
+// ServeTransports then applies the server schema to the options of each
+// Bindaddr before calling ServerFactory, emitting SMETHOD-ERROR with the
+// *ArgsError if they are not valid, and passes the options with defaults
+// filled in as ServerConfig.Options. If the ServerFactory is not a
+// ServerArgser, the Public options are emitted in SMETHOD ARGS. Likewise the
+// client schema is applied to the Args of each SOCKS request before Dial, and
+// a request with invalid Args is rejected as SocksReplyForError says for an
+// *ArgsError. Either method may return nil to leave its side unchecked.
+type OptionSchemer interface {
+	ServerOptionSchema() *OptionSchema
+	ClientOptionSchema() *OptionSchema
+}
+
+// Return an ArgsValidator that checks the names, counts, and types of options.
+func (s *OptionSchema) Validator() *ArgsValidator {
+	v := &ArgsValidator{
+		Rules:        make([]ArgRule, 0, len(s.Options)),
+		AllowUnknown: s.AllowUnknown,
+	}
+	for _, opt := range s.Options {
+		opt := opt
+		v.Rules = append(v.Rules, ArgRule{
+			Key:      opt.Name,
+			Required: opt.Required,
+			Check: func(value string) error {
+				err := opt.Type.check(value)
+				if err == nil && opt.Check != nil {
+					err = opt.Check(value)
+				}
+				return err
+			},
+		})
+	}
+	return v
+}
+
+// Check args against s and return a copy of them with the Default of each
+// option that is absent filled in. Returns an *ArgsError naming the first
+// option that is not valid, with its Description, if it has one, in the
+// message.
+func (s *OptionSchema) Apply(args Args) (Args, error) {
+	err := s.Validator().Validate(args)
+	if err != nil {
+		if e, ok := err.(*ArgsError); ok {
+			if opt := s.option(e.Key); opt != nil && opt.Description != "" {
+				e.Err = fmt.Errorf("%s (%s, %s)", e.Err.Error(), opt.Description, opt.Type)
+			}
+		}
+		return nil, err
+	}
+	result := make(Args, len(args))
+	for key, values := range args {
+		result[key] = append([]string(nil), values...)
+	}
+	for _, opt := range s.Options {
+		if _, ok := result[opt.Name]; !ok && opt.Default != "" {
+			result.Add(opt.Name, opt.Default)
+		}
+	}
+	return result, nil
+}
+
+// Return the Public options of s from args, which should have come from
+// Apply, for an SMETHOD ARGS option, or nil if there are none.
+func (s *OptionSchema) ServerArgs(args Args) Args {
+	var result Args
+	for _, opt := range s.Options {
+		values, ok := args[opt.Name]
+		if !opt.Public || !ok {
+			continue
+		}
+		if result == nil {
+			result = make(Args)
+		}
+		result[opt.Name] = append([]string(nil), values...)
+	}
+	return result
+}
+
+func (s *OptionSchema) option(name string) *Option {
+	for i := range s.Options {
+		if s.Options[i].Name == name {
+			return &s.Options[i]
+		}
+	}
+	return nil
+}
+
+// Return the client and server schemas of t, if it is an OptionSchemer.
+func optionSchemas(t Transport) (client, server *OptionSchema) {
+	if s, ok := t.(OptionSchemer); ok {
+		return s.ClientOptionSchema(), s.ServerOptionSchema()
+	}
+	return nil, nil
+}
+
+// Return a Dialer that applies schema to the Args of each Dial before calling
+// d, or d itself if schema is nil.
+func schemaDialer(schema *OptionSchema, d Dialer) Dialer {
+	if schema == nil {
+		return d
+	}
+	return DialerFunc(func(network, address string, args Args) (net.Conn, error) {
+		args, err := schema.Apply(args)
+		if err != nil {
+			return nil, err
+		}
+		return d.Dial(network, address, args)
+	})
+}
@@ -0,0 +1,106 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testOptionSchema = OptionSchema{Options: []Option{
+	{Name: "cert", Type: OptionBase64, Required: true, Public: true},
+	{Name: "iat-mode", Type: OptionInteger, Default: "0", Check: CheckInteger(0, 2),
+		Public: true, Description: "inter-arrival timing mode"},
+	{Name: "verbose", Type: OptionBoolean, Default: "false"},
+}}
+
+func TestOptionSchemaApply(t *testing.T) {
+	args, err := testOptionSchema.Apply(Args{"cert": []string{"AAAA"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Args{"cert": []string{"AAAA"}, "iat-mode": []string{"0"}, "verbose": []string{"false"}}
+	if !argsEqual(args, expected) {
+		t.Errorf("got %q (expected %q)", args, expected)
+	}
+	if !argsEqual(testOptionSchema.ServerArgs(args), Args{"cert": []string{"AAAA"}, "iat-mode": []string{"0"}}) {
+		t.Errorf("unexpected ServerArgs %q", testOptionSchema.ServerArgs(args))
+	}
+
+	tests := []struct {
+		args    Args
+		key     string
+		message string
+	}{
+		{Args{}, "cert", "missing"},
+		{Args{"cert": []string{"!!"}}, "cert", "not base64"},
+		{Args{"cert": []string{"AAAA"}, "iat-mode": []string{"x"}}, "iat-mode", "not an integer (inter-arrival timing mode, integer)"},
+		{Args{"cert": []string{"AAAA"}, "iat-mode": []string{"3"}}, "iat-mode", "not between 0 and 2 (inter-arrival timing mode, integer)"},
+		{Args{"cert": []string{"AAAA"}, "verbose": []string{"maybe"}}, "verbose", "not a boolean"},
+		{Args{"cert": []string{"AAAA"}, "other": []string{"1"}}, "other", "unknown"},
+	}
+	for _, test := range tests {
+		_, err := testOptionSchema.Apply(test.args)
+		var e *ArgsError
+		if !errors.As(err, &e) {
+			t.Errorf("%q: got %v (expected *ArgsError)", test.args, err)
+			continue
+		}
+		if e.Key != test.key || e.Err.Error() != test.message {
+			t.Errorf("%q: got %q: %q (expected %q: %q)", test.args, e.Key, e.Err, test.key, test.message)
+		}
+	}
+}
+
+// schemaTransport is an identityTransport that declares testOptionSchema for
+// its server side.
+type schemaTransport struct {
+	identityTransport
+}
+
+func (t schemaTransport) ServerFactory(config *ServerConfig) (ServerFactory, error) {
+	if v, _ := config.Options.Get("iat-mode"); v != "0" {
+		return nil, errors.New("default iat-mode not filled in")
+	}
+	return t, nil
+}
+
+func (t schemaTransport) ServerOptionSchema() *OptionSchema {
+	return &testOptionSchema
+}
+
+func (t schemaTransport) ClientOptionSchema() *OptionSchema {
+	return nil
+}
+
+func TestServeServerTransportsOptionSchema(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_SERVER_TRANSPORTS", "good,bad")
+	os.Setenv("TOR_PT_SERVER_BINDADDR", "good-127.0.0.1:0,bad-127.0.0.1:0")
+	os.Setenv("TOR_PT_SERVER_TRANSPORT_OPTIONS", "good:cert=AAAA;bad:cert=!!")
+	os.Setenv("TOR_PT_ORPORT", echo.Addr().String())
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := serveServerTransports(m, []Transport{
+		schemaTransport{identityTransport{"good"}},
+		schemaTransport{identityTransport{"bad"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+	if !strings.Contains(output, `SMETHOD-ERROR bad arg "cert": not base64`+"\n") {
+		t.Errorf("no SMETHOD-ERROR for bad options in %q", output)
+	}
+	if !strings.Contains(output, " ARGS:cert=AAAA,iat-mode=0\n") {
+		t.Errorf("no SMETHOD ARGS from schema in %q", output)
+	}
+}
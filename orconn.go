@@ -0,0 +1,29 @@
+package pt
+
+import (
+	"net"
+	"time"
+)
+
+// ORConn is a connection to the ORPort or extended ORPort made by DialOrConn,
+// with a record of how it was made, for logging, metrics, and debugging.
+type ORConn struct {
+	*net.TCPConn
+	// The methodName passed to DialOrConn.
+	MethodName string
+	// The client address sent in USERADDR, or "" if none was sent (because
+	// it was "" or because the connection is not to the extended ORPort).
+	UserAddr string
+	// Whether the connection is to the extended ORPort, rather than to the
+	// plain ORPort.
+	Extended bool
+	// Whether the connection was taken from the pool of StartORPool, in
+	// which case it was dialed and authenticated in advance.
+	Pooled bool
+	// How long it took to connect. Zero for a pooled connection.
+	DialDuration time.Duration
+	// How long extended ORPort authentication and the USERADDR and
+	// TRANSPORT commands took. For a pooled connection, only the commands
+	// are counted. Zero for a connection to the plain ORPort.
+	HandshakeDuration time.Duration
+}
@@ -0,0 +1,46 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDialOrConn(t *testing.T) {
+	cookie := make([]byte, 32)
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, cookiePath, cookie, time.Now())
+	var accepted int32
+	ln, userAddrs := startFakeExtOrPort(t, cookie, &accepted)
+	defer ln.Close()
+
+	// The plain ORPort.
+	info := &ServerInfo{OrAddr: ln.Addr().(*net.TCPAddr)}
+	c, err := DialOrConn(context.Background(), info, "192.0.2.1:1234", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if c.MethodName != "foo" || c.UserAddr != "" || c.Extended || c.Pooled || c.HandshakeDuration != 0 {
+		t.Errorf("unexpected plain ORConn %+v", c)
+	}
+
+	// The extended ORPort.
+	info = &ServerInfo{
+		ExtendedOrAddr: ln.Addr().(*net.TCPAddr),
+		AuthCookiePath: cookiePath,
+	}
+	c, err = DialOrConn(context.Background(), info, "192.0.2.1:1234", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if c.MethodName != "foo" || c.UserAddr != "192.0.2.1:1234" || !c.Extended || c.Pooled || c.HandshakeDuration <= 0 {
+		t.Errorf("unexpected extended ORConn %+v", c)
+	}
+	if userAddr := <-userAddrs; userAddr != "192.0.2.1:1234" {
+		t.Errorf("USERADDR %q", userAddr)
+	}
+}
@@ -0,0 +1,142 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// If ORHealthCheckInterval is greater than zero, RunServer and ServeTransports
+// call StartORHealthCheck with it, with no callback.
+var ORHealthCheckInterval time.Duration
+
+// How long a single health check may take.
+const orHealthTimeout = 10 * time.Second
+
+// ORHealthStatus is the result of the most recent check made by
+// StartORHealthCheck.
+type ORHealthStatus struct {
+	// Whether the most recent check succeeded.
+	Healthy bool
+	// The error from the most recent check, or nil if it succeeded.
+	Err error
+	// When the most recent check finished. The zero Time means that no
+	// check has finished yet.
+	Checked time.Time
+}
+
+var orHealth struct {
+	sync.Mutex
+	status ORHealthStatus
+}
+
+// Return the result of the most recent check made by StartORHealthCheck. The
+// Checked field is zero if no check has been made.
+func ORHealth() ORHealthStatus {
+	orHealth.Lock()
+	defer orHealth.Unlock()
+	return orHealth.status
+}
+
+// Check, every interval, that the ORPort of info can be reached, and if info
+// has an extended ORPort, that authentication with the auth cookie succeeds.
+// The probe connection is closed without sending any client metadata. A LOG at
+// warning severity is emitted when a check fails after the previous one
+// succeeded (or when the first check fails), and a LOG at notice severity when
+// a check succeeds again after failures; so broken local wiring between the
+// transport and tor is reported before clients notice it. The result of each
+// check is available from ORHealth, and is passed to callback if it is not
+// nil. The first check is made immediately.
+//
+// Checks stop when m is shut down or when the returned stop function is
+// called, whichever is first.
+func StartORHealthCheck(m *ShutdownManager, info *ServerInfo, interval time.Duration, callback func(ORHealthStatus)) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	closed := m.closed()
+	go func() {
+		defer close(done)
+		defer recoverPanic("ORPort health check", nil)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var prev *ORHealthStatus
+		for {
+			status := checkOR(ctx, info)
+			if ctx.Err() != nil {
+				return
+			}
+			if !status.Healthy && (prev == nil || prev.Healthy) {
+				Log(LogSeverityWarning, "ORPort health check failed: "+status.Err.Error())
+			} else if status.Healthy && prev != nil && !prev.Healthy {
+				Log(LogSeverityNotice, "ORPort health check succeeded after failures")
+			}
+			prev = &status
+			orHealth.Lock()
+			orHealth.status = status
+			orHealth.Unlock()
+			if callback != nil {
+				callback(status)
+			}
+			select {
+			case <-ticker.C:
+			case <-closed:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(cancel)
+		<-done
+	}
+}
+
+// Make one health check of the ORPort of info.
+func checkOR(ctx context.Context, info *ServerInfo) ORHealthStatus {
+	ctx, cancel := context.WithTimeout(ctx, orHealthTimeout)
+	defer cancel()
+	err := probeOR(ctx, info)
+	return ORHealthStatus{Healthy: err == nil, Err: err, Checked: time.Now()}
+}
+
+// Connect to the ORPort of info, as DialOr does, and authenticate if it is an
+// extended ORPort, without affecting the counters in Stats.
+func probeOR(ctx context.Context, info *ServerInfo) error {
+	extended := (info.ExtendedOrAddr != nil || len(info.ExtendedOrAddrs) > 0) && info.hasAuthCookie()
+	var addrs []*net.TCPAddr
+	if extended {
+		addrs = info.currentExtendedOrAddrs()
+	} else {
+		addrs = info.currentOrAddrs()
+	}
+	// A connection made with Fast Open is not made until something is
+	// written, which a probe of an ORPort does not do.
+	opts := info.OrTCPOptions
+	opts.FastOpen = false
+	s, err := dialTCPAddrs(ctx, addrs, &opts)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if !extended {
+		return nil
+	}
+	stop := interruptOnDone(ctx, s)
+	err = extOrPortAuthOnly(s, orHealthTimeout, info)
+	if stop() {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Start health checks of info if ORHealthCheckInterval is set. They stop when m
+// is shut down.
+func startORHealthCheckFor(m *ShutdownManager, info *ServerInfo) {
+	if ORHealthCheckInterval <= 0 {
+		return
+	}
+	StartORHealthCheck(m, info, ORHealthCheckInterval, nil)
+}
@@ -0,0 +1,79 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestORHealthCheck(t *testing.T) {
+	var buf lockedBuffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	cookie := make([]byte, 32)
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, cookiePath, cookie, time.Now())
+	var accepted int32
+	ln, _ := startFakeExtOrPort(t, cookie, &accepted)
+	defer ln.Close()
+
+	info := &ServerInfo{
+		ExtendedOrAddr: ln.Addr().(*net.TCPAddr),
+		AuthCookiePath: cookiePath,
+	}
+	results := make(chan ORHealthStatus, 100)
+	m := new(ShutdownManager)
+	stop := StartORHealthCheck(m, info, 10*time.Millisecond, func(status ORHealthStatus) {
+		results <- status
+	})
+	defer stop()
+
+	status := <-results
+	if !status.Healthy || status.Err != nil || status.Checked.IsZero() {
+		t.Fatalf("first check: %+v", status)
+	}
+	if h := ORHealth(); !h.Healthy {
+		t.Errorf("ORHealth: %+v", h)
+	}
+
+	// Once tor stops listening, a check fails and a warning is logged.
+	ln.Close()
+	for status.Healthy {
+		status = <-results
+	}
+	if status.Err == nil {
+		t.Errorf("unhealthy with no error: %+v", status)
+	}
+	if n := strings.Count(buf.String(), "LOG SEVERITY=warning MESSAGE=\"ORPort health check failed: "); n != 1 {
+		t.Errorf("%d warnings in %q", n, buf.String())
+	}
+	stop()
+	if h := ORHealth(); h.Healthy {
+		t.Errorf("ORHealth: %+v", h)
+	}
+}
+
+func TestORHealthCheckBadCookie(t *testing.T) {
+	cookie := make([]byte, 32)
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, cookiePath, cookie, time.Now())
+	var accepted int32
+	wrongCookie := make([]byte, 32)
+	wrongCookie[0] = 1
+	ln, _ := startFakeExtOrPort(t, wrongCookie, &accepted)
+	defer ln.Close()
+
+	info := &ServerInfo{
+		ExtendedOrAddr: ln.Addr().(*net.TCPAddr),
+		AuthCookiePath: cookiePath,
+	}
+	status := checkOR(context.Background(), info)
+	if status.Healthy || status.Err == nil {
+		t.Errorf("authentication with the wrong cookie: %+v", status)
+	}
+}
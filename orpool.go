@@ -0,0 +1,176 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// If ORPoolSize is greater than zero, RunServer and ServeTransports call
+// StartORPool with it, so that connections to the extended ORPort are made and
+// authenticated ahead of time.
+var ORPoolSize int
+
+// How long a pooled connection may wait to be used before it is discarded, in
+// case tor has given up on it.
+const orPoolMaxIdle = 60 * time.Second
+
+// Bounds on the delay before retrying after failing to fill the pool.
+const (
+	orPoolBackoffMin = 1 * time.Second
+	orPoolBackoffMax = 30 * time.Second
+)
+
+// A connection to the extended ORPort that has been authenticated but has not
+// yet had its metadata set.
+type pooledOrConn struct {
+	conn *net.TCPConn
+	made time.Time
+}
+
+type orPool struct {
+	conns chan pooledOrConn
+	ctx   context.Context
+}
+
+// Keep about size connections to the extended ORPort of info connected and
+// authenticated, so that DialOr and DialOrContext with info need only send the
+// per-client USERADDR and TRANSPORT commands, saving the TCP and authentication
+// round trips on every new client. A pooled connection that cannot be used
+// (for example, because tor has closed it) is replaced with a fresh one, so
+// DialOr succeeds whenever it would without the pool. If tor answers DENY on a
+// pooled connection, DialOr returns the error, as it would without the pool.
+// Pooled connections that have waited for a minute are discarded.
+//
+// StartORPool does nothing if info has no extended ORPort or auth cookie, or
+// if size is not positive. It must be called before DialOr is used with info.
+// The returned function stops filling the pool and closes the connections in
+// it.
+func (info *ServerInfo) StartORPool(size int) (stop func()) {
+	if size <= 0 || (info.ExtendedOrAddr == nil && len(info.ExtendedOrAddrs) == 0) || !info.hasAuthCookie() {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &orPool{conns: make(chan pooledOrConn, size), ctx: ctx}
+	// The pool dials with a copy of info that has no pool.
+	dialInfo := *info
+	info.pool = p
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer recoverPanic("ORPort connection pool", nil)
+		p.fill(&dialInfo)
+	}()
+	return func() {
+		cancel()
+		<-done
+		for {
+			select {
+			case pc := <-p.conns:
+				pc.conn.Close()
+			default:
+				return
+			}
+		}
+	}
+}
+
+// Dial and authenticate connections and put them in the pool, until p.ctx is
+// done.
+func (p *orPool) fill(info *ServerInfo) {
+	var backoff time.Duration
+	failing := false
+	for {
+		s, err := dialTCPAddrs(p.ctx, info.currentExtendedOrAddrs(), &info.OrTCPOptions)
+		if err == nil {
+			err = info.OrTCPOptions.apply(s)
+			if err == nil {
+				stop := interruptOnDone(p.ctx, s)
+				err = extOrPortAuthOnly(s, 5*time.Second, info)
+				stop()
+			}
+			if err != nil {
+				s.Close()
+			}
+		}
+		if p.ctx.Err() != nil {
+			if err == nil {
+				s.Close()
+			}
+			return
+		}
+		if err != nil {
+			if !failing {
+				Log(LogSeverityWarning, "cannot make pooled extended ORPort connection: "+err.Error())
+				failing = true
+			}
+			if backoff == 0 {
+				backoff = orPoolBackoffMin
+			} else if backoff *= 2; backoff > orPoolBackoffMax {
+				backoff = orPoolBackoffMax
+			}
+			select {
+			case <-time.After(backoff):
+			case <-p.ctx.Done():
+				return
+			}
+			continue
+		}
+		failing = false
+		backoff = 0
+		select {
+		case p.conns <- pooledOrConn{s, time.Now()}:
+		case <-p.ctx.Done():
+			s.Close()
+			return
+		}
+	}
+}
+
+// Take a connection from the pool and set its metadata. Returns nil and no
+// error if the pool is empty or if no pooled connection could be used, in which
+// case the caller should dial a fresh one. If tor answers DENY, or ctx is done,
+// returns the error, because a fresh connection would fare no better.
+func (p *orPool) take(ctx context.Context, addr, methodName string) (*net.TCPConn, error) {
+	for {
+		var pc pooledOrConn
+		select {
+		case pc = <-p.conns:
+		default:
+			return nil, nil
+		}
+		if time.Since(pc.made) > orPoolMaxIdle {
+			pc.conn.Close()
+			continue
+		}
+		ContextPTTrace(ctx).orAuthStart()
+		stop := interruptOnDone(ctx, pc.conn)
+		err := extOrPortSetupMetadata(pc.conn, 5*time.Second, addr, methodName)
+		if stop() {
+			err = ctx.Err()
+		}
+		ContextPTTrace(ctx).orAuthDone(err)
+		if err != nil {
+			pc.conn.Close()
+			if _, ok := err.(*ExtOrPortDenyError); ok || ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		return pc.conn, nil
+	}
+}
+
+// Start the pool for info if ORPoolSize is set, and stop it when m is shut
+// down.
+func startORPoolFor(m *ShutdownManager, info *ServerInfo) {
+	if ORPoolSize <= 0 {
+		return
+	}
+	stop := info.StartORPool(ORPoolSize)
+	closed := m.closed()
+	go func() {
+		<-closed
+		stop()
+	}()
+}
@@ -0,0 +1,210 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Start a fake extended ORPort that authenticates with cookie and sends each
+// USERADDR it receives on the returned channel. It counts the connections it
+// accepts in *accepted.
+func startFakeExtOrPort(t *testing.T, cookie []byte, accepted *int32) (*net.TCPListener, <-chan string) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userAddrs := make(chan string, 10)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go func() {
+				defer c.Close()
+				if simulateServerExtOrPortAuth(c, c, cookie) != nil {
+					return
+				}
+				for {
+					cmd, body, err := extOrPortRecvCommand(c)
+					if err != nil {
+						return
+					}
+					if cmd == extOrCmdUserAddr {
+						userAddrs <- string(body)
+					}
+					if cmd == extOrCmdDone {
+						break
+					}
+				}
+				if extOrPortSendCommand(c, extOrCmdOkay, nil) != nil {
+					return
+				}
+				c.Read(make([]byte, 1))
+			}()
+		}
+	}()
+	return ln, userAddrs
+}
+
+func TestORPool(t *testing.T) {
+	cookie := make([]byte, 32)
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, cookiePath, cookie, time.Now())
+	var accepted int32
+	ln, userAddrs := startFakeExtOrPort(t, cookie, &accepted)
+	defer ln.Close()
+
+	info := &ServerInfo{
+		ExtendedOrAddr: ln.Addr().(*net.TCPAddr),
+		AuthCookiePath: cookiePath,
+	}
+	stop := info.StartORPool(2)
+	defer stop()
+
+	// Wait for the pool to fill.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(info.pool.conns) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool not filled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	before := atomic.LoadInt32(&accepted)
+
+	s, err := DialOr(info, "192.0.2.1:1234", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if addr := <-userAddrs; addr != "192.0.2.1:1234" {
+		t.Errorf("got USERADDR %q", addr)
+	}
+	// The connection came from the pool, so no new one was needed when
+	// DialOr returned. (The pool may have started refilling since.)
+	if n := atomic.LoadInt32(&accepted); n < before {
+		t.Errorf("accepted count went from %d to %d", before, n)
+	}
+
+	// With the pool stopped and emptied, DialOr dials directly.
+	stop()
+	if len(info.pool.conns) != 0 {
+		t.Errorf("%d connections left in pool after stop", len(info.pool.conns))
+	}
+	s2, err := DialOr(info, "192.0.2.2:1234", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if addr := <-userAddrs; addr != "192.0.2.2:1234" {
+		t.Errorf("got USERADDR %q", addr)
+	}
+}
+
+func TestORPoolStaleConn(t *testing.T) {
+	cookie := make([]byte, 32)
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, cookiePath, cookie, time.Now())
+	var accepted int32
+	ln, userAddrs := startFakeExtOrPort(t, cookie, &accepted)
+	defer ln.Close()
+
+	info := &ServerInfo{
+		ExtendedOrAddr: ln.Addr().(*net.TCPAddr),
+		AuthCookiePath: cookiePath,
+	}
+	// A pooled connection that tor has closed is skipped.
+	closed, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	info.pool = &orPool{conns: make(chan pooledOrConn, 1)}
+	info.pool.conns <- pooledOrConn{closed, time.Now()}
+
+	s, err := DialOr(info, "192.0.2.3:1234", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if addr := <-userAddrs; addr != "192.0.2.3:1234" {
+		t.Errorf("got USERADDR %q", addr)
+	}
+
+	// A StartORPool without an extended ORPort does nothing.
+	plain := &ServerInfo{OrAddr: ln.Addr().(*net.TCPAddr)}
+	plain.StartORPool(2)()
+	if plain.pool != nil {
+		t.Error("pool started without an extended ORPort")
+	}
+}
+
+func TestORPoolDeny(t *testing.T) {
+	cookie := make([]byte, 32)
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, cookiePath, cookie, time.Now())
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func() {
+				defer c.Close()
+				for {
+					cmd, _, err := extOrPortRecvCommand(c)
+					if err != nil || cmd == extOrCmdDone {
+						break
+					}
+				}
+				extOrPortSendCommand(c, extOrCmdDeny, nil)
+			}()
+		}
+	}()
+
+	// The pooled connection is taken as already authenticated.
+	pooled, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &ServerInfo{
+		ExtendedOrAddr: ln.Addr().(*net.TCPAddr),
+		AuthCookiePath: cookiePath,
+	}
+	info.pool = &orPool{conns: make(chan pooledOrConn, 1)}
+	info.pool.conns <- pooledOrConn{pooled, time.Now()}
+
+	var authStarts int
+	var authErr error
+	ctx := WithPTTrace(context.Background(), &PTTrace{
+		ORAuthStart: func() { authStarts++ },
+		ORAuthDone:  func(err error) { authErr = err },
+	})
+	before := statsForMethod("pooldeny").OrAuthFailures
+	_, err = DialOrContext(ctx, info, "192.0.2.4:1234", "pooldeny")
+	if _, ok := err.(*ExtOrPortDenyError); !ok {
+		t.Fatalf("got error %v, expected an *ExtOrPortDenyError", err)
+	}
+	// A DENY is returned without dialing a fresh connection.
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Errorf("%d connections accepted, expected 1", n)
+	}
+	if authStarts != 1 || authErr != err {
+		t.Errorf("ORAuthStart called %d times, ORAuthDone with %v", authStarts, authErr)
+	}
+	if n := statsForMethod("pooldeny").OrAuthFailures; n != before+1 {
+		t.Errorf("orAuthFailures went from %d to %d", before, n)
+	}
+}
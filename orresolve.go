@@ -0,0 +1,84 @@
+package pt
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// The host name and port of an ORPort given as a host name rather than an IP
+// address, and the addresses it most recently resolved to. It is shared by the
+// copies of a ServerInfo.
+type orName struct {
+	host string
+	port int
+
+	mu       sync.Mutex
+	addrs    []*net.TCPAddr
+	resolved time.Time
+	// Whether a lookup is in progress.
+	resolving bool
+}
+
+// Return an orName for addrStr, which resolved to addrs, or nil if addrStr has
+// a literal IP address and so never needs to be looked up again.
+func newORName(addrStr string, addrs []*net.TCPAddr) *orName {
+	host, portStr, _, err := splitAddrPort(addrStr)
+	if err != nil {
+		return nil
+	}
+	if _, _, err := parseIPZone(host); err == nil {
+		return nil
+	}
+	port, err := parsePort(portStr)
+	if err != nil {
+		return nil
+	}
+	return &orName{host: host, port: port, addrs: addrs, resolved: time.Now()}
+}
+
+// Return the addresses of n, looking the name up again first if the addresses
+// are more than ttl old. Only one lookup is done at a time; while it is in
+// progress, other callers get the previous addresses without waiting. If the
+// lookup fails, a warning is logged and the previous addresses are returned;
+// another lookup is not tried until ttl has passed again. If n is nil or ttl is
+// not positive, return fallback.
+func (n *orName) current(ttl time.Duration, fallback []*net.TCPAddr) []*net.TCPAddr {
+	if n == nil || ttl <= 0 {
+		return fallback
+	}
+	n.mu.Lock()
+	if n.resolving || time.Since(n.resolved) < ttl {
+		addrs := n.addrs
+		n.mu.Unlock()
+		return addrs
+	}
+	n.resolving = true
+	n.mu.Unlock()
+
+	addrs, err := lookupTCPAddrs(n.host, n.port)
+
+	n.mu.Lock()
+	n.resolving = false
+	n.resolved = time.Now()
+	if err == nil {
+		n.addrs = addrs
+	}
+	addrs = n.addrs
+	n.mu.Unlock()
+	if err != nil {
+		Log(LogSeverityWarning, fmt.Sprintf("cannot resolve ORPort %s again, keeping previous addresses: %s", n.host, err.Error()))
+	}
+	return addrs
+}
+
+// Return the addresses of the ORPort to dial.
+func (info *ServerInfo) currentOrAddrs() []*net.TCPAddr {
+	return info.orName.current(info.OrResolveTTL, addrsOrDefault(info.OrAddrs, info.OrAddr))
+}
+
+// Return the addresses of the extended ORPort to dial.
+func (info *ServerInfo) currentExtendedOrAddrs() []*net.TCPAddr {
+	return info.extendedOrName.current(info.OrResolveTTL, addrsOrDefault(info.ExtendedOrAddrs, info.ExtendedOrAddr))
+}
@@ -0,0 +1,151 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDialOrReresolve(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// The name first resolves to an address with nothing listening.
+	var mu sync.Mutex
+	answer := net.ParseIP("127.0.0.2")
+	var lookups int
+	saved := Resolver
+	defer func() { Resolver = saved }()
+	Resolver = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups++
+		if answer == nil {
+			return nil, errors.New("server failure")
+		}
+		return []net.IPAddr{{IP: answer}}, nil
+	}
+
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	t.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	t.Setenv("TOR_PT_SERVER_TRANSPORTS", "alpha")
+	t.Setenv("TOR_PT_SERVER_BINDADDR", "alpha-127.0.0.1:0")
+	t.Setenv("TOR_PT_ORPORT", fmt.Sprintf("orport.example:%d", port))
+	info, err := ServerSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	answer = net.ParseIP("127.0.0.1")
+	mu.Unlock()
+
+	// Without OrResolveTTL, the address from setup is used.
+	_, err = DialOr(&info, "", "")
+	if err == nil {
+		t.Fatal("DialOr to address from setup unexpectedly succeeded")
+	}
+
+	// With a long OrResolveTTL, the address is not yet stale.
+	info.OrResolveTTL = time.Hour
+	_, err = DialOr(&info, "", "")
+	if err == nil {
+		t.Fatal("DialOr before TTL expired unexpectedly succeeded")
+	}
+
+	info.OrResolveTTL = time.Nanosecond
+	s, err := DialOr(&info, "", "")
+	if err != nil {
+		t.Fatalf("DialOr after TTL expired: %s", err)
+	}
+	s.Close()
+
+	// A failed lookup keeps the previous address.
+	mu.Lock()
+	answer = nil
+	mu.Unlock()
+	s, err = DialOr(&info, "", "")
+	if err != nil {
+		t.Fatalf("DialOr after failed lookup: %s", err)
+	}
+	s.Close()
+	if !strings.Contains(buf.String(), "LOG SEVERITY=warning") {
+		t.Errorf("no warning for failed lookup in %q", buf.String())
+	}
+	mu.Lock()
+	if lookups != 3 {
+		t.Errorf("%d lookups, expected 3", lookups)
+	}
+	mu.Unlock()
+}
+
+// A slow lookup does not hold up other callers, and is not repeated by them.
+func TestORNameConcurrentLookup(t *testing.T) {
+	var mu sync.Mutex
+	var lookups int
+	started := make(chan struct{})
+	release := make(chan struct{})
+	saved := Resolver
+	defer func() { Resolver = saved }()
+	Resolver = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		mu.Lock()
+		lookups++
+		mu.Unlock()
+		close(started)
+		<-release
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.2")}}, nil
+	}
+
+	old := []*net.TCPAddr{{IP: net.ParseIP("127.0.0.1"), Port: 9001}}
+	n := newORName("orport.example:9001", old)
+	n.resolved = time.Time{}
+
+	done := make(chan []*net.TCPAddr)
+	go func() {
+		done <- n.current(time.Hour, nil)
+	}()
+	<-started
+	for i := 0; i < 3; i++ {
+		addrs := n.current(time.Hour, nil)
+		if len(addrs) != 1 || !addrs[0].IP.Equal(old[0].IP) {
+			t.Errorf("during lookup, got %v", addrs)
+		}
+	}
+	close(release)
+	addrs := <-done
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("127.0.0.2")) || addrs[0].Port != 9001 {
+		t.Errorf("after lookup, got %v", addrs)
+	}
+	addrs = n.current(time.Hour, nil)
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("127.0.0.2")) {
+		t.Errorf("after lookup, later call got %v", addrs)
+	}
+	mu.Lock()
+	if lookups != 1 {
+		t.Errorf("%d lookups, expected 1", lookups)
+	}
+	mu.Unlock()
+}
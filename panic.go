@@ -0,0 +1,31 @@
+package pt
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Log a panic, recovered from a goroutine that the package started, as LOG
+// SEVERITY=error lines: one with what and the panic value, and one with the
+// stack of the goroutine.
+func logPanic(what string, r interface{}, stack []byte) {
+	Log(LogSeverityError, fmt.Sprintf("panic in %s: %v", what, r))
+	Log(LogSeverityError, fmt.Sprintf("stack of panic in %s: %s", what, stack))
+}
+
+// Recover a panic, log it with logPanic, and call cleanup, if it is not nil,
+// to release what the goroutine held. It must be called with defer, at the top
+// of a goroutine:
+//
+//	go func() {
+//		defer recoverPanic("relay", func() { conn.Close() })
+//		...
+//	}()
+func recoverPanic(what string, cleanup func()) {
+	if r := recover(); r != nil {
+		logPanic(what, r, debug.Stack())
+		if cleanup != nil {
+			cleanup()
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package pt
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+var parentGone struct {
+	once sync.Once
+	c    chan struct{}
+}
+
+// Return a channel that is closed when the parent process (normally tor)
+// appears to have gone away. On POSIX systems, that is when stdin reaches EOF
+// or returns an error; on Windows, it is when the parent process handle
+// becomes signaled, because tor on Windows does not reliably close a child's
+// stdin when it exits. The watch starts on the first call; later calls return
+// the same channel.
+//
+// Tor sets TOR_PT_EXIT_ON_STDIN_CLOSE=1 to indicate that a transport should
+// shut down when this happens (https://bugs.torproject.org/15435). The
+// function does not check the variable itself; HandleShutdownSignals does.
+func WatchParent() <-chan struct{} {
+	parentGone.once.Do(func() {
+		parentGone.c = make(chan struct{})
+		go func() {
+			waitParent()
+			close(parentGone.c)
+		}()
+	})
+	return parentGone.c
+}
+
+// Block until stdin is closed.
+func waitStdinClose() {
+	io.Copy(ioutil.Discard, os.Stdin)
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package pt
+
+// Block until the parent process appears to have gone away.
+func waitParent() {
+	waitStdinClose()
+}
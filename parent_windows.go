@@ -0,0 +1,19 @@
+package pt
+
+import (
+	"os"
+	"syscall"
+)
+
+// Block until the parent process appears to have gone away. If the parent
+// process can't be opened (it may already have exited, or we may lack
+// permission), fall back to watching stdin.
+func waitParent() {
+	h, err := syscall.OpenProcess(syscall.SYNCHRONIZE, false, uint32(os.Getppid()))
+	if err != nil {
+		waitStdinClose()
+		return
+	}
+	defer syscall.CloseHandle(h)
+	syscall.WaitForSingleObject(h, syscall.INFINITE)
+}
@@ -138,7 +138,7 @@
 package pt
 
 import (
-	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -146,24 +146,39 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// This type wraps a Write method and calls Sync after each Write.
+// This type wraps a Write method and calls Sync after each Write. Except on
+// Windows, Sync is skipped when the file is not a regular file: syncing a pipe
+// or terminal has no effect and costs a system call per line.
 type syncWriter struct {
 	*os.File
+	once     sync.Once
+	skipSync bool
 }
 
 // Call File.Write and then Sync. An error is returned if either operation
 // returns an error.
-func (w syncWriter) Write(p []byte) (n int, err error) {
+func (w *syncWriter) Write(p []byte) (n int, err error) {
+	w.once.Do(func() {
+		if runtime.GOOS == "windows" {
+			return
+		}
+		fi, err := w.File.Stat()
+		w.skipSync = err == nil && !fi.Mode().IsRegular()
+	})
 	n, err = w.File.Write(p)
-	if err != nil {
+	if err != nil || w.skipSync {
 		return
 	}
 	err = w.Sync()
@@ -172,7 +187,7 @@ func (w syncWriter) Write(p []byte) (n int, err error) {
 
 // Writer to which pluggable transports negotiation messages are written. It
 // defaults to a Writer that writes to os.Stdout and calls Sync after each
-// write.
+// write, if os.Stdout is a regular file or the platform is Windows.
 //
 // You may, for example, log pluggable transports messages by defining a Writer
 // that logs what is written to it:
@@ -186,7 +201,7 @@ func (w syncWriter) Write(p []byte) (n int, err error) {
 // 	}
 // and then redefining Stdout:
 // 	pt.Stdout = logWriteWrapper{pt.Stdout}
-var Stdout io.Writer = syncWriter{os.Stdout}
+var Stdout io.Writer = &syncWriter{File: os.Stdout}
 
 // Represents an error that can happen during negotiation, for example
 // ENV-ERROR. When an error occurs, we print it to stdout and also pass it up
@@ -201,13 +216,19 @@ func (err *ptErr) Error() string {
 	return formatline(err.Keyword, err.Args...)
 }
 
+// Get an environment variable, from the process environment or else the file
+// named by TOR_PT_ENV_FILE.
 func getenv(key string) string {
-	return os.Getenv(key)
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	environ, _ := envFileEnviron()
+	return environGet(environ, key)
 }
 
 // Returns an ENV-ERROR if the environment variable isn't set.
 func getenvRequired(key string) (string, error) {
-	value := os.Getenv(key)
+	value := getenv(key)
 	if value == "" {
 		return "", envError(fmt.Sprintf("no %s environment variable", key))
 	}
@@ -247,77 +268,187 @@ func argIsSafe(arg string) bool {
 }
 
 func formatline(keyword string, v ...string) string {
-	var buf bytes.Buffer
+	return string(appendLine(nil, keyword, v...))
+}
+
+// Append the protocol line made of keyword and v, without a trailing newline,
+// to b. Panics if there are forbidden bytes in the keyword or the args.
+func appendLine(b []byte, keyword string, v ...string) []byte {
+	if err := checkLine(keyword, v); err != nil {
+		panic(err.Error())
+	}
+	b = append(b, keyword...)
+	for _, x := range v {
+		b = append(b, ' ')
+		b = append(b, x...)
+	}
+	return b
+}
+
+// Return an error if there are forbidden bytes in keyword or v.
+func checkLine(keyword string, v []string) error {
 	if !keywordIsSafe(keyword) {
-		panic(fmt.Sprintf("keyword %q contains forbidden bytes", keyword))
+		return fmt.Errorf("keyword %q contains forbidden bytes", keyword)
 	}
-	buf.WriteString(keyword)
 	for _, x := range v {
 		if !argIsSafe(x) {
-			panic(fmt.Sprintf("arg %q contains forbidden bytes", x))
+			return fmt.Errorf("arg %q contains forbidden bytes", x)
 		}
-		buf.WriteString(" " + x)
 	}
-	return buf.String()
+	return nil
 }
 
-// Print a pluggable transports protocol line to Stdout. The line consists of a
-// keyword followed by any number of space-separated arg strings. Panics if
-// there are forbidden bytes in the keyword or the args (pt-spec.txt 2.2.1).
+// Print a pluggable transports protocol line to Stdout, or pass it to
+// EventReporter. The line consists of a keyword followed by any number of
+// space-separated arg strings. Panics if there are forbidden bytes in the
+// keyword or the args (pt-spec.txt 2.2.1).
 func line(keyword string, v ...string) {
-	fmt.Fprintln(Stdout, formatline(keyword, v...))
+	emit(Event{Keyword: keyword, LineArgs: v})
 }
 
 // Emit and return the given error as a ptErr.
-func doError(keyword string, v ...string) *ptErr {
-	line(keyword, v...)
-	return &ptErr{keyword, v}
+func doError(e Event) *ptErr {
+	emit(e)
+	return &ptErr{e.Keyword, e.LineArgs}
 }
 
 // Emit an ENV-ERROR line with explanation text. Returns a representation of the
 // error.
 func envError(msg string) error {
-	return doError("ENV-ERROR", msg)
+	return doError(Event{Keyword: "ENV-ERROR", Message: msg, LineArgs: []string{msg}})
 }
 
 // Emit a VERSION-ERROR line with explanation text. Returns a representation of
 // the error.
 func versionError(msg string) error {
-	return doError("VERSION-ERROR", msg)
+	return doError(Event{Keyword: "VERSION-ERROR", Message: msg, LineArgs: []string{msg}})
 }
 
 // Emit a CMETHOD-ERROR line with explanation text. Returns a representation of
 // the error.
 func CmethodError(methodName, msg string) error {
-	return doError("CMETHOD-ERROR", methodName, msg)
+	return doError(Event{Keyword: "CMETHOD-ERROR", MethodName: methodName, Message: msg, LineArgs: []string{methodName, msg}})
 }
 
 // Emit an SMETHOD-ERROR line with explanation text. Returns a representation of
 // the error.
 func SmethodError(methodName, msg string) error {
-	return doError("SMETHOD-ERROR", methodName, msg)
+	return doError(Event{Keyword: "SMETHOD-ERROR", MethodName: methodName, Message: msg, LineArgs: []string{methodName, msg}})
+}
+
+// MethodErrorReason is a code that classifies the failure reported by a
+// CMETHOD-ERROR or SMETHOD-ERROR line, so that programs that parse a
+// transport's output can tell kinds of failure apart without matching on
+// free-form messages. A reason consists of lowercase letters, digits, and
+// dashes. Transports may define reasons of their own besides the ones here.
+type MethodErrorReason string
+
+// Reasons for common failures.
+const (
+	// A listener could not be opened on the requested address.
+	ReasonBindFailed MethodErrorReason = "bind-failed"
+	// A host name or address could not be resolved.
+	ReasonResolveFailed MethodErrorReason = "resolve-failed"
+	// A transport option, or a feature requested by tor, is not supported.
+	ReasonUnsupportedOption MethodErrorReason = "unsupported-option"
+	// The requested method is not one the transport provides.
+	ReasonUnknownMethod MethodErrorReason = "unknown-method"
+)
+
+// Return true iff reason is nonempty and consists only of lowercase letters,
+// digits, and dashes.
+func reasonIsValid(reason MethodErrorReason) bool {
+	if reason == "" {
+		return false
+	}
+	for _, b := range []byte(reason) {
+		if !('a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the message of a method error line with the given reason.
+func reasonMessage(reason MethodErrorReason, msg string) string {
+	if !reasonIsValid(reason) {
+		panic(fmt.Sprintf("invalid method error reason %q", reason))
+	}
+	if msg == "" {
+		return string(reason)
+	}
+	return string(reason) + ": " + msg
+}
+
+// Emit a CMETHOD-ERROR line whose message is reason, a colon, and explanation
+// text, for example
+//
+//	CMETHOD-ERROR foo bind-failed: listen tcp 127.0.0.1:0: too many open files
+//
+// The Reason and Message of the emitted Event are reason and msg. Returns a
+// representation of the error. Panics if reason is not a valid reason.
+func CmethodErrorReason(methodName string, reason MethodErrorReason, msg string) error {
+	return doError(Event{Keyword: "CMETHOD-ERROR", MethodName: methodName, Reason: string(reason), Message: msg,
+		LineArgs: []string{methodName, reasonMessage(reason, msg)}})
+}
+
+// Like CmethodErrorReason, but emit an SMETHOD-ERROR line.
+func SmethodErrorReason(methodName string, reason MethodErrorReason, msg string) error {
+	return doError(Event{Keyword: "SMETHOD-ERROR", MethodName: methodName, Reason: string(reason), Message: msg,
+		LineArgs: []string{methodName, reasonMessage(reason, msg)}})
+}
+
+// Split the message of a CMETHOD-ERROR or SMETHOD-ERROR line into the reason
+// and the explanation text, the inverse of CmethodErrorReason and
+// SmethodErrorReason. If the message does not begin with a valid reason
+// followed by ": " or the end of the message, the reason is "" and the text is
+// the whole message. A message from CmethodError or SmethodError may happen to
+// look as if it begins with a reason.
+func SplitMethodErrorReason(msg string) (reason MethodErrorReason, text string) {
+	prefix := msg
+	if i := strings.Index(msg, ": "); i >= 0 {
+		prefix, text = msg[:i], msg[i+2:]
+	}
+	if !reasonIsValid(MethodErrorReason(prefix)) {
+		return "", msg
+	}
+	return MethodErrorReason(prefix), text
 }
 
 // Emit a PROXY-ERROR line with explanation text. Returns a representation of
 // the error.
 func ProxyError(msg string) error {
-	return doError("PROXY-ERROR", msg)
+	return doError(Event{Keyword: "PROXY-ERROR", Message: msg, LineArgs: []string{msg}})
 }
 
 // Emit a CMETHOD line. socks must be "socks4" or "socks5". Call this once for
 // each listening client SOCKS port.
 func Cmethod(name string, socks string, addr net.Addr) {
-	line("CMETHOD", name, socks, addr.String())
+	emit(Event{
+		Keyword:    "CMETHOD",
+		MethodName: name,
+		Protocol:   socks,
+		Addr:       addr,
+		LineArgs:   []string{name, socks, addr.String()},
+	})
 }
 
-// Emit a CMETHODS DONE line. Call this after opening all client listeners.
+// Emit a CMETHODS DONE line. Call this after opening all client listeners. If
+// SystemdNotify is set, also tell the service manager that the transport is
+// ready.
 func CmethodsDone() {
 	line("CMETHODS", "DONE")
+	sdNotifyReady()
 }
 
 // Emit an SMETHOD line. Call this once for each listening server port.
 func Smethod(name string, addr net.Addr) {
-	line("SMETHOD", name, addr.String())
+	emit(Event{
+		Keyword:    "SMETHOD",
+		MethodName: name,
+		Addr:       addr,
+		LineArgs:   []string{name, addr.String()},
+	})
 }
 
 // Emit an SMETHOD line with an ARGS option. args is a name–value mapping that
@@ -336,17 +467,26 @@ func Smethod(name string, addr net.Addr) {
 // TransportServerOptions configuration,
 // 	pt.SmethodArgs(bindaddr.MethodName, ln.Addr(), bindaddr.Options)
 func SmethodArgs(name string, addr net.Addr, args Args) {
-	line("SMETHOD", name, addr.String(), "ARGS:"+encodeSmethodArgs(args))
+	emit(Event{
+		Keyword:    "SMETHOD",
+		MethodName: name,
+		Addr:       addr,
+		Args:       args,
+		LineArgs:   []string{name, addr.String(), "ARGS:" + encodeSmethodArgs(args)},
+	})
 }
 
-// Emit an SMETHODS DONE line. Call this after opening all server listeners.
+// Emit an SMETHODS DONE line. Call this after opening all server listeners. If
+// SystemdNotify is set, also tell the service manager that the transport is
+// ready.
 func SmethodsDone() {
 	line("SMETHODS", "DONE")
+	sdNotifyReady()
 }
 
 // Emit a PROXY DONE line. Call this after parsing ClientInfo.ProxyURL.
 func ProxyDone() {
-	fmt.Fprintf(Stdout, "PROXY DONE\n")
+	line("PROXY", "DONE")
 }
 
 // Unexported type to represent log severities, preventing external callers from
@@ -390,17 +530,21 @@ var (
 // We additionally need to ensure that whatever we return passes argIsSafe,
 // because strings encoded by this function are printed verbatim by Log.
 func encodeCString(s string) string {
-	result := bytes.NewBuffer([]byte{})
-	result.WriteByte('"')
-	for _, c := range []byte(s) {
+	return string(appendCString(nil, s))
+}
+
+// Append the encoding of s by encodeCString to b.
+func appendCString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
 		if c == 32 || c == 33 || (35 <= c && c <= 91) || (93 <= c && c <= 126) {
-			result.WriteByte(c)
+			b = append(b, c)
 		} else {
-			fmt.Fprintf(result, "\\%03o", c)
+			b = append(b, '\\', '0'+c>>6, '0'+(c>>3)&7, '0'+c&7)
 		}
 	}
-	result.WriteByte('"')
-	return result.String()
+	return append(b, '"')
 }
 
 // Emit a LOG message with the given severity (one of LogSeverityError,
@@ -409,24 +553,22 @@ func Log(severity logSeverity, message string) {
 	// "<Message> contains the log message which can be a String or CString..."
 	// encodeCString always makes the string safe to emit; i.e., it
 	// satisfies argIsSafe.
-	line("LOG", "SEVERITY="+severity.string, "MESSAGE="+encodeCString(message))
+	emit(logEvent(severity, message))
 }
 
-// Get a pluggable transports version offered by Tor and understood by us, if
-// any. The only version we understand is "1". This function reads the
-// environment variable TOR_PT_MANAGED_TRANSPORT_VER.
-func getManagedTransportVer() (string, error) {
-	const transportVersion = "1"
-	managedTransportVer, err := getenvRequired("TOR_PT_MANAGED_TRANSPORT_VER")
-	if err != nil {
-		return "", err
-	}
-	for _, offered := range strings.Split(managedTransportVer, ",") {
-		if offered == transportVersion {
-			return offered, nil
-		}
+func logEvent(severity logSeverity, message string) Event {
+	return Event{
+		Keyword:  "LOG",
+		Severity: severity.string,
+		Message:  message,
+		LineArgs: []string{"SEVERITY=" + severity.string, string(appendCString([]byte("MESSAGE="), message))},
 	}
-	return "", versionError("no-version")
+}
+
+// Parse a comma-separated list of managed transport protocol versions, as from
+// TOR_PT_MANAGED_TRANSPORT_VER. No escaping is defined for the list.
+func ParseManagedTransportVersions(s string) []string {
+	return strings.Split(s, ",")
 }
 
 // Return the directory name in the TOR_PT_STATE_LOCATION environment variable,
@@ -445,8 +587,13 @@ func MakeStateDir() (string, error) {
 // Get the list of method names requested by Tor. This function reads the
 // environment variable TOR_PT_CLIENT_TRANSPORTS.
 func getClientTransports() ([]string, error) {
-	clientTransports, err := getenvRequired("TOR_PT_CLIENT_TRANSPORTS")
-	if err != nil {
+	return checkClientTransports(&envChecker{})
+}
+
+// Like getClientTransports, but record problems in c.
+func checkClientTransports(c *envChecker) ([]string, error) {
+	clientTransports, err := c.getenvRequired("TOR_PT_CLIENT_TRANSPORTS")
+	if err != nil || clientTransports == "" {
 		return nil, err
 	}
 	return strings.Split(clientTransports, ","), nil
@@ -462,7 +609,11 @@ func getClientTransports() ([]string, error) {
 // able to handle any returned scheme (which may be by calling ProxyError if
 // it doesn't know how to handle the scheme).
 func getProxyURL() (*url.URL, error) {
-	rawurl := os.Getenv("TOR_PT_PROXY")
+	return parseProxyURL(getenv("TOR_PT_PROXY"))
+}
+
+// Like getProxyURL, but parse rawurl rather than TOR_PT_PROXY.
+func parseProxyURL(rawurl string) (*url.URL, error) {
 	if rawurl == "" {
 		return nil, nil
 	}
@@ -493,12 +644,25 @@ func getProxyURL() (*url.URL, error) {
 // names and the upstream proxy URL, if any.
 type ClientInfo struct {
 	MethodNames []string
-	ProxyURL    *url.URL
+	// The unparsed value of TOR_PT_CLIENT_TRANSPORTS, from which
+	// MethodNames is split.
+	OfferedTransports string
+	// The upstream proxy from TOR_PT_PROXY, or nil if there is none. After
+	// setting up the proxy, call ProxyDone or ProxyError.
+	ProxyURL *url.URL
+	// The negotiated managed transport protocol version, and all the
+	// versions offered in TOR_PT_MANAGED_TRANSPORT_VER.
+	Version         string
+	OfferedVersions []string
+	// The optional features announced in the FEATURES line; see
+	// RegisterFeature.
+	Features []string
 }
 
 // Check the client pluggable transports environment, emitting an error message
 // and returning a non-nil error if any error is encountered. Returns a
-// ClientInfo struct.
+// ClientInfo struct. See ReportAllEnvErrors for how to have every error
+// reported, rather than only the first.
 //
 // If your program needs to know whether to call ClientSetup or ServerSetup
 // (i.e., if the same program can be run as either a client or a server), check
@@ -509,24 +673,44 @@ type ClientInfo struct {
 // 		// Server mode; call pt.ServerSetup.
 // 	}
 //
+// To tell whether the program was started by tor as a managed transport at
+// all, rather than being run standalone, call Managed.
+//
 // Always pass nil for the unused single parameter. In the past, the parameter
 // was a list of transport names to use in case Tor requested "*". That feature
 // was never implemented and has been removed from the pluggable transports
 // specification.
 // https://bugs.torproject.org/15612
+//
+// ClientSetup is the same as ClientSetupOptions with no options.
 func ClientSetup(_ []string) (info ClientInfo, err error) {
-	ver, err := getManagedTransportVer()
+	return ClientSetupOptions()
+}
+
+func clientSetup(o *setupOptions) (info ClientInfo, err error) {
+	err = checkEnvFile(o)
 	if err != nil {
 		return
 	}
-	line("VERSION", ver)
+	info.Version, info.OfferedVersions, err = negotiateVersion(o)
+	if err != nil {
+		return
+	}
+	info.Features = featuresFor(info.Version)
+	checkStrictEnv(o)
 
-	info.MethodNames, err = getClientTransports()
+	c := newEnvChecker(o)
+	info.MethodNames, err = checkClientTransports(c)
+	if err != nil {
+		return
+	}
+	info.OfferedTransports = o.getenv("TOR_PT_CLIENT_TRANSPORTS")
+	err = c.err()
 	if err != nil {
 		return
 	}
 
-	info.ProxyURL, err = getProxyURL()
+	info.ProxyURL, err = parseProxyURL(o.getenv("TOR_PT_PROXY"))
 	if err != nil {
 		return
 	}
@@ -540,8 +724,14 @@ type Bindaddr struct {
 	MethodName string
 	Addr       *net.TCPAddr
 	// Options from TOR_PT_SERVER_TRANSPORT_OPTIONS that pertain to this
-	// transport.
+	// transport. A program that makes its own Bindaddrs may set any
+	// per-listener configuration here.
 	Options Args
+	// The position of this bindaddr in TOR_PT_SERVER_BINDADDR (or in the
+	// string passed to ParseBindaddrs), counting from 0. It distinguishes
+	// the listeners of a transport when the Bindaddrs have been filtered
+	// or reordered, for example in the name of a per-listener state file.
+	MethodIndex int
 }
 
 func parsePort(portStr string) (int, error) {
@@ -551,39 +741,107 @@ func parsePort(portStr string) (int, error) {
 
 // Resolve an address string into a net.TCPAddr. We are a bit more strict than
 // net.ResolveTCPAddr; we don't allow an empty host or port, and the host part
-// must be a literal IP address.
+// must be a literal IP address as accepted by ParseAddrPort, unless Resolver is
+// set. If a host name resolves to more than one address, the first is returned.
 func resolveAddr(addrStr string) (*net.TCPAddr, error) {
-	ipStr, portStr, err := net.SplitHostPort(addrStr)
+	addrs, err := resolveAddrs(addrStr)
 	if err != nil {
-		// Before the fixing of bug #7011, tor doesn't put brackets around IPv6
-		// addresses. Split after the last colon, assuming it is a port
-		// separator, and try adding the brackets.
-		// https://bugs.torproject.org/7011
-		parts := strings.Split(addrStr, ":")
-		if len(parts) <= 2 {
-			return nil, err
-		}
-		addrStr := "[" + strings.Join(parts[:len(parts)-1], ":") + "]:" + parts[len(parts)-1]
-		ipStr, portStr, err = net.SplitHostPort(addrStr)
+		return nil, err
 	}
+	return addrs[0], nil
+}
+
+// Like resolveAddr, but return all the addresses a host name resolves to.
+func resolveAddrs(addrStr string) ([]*net.TCPAddr, error) {
+	host, portStr, bracketed, err := splitAddrPort(addrStr)
 	if err != nil {
 		return nil, err
 	}
-	if ipStr == "" {
-		return nil, net.InvalidAddrError(fmt.Sprintf("address string %q lacks a host part", addrStr))
+	port, err := parsePort(portStr)
+	if err != nil {
+		return nil, err
 	}
-	if portStr == "" {
-		return nil, net.InvalidAddrError(fmt.Sprintf("address string %q lacks a port part", addrStr))
+	ip, zone, err := parseIPZone(host)
+	if err == nil {
+		return []*net.TCPAddr{{IP: ip, Port: port, Zone: zone}}, nil
 	}
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		return nil, net.InvalidAddrError(fmt.Sprintf("not an IP string: %q", ipStr))
+	// A bracketed host must be an IPv6 literal.
+	if bracketed || Resolver == nil {
+		return nil, err
+	}
+	return lookupTCPAddrs(host, port)
+}
+
+// Parse a string of the form "<address>:<port>", where <address> is a literal
+// IPv4 or IPv6 address, into a net.TCPAddr. No DNS lookups are done. Neither the
+// address nor the port may be empty. An IPv6 address is normally enclosed in
+// square brackets, as in "[2001:db8::1]:9001", but for compatibility with
+// versions of tor before the fixing of bug #7011, an unbracketed IPv6 address is
+// also accepted, with the port taken to be after the last colon. An IPv6 address
+// may have a zone identifier, as in "[fe80::1%eth0]:9001". Wildcard addresses
+// such as "0.0.0.0:9001" and "[::]:9001" are returned as the unspecified
+// address.
+//
+// https://bugs.torproject.org/7011
+func ParseAddrPort(s string) (*net.TCPAddr, error) {
+	host, portStr, _, err := splitAddrPort(s)
+	if err != nil {
+		return nil, err
 	}
 	port, err := parsePort(portStr)
 	if err != nil {
 		return nil, err
 	}
-	return &net.TCPAddr{IP: ip, Port: port}, nil
+	ip, zone, err := parseIPZone(host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: ip, Port: port, Zone: zone}, nil
+}
+
+// Split s into host and port parts as described for ParseAddrPort. bracketed is
+// true if the host part was enclosed in square brackets.
+func splitAddrPort(s string) (host, port string, bracketed bool, err error) {
+	if strings.HasPrefix(s, "[") {
+		host, port, err = net.SplitHostPort(s)
+		if err != nil {
+			return "", "", false, err
+		}
+		bracketed = true
+	} else {
+		i := strings.LastIndexByte(s, ':')
+		if i < 0 {
+			return "", "", false, &net.AddrError{Err: "missing port in address", Addr: s}
+		}
+		host, port = s[:i], s[i+1:]
+	}
+	if host == "" {
+		return "", "", false, net.InvalidAddrError(fmt.Sprintf("address string %q lacks a host part", s))
+	}
+	if port == "" {
+		return "", "", false, net.InvalidAddrError(fmt.Sprintf("address string %q lacks a port part", s))
+	}
+	return host, port, bracketed, nil
+}
+
+// Parse a literal IP address with an optional "%zone" suffix. A zone is allowed
+// only on an IPv6 address.
+func parseIPZone(s string) (net.IP, string, error) {
+	ipStr, zone := s, ""
+	if i := strings.LastIndexByte(s, '%'); i >= 0 {
+		ipStr, zone = s[:i], s[i+1:]
+		if zone == "" {
+			return nil, "", net.InvalidAddrError(fmt.Sprintf("empty zone in %q", s))
+		}
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, "", net.InvalidAddrError(fmt.Sprintf("not an IP string: %q", ipStr))
+	}
+	if zone != "" && ip.To4() != nil {
+		return nil, "", net.InvalidAddrError(fmt.Sprintf("zone on an IPv4 address: %q", s))
+	}
+	return ip, zone, nil
 }
 
 // Return a new slice, the members of which are those members of addrs having a
@@ -603,50 +861,81 @@ func filterBindaddrs(addrs []Bindaddr, methodNames []string) []Bindaddr {
 	return result
 }
 
-// Return an array of Bindaddrs, being the contents of TOR_PT_SERVER_BINDADDR
-// with keys filtered by TOR_PT_SERVER_TRANSPORTS. Transport-specific options
-// from TOR_PT_SERVER_TRANSPORT_OPTIONS are assigned to the Options member.
-func getServerBindaddrs() ([]Bindaddr, error) {
+// Parse a comma-separated list of <methodname>-<address>:<port> specifications,
+// as from TOR_PT_SERVER_BINDADDR, into a slice of Bindaddrs. The Options
+// member of each Bindaddr is left nil, and the MethodIndex member is set to the
+// Bindaddr's position in s. Returns an error if any address is not
+// a literal IP address and port (or a host name and port, if Resolver is set),
+// or if a method name is repeated.
+func ParseBindaddrs(s string) ([]Bindaddr, error) {
 	var result []Bindaddr
 
-	// Parse the list of server transport options.
-	serverTransportOptions := getenv("TOR_PT_SERVER_TRANSPORT_OPTIONS")
-	optionsMap, err := parseServerTransportOptions(serverTransportOptions)
-	if err != nil {
-		return nil, envError(fmt.Sprintf("TOR_PT_SERVER_TRANSPORT_OPTIONS: %q: %s", serverTransportOptions, err.Error()))
-	}
-
-	// Get the list of all requested bindaddrs.
-	serverBindaddr, err := getenvRequired("TOR_PT_SERVER_BINDADDR")
-	if err != nil {
-		return nil, err
-	}
 	seenMethods := make(map[string]bool)
-	for _, spec := range strings.Split(serverBindaddr, ",") {
-		var bindaddr Bindaddr
+	for i, spec := range strings.Split(s, ",") {
+		bindaddr := Bindaddr{MethodIndex: i}
 
 		parts := strings.SplitN(spec, "-", 2)
 		if len(parts) != 2 {
-			return nil, envError(fmt.Sprintf("TOR_PT_SERVER_BINDADDR: %q: doesn't contain \"-\"", spec))
+			return nil, fmt.Errorf("%q: doesn't contain \"-\"", spec)
 		}
 		bindaddr.MethodName = parts[0]
 		// Check for duplicate method names: "Applications MUST NOT set
 		// more than one <address>:<port> pair per PT name."
 		if seenMethods[bindaddr.MethodName] {
-			return nil, envError(fmt.Sprintf("TOR_PT_SERVER_BINDADDR: %q: duplicate method name %q", spec, bindaddr.MethodName))
+			return nil, fmt.Errorf("%q: duplicate method name %q", spec, bindaddr.MethodName)
 		}
 		seenMethods[bindaddr.MethodName] = true
 		addr, err := resolveAddr(parts[1])
 		if err != nil {
-			return nil, envError(fmt.Sprintf("TOR_PT_SERVER_BINDADDR: %q: %s", spec, err.Error()))
+			return nil, fmt.Errorf("%q: %s", spec, err.Error())
 		}
 		bindaddr.Addr = addr
-		bindaddr.Options = optionsMap[bindaddr.MethodName]
 		result = append(result, bindaddr)
 	}
 
+	return result, nil
+}
+
+// Return an array of Bindaddrs, being the contents of TOR_PT_SERVER_BINDADDR
+// with keys filtered by TOR_PT_SERVER_TRANSPORTS. Transport-specific options
+// from TOR_PT_SERVER_TRANSPORT_OPTIONS are assigned to the Options member.
+func getServerBindaddrs() ([]Bindaddr, error) {
+	return checkServerBindaddrs(&envChecker{})
+}
+
+// Like getServerBindaddrs, but record problems in c.
+func checkServerBindaddrs(c *envChecker) ([]Bindaddr, error) {
+	// Parse the list of server transport options.
+	serverTransportOptions := c.o.getenv("TOR_PT_SERVER_TRANSPORT_OPTIONS")
+	optionsMap, err := ParseServerTransportOptions(serverTransportOptions)
+	if err != nil {
+		err = c.problem(fmt.Sprintf("TOR_PT_SERVER_TRANSPORT_OPTIONS: %q: %s", serverTransportOptions, err.Error()))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get the list of all requested bindaddrs.
+	serverBindaddr, err := c.getenvRequired("TOR_PT_SERVER_BINDADDR")
+	if err != nil {
+		return nil, err
+	}
+	var result []Bindaddr
+	if serverBindaddr != "" {
+		result, err = ParseBindaddrs(serverBindaddr)
+		if err != nil {
+			err = c.problem(fmt.Sprintf("TOR_PT_SERVER_BINDADDR: %s", err.Error()))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := range result {
+		result[i].Options = optionsMap[result[i].MethodName]
+	}
+
 	// Filter by TOR_PT_SERVER_TRANSPORTS.
-	serverTransports, err := getenvRequired("TOR_PT_SERVER_TRANSPORTS")
+	serverTransports, err := c.getenvRequired("TOR_PT_SERVER_TRANSPORTS")
 	if err != nil {
 		return nil, err
 	}
@@ -655,23 +944,18 @@ func getServerBindaddrs() ([]Bindaddr, error) {
 	return result, nil
 }
 
-func readAuthCookie(f io.Reader) ([]byte, error) {
+// Parse and validate the contents of an auth cookie file. Returns the 32-byte
+// cookie. See section 4.2.1.2 of 217-ext-orport-auth.txt.
+func ParseAuthCookie(data []byte) ([]byte, error) {
 	authCookieHeader := []byte("! Extended ORPort Auth Cookie !\x0a")
-	buf := make([]byte, 64)
 
-	n, err := io.ReadFull(f, buf)
-	if err != nil {
-		return nil, err
-	}
-	// Check that the file ends here.
-	n, err = f.Read(make([]byte, 1))
-	if n != 0 {
+	if len(data) < 64 {
+		return nil, fmt.Errorf("file is shorter than 64 bytes")
+	} else if len(data) > 64 {
 		return nil, fmt.Errorf("file is longer than 64 bytes")
-	} else if err != io.EOF {
-		return nil, fmt.Errorf("did not find EOF at end of file")
 	}
-	header := buf[0:32]
-	cookie := buf[32:64]
+	header := data[0:32]
+	cookie := data[32:64]
 	if subtle.ConstantTimeCompare(header, authCookieHeader) != 1 {
 		return nil, fmt.Errorf("missing auth cookie header")
 	}
@@ -679,6 +963,22 @@ func readAuthCookie(f io.Reader) ([]byte, error) {
 	return cookie, nil
 }
 
+func readAuthCookie(f io.Reader) ([]byte, error) {
+	// Read one byte more than necessary, in order to detect a file that is
+	// too long.
+	data, err := ioutil.ReadAll(io.LimitReader(f, 65))
+	defer wipe(data)
+	if err != nil {
+		return nil, err
+	}
+	cookie, err := ParseAuthCookie(data)
+	if err != nil {
+		return nil, err
+	}
+	// Return a copy, so that the file contents can be wiped.
+	return append([]byte(nil), cookie...), nil
+}
+
 // Read and validate the contents of an auth cookie file. Returns the 32-byte
 // cookie. See section 4.2.1.2 of 217-ext-orport-auth.txt.
 func readAuthCookieFile(filename string) (cookie []byte, err error) {
@@ -704,12 +1004,57 @@ type ServerInfo struct {
 	OrAddr         *net.TCPAddr
 	ExtendedOrAddr *net.TCPAddr
 	AuthCookiePath string
+	// If not nil, called for the 32-byte auth cookie of the extended ORPort
+	// in place of reading AuthCookiePath. It must return a new slice each
+	// time, which is wiped after use. See AuthCookieSource.
+	AuthCookie func() ([]byte, error)
+	// Socket options for connections made by DialOr. ServerSetup leaves
+	// this as the zero value; set it afterward to tune OR connections.
+	OrTCPOptions TCPOptions
+	// All the candidate addresses of the ORPort and extended ORPort, of
+	// which OrAddr and ExtendedOrAddr are the first. There may be more
+	// than one (for example, both an IPv6 and an IPv4 address) when
+	// Resolver is set, and a program may set its own list of fallbacks
+	// after ServerSetup. When set, DialOr dials them in place of OrAddr
+	// and ExtendedOrAddr, as described for DialOr: the order is kept within
+	// each address family, but the families alternate, and an attempt is
+	// started on the next address if the previous one fails or is slow. A
+	// down address therefore does not stop connections, but the addresses
+	// are not tried strictly in order. Only TCP addresses are possible,
+	// because DialOr returns a *net.TCPConn.
+	OrAddrs         []*net.TCPAddr
+	ExtendedOrAddrs []*net.TCPAddr
+	// If positive, and TOR_PT_ORPORT or TOR_PT_EXTENDED_SERVER_PORT is a
+	// host name, DialOr looks the name up again with Resolver whenever the
+	// addresses it resolved to are older than OrResolveTTL, so that a
+	// long-running server follows DNS changes. (Resolver does not report
+	// the TTLs of DNS records, so this takes their place.) If a lookup
+	// fails, the previous addresses continue to be used. ServerSetup leaves
+	// this as zero, which means that a name is looked up only once, and the
+	// addresses in OrAddrs and ExtendedOrAddrs are used.
+	OrResolveTTL time.Duration
+	// The negotiated managed transport protocol version, and all the
+	// versions offered in TOR_PT_MANAGED_TRANSPORT_VER.
+	Version         string
+	OfferedVersions []string
+	// The optional features announced in the FEATURES line; see
+	// RegisterFeature.
+	Features []string
+	// If not nil, DialOr takes connections from this pool; see
+	// StartORPool.
+	pool *orPool
+	// The host names of the ORPort and extended ORPort, if they were not IP
+	// addresses, for re-resolution according to OrResolveTTL.
+	orName         *orName
+	extendedOrName *orName
 }
 
 // Check the server pluggable transports environment, emitting an error message
 // and returning a non-nil error if any error is encountered. Resolves the
 // various requested bind addresses, the server ORPort and extended ORPort, and
 // reads the auth cookie file. Returns a ServerInfo struct.
+// See ReportAllEnvErrors for how to have every error reported, rather than
+// only the first.
 //
 // If your program needs to know whether to call ClientSetup or ServerSetup
 // (i.e., if the same program can be run as either a client or a server), check
@@ -720,50 +1065,89 @@ type ServerInfo struct {
 // 		// Server mode; call pt.ServerSetup.
 // 	}
 //
+// To tell whether the program was started by tor as a managed transport at
+// all, rather than being run standalone, call Managed.
+//
 // Always pass nil for the unused single parameter. In the past, the parameter
 // was a list of transport names to use in case Tor requested "*". That feature
 // was never implemented and has been removed from the pluggable transports
 // specification.
 // https://bugs.torproject.org/15612
+//
+// ServerSetup is the same as ServerSetupOptions with no options.
 func ServerSetup(_ []string) (info ServerInfo, err error) {
-	ver, err := getManagedTransportVer()
+	return ServerSetupOptions()
+}
+
+func serverSetup(o *setupOptions) (info ServerInfo, err error) {
+	err = checkEnvFile(o)
+	if err != nil {
+		return
+	}
+	info.Version, info.OfferedVersions, err = negotiateVersion(o)
 	if err != nil {
 		return
 	}
-	line("VERSION", ver)
+	info.Features = featuresFor(info.Version)
+	checkStrictEnv(o)
 
-	info.Bindaddrs, err = getServerBindaddrs()
+	c := newEnvChecker(o)
+	info.Bindaddrs, err = checkServerBindaddrs(c)
 	if err != nil {
 		return
 	}
 
-	orPort := getenv("TOR_PT_ORPORT")
+	orPort := o.getenv("TOR_PT_ORPORT")
 	if orPort != "" {
-		info.OrAddr, err = resolveAddr(orPort)
+		var addrs []*net.TCPAddr
+		addrs, err = resolveAddrs(orPort)
 		if err != nil {
-			err = envError(fmt.Sprintf("cannot resolve TOR_PT_ORPORT %q: %s", orPort, err.Error()))
-			return
+			err = c.problem(fmt.Sprintf("cannot resolve TOR_PT_ORPORT %q: %s", orPort, err.Error()))
+			if err != nil {
+				return
+			}
+		} else {
+			info.OrAddrs = addrs
+			info.OrAddr = info.OrAddrs[0]
+			info.orName = newORName(orPort, addrs)
 		}
 	}
 
-	info.AuthCookiePath = getenv("TOR_PT_AUTH_COOKIE_FILE")
+	info.AuthCookiePath = o.getenv("TOR_PT_AUTH_COOKIE_FILE")
+	info.AuthCookie = AuthCookieSource
 
-	extendedOrPort := getenv("TOR_PT_EXTENDED_SERVER_PORT")
+	extendedOrPort := o.getenv("TOR_PT_EXTENDED_SERVER_PORT")
 	if extendedOrPort != "" {
-		if info.AuthCookiePath == "" {
-			err = envError("need TOR_PT_AUTH_COOKIE_FILE environment variable with TOR_PT_EXTENDED_SERVER_PORT")
-			return
+		if !info.hasAuthCookie() {
+			err = c.problem("need TOR_PT_AUTH_COOKIE_FILE environment variable with TOR_PT_EXTENDED_SERVER_PORT")
+			if err != nil {
+				return
+			}
 		}
-		info.ExtendedOrAddr, err = resolveAddr(extendedOrPort)
+		var addrs []*net.TCPAddr
+		addrs, err = resolveAddrs(extendedOrPort)
+		if err != nil {
+			err = c.problem(fmt.Sprintf("cannot resolve TOR_PT_EXTENDED_SERVER_PORT %q: %s", extendedOrPort, err.Error()))
+			if err != nil {
+				return
+			}
+		} else {
+			info.ExtendedOrAddrs = addrs
+			info.ExtendedOrAddr = info.ExtendedOrAddrs[0]
+			info.extendedOrName = newORName(extendedOrPort, addrs)
+		}
+	}
+
+	// Need either TOR_PT_ORPORT or TOR_PT_EXTENDED_SERVER_PORT.
+	if orPort == "" && extendedOrPort == "" {
+		err = c.problem("need TOR_PT_ORPORT or TOR_PT_EXTENDED_SERVER_PORT environment variable")
 		if err != nil {
-			err = envError(fmt.Sprintf("cannot resolve TOR_PT_EXTENDED_SERVER_PORT %q: %s", extendedOrPort, err.Error()))
 			return
 		}
 	}
 
-	// Need either OrAddr or ExtendedOrAddr.
-	if info.OrAddr == nil && info.ExtendedOrAddr == nil {
-		err = envError("need TOR_PT_ORPORT or TOR_PT_EXTENDED_SERVER_PORT environment variable")
+	err = c.err()
+	if err != nil {
 		return
 	}
 
@@ -789,12 +1173,44 @@ func computeClientHash(authCookie, clientNonce, serverNonce []byte) []byte {
 }
 
 func extOrPortAuthenticate(s io.ReadWriter, info *ServerInfo) error {
+	r := &extOrPortAuthReader{r: s, n: extOrPortAuthMaxBytes}
+
+	clientNonce := make([]byte, 32)
+	clientHash := make([]byte, 32)
+	serverNonce := make([]byte, 32)
+	serverHash := make([]byte, 32)
+	defer func() {
+		wipe(clientNonce)
+		wipe(clientHash)
+		wipe(serverNonce)
+		wipe(serverHash)
+	}()
+
+	_, err := io.ReadFull(rand.Reader, clientNonce)
+	if err != nil {
+		return err
+	}
+
+	// A connection made with TCP Fast Open is not made until something is
+	// written to it, so in that case send the auth type and nonce before
+	// reading the auth types the server offers. There is only one auth type
+	// to choose.
+	pipelined := info.OrTCPOptions.FastOpen
+	if pipelined {
+		msg := append([]byte{1}, clientNonce...)
+		_, err = s.Write(msg)
+		wipe(msg)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Read auth types. 217-ext-orport-auth.txt section 4.1.
 	var authTypes [256]bool
 	var count int
+	buf := make([]byte, 1)
 	for count = 0; count < 256; count++ {
-		buf := make([]byte, 1)
-		_, err := io.ReadFull(s, buf)
+		_, err := io.ReadFull(r, buf)
 		if err != nil {
 			return err
 		}
@@ -812,46 +1228,58 @@ func extOrPortAuthenticate(s io.ReadWriter, info *ServerInfo) error {
 	if !authTypes[1] {
 		return fmt.Errorf("server didn't offer auth type 1")
 	}
-	_, err := s.Write([]byte{1})
-	if err != nil {
-		return err
+	if !pipelined {
+		_, err = s.Write([]byte{1})
+		if err != nil {
+			return err
+		}
+		_, err = s.Write(clientNonce)
+		if err != nil {
+			return err
+		}
 	}
 
-	clientNonce := make([]byte, 32)
-	clientHash := make([]byte, 32)
-	serverNonce := make([]byte, 32)
-	serverHash := make([]byte, 32)
-
-	_, err = io.ReadFull(rand.Reader, clientNonce)
+	_, err = io.ReadFull(r, serverHash)
 	if err != nil {
 		return err
 	}
-	_, err = s.Write(clientNonce)
+	_, err = io.ReadFull(r, serverNonce)
 	if err != nil {
 		return err
 	}
-
-	_, err = io.ReadFull(s, serverHash)
-	if err != nil {
-		return err
-	}
-	_, err = io.ReadFull(s, serverNonce)
-	if err != nil {
-		return err
+	// A server that sends back our own nonce may be reflecting our messages
+	// to us (as another client of the same cookie would); the hashes are
+	// then no proof that it knows the cookie.
+	if subtle.ConstantTimeCompare(serverNonce, clientNonce) == 1 {
+		return fmt.Errorf("server nonce is the same as client nonce")
 	}
 
 	// Work around tor bug #15240 where the auth cookie is generated after
 	// pluggable transports are launched, leading to a stale cookie getting
 	// cached forever if it is only read once as part of ServerSetup.
 	// https://bugs.torproject.org/15240
-	authCookie, err := readAuthCookieFile(info.AuthCookiePath)
+	authCookie, err := info.loadAuthCookie(false)
 	if err != nil {
-		return fmt.Errorf("error reading TOR_PT_AUTH_COOKIE_FILE %q: %s", info.AuthCookiePath, err.Error())
+		return err
 	}
+	defer func() { wipe(authCookie) }()
 
 	expectedServerHash := computeServerHash(authCookie, clientNonce, serverNonce)
-	if subtle.ConstantTimeCompare(serverHash, expectedServerHash) != 1 {
-		return fmt.Errorf("mismatch in server hash")
+	defer func() { wipe(expectedServerHash) }()
+	if !SecretEqual(serverHash, expectedServerHash) {
+		// The cached cookie may be stale if the file was rewritten
+		// without a change in modification time or size; try once more
+		// with a fresh read.
+		wipe(authCookie)
+		wipe(expectedServerHash)
+		authCookie, err = info.loadAuthCookie(true)
+		if err != nil {
+			return err
+		}
+		expectedServerHash = computeServerHash(authCookie, clientNonce, serverNonce)
+		if !SecretEqual(serverHash, expectedServerHash) {
+			return fmt.Errorf("mismatch in server hash")
+		}
 	}
 
 	clientHash = computeClientHash(authCookie, clientNonce, serverNonce)
@@ -861,7 +1289,7 @@ func extOrPortAuthenticate(s io.ReadWriter, info *ServerInfo) error {
 	}
 
 	status := make([]byte, 1)
-	_, err = io.ReadFull(s, status)
+	_, err = io.ReadFull(r, status)
 	if err != nil {
 		return err
 	}
@@ -872,6 +1300,35 @@ func extOrPortAuthenticate(s io.ReadWriter, info *ServerInfo) error {
 	return nil
 }
 
+// The most bytes that a server can send in a correct authentication handshake:
+// a list of 255 auth types and its terminator, a server hash, a server nonce,
+// and a status byte.
+const extOrPortAuthMaxBytes = 256 + 32 + 32 + 1
+
+// Returned by extOrPortAuthReader when the server has sent more than the
+// handshake allows.
+var errExtOrPortAuthTooLong = fmt.Errorf("extended ORPort authentication exceeded %d bytes", extOrPortAuthMaxBytes)
+
+// A reader of the server's side of the authentication handshake that fails
+// with errExtOrPortAuthTooLong after n bytes, so that a broken peer cannot
+// keep the handshake going.
+type extOrPortAuthReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *extOrPortAuthReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, errExtOrPortAuthTooLong
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.r.Read(p)
+	r.n -= n
+	return n, err
+}
+
 // See section 3.1.1 of 196-transport-control-ports.txt.
 const (
 	extOrCmdDone      = 0x0000
@@ -881,29 +1338,22 @@ const (
 	extOrCmdDeny      = 0x1001
 )
 
-func extOrPortSendCommand(s io.Writer, cmd uint16, body []byte) error {
-	var buf bytes.Buffer
+// Append the encoding of the command cmd with the given body to b.
+func appendExtOrPortCommand(b []byte, cmd uint16, body []byte) ([]byte, error) {
 	if len(body) > 65535 {
-		return fmt.Errorf("body length %d exceeds maximum of 65535", len(body))
-	}
-	err := binary.Write(&buf, binary.BigEndian, cmd)
-	if err != nil {
-		return err
-	}
-	err = binary.Write(&buf, binary.BigEndian, uint16(len(body)))
-	if err != nil {
-		return err
+		return b, fmt.Errorf("body length %d exceeds maximum of 65535", len(body))
 	}
-	err = binary.Write(&buf, binary.BigEndian, body)
-	if err != nil {
-		return err
-	}
-	_, err = s.Write(buf.Bytes())
+	b = append(b, byte(cmd>>8), byte(cmd), byte(len(body)>>8), byte(len(body)))
+	return append(b, body...), nil
+}
+
+func extOrPortSendCommand(s io.Writer, cmd uint16, body []byte) error {
+	b, err := appendExtOrPortCommand(make([]byte, 0, 4+len(body)), cmd, body)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	_, err = s.Write(b)
+	return err
 }
 
 // Send a USERADDR command on s. See section 3.1.2.1 of
@@ -924,23 +1374,27 @@ func extOrPortSendDone(s io.Writer) error {
 }
 
 func extOrPortRecvCommand(s io.Reader) (cmd uint16, body []byte, err error) {
-	var bodyLen uint16
-	data := make([]byte, 4)
+	return extOrPortRecvCommandBuf(s, nil)
+}
 
-	_, err = io.ReadFull(s, data)
-	if err != nil {
-		return
+// Like extOrPortRecvCommand, but read into buf, if it has enough capacity,
+// rather than allocating. The returned body may share storage with buf.
+func extOrPortRecvCommandBuf(s io.Reader, buf []byte) (cmd uint16, body []byte, err error) {
+	if cap(buf) < 4 {
+		buf = make([]byte, 4)
 	}
-	buf := bytes.NewBuffer(data)
-	err = binary.Read(buf, binary.BigEndian, &cmd)
+	header := buf[:4]
+	_, err = io.ReadFull(s, header)
 	if err != nil {
 		return
 	}
-	err = binary.Read(buf, binary.BigEndian, &bodyLen)
-	if err != nil {
-		return
+	cmd = binary.BigEndian.Uint16(header[0:2])
+	bodyLen := int(binary.BigEndian.Uint16(header[2:4]))
+	if cap(buf) >= bodyLen {
+		body = buf[:bodyLen]
+	} else {
+		body = make([]byte, bodyLen)
 	}
-	body = make([]byte, bodyLen)
 	_, err = io.ReadFull(s, body)
 	if err != nil {
 		return
@@ -952,32 +1406,43 @@ func extOrPortRecvCommand(s io.Reader) (cmd uint16, body []byte, err error) {
 // Send USERADDR and TRANSPORT commands followed by a DONE command. Wait for an
 // OKAY or DENY response command from the server. If addr or methodName is "",
 // the corresponding command is not sent. Returns nil if and only if OKAY is
-// received.
+// received, and an *ExtOrPortDenyError if DENY is received. The commands are
+// sent with a single Write, so that they go in one TCP segment rather than
+// three small ones.
 func extOrPortSetMetadata(s io.ReadWriter, addr, methodName string) error {
 	var err error
 
+	b := make([]byte, 0, 4+len(addr)+4+len(methodName)+4)
 	if addr != "" {
-		err = extOrPortSendUserAddr(s, addr)
+		b, err = appendExtOrPortCommand(b, extOrCmdUserAddr, []byte(addr))
 		if err != nil {
 			return err
 		}
 	}
 	if methodName != "" {
-		err = extOrPortSendTransport(s, methodName)
+		b, err = appendExtOrPortCommand(b, extOrCmdTransport, []byte(methodName))
 		if err != nil {
 			return err
 		}
 	}
-	err = extOrPortSendDone(s)
+	b, err = appendExtOrPortCommand(b, extOrCmdDone, nil)
 	if err != nil {
 		return err
 	}
-	cmd, _, err := extOrPortRecvCommand(s)
+	_, err = s.Write(b)
+	if err != nil {
+		return err
+	}
+	// The body of OKAY or DENY is ignored, so a small buffer is enough.
+	var buf [16]byte
+	cmd, _, err := extOrPortRecvCommandBuf(s, buf[:])
 	if err != nil {
 		return err
 	}
 	if cmd == extOrCmdDeny {
-		return fmt.Errorf("server returned DENY after our USERADDR and DONE")
+		err := newExtOrPortDenyError(addr, methodName)
+		logExtOrPortDeny(err)
+		return err
 	} else if cmd != extOrCmdOkay {
 		return fmt.Errorf("server returned unknown command 0x%04x after our USERADDR and DONE", cmd)
 	}
@@ -1006,6 +1471,33 @@ func extOrPortSetup(s net.Conn, timeout time.Duration,
 	return nil
 }
 
+// Do only the authentication part of extOrPortSetup.
+func extOrPortAuthOnly(s net.Conn, timeout time.Duration, info *ServerInfo) error {
+	err := s.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+	err = extOrPortAuthenticate(s, info)
+	if err != nil {
+		return err
+	}
+	return s.SetDeadline(time.Time{})
+}
+
+// Do only the metadata part of extOrPortSetup, on a connection that has
+// already been authenticated.
+func extOrPortSetupMetadata(s net.Conn, timeout time.Duration, addr, methodName string) error {
+	err := s.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+	err = extOrPortSetMetadata(s, addr, methodName)
+	if err != nil {
+		return err
+	}
+	return s.SetDeadline(time.Time{})
+}
+
 // Dial info.ExtendedOrAddr if defined, or else info.OrAddr, and return an open
 // *net.TCPConn. If connecting to the extended OR port, extended OR port
 // authentication à la 217-ext-orport-auth.txt is done before returning; an
@@ -1013,21 +1505,104 @@ func extOrPortSetup(s net.Conn, timeout time.Duration,
 //
 // The addr and methodName arguments are put in USERADDR and TRANSPORT ExtOrPort
 // commands, respectively. If either is "", the corresponding command is not
-// sent.
+// sent. If tor refuses them, the error is an *ExtOrPortDenyError; see also
+// LogExtOrPortDeny.
+//
+// If info.OrAddrs or info.ExtendedOrAddrs lists more than one address,
+// connection attempts are made to them in the manner of RFC 8305 ("Happy
+// Eyeballs"), alternating address families and not waiting more than 250 ms for
+// one attempt before starting the next, and the first to succeed is used.
+//
+// The socket options in info.OrTCPOptions are applied to the connection. See
+// info.OrResolveTTL for when a host name ORPort is looked up again.
 func DialOr(info *ServerInfo, addr, methodName string) (*net.TCPConn, error) {
-	if info.ExtendedOrAddr == nil || info.AuthCookiePath == "" {
-		return net.DialTCP("tcp", nil, info.OrAddr)
+	return DialOrContext(context.Background(), info, addr, methodName)
+}
+
+// Like DialOr, but stop connecting or authenticating, and return an error, if
+// ctx is done before the connection is ready.
+func DialOrContext(ctx context.Context, info *ServerInfo, addr, methodName string) (*net.TCPConn, error) {
+	c, err := DialOrConn(ctx, info, addr, methodName)
+	if err != nil {
+		return nil, err
 	}
+	return c.TCPConn, nil
+}
+
+// Like DialOrContext, but return an *ORConn that records how the connection
+// was made.
+func DialOrConn(ctx context.Context, info *ServerInfo, addr, methodName string) (*ORConn, error) {
+	counters := statsFor(methodName)
+	c := &ORConn{MethodName: methodName}
+	start := time.Now()
 
-	s, err := net.DialTCP("tcp", nil, info.ExtendedOrAddr)
+	if info.pool != nil {
+		s, err := info.pool.take(ctx, addr, methodName)
+		if err != nil {
+			atomic.AddUint64(&counters.orAuthFailures, 1)
+			return nil, err
+		}
+		if s != nil {
+			atomic.AddUint64(&counters.orConnsDialed, 1)
+			c.TCPConn = s
+			c.UserAddr = addr
+			c.Extended = true
+			c.Pooled = true
+			c.HandshakeDuration = time.Since(start)
+			return c, nil
+		}
+	}
+
+	if (info.ExtendedOrAddr == nil && len(info.ExtendedOrAddrs) == 0) || !info.hasAuthCookie() {
+		s, err := dialTCPAddrs(ctx, info.currentOrAddrs(), &info.OrTCPOptions)
+		if err != nil {
+			checkFDExhausted(err, "dialing ORPort")
+			atomic.AddUint64(&counters.orDialFailures, 1)
+			return nil, err
+		}
+		err = info.OrTCPOptions.apply(s)
+		if err != nil {
+			atomic.AddUint64(&counters.orDialFailures, 1)
+			s.Close()
+			return nil, err
+		}
+		atomic.AddUint64(&counters.orConnsDialed, 1)
+		c.TCPConn = s
+		c.DialDuration = time.Since(start)
+		return c, nil
+	}
+
+	s, err := dialTCPAddrs(ctx, info.currentExtendedOrAddrs(), &info.OrTCPOptions)
+	if err != nil {
+		checkFDExhausted(err, "dialing extended ORPort")
+		atomic.AddUint64(&counters.orDialFailures, 1)
+		return nil, err
+	}
+	err = info.OrTCPOptions.apply(s)
 	if err != nil {
+		atomic.AddUint64(&counters.orDialFailures, 1)
+		s.Close()
 		return nil, err
 	}
+	c.DialDuration = time.Since(start)
+	ContextPTTrace(ctx).orAuthStart()
+	handshakeStart := time.Now()
+	stop := interruptOnDone(ctx, s)
 	err = extOrPortSetup(s, 5*time.Second, info, addr, methodName)
+	if stop() {
+		err = ctx.Err()
+	}
+	ContextPTTrace(ctx).orAuthDone(err)
 	if err != nil {
+		atomic.AddUint64(&counters.orAuthFailures, 1)
 		s.Close()
 		return nil, err
 	}
 
-	return s, nil
+	atomic.AddUint64(&counters.orConnsDialed, 1)
+	c.TCPConn = s
+	c.UserAddr = addr
+	c.Extended = true
+	c.HandshakeDuration = time.Since(handshakeStart)
+	return c, nil
 }
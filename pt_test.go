@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -42,6 +43,50 @@ func TestErrors(t *testing.T) {
 	}
 }
 
+func TestMethodErrorReason(t *testing.T) {
+	Stdout = ioutil.Discard
+
+	err := CmethodErrorReason("method", ReasonBindFailed, "XYZ")
+	if err.Error() != "CMETHOD-ERROR method bind-failed: XYZ" {
+		t.Errorf("unexpected string %q from CmethodErrorReason", err.Error())
+	}
+	err = SmethodErrorReason("method", ReasonUnsupportedOption, "")
+	if err.Error() != "SMETHOD-ERROR method unsupported-option" {
+		t.Errorf("unexpected string %q from SmethodErrorReason", err.Error())
+	}
+
+	tests := [...]struct {
+		msg    string
+		reason MethodErrorReason
+		text   string
+	}{
+		{"bind-failed: XYZ", ReasonBindFailed, "XYZ"},
+		{"resolve-failed", ReasonResolveFailed, ""},
+		{"custom-1: a: b", "custom-1", "a: b"},
+		{"no such method", "", "no such method"},
+		{"Bad-Case: x", "", "Bad-Case: x"},
+		{": x", "", ": x"},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		reason, text := SplitMethodErrorReason(test.msg)
+		if reason != test.reason || text != test.text {
+			t.Errorf("%q → %q %q (expected %q %q)", test.msg, reason, text, test.reason, test.text)
+		}
+	}
+
+	for _, reason := range []MethodErrorReason{"", "has space", "UPPER", "colon:"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("reason %q did not panic", reason)
+				}
+			}()
+			CmethodErrorReason("method", reason, "XYZ")
+		}()
+	}
+}
+
 func TestKeywordIsSafe(t *testing.T) {
 	tests := [...]struct {
 		keyword  string
@@ -100,7 +145,7 @@ func TestArgIsSafe(t *testing.T) {
 	}
 }
 
-func TestGetManagedTransportVer(t *testing.T) {
+func TestNegotiateVersionEnv(t *testing.T) {
 	badTests := [...]string{
 		"",
 		"2",
@@ -117,14 +162,14 @@ func TestGetManagedTransportVer(t *testing.T) {
 	Stdout = ioutil.Discard
 
 	os.Clearenv()
-	_, err := getManagedTransportVer()
+	_, _, err := negotiateVersion(nil)
 	if err == nil {
 		t.Errorf("empty environment unexpectedly succeeded")
 	}
 
 	for _, input := range badTests {
 		os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", input)
-		_, err := getManagedTransportVer()
+		_, _, err := negotiateVersion(nil)
 		if err == nil {
 			t.Errorf("TOR_PT_MANAGED_TRANSPORT_VER=%q unexpectedly succeeded", input)
 		}
@@ -132,7 +177,7 @@ func TestGetManagedTransportVer(t *testing.T) {
 
 	for _, test := range goodTests {
 		os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", test.input)
-		output, err := getManagedTransportVer()
+		output, _, err := negotiateVersion(nil)
 		if err != nil {
 			t.Errorf("TOR_PT_MANAGED_TRANSPORT_VER=%q unexpectedly returned an error: %s", test.input, err)
 		}
@@ -273,6 +318,56 @@ func TestResolveAddr(t *testing.T) {
 	}
 }
 
+func TestParseAddrPort(t *testing.T) {
+	badTests := [...]string{
+		"",
+		"1.2.3.4",
+		"1.2.3.4:",
+		":9999",
+		"[1:2::3:4]:",
+		"[1::2::3:4]:9999",
+		"localhost:9999",
+		"[localhost]:9999",
+		"[1.2.3.4%eth0]:9999",
+		"1.2.3.4%eth0:9999",
+		"[fe80::1%]:9999",
+		"[fe80::1]9999",
+		"1.2.3.4:65536",
+	}
+	goodTests := [...]struct {
+		input    string
+		expected net.TCPAddr
+	}{
+		{"1.2.3.4:9999", net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 9999}},
+		{"[1:2::3:4]:9999", net.TCPAddr{IP: net.ParseIP("1:2::3:4"), Port: 9999}},
+		{"1:2::3:4:9999", net.TCPAddr{IP: net.ParseIP("1:2::3:4"), Port: 9999}},
+		{"[fe80::1%eth0]:9999", net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 9999, Zone: "eth0"}},
+		{"fe80::1%eth0:9999", net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 9999, Zone: "eth0"}},
+		{"0.0.0.0:9999", net.TCPAddr{IP: net.IPv4zero, Port: 9999}},
+		{"[::]:9999", net.TCPAddr{IP: net.IPv6unspecified, Port: 9999}},
+		{":::9999", net.TCPAddr{IP: net.IPv6unspecified, Port: 9999}},
+		{"[::ffff:1.2.3.4]:0", net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 0}},
+	}
+
+	for _, input := range badTests {
+		output, err := ParseAddrPort(input)
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded: %q", input, output)
+		}
+	}
+
+	for _, test := range goodTests {
+		output, err := ParseAddrPort(test.input)
+		if err != nil {
+			t.Errorf("%q unexpectedly returned an error: %s", test.input, err)
+			continue
+		}
+		if !tcpAddrsEqual(output, &test.expected) || output.Zone != test.expected.Zone {
+			t.Errorf("%q → %q (expected %q)", test.input, output, test.expected)
+		}
+	}
+}
+
 func bindaddrSliceContains(s []Bindaddr, v Bindaddr) bool {
 	for _, sv := range s {
 		if sv.MethodName == v.MethodName && tcpAddrsEqual(sv.Addr, v.Addr) {
@@ -356,8 +451,8 @@ func TestGetServerBindaddrs(t *testing.T) {
 			"alpha,beta,gamma",
 			"alpha:k1=v1,beta:k2=v2,gamma:k3=v3",
 			[]Bindaddr{
-				{"alpha", &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1111}, Args{"k1": []string{"v1"}}},
-				{"beta", &net.TCPAddr{IP: net.ParseIP("1:2::3:4"), Port: 2222}, Args{"k2": []string{"v2"}}},
+				{"alpha", &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1111}, Args{"k1": []string{"v1"}}, 0},
+				{"beta", &net.TCPAddr{IP: net.ParseIP("1:2::3:4"), Port: 2222}, Args{"k2": []string{"v2"}}, 1},
 			},
 		},
 		{
@@ -371,7 +466,7 @@ func TestGetServerBindaddrs(t *testing.T) {
 			"alpha,beta,gamma",
 			"",
 			[]Bindaddr{
-				{"alpha", &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1111}, Args{}},
+				{"alpha", &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1111}, Args{}, 0},
 			},
 		},
 		{
@@ -379,8 +474,8 @@ func TestGetServerBindaddrs(t *testing.T) {
 			"trebuchet,ballista",
 			"trebuchet:secret=nou;trebuchet:cache=/tmp/cache;ballista:secret=yes",
 			[]Bindaddr{
-				{"trebuchet", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1984}, Args{"secret": []string{"nou"}, "cache": []string{"/tmp/cache"}}},
-				{"ballista", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4891}, Args{"secret": []string{"yes"}}},
+				{"trebuchet", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1984}, Args{"secret": []string{"nou"}, "cache": []string{"/tmp/cache"}}, 0},
+				{"ballista", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4891}, Args{"secret": []string{"yes"}}, 1},
 			},
 		},
 		// In the past, "*" meant to return all known transport names.
@@ -423,12 +518,55 @@ func TestGetServerBindaddrs(t *testing.T) {
 				test.ptServerBindaddr, test.ptServerTransports, test.ptServerTransportOptions, err)
 		}
 		if !bindaddrSetsEqual(output, test.expected) {
-			t.Errorf("TOR_PT_SERVER_BINDADDR=%q TOR_PT_SERVER_TRANSPORTS=%q TOR_PT_SERVER_TRANSPORT_OPTIONS=%q → %q (expected %q)",
+			t.Errorf("TOR_PT_SERVER_BINDADDR=%q TOR_PT_SERVER_TRANSPORTS=%q TOR_PT_SERVER_TRANSPORT_OPTIONS=%q → %+v (expected %+v)",
 				test.ptServerBindaddr, test.ptServerTransports, test.ptServerTransportOptions, output, test.expected)
 		}
 	}
 }
 
+func TestBindaddrMethodIndex(t *testing.T) {
+	Stdout = ioutil.Discard
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_SERVER_BINDADDR", "alpha-1.2.3.4:1111,beta-1.2.3.4:2222,gamma-1.2.3.4:3333")
+	os.Setenv("TOR_PT_SERVER_TRANSPORTS", "gamma,alpha")
+	bindaddrs, err := getServerBindaddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"alpha": 0, "gamma": 2}
+	if len(bindaddrs) != len(expected) {
+		t.Fatalf("got %+v", bindaddrs)
+	}
+	for _, bindaddr := range bindaddrs {
+		if bindaddr.MethodIndex != expected[bindaddr.MethodName] {
+			t.Errorf("%s has MethodIndex %d (expected %d)", bindaddr.MethodName, bindaddr.MethodIndex, expected[bindaddr.MethodName])
+		}
+	}
+}
+
+func FuzzParseBindaddrs(f *testing.F) {
+	f.Add("alpha-1.2.3.4:1111,beta-[1:2::3:4]:2222")
+	f.Add("alpha-1:2::3:4:9999")
+	f.Add("alpha-0.0.0.0:1234,alpha-[::]:1234")
+	f.Fuzz(func(t *testing.T, s string) {
+		bindaddrs, err := ParseBindaddrs(s)
+		if err != nil {
+			return
+		}
+		seen := make(map[string]bool)
+		for _, bindaddr := range bindaddrs {
+			if bindaddr.Addr == nil || bindaddr.Addr.IP == nil {
+				t.Errorf("%q → %+v with nil address", s, bindaddr)
+			}
+			if seen[bindaddr.MethodName] {
+				t.Errorf("%q → duplicate method name %q", s, bindaddr.MethodName)
+			}
+			seen[bindaddr.MethodName] = true
+		}
+	})
+}
+
 func TestReadAuthCookie(t *testing.T) {
 	badTests := [...][]byte{
 		[]byte(""),
@@ -465,6 +603,20 @@ func TestReadAuthCookie(t *testing.T) {
 	}
 }
 
+func FuzzParseAuthCookie(f *testing.F) {
+	f.Add([]byte("! Extended ORPort Auth Cookie !\x0a0123456789ABCDEF0123456789ABCDEF"))
+	f.Add([]byte("! Extended ORPort Auth Cookie !\x0a0123456789ABCDEF0123456789ABCDE"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cookie, err := ParseAuthCookie(data)
+		if err != nil {
+			return
+		}
+		if len(cookie) != 32 {
+			t.Errorf("%q → cookie of length %d", data, len(cookie))
+		}
+	})
+}
+
 func TestComputeServerHash(t *testing.T) {
 	authCookie := make([]byte, 32)
 	clientNonce := make([]byte, 32)
@@ -707,10 +859,28 @@ func TestExtOrPortRecvCommand(t *testing.T) {
 	}
 }
 
+func TestExtOrPortRecvCommandBuf(t *testing.T) {
+	buf := make([]byte, 0, 16)
+	r := bytes.NewReader([]byte("\x12\x34\x00\x04body\x00\x01\x00\x20" + strings.Repeat("x", 32)))
+	cmd, body, err := extOrPortRecvCommandBuf(r, buf)
+	if err != nil || cmd != 0x1234 || string(body) != "body" {
+		t.Fatalf("got 0x%04x %q %v", cmd, body, err)
+	}
+	if &body[0] != &buf[:1][0] {
+		t.Error("body not read into buf")
+	}
+	// A body too long for buf is allocated.
+	cmd, body, err = extOrPortRecvCommandBuf(r, buf)
+	if err != nil || cmd != 0x0001 || string(body) != strings.Repeat("x", 32) {
+		t.Fatalf("got 0x%04x %q %v", cmd, body, err)
+	}
+}
+
 // set up so that extOrPortSetMetadata can write to one buffer and read from another.
 type mockSetMetadataBuf struct {
 	ReadBuf  bytes.Buffer
 	WriteBuf bytes.Buffer
+	writes   int
 }
 
 func (buf *mockSetMetadataBuf) Read(p []byte) (int, error) {
@@ -718,6 +888,7 @@ func (buf *mockSetMetadataBuf) Read(p []byte) (int, error) {
 }
 
 func (buf *mockSetMetadataBuf) Write(p []byte) (int, error) {
+	buf.writes++
 	return buf.WriteBuf.Write(p)
 }
 
@@ -733,6 +904,9 @@ func testExtOrPortSetMetadataIndividual(t *testing.T, addr, methodName string) {
 	if err != nil {
 		t.Fatalf("error in extOrPortSetMetadata: %s", err)
 	}
+	if buf.writes != 1 {
+		t.Errorf("addr=%q methodName=%q commands sent in %d writes", addr, methodName, buf.writes)
+	}
 	for {
 		cmd, body, err := extOrPortRecvCommand(&buf.WriteBuf)
 		if err != nil {
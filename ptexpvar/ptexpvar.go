@@ -0,0 +1,77 @@
+// Package ptexpvar publishes goptlib's counters with the standard expvar
+// package, under the name "goptlib". Import it for its side effect:
+//
+//	import _ "git.torproject.org/pluggable-transports/goptlib.git/ptexpvar"
+//
+// The counters then appear, along with the other expvar variables, at
+// /debug/vars of any HTTP server that uses http.DefaultServeMux, or of one that
+// serves expvar.Handler. The variables reveal information about a bridge's
+// users, so such a server should listen only on a loopback address:
+//
+//	go http.ListenAndServe("127.0.0.1:9053", nil)
+//
+// The "goptlib" map contains totals over all method names of the counters
+// returned by pt.Stats, the number of goroutines relaying data, and the
+// counters for each method name under "methods".
+package ptexpvar
+
+import (
+	"expvar"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// The published map.
+var vars = expvar.NewMap("goptlib")
+
+// Return the sum of f over all methods.
+func total(f func(*pt.MethodStats) uint64) expvar.Func {
+	return expvar.Func(func() interface{} {
+		var sum uint64
+		stats := pt.Stats()
+		for i := range stats {
+			sum += f(&stats[i])
+		}
+		return sum
+	})
+}
+
+// Return the counters of s, keyed as in the published map.
+func methodVars(s *pt.MethodStats) map[string]interface{} {
+	return map[string]interface{}{
+		"conns_accepted":   s.ConnsAccepted,
+		"conns_active":     s.ConnsActive,
+		"or_conns_dialed":  s.OrConnsDialed,
+		"or_dial_failures": s.OrDialFailures,
+		"or_auth_failures": s.OrAuthFailures,
+		"bytes_sent":       s.BytesSent,
+		"bytes_received":   s.BytesReceived,
+	}
+}
+
+func init() {
+	vars.Set("conns_accepted", total(func(s *pt.MethodStats) uint64 { return s.ConnsAccepted }))
+	vars.Set("conns_active", expvar.Func(func() interface{} {
+		var sum int64
+		for _, s := range pt.Stats() {
+			sum += s.ConnsActive
+		}
+		return sum
+	}))
+	vars.Set("or_conns_dialed", total(func(s *pt.MethodStats) uint64 { return s.OrConnsDialed }))
+	vars.Set("or_dial_failures", total(func(s *pt.MethodStats) uint64 { return s.OrDialFailures }))
+	vars.Set("or_auth_failures", total(func(s *pt.MethodStats) uint64 { return s.OrAuthFailures }))
+	vars.Set("bytes_sent", total(func(s *pt.MethodStats) uint64 { return s.BytesSent }))
+	vars.Set("bytes_received", total(func(s *pt.MethodStats) uint64 { return s.BytesReceived }))
+	vars.Set("copy_loop_goroutines", expvar.Func(func() interface{} {
+		return pt.CopyLoopGoroutines()
+	}))
+	vars.Set("methods", expvar.Func(func() interface{} {
+		result := make(map[string]interface{})
+		stats := pt.Stats()
+		for i := range stats {
+			result[stats[i].MethodName] = methodVars(&stats[i])
+		}
+		return result
+	}))
+}
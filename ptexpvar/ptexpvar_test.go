@@ -0,0 +1,28 @@
+package ptexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestPublished(t *testing.T) {
+	v := expvar.Get("goptlib")
+	if v == nil {
+		t.Fatal("goptlib not published")
+	}
+	var m map[string]interface{}
+	err := json.Unmarshal([]byte(v.String()), &m)
+	if err != nil {
+		t.Fatalf("cannot decode %q: %v", v.String(), err)
+	}
+	for _, key := range []string{
+		"conns_accepted", "conns_active", "or_conns_dialed",
+		"or_dial_failures", "or_auth_failures", "bytes_sent",
+		"bytes_received", "copy_loop_goroutines", "methods",
+	} {
+		if _, ok := m[key]; !ok {
+			t.Errorf("missing %q in %q", key, v.String())
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package pthealth serves a health check endpoint for a transport, so that
+// container orchestrators such as Docker and Kubernetes can tell whether the
+// transport is doing its job, not just whether its process is running.
+//
+// The endpoint responds with 200 OK when the transport has open listeners, is
+// not shutting down, and, if pt.StartORHealthCheck is running (for example
+// because pt.ORHealthCheckInterval is set), the most recent ORPort check
+// succeeded. Otherwise it responds with 503 Service Unavailable and the reason
+// in the body.
+//
+// Sample usage, in a server transport's main function:
+//
+//	pt.ORHealthCheckInterval = time.Minute
+//	ln, err := pthealth.Listen("127.0.0.1:9053")
+//	if err != nil {
+//		pt.Log(pt.LogSeverityWarning, "cannot start health check listener: "+err.Error())
+//	} else {
+//		defer ln.Close()
+//	}
+//
+// and in a Dockerfile:
+//
+//	HEALTHCHECK CMD wget -q -O /dev/null http://127.0.0.1:9053/healthz || exit 1
+package pthealth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// The path at which the listeners started by Listen and ListenUnix serve
+// Handler.
+const HealthPath = "/healthz"
+
+// Return nil if the listeners and connections of m are healthy, or an error
+// saying why not.
+func Check(m *pt.ShutdownManager) error {
+	if m.ShuttingDown() {
+		return fmt.Errorf("shutting down")
+	}
+	if m.ActiveListeners() == 0 {
+		return fmt.Errorf("no listeners")
+	}
+	if h := pt.ORHealth(); !h.Checked.IsZero() && !h.Healthy {
+		return fmt.Errorf("ORPort health check failed: %s", h.Err.Error())
+	}
+	return nil
+}
+
+// Return an http.Handler that responds to every request with the result of
+// Check(m).
+func Handler(m *pt.ShutdownManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		err := Check(m)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err.Error())
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// Return true iff host is a loopback IP address or "localhost".
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Listen on the TCP address addr and serve Handler(pt.DefaultShutdownManager)
+// at HealthPath in a separate goroutine. addr must have a loopback host part
+// (for example "127.0.0.1:9053" or "[::1]:0"); the endpoint reveals whether a
+// bridge's tor is reachable and must not be exposed to the network. Close the
+// returned listener to stop serving.
+func Listen(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !isLoopback(host) {
+		return nil, fmt.Errorf("health check address %q is not a loopback address", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	serve(ln)
+	return ln, nil
+}
+
+// Like Listen, but listen on a Unix domain socket at path, which may be
+// mounted into a sidecar container. An existing socket at path is removed
+// first. Close the returned listener to stop serving and remove the socket.
+func ListenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	serve(ln)
+	return ln, nil
+}
+
+func serve(ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.Handle(HealthPath, Handler(pt.DefaultShutdownManager))
+	go http.Serve(ln, mux)
+}
@@ -0,0 +1,98 @@
+package pthealth
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+func TestHandler(t *testing.T) {
+	m := new(pt.ShutdownManager)
+	check := func(expectedStatus int, expectedBody string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		Handler(m).ServeHTTP(w, httptest.NewRequest("GET", HealthPath, nil))
+		if w.Code != expectedStatus || w.Body.String() != expectedBody {
+			t.Errorf("got %d %q (expected %d %q)", w.Code, w.Body.String(), expectedStatus, expectedBody)
+		}
+	}
+
+	check(http.StatusServiceUnavailable, "no listeners\n")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.TrackListener(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(http.StatusOK, "ok\n")
+	m.Shutdown(context.Background())
+	check(http.StatusServiceUnavailable, "shutting down\n")
+}
+
+func TestListen(t *testing.T) {
+	badTests := [...]string{
+		"",
+		"127.0.0.1",
+		"0.0.0.0:0",
+		"[::]:0",
+		"192.0.2.1:0",
+		"example.com:0",
+	}
+	for _, input := range badTests {
+		ln, err := Listen(input)
+		if err == nil {
+			ln.Close()
+			t.Errorf("%q unexpectedly succeeded", input)
+		}
+	}
+
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	resp, err := http.Get("http://" + ln.Addr().String() + HealthPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// DefaultShutdownManager has no listeners in this test.
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d", resp.StatusCode)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "health.sock")
+	ln, err := ListenUnix(path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://health" + HealthPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "no listeners") {
+		t.Errorf("unexpected body %q", body)
+	}
+}
@@ -0,0 +1,241 @@
+package ptmgr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Dialer makes connections through a client method of a transport, by way of
+// the SOCKS5 proxy that the method reported in its CMETHOD line. Its Dial
+// method has the signature of the Dialer interface in golang.org/x/net/proxy,
+// so it can be used wherever one of those is expected:
+//
+//	d, err := p.Dialer("obfs4", pt.Args{
+//		"cert":     []string{"..."},
+//		"iat-mode": []string{"0"},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	conn, err := d.Dial("tcp", "192.0.2.1:443")
+type Dialer struct {
+	Method Cmethod
+	// Per-connection arguments (for tor, those from the bridge line), sent
+	// to the transport in the SOCKS username and password.
+	Args pt.Args
+	// How long to wait for the connection to the proxy and the SOCKS
+	// handshake, if non-zero.
+	Timeout time.Duration
+}
+
+// Return a Dialer for the client method methodName, which must have been
+// reported with a socks5 CMETHOD line during setup.
+func (p *Process) Dialer(methodName string, args pt.Args) (*Dialer, error) {
+	for _, m := range p.Cmethods {
+		if m.MethodName != methodName {
+			continue
+		}
+		if m.Protocol != "socks5" {
+			return nil, fmt.Errorf("%s: protocol %q is not supported", methodName, m.Protocol)
+		}
+		return &Dialer{Method: m, Args: args}, nil
+	}
+	return nil, fmt.Errorf("%s: no such method", methodName)
+}
+
+// Connect to addr through the transport. network must be "tcp", "tcp4", or
+// "tcp6"; the transport decides how to reach addr.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// Like Dial, but the connection to the proxy and the SOCKS handshake are
+// abandoned if ctx is done before they are complete.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("network %q is not supported", network)
+	}
+	if d.Method.Protocol != "socks5" {
+		return nil, fmt.Errorf("%s: protocol %q is not supported", d.Method.MethodName, d.Method.Protocol)
+	}
+	if d.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.Method.Addr)
+	if err != nil {
+		return nil, err
+	}
+	// Interrupt the handshake if ctx is done before it finishes.
+	handshakeDone := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-handshakeDone:
+		}
+	}()
+	err = socks5Connect(conn, addr, d.Args)
+	close(handshakeDone)
+	<-watcherDone
+	if err == nil {
+		// The watcher may have set a deadline just as the handshake
+		// finished.
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %s", d.Method.MethodName, err.Error())
+	}
+	return conn, nil
+}
+
+// Replies to a SOCKS5 request, from RFC 1928 section 6.
+var socksReplies = map[byte]string{
+	pt.SocksRepGeneralFailure:       "general SOCKS server failure",
+	pt.SocksRepConnectionNotAllowed: "connection not allowed by ruleset",
+	pt.SocksRepNetworkUnreachable:   "network unreachable",
+	pt.SocksRepHostUnreachable:      "host unreachable",
+	pt.SocksRepConnectionRefused:    "connection refused",
+	pt.SocksRepTTLExpired:           "TTL expired",
+	pt.SocksRepCommandNotSupported:  "command not supported",
+	pt.SocksRepAddressNotSupported:  "address type not supported",
+}
+
+// Do a SOCKS5 CONNECT handshake to addr on conn, sending args encoded in the
+// username and password as described in pt-spec.txt section 3.5.
+func socks5Connect(conn net.Conn, addr string, args pt.Args) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	// "If the encoded argument list is less than 255 bytes in length, the
+	// PT client MUST set the PASSWD field to contain a single NUL
+	// character."
+	var username, password string
+	encoded := pt.EncodeClientParameters(args)
+	if len(encoded) > 2*255 {
+		return fmt.Errorf("encoded arguments are too long (%d bytes)", len(encoded))
+	}
+	if encoded != "" {
+		if len(encoded) > 255 {
+			username, password = encoded[:255], encoded[255:]
+		} else {
+			username, password = encoded, "\x00"
+		}
+	}
+
+	// Method selection.
+	method := byte(0x00)
+	if username != "" {
+		method = 0x02
+	}
+	_, err = conn.Write([]byte{0x05, 0x01, method})
+	if err != nil {
+		return err
+	}
+	var resp [2]byte
+	_, err = io.ReadFull(conn, resp[:])
+	if err != nil {
+		return err
+	}
+	if resp[0] != 0x05 || resp[1] != method {
+		return fmt.Errorf("SOCKS server did not accept authentication method 0x%02x", method)
+	}
+
+	// RFC 1929 username/password authentication.
+	if username != "" {
+		msg := []byte{0x01, byte(len(username))}
+		msg = append(msg, username...)
+		msg = append(msg, byte(len(password)))
+		msg = append(msg, password...)
+		_, err = conn.Write(msg)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, resp[:])
+		if err != nil {
+			return err
+		}
+		if resp[0] != 0x01 || resp[1] != 0x00 {
+			return fmt.Errorf("SOCKS server rejected arguments")
+		}
+	}
+
+	// CONNECT request.
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) == 0 || len(host) > 255 {
+			return fmt.Errorf("invalid host %q", host)
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	// Reply: VER REP RSV ATYP BND.ADDR BND.PORT.
+	var hdr [4]byte
+	_, err = io.ReadFull(conn, hdr[:])
+	if err != nil {
+		return err
+	}
+	if hdr[0] != 0x05 {
+		return fmt.Errorf("SOCKS reply has version 0x%02x", hdr[0])
+	}
+	if hdr[1] != 0x00 {
+		if msg, ok := socksReplies[hdr[1]]; ok {
+			return fmt.Errorf("SOCKS server: %s", msg)
+		}
+		return fmt.Errorf("SOCKS server: reply code 0x%02x", hdr[1])
+	}
+	var addrLen int
+	switch hdr[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		var n [1]byte
+		_, err = io.ReadFull(conn, n[:])
+		if err != nil {
+			return err
+		}
+		addrLen = int(n[0])
+	default:
+		return fmt.Errorf("SOCKS reply has address type 0x%02x", hdr[3])
+	}
+	bnd := make([]byte, addrLen+2)
+	_, err = io.ReadFull(conn, bnd)
+	if err != nil {
+		return err
+	}
+	return nil
+}
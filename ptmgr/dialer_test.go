@@ -0,0 +1,162 @@
+package ptmgr
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Start a SOCKS server that sends each accepted request on the returned
+// channel, then either rejects it (if its target is rejectTarget) or grants it
+// and echoes data.
+func startSocksServer(t *testing.T, rejectTarget string) (*pt.SocksListener, <-chan pt.SocksRequest) {
+	ln, err := pt.ListenSocks("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	reqs := make(chan pt.SocksRequest, 10)
+	go func() {
+		for {
+			conn, err := ln.AcceptSocks()
+			if err != nil {
+				return
+			}
+			reqs <- conn.Req
+			if conn.Req.Target == rejectTarget {
+				conn.RejectReason(pt.SocksRepConnectionRefused)
+				conn.Close()
+				continue
+			}
+			conn.Grant(nil)
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln, reqs
+}
+
+func TestDialer(t *testing.T) {
+	ln, reqs := startSocksServer(t, "192.0.2.9:1")
+
+	// Arguments long enough to be split between username and password.
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		target string
+		args   pt.Args
+	}{
+		{"192.0.2.1:443", nil},
+		{"[2001:db8::1]:443", pt.Args{"cert": []string{"a=b;c"}, "iat-mode": []string{"0"}}},
+		{"example.com:80", pt.Args{"long": []string{long}}},
+	}
+	for _, test := range tests {
+		d := &Dialer{Method: Cmethod{"foo", "socks5", ln.Addr().String()}, Args: test.args}
+		conn, err := d.Dial("tcp", test.target)
+		if err != nil {
+			t.Errorf("%s: %v", test.target, err)
+			continue
+		}
+		req := <-reqs
+		if req.Target != test.target {
+			t.Errorf("%s: server got target %q", test.target, req.Target)
+		}
+		if len(req.Args) != len(test.args) {
+			t.Errorf("%s: server got args %q (expected %q)", test.target, req.Args, test.args)
+		}
+		for key, values := range test.args {
+			if got := req.Args[key]; len(got) != len(values) || got[0] != values[0] {
+				t.Errorf("%s: server got args %q (expected %q)", test.target, req.Args, test.args)
+			}
+		}
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		if err != nil || string(buf) != "hello" {
+			t.Errorf("%s: echo got %q, %v", test.target, buf, err)
+		}
+		conn.Close()
+	}
+
+	d := &Dialer{Method: Cmethod{"foo", "socks5", ln.Addr().String()}}
+	_, err := d.Dial("tcp", "192.0.2.9:1")
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("rejected request: got error %v", err)
+	}
+	<-reqs
+
+	_, err = d.Dial("udp", "192.0.2.1:53")
+	if err == nil {
+		t.Error("udp unexpectedly succeeded")
+	}
+	d.Args = pt.Args{"long": []string{strings.Repeat("x", 600)}}
+	_, err = d.Dial("tcp", "192.0.2.1:443")
+	if err == nil {
+		t.Error("too-long args unexpectedly succeeded")
+	}
+}
+
+func TestDialerContext(t *testing.T) {
+	// A server that accepts TCP connections but never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	d := &Dialer{Method: Cmethod{"foo", "socks5", ln.Addr().String()}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = d.DialContext(ctx, "tcp", "192.0.2.1:443")
+	if err == nil {
+		t.Error("DialContext unexpectedly succeeded")
+	}
+
+	d.Timeout = 100 * time.Millisecond
+	_, err = d.Dial("tcp", "192.0.2.1:443")
+	if err == nil {
+		t.Error("Dial with Timeout unexpectedly succeeded")
+	}
+}
+
+func TestProcessDialer(t *testing.T) {
+	p := &Process{Cmethods: []Cmethod{
+		{"foo", "socks5", "127.0.0.1:1080"},
+		{"bar", "socks4", "127.0.0.1:1081"},
+	}}
+	d, err := p.Dialer("foo", pt.Args{"k": []string{"v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Method != p.Cmethods[0] || d.Args["k"][0] != "v" {
+		t.Errorf("unexpected Dialer %+v", d)
+	}
+	_, err = p.Dialer("bar", nil)
+	if err == nil {
+		t.Error("socks4 method unexpectedly succeeded")
+	}
+	_, err = p.Dialer("baz", nil)
+	if err == nil {
+		t.Error("nonexistent method unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,267 @@
+package ptmgr
+
+import (
+	"fmt"
+	"strings"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Line is a parsed line of transport output, as returned by ParseLine. Its
+// dynamic type is one of *Version, *EnvError, *VersionError, *ProxyError,
+// *ProxyDone, *Cmethod, *CmethodError, *CmethodsDone, *Smethod, *SmethodError,
+// *SmethodsDone, *Log, *Status, or *Unknown.
+type Line interface {
+	// The line's keyword, for example "CMETHOD".
+	Keyword() string
+}
+
+// A VERSION line.
+type Version struct {
+	Version string
+}
+
+// An ENV-ERROR line.
+type EnvError struct {
+	Message string
+}
+
+// A VERSION-ERROR line.
+type VersionError struct {
+	Message string
+}
+
+// A PROXY-ERROR line.
+type ProxyError struct {
+	Message string
+}
+
+// A PROXY DONE line.
+type ProxyDone struct{}
+
+// A CMETHOD-ERROR line.
+type CmethodError MethodError
+
+// A CMETHODS DONE line.
+type CmethodsDone struct{}
+
+// An SMETHOD-ERROR line.
+type SmethodError MethodError
+
+// An SMETHODS DONE line.
+type SmethodsDone struct{}
+
+// A LOG line.
+type Log struct {
+	// "error", "warning", "notice", "info", or "debug".
+	Severity string
+	Message  string
+}
+
+// A STATUS line.
+type Status struct {
+	// The value of TRANSPORT.
+	Transport string
+	// The other key–value pairs.
+	Args pt.Args
+}
+
+// A line with a keyword that is not otherwise recognized, or a line (such as
+// "CMETHODS" not followed by "DONE") that is not in a known form. The
+// specification says such lines are to be ignored.
+type Unknown struct {
+	// The keyword.
+	Name string
+	// The space-separated fields following the keyword.
+	Args []string
+}
+
+func (*Version) Keyword() string      { return "VERSION" }
+func (*EnvError) Keyword() string     { return "ENV-ERROR" }
+func (*VersionError) Keyword() string { return "VERSION-ERROR" }
+func (*ProxyError) Keyword() string   { return "PROXY-ERROR" }
+func (*ProxyDone) Keyword() string    { return "PROXY" }
+func (*Cmethod) Keyword() string      { return "CMETHOD" }
+func (*CmethodError) Keyword() string { return "CMETHOD-ERROR" }
+func (*CmethodsDone) Keyword() string { return "CMETHODS" }
+func (*Smethod) Keyword() string      { return "SMETHOD" }
+func (*SmethodError) Keyword() string { return "SMETHOD-ERROR" }
+func (*SmethodsDone) Keyword() string { return "SMETHODS" }
+func (*Log) Keyword() string          { return "LOG" }
+func (*Status) Keyword() string       { return "STATUS" }
+func (l *Unknown) Keyword() string    { return l.Name }
+
+// Parse one line of transport output (without its terminating newline); the
+// inverse of the functions in package pt that emit lines. Returns an error if
+// the line has a recognized keyword but is malformed. Lines with an
+// unrecognized keyword are returned as *Unknown.
+func ParseLine(line string) (Line, error) {
+	keyword, rest := line, ""
+	if i := strings.IndexByte(line, ' '); i != -1 {
+		keyword, rest = line[:i], line[i+1:]
+	}
+	if keyword == "" {
+		return nil, fmt.Errorf("empty keyword in %q", line)
+	}
+	args := strings.Split(rest, " ")
+	if rest == "" {
+		args = nil
+	}
+	malformed := func() (Line, error) {
+		return nil, fmt.Errorf("malformed %s line %q", keyword, line)
+	}
+	switch keyword {
+	case "VERSION":
+		if len(args) != 1 {
+			return malformed()
+		}
+		return &Version{args[0]}, nil
+	case "ENV-ERROR":
+		return &EnvError{rest}, nil
+	case "VERSION-ERROR":
+		return &VersionError{rest}, nil
+	case "PROXY-ERROR":
+		return &ProxyError{rest}, nil
+	case "PROXY":
+		if rest == "DONE" {
+			return &ProxyDone{}, nil
+		}
+	case "CMETHOD":
+		if len(args) < 3 {
+			return malformed()
+		}
+		return &Cmethod{args[0], args[1], args[2]}, nil
+	case "CMETHOD-ERROR":
+		if len(args) < 1 {
+			return malformed()
+		}
+		return &CmethodError{args[0], strings.Join(args[1:], " ")}, nil
+	case "CMETHODS":
+		if rest == "DONE" {
+			return &CmethodsDone{}, nil
+		}
+	case "SMETHOD":
+		if len(args) < 2 {
+			return malformed()
+		}
+		m := &Smethod{MethodName: args[0], Addr: args[1]}
+		for _, option := range args[2:] {
+			if strings.HasPrefix(option, "ARGS:") {
+				smethodArgs, err := parseSmethodArgs(option[len("ARGS:"):])
+				if err != nil {
+					return nil, fmt.Errorf("malformed SMETHOD line %q: %s", line, err.Error())
+				}
+				m.Args = smethodArgs
+			} else {
+				m.Options = append(m.Options, option)
+			}
+		}
+		return m, nil
+	case "SMETHOD-ERROR":
+		if len(args) < 1 {
+			return malformed()
+		}
+		return &SmethodError{args[0], strings.Join(args[1:], " ")}, nil
+	case "SMETHODS":
+		if rest == "DONE" {
+			return &SmethodsDone{}, nil
+		}
+	case "LOG":
+		kvs, err := parseKeyValues(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed LOG line %q: %s", line, err.Error())
+		}
+		severity, ok1 := kvs.Get("SEVERITY")
+		message, ok2 := kvs.Get("MESSAGE")
+		if !ok1 || !ok2 {
+			return malformed()
+		}
+		return &Log{severity, message}, nil
+	case "STATUS":
+		kvs, err := parseKeyValues(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed STATUS line %q: %s", line, err.Error())
+		}
+		transport, ok := kvs.Get("TRANSPORT")
+		if !ok {
+			return malformed()
+		}
+		delete(kvs, "TRANSPORT")
+		return &Status{transport, kvs}, nil
+	}
+	return &Unknown{keyword, args}, nil
+}
+
+// Parse the value of an SMETHOD ARGS option: comma-separated key=value pairs
+// in which equal signs, commas, and backslashes are escaped with a backslash.
+func parseSmethodArgs(s string) (pt.Args, error) {
+	args := make(pt.Args)
+	if s == "" {
+		return args, nil
+	}
+	for _, pair := range splitUnescaped(s, ',') {
+		kv := splitUnescaped(pair, '=')
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("no equals sign in %q", pair)
+		}
+		key, err := pt.Unescape(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, fmt.Errorf("empty key in %q", pair)
+		}
+		value, err := pt.Unescape(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		args.Add(key, value)
+	}
+	return args, nil
+}
+
+// Split s at each occurrence of sep not preceded by an escaping backslash. The
+// parts are returned still escaped.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	begin := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[begin:i])
+			begin = i + 1
+		}
+	}
+	return append(parts, s[begin:])
+}
+
+// Parse the space-separated KEY=VALUE pairs that follow the keyword of line,
+// as in LOG and STATUS lines. Each value is either a CString (in double quotes)
+// or a string that extends to the next space.
+func parseKeyValues(line string) (pt.Args, error) {
+	_, args, err := pt.ParseLine(line)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(pt.Args)
+	for _, arg := range args {
+		eq := strings.IndexByte(arg, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("no equals sign in %q", arg)
+		}
+		key, value := arg[:eq], arg[eq+1:]
+		if key == "" {
+			return nil, fmt.Errorf("bad key %q", key)
+		}
+		if strings.HasPrefix(value, "\"") {
+			value, err = pt.DecodeCString(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		kvs.Add(key, value)
+	}
+	return kvs, nil
+}
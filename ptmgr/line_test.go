@@ -0,0 +1,135 @@
+package ptmgr
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := [...]struct {
+		line     string
+		expected Line
+	}{
+		{"VERSION 1", &Version{"1"}},
+		{"ENV-ERROR no TOR_PT_STATE_LOCATION", &EnvError{"no TOR_PT_STATE_LOCATION"}},
+		{"VERSION-ERROR no-version", &VersionError{"no-version"}},
+		{"PROXY-ERROR proxy is not supported", &ProxyError{"proxy is not supported"}},
+		{"PROXY DONE", &ProxyDone{}},
+		{"CMETHOD foo socks5 127.0.0.1:1080", &Cmethod{"foo", "socks5", "127.0.0.1:1080"}},
+		{"CMETHOD-ERROR foo no such method", &CmethodError{"foo", "no such method"}},
+		{"CMETHOD-ERROR foo", &CmethodError{"foo", ""}},
+		{"CMETHODS DONE", &CmethodsDone{}},
+		{"SMETHOD foo 0.0.0.0:4444", &Smethod{MethodName: "foo", Addr: "0.0.0.0:4444"}},
+		{
+			`SMETHOD foo [::]:4444 ARGS:k=v\,1,k=v\=2,\\=3 OPT`,
+			&Smethod{"foo", "[::]:4444", pt.Args{"k": []string{"v,1", "v=2"}, "\\": []string{"3"}}, []string{"OPT"}},
+		},
+		{"SMETHOD foo 0.0.0.0:4444 ARGS:", &Smethod{"foo", "0.0.0.0:4444", pt.Args{}, nil}},
+		{"SMETHOD-ERROR foo address in use", &SmethodError{"foo", "address in use"}},
+		{"SMETHODS DONE", &SmethodsDone{}},
+		{`LOG SEVERITY=notice MESSAGE="hello world"`, &Log{"notice", "hello world"}},
+		{`LOG SEVERITY=debug MESSAGE="a\"b\\c\n\t\r\001\10\377"`, &Log{"debug", "a\"b\\c\n\t\r\x01\x08\xff"}},
+		{`LOG SEVERITY=info MESSAGE=bare`, &Log{"info", "bare"}},
+		{
+			`STATUS TRANSPORT=foo CONNECT=Success ADDRESS="192.0.2.1:443"`,
+			&Status{"foo", pt.Args{"CONNECT": []string{"Success"}, "ADDRESS": []string{"192.0.2.1:443"}}},
+		},
+		{"STATUS TRANSPORT=foo", &Status{"foo", pt.Args{}}},
+		{"FUTURE-KEYWORD a b", &Unknown{"FUTURE-KEYWORD", []string{"a", "b"}}},
+		{"CMETHODS", &Unknown{"CMETHODS", nil}},
+		{"PROXY NOTDONE", &Unknown{"PROXY", []string{"NOTDONE"}}},
+	}
+	for _, test := range tests {
+		l, err := ParseLine(test.line)
+		if err != nil {
+			t.Errorf("%q unexpectedly returned an error: %s", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(l, test.expected) {
+			t.Errorf("%q → %#v (expected %#v)", test.line, l, test.expected)
+		}
+		if l.Keyword() != strings.SplitN(test.line, " ", 2)[0] {
+			t.Errorf("%q → keyword %q", test.line, l.Keyword())
+		}
+	}
+
+	badTests := [...]string{
+		"",
+		" VERSION 1",
+		"VERSION",
+		"VERSION 1 2",
+		"CMETHOD foo socks5",
+		"CMETHOD-ERROR",
+		"SMETHOD foo",
+		`SMETHOD foo 0.0.0.0:4444 ARGS:k`,
+		`SMETHOD foo 0.0.0.0:4444 ARGS:=v`,
+		`SMETHOD foo 0.0.0.0:4444 ARGS:k=v\`,
+		"SMETHOD-ERROR",
+		`LOG SEVERITY=notice`,
+		`LOG MESSAGE="x"`,
+		`LOG SEVERITY=notice MESSAGE="unterminated`,
+		`LOG SEVERITY=notice MESSAGE="bad escape \q"`,
+		`LOG SEVERITY=notice MESSAGE="octal \400"`,
+		`LOG SEVERITY=notice MESSAGE="x"garbage`,
+		`LOG SEVERITY=notice noequals`,
+		`STATUS CONNECT=Success`,
+	}
+	for _, line := range badTests {
+		_, err := ParseLine(line)
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", line)
+		}
+	}
+}
+
+// Test that ParseLine inverts the functions in package pt that emit lines.
+func TestParseLineRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	saved := pt.Stdout
+	pt.Stdout = &buf
+	defer func() { pt.Stdout = saved }()
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1080}
+	args := pt.Args{"cert": []string{"a=b,c\\d"}, "iat-mode": []string{"0"}}
+	message := "a message with \"quotes\", \\backslashes\\, and\nnewlines\x00\xff"
+	pt.Cmethod("foo", "socks5", addr)
+	pt.CmethodError("bar", "no such method")
+	pt.CmethodsDone()
+	pt.SmethodArgs("foo", addr, args)
+	pt.SmethodError("bar", "no such method")
+	pt.SmethodsDone()
+	pt.ProxyDone()
+	pt.ProxyError("proxy error")
+	pt.Log(pt.LogSeverityWarning, message)
+
+	expected := []Line{
+		&Cmethod{"foo", "socks5", "127.0.0.1:1080"},
+		&CmethodError{"bar", "no such method"},
+		&CmethodsDone{},
+		&Smethod{"foo", "127.0.0.1:1080", args, nil},
+		&SmethodError{"bar", "no such method"},
+		&SmethodsDone{},
+		&ProxyDone{},
+		&ProxyError{"proxy error"},
+		&Log{"warning", message},
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("got %d lines (expected %d): %q", len(lines), len(expected), lines)
+	}
+	for i, line := range lines {
+		l, err := ParseLine(line)
+		if err != nil {
+			t.Errorf("%q unexpectedly returned an error: %s", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(l, expected[i]) {
+			t.Errorf("%q → %#v (expected %#v)", line, l, expected[i])
+		}
+	}
+}
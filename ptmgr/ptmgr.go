@@ -0,0 +1,367 @@
+// Package ptmgr implements the tor side of the pluggable transports managed
+// proxy protocol: it launches a transport executable as a subprocess, with the
+// TOR_PT_* environment that tells it what to do, and reads the methods that
+// the transport reports on its stdout.
+//
+//	p, err := ptmgr.Launch(&ptmgr.Config{
+//		Path:             "/usr/bin/obfs4proxy",
+//		StateDir:         "/var/lib/myapp/pt_state",
+//		ExitOnStdinClose: true,
+//		ClientTransports: []string{"obfs4"},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer p.Close()
+//	for _, m := range p.Cmethods {
+//		fmt.Printf("%s listening on %s\n", m.MethodName, m.Addr)
+//	}
+package ptmgr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// How long Launch waits for a transport to finish setup, if
+// Config.StartupTimeout is zero.
+const DefaultStartupTimeout = 30 * time.Second
+
+// Bindaddr is one element of TOR_PT_SERVER_BINDADDR.
+type Bindaddr struct {
+	MethodName string
+	// A "host:port" address.
+	Addr string
+}
+
+// Config describes a transport executable and the environment to run it in.
+// Exactly one of ClientTransports and ServerTransports must be non-empty.
+type Config struct {
+	// The path of the executable and its command-line arguments, as for
+	// exec.Command.
+	Path string
+	Args []string
+
+	// TOR_PT_STATE_LOCATION. Required.
+	StateDir string
+	// TOR_PT_EXIT_ON_STDIN_CLOSE=1. If set, Close asks the transport to
+	// exit by closing its stdin, before resorting to killing it.
+	ExitOnStdinClose bool
+
+	// TOR_PT_CLIENT_TRANSPORTS.
+	ClientTransports []string
+	// TOR_PT_PROXY. If set, the transport must reply with PROXY DONE.
+	ProxyURL *url.URL
+
+	// TOR_PT_SERVER_TRANSPORTS.
+	ServerTransports []string
+	// TOR_PT_SERVER_BINDADDR.
+	Bindaddrs []Bindaddr
+	// TOR_PT_SERVER_TRANSPORT_OPTIONS.
+	ServerTransportOptions map[string]pt.Args
+	// TOR_PT_ORPORT.
+	ORPort string
+	// TOR_PT_EXTENDED_SERVER_PORT and TOR_PT_AUTH_COOKIE_FILE.
+	ExtendedORPort string
+	AuthCookieFile string
+
+	// The environment, apart from TOR_PT_* variables, in the form of
+	// os.Environ. If nil, the current process's environment is used, with
+	// any TOR_PT_* variables removed.
+	Env []string
+	// Where to send the transport's stderr. If nil, it is discarded.
+	Stderr io.Writer
+	// How long to wait for the transport to finish setup. If zero,
+	// DefaultStartupTimeout is used.
+	StartupTimeout time.Duration
+	// If not nil, called with every line the transport writes to stdout,
+	// including those after setup, such as LOG and STATUS lines. It is
+	// called from a single goroutine and must not block for long.
+	LineFunc func(line string)
+}
+
+// Return the TOR_PT_* variables described by config, in the form of
+// os.Environ.
+func (config *Config) environ() ([]string, error) {
+	if config.StateDir == "" {
+		return nil, fmt.Errorf("StateDir is required")
+	}
+	if (len(config.ClientTransports) == 0) == (len(config.ServerTransports) == 0) {
+		return nil, fmt.Errorf("exactly one of ClientTransports and ServerTransports must be set")
+	}
+	env := []string{
+		"TOR_PT_MANAGED_TRANSPORT_VER=1",
+		"TOR_PT_STATE_LOCATION=" + config.StateDir,
+	}
+	if config.ExitOnStdinClose {
+		env = append(env, "TOR_PT_EXIT_ON_STDIN_CLOSE=1")
+	}
+	if len(config.ClientTransports) > 0 {
+		env = append(env, "TOR_PT_CLIENT_TRANSPORTS="+strings.Join(config.ClientTransports, ","))
+		if config.ProxyURL != nil {
+			env = append(env, "TOR_PT_PROXY="+config.ProxyURL.String())
+		}
+		return env, nil
+	}
+
+	env = append(env, "TOR_PT_SERVER_TRANSPORTS="+strings.Join(config.ServerTransports, ","))
+	if len(config.Bindaddrs) > 0 {
+		var specs []string
+		for _, bindaddr := range config.Bindaddrs {
+			specs = append(specs, bindaddr.MethodName+"-"+bindaddr.Addr)
+		}
+		env = append(env, "TOR_PT_SERVER_BINDADDR="+strings.Join(specs, ","))
+	}
+	if len(config.ServerTransportOptions) > 0 {
+		env = append(env, "TOR_PT_SERVER_TRANSPORT_OPTIONS="+pt.EncodeServerTransportOptions(config.ServerTransportOptions))
+	}
+	if config.ORPort != "" {
+		env = append(env, "TOR_PT_ORPORT="+config.ORPort)
+	}
+	if config.ExtendedORPort != "" {
+		env = append(env, "TOR_PT_EXTENDED_SERVER_PORT="+config.ExtendedORPort)
+	}
+	if config.AuthCookieFile != "" {
+		env = append(env, "TOR_PT_AUTH_COOKIE_FILE="+config.AuthCookieFile)
+	}
+	return env, nil
+}
+
+// Cmethod is a client method reported by a CMETHOD line.
+type Cmethod struct {
+	MethodName string
+	// "socks4" or "socks5".
+	Protocol string
+	// The "host:port" address of the method's proxy.
+	Addr string
+}
+
+// Smethod is a server method reported by an SMETHOD line.
+type Smethod struct {
+	MethodName string
+	// The "host:port" address the method is listening on.
+	Addr string
+	// The decoded ARGS option, or nil if there was none.
+	Args pt.Args
+	// Any other fields after the address.
+	Options []string
+}
+
+// MethodError is a method that the transport could not launch, reported by a
+// CMETHOD-ERROR or SMETHOD-ERROR line.
+type MethodError struct {
+	MethodName string
+	Message    string
+}
+
+// Process is a transport started by Launch.
+type Process struct {
+	Cmd *exec.Cmd
+	// The version from the VERSION line.
+	Version string
+	// The methods reported during setup.
+	Cmethods      []Cmethod
+	CmethodErrors []MethodError
+	Smethods      []Smethod
+	SmethodErrors []MethodError
+	// Lines written during setup that could not be parsed. Like tor, Launch
+	// ignores them apart from recording them here.
+	BadLines []string
+
+	exitOnStdinClose bool
+	stdin            io.WriteCloser
+
+	// Closed after the process has exited and all its output has been
+	// read. waitErr is the result of Cmd.Wait.
+	exited  chan struct{}
+	waitErr error
+
+	closeOnce sync.Once
+}
+
+// Start the transport described by config and read its stdout until it reports
+// that setup is complete with CMETHODS DONE or SMETHODS DONE. Returns an error,
+// after killing the process, if the process reports a fatal error (ENV-ERROR,
+// VERSION-ERROR, or PROXY-ERROR), exits, or does not finish setup within the
+// startup timeout. It is not an error for individual methods to fail with
+// CMETHOD-ERROR or SMETHOD-ERROR; those are recorded in the returned Process.
+// Nor is it an error for the process to write a malformed line, such as a LOG
+// or STATUS line that cannot be parsed; those are recorded in BadLines.
+func Launch(config *Config) (*Process, error) {
+	ptEnv, err := config.environ()
+	if err != nil {
+		return nil, err
+	}
+	env := config.Env
+	if env == nil {
+		for _, kv := range os.Environ() {
+			if !strings.HasPrefix(kv, "TOR_PT_") {
+				env = append(env, kv)
+			}
+		}
+	}
+
+	p := &Process{
+		Cmd:              exec.Command(config.Path, config.Args...),
+		exitOnStdinClose: config.ExitOnStdinClose,
+		exited:           make(chan struct{}),
+	}
+	p.Cmd.Env = append(append([]string(nil), env...), ptEnv...)
+	p.Cmd.Stderr = config.Stderr
+	p.stdin, err = p.Cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.Cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = p.Cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	// The reader goroutine sends lines on lines until setup is finished
+	// (signaled by closing setupDone), then only passes them to LineFunc.
+	lines := make(chan string)
+	setupDone := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if config.LineFunc != nil {
+				config.LineFunc(line)
+			}
+			select {
+			case lines <- line:
+			case <-setupDone:
+			}
+		}
+		close(lines)
+		// Cmd.Wait must not be called until reading from stdout is
+		// finished.
+		p.waitErr = p.Cmd.Wait()
+		close(p.exited)
+	}()
+
+	timeout := config.StartupTimeout
+	if timeout == 0 {
+		timeout = DefaultStartupTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	needProxyDone := len(config.ClientTransports) > 0 && config.ProxyURL != nil
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				close(setupDone)
+				p.Close()
+				return nil, fmt.Errorf("%s exited before finishing setup", config.Path)
+			}
+			done, err := p.handleSetupLine(line, &needProxyDone)
+			if err != nil {
+				close(setupDone)
+				p.Close()
+				return nil, err
+			}
+			if done {
+				close(setupDone)
+				return p, nil
+			}
+		case <-timer.C:
+			close(setupDone)
+			p.Close()
+			return nil, fmt.Errorf("%s did not finish setup within %v", config.Path, timeout)
+		}
+	}
+}
+
+// Interpret a line of output during setup. Returns done == true when the end
+// of setup has been reached, or an error if the line is a fatal error. A line
+// that cannot be parsed is appended to p.BadLines and otherwise ignored.
+// *needProxyDone is cleared on PROXY DONE; reaching the end of setup while it is
+// still set is an error.
+func (p *Process) handleSetupLine(line string, needProxyDone *bool) (done bool, err error) {
+	l, err := ParseLine(line)
+	if err != nil {
+		p.BadLines = append(p.BadLines, line)
+		return false, nil
+	}
+	switch l := l.(type) {
+	case *Version:
+		p.Version = l.Version
+	case *EnvError:
+		return false, fmt.Errorf("ENV-ERROR: %s", l.Message)
+	case *VersionError:
+		return false, fmt.Errorf("VERSION-ERROR: %s", l.Message)
+	case *ProxyError:
+		return false, fmt.Errorf("PROXY-ERROR: %s", l.Message)
+	case *ProxyDone:
+		*needProxyDone = false
+	case *Cmethod:
+		p.Cmethods = append(p.Cmethods, *l)
+	case *CmethodError:
+		p.CmethodErrors = append(p.CmethodErrors, MethodError(*l))
+	case *Smethod:
+		p.Smethods = append(p.Smethods, *l)
+	case *SmethodError:
+		p.SmethodErrors = append(p.SmethodErrors, MethodError(*l))
+	case *CmethodsDone, *SmethodsDone:
+		if *needProxyDone {
+			return false, fmt.Errorf("transport did not acknowledge proxy")
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Return a channel that is closed when the process has exited.
+func (p *Process) Exited() <-chan struct{} {
+	return p.exited
+}
+
+// Wait for the process to exit and return the result of exec.Cmd.Wait.
+func (p *Process) Wait() error {
+	<-p.exited
+	return p.waitErr
+}
+
+// How long Close waits for a process started with ExitOnStdinClose to exit on
+// its own.
+var closeGracePeriod = 5 * time.Second
+
+// Stop the process and wait for it to exit. If the process was started with
+// ExitOnStdinClose, Close first closes its stdin and gives it a few seconds to
+// exit on its own; otherwise, or if it does not exit in that time, it is
+// killed. Returns the result of exec.Cmd.Wait.
+func (p *Process) Close() error {
+	p.closeOnce.Do(func() {
+		p.stdin.Close()
+		if p.exitOnStdinClose {
+			timer := time.NewTimer(closeGracePeriod)
+			defer timer.Stop()
+			select {
+			case <-p.exited:
+				return
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-p.exited:
+		default:
+			p.Cmd.Process.Kill()
+			<-p.exited
+		}
+	})
+	return p.Wait()
+}
@@ -0,0 +1,198 @@
+package ptmgr
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// TestHelperProcess is not a real test. It is run as a subprocess by the other
+// tests in this file, acting as a pluggable transport. PTMGR_HELPER_PROCESS
+// selects its behavior.
+func TestHelperProcess(t *testing.T) {
+	mode := os.Getenv("PTMGR_HELPER_PROCESS")
+	if mode == "" {
+		return
+	}
+	defer os.Exit(0)
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1080}
+	switch mode {
+	case "client":
+		info, err := pt.ClientSetup(nil)
+		if err != nil {
+			os.Exit(1)
+		}
+		if info.ProxyURL != nil {
+			pt.ProxyDone()
+		}
+		for _, methodName := range info.MethodNames {
+			if methodName == "good" {
+				pt.Cmethod(methodName, "socks5", addr)
+			} else {
+				pt.CmethodError(methodName, "no such method")
+			}
+		}
+		pt.CmethodsDone()
+	case "badlines":
+		if _, err := pt.ClientSetup(nil); err != nil {
+			os.Exit(1)
+		}
+		fmt.Fprintln(pt.Stdout, "LOG SEVERITY=notice")
+		fmt.Fprintln(pt.Stdout, "STATUS X=1")
+		pt.Cmethod("good", "socks5", addr)
+		pt.CmethodsDone()
+	case "noproxy":
+		if _, err := pt.ClientSetup(nil); err != nil {
+			os.Exit(1)
+		}
+		pt.CmethodsDone()
+	case "server":
+		info, err := pt.ServerSetup(nil)
+		if err != nil {
+			os.Exit(1)
+		}
+		for _, bindaddr := range info.Bindaddrs {
+			pt.SmethodArgs(bindaddr.MethodName, bindaddr.Addr, bindaddr.Options)
+		}
+		pt.SmethodsDone()
+	case "hang":
+	}
+	pt.Log(pt.LogSeverityNotice, "started")
+	<-pt.WatchParent()
+}
+
+func helperConfig(t *testing.T, mode string) *Config {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "TOR_PT_") {
+			env = append(env, kv)
+		}
+	}
+	return &Config{
+		Path:             os.Args[0],
+		Args:             []string{"-test.run=^TestHelperProcess$"},
+		StateDir:         t.TempDir(),
+		ExitOnStdinClose: true,
+		Env:              append(env, "PTMGR_HELPER_PROCESS="+mode),
+		StartupTimeout:   10 * time.Second,
+	}
+}
+
+func TestLaunchClient(t *testing.T) {
+	config := helperConfig(t, "client")
+	config.ClientTransports = []string{"good", "bad"}
+	config.ProxyURL, _ = url.Parse("socks5://127.0.0.1:9050")
+	var mu sync.Mutex
+	var lines []string
+	config.LineFunc = func(line string) {
+		mu.Lock()
+		lines = append(lines, line)
+		mu.Unlock()
+	}
+	p, err := Launch(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != "1" {
+		t.Errorf("unexpected Version %q", p.Version)
+	}
+	if len(p.Cmethods) != 1 || p.Cmethods[0] != (Cmethod{"good", "socks5", "127.0.0.1:1080"}) {
+		t.Errorf("unexpected Cmethods %+v", p.Cmethods)
+	}
+	if len(p.CmethodErrors) != 1 || p.CmethodErrors[0] != (MethodError{"bad", "no such method"}) {
+		t.Errorf("unexpected CmethodErrors %+v", p.CmethodErrors)
+	}
+
+	err = p.Close()
+	if err != nil {
+		t.Errorf("process exited with %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lines) == 0 || lines[len(lines)-1] != `LOG SEVERITY=notice MESSAGE="started"` {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
+
+func TestLaunchBadLines(t *testing.T) {
+	config := helperConfig(t, "badlines")
+	config.ClientTransports = []string{"good"}
+	p, err := Launch(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if len(p.Cmethods) != 1 || p.Cmethods[0].MethodName != "good" {
+		t.Errorf("unexpected Cmethods %+v", p.Cmethods)
+	}
+	expected := []string{"LOG SEVERITY=notice", "STATUS X=1"}
+	if !reflect.DeepEqual(p.BadLines, expected) {
+		t.Errorf("expected BadLines %q, got %q", expected, p.BadLines)
+	}
+}
+
+func TestLaunchServer(t *testing.T) {
+	config := helperConfig(t, "server")
+	config.ServerTransports = []string{"foo"}
+	config.Bindaddrs = []Bindaddr{{"foo", "127.0.0.1:4444"}}
+	config.ServerTransportOptions = map[string]pt.Args{"foo": {"key": []string{"a=b"}}}
+	config.ORPort = "127.0.0.1:9001"
+	p, err := Launch(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if len(p.Smethods) != 1 || p.Smethods[0].MethodName != "foo" ||
+		p.Smethods[0].Addr != "127.0.0.1:4444" ||
+		len(p.Smethods[0].Options) != 0 || len(p.Smethods[0].Args) != 1 ||
+		p.Smethods[0].Args["key"][0] != "a=b" {
+		t.Errorf("unexpected Smethods %+v", p.Smethods)
+	}
+}
+
+func TestLaunchErrors(t *testing.T) {
+	// No ORPort: the transport reports ENV-ERROR.
+	config := helperConfig(t, "server")
+	config.ServerTransports = []string{"foo"}
+	_, err := Launch(config)
+	if err == nil || !strings.HasPrefix(err.Error(), "ENV-ERROR: ") {
+		t.Errorf("missing ORPort: got error %v", err)
+	}
+
+	// A proxy that the transport does not acknowledge.
+	config = helperConfig(t, "noproxy")
+	config.ClientTransports = []string{"good"}
+	config.ProxyURL, _ = url.Parse("socks5://127.0.0.1:9050")
+	_, err = Launch(config)
+	if err == nil {
+		t.Error("unacknowledged proxy: Launch unexpectedly succeeded")
+	}
+
+	config = helperConfig(t, "hang")
+	config.ClientTransports = []string{"good"}
+	config.StartupTimeout = 100 * time.Millisecond
+	_, err = Launch(config)
+	if err == nil || !strings.Contains(err.Error(), "did not finish setup") {
+		t.Errorf("timeout: got error %v", err)
+	}
+
+	config = helperConfig(t, "client")
+	_, err = Launch(config)
+	if err == nil {
+		t.Error("no transports: Launch unexpectedly succeeded")
+	}
+	config.ClientTransports = []string{"good"}
+	config.StateDir = ""
+	_, err = Launch(config)
+	if err == nil {
+		t.Error("no StateDir: Launch unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,251 @@
+// Package ptmobile runs pluggable transports inside an app's own process, with
+// an API that can be bound by gomobile for use from Java, Kotlin, or Swift.
+//
+// Mobile apps cannot usually run a transport as a subprocess of tor the way
+// desktop systems do, so the managed-proxy machinery of package pt, with its
+// environment variables, stdout protocol, and signal handling, does not apply.
+// Instead, the app's Go code registers the transports it includes, from an
+// init function:
+//
+//	func init() {
+//		ptmobile.RegisterTransport(obfs4.Transport{})
+//	}
+//
+// and the app's platform code starts a client, then points tor (or any SOCKS
+// client) at its port:
+//
+//	Client client = Ptmobile.startClient("obfs4", stateDir, "");
+//	long port = client.port();
+//	...
+//	client.stop();
+//
+// Apart from RegisterTransport, the exported functions and methods use only
+// types that gomobile can bind: strings, integers, errors, and interfaces
+// whose methods use those types.
+package ptmobile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Make t available to StartClient and StartServer under its Name, by
+// registering it with pt.RegisterTransport. This is for the app's Go code; it
+// cannot be called through gomobile bindings.
+func RegisterTransport(t pt.Transport) error {
+	return pt.RegisterTransport(t)
+}
+
+// Logger receives log messages from transports and from the library. severity
+// is one of "error", "warning", "notice", "info", or "debug".
+type Logger interface {
+	Log(severity, message string)
+}
+
+// Send the library's log messages to logger, in place of stdout. Pass nil to
+// discard them.
+func SetLogger(logger Logger) {
+	pt.EventReporter = pt.ReporterFunc(func(e pt.Event) {
+		if logger != nil && e.Keyword == "LOG" {
+			logger.Log(e.Severity, e.Message)
+		}
+	})
+}
+
+func init() {
+	// In process, nothing reads the managed-proxy protocol on stdout.
+	SetLogger(nil)
+}
+
+// Client is the client side of a transport, running in process. It accepts
+// SOCKS connections on a loopback port and relays each one through the
+// transport to the server named in the SOCKS request, with the per-connection
+// arguments (for tor, those from the bridge line) in the SOCKS username and
+// password.
+type Client struct {
+	ln *pt.SocksListener
+	m  *pt.ShutdownManager
+}
+
+// Start the client side of the transport registered as methodName. stateDir is
+// a directory in which the transport may keep persistent state, or "".
+// proxyURL is the upstream proxy through which the transport should connect,
+// for example "socks5://127.0.0.1:9050", or "" for none.
+func StartClient(methodName, stateDir, proxyURL string) (*Client, error) {
+	config := &pt.ClientConfig{StateDir: stateDir}
+	if proxyURL != "" {
+		var err error
+		config.ProxyURL, err = url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, err := pt.ClientFactoryFor(methodName, config)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{m: new(pt.ShutdownManager)}
+	tln, err := c.m.TrackListener(ln)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	c.ln = pt.NewSocksListener(tln)
+	c.ln.MethodName = methodName
+	go pt.AcceptLoop(c.ln, 0, func(conn net.Conn) {
+		handleSocks(conn.(*pt.SocksConn), f)
+	})
+	return c, nil
+}
+
+func handleSocks(conn *pt.SocksConn, d pt.Dialer) {
+	remote, err := d.Dial("tcp", conn.Req.Target, conn.Req.Args)
+	if err != nil {
+		conn.Reject()
+		return
+	}
+	defer remote.Close()
+	err = conn.Grant(nil)
+	if err != nil {
+		return
+	}
+	relay(conn, remote)
+}
+
+// The address of the SOCKS listener, as "127.0.0.1:port".
+func (c *Client) Addr() string {
+	return c.ln.Addr().String()
+}
+
+// The port of the SOCKS listener.
+func (c *Client) Port() int {
+	return c.ln.Addr().(*net.TCPAddr).Port
+}
+
+// The SOCKS version that the listener speaks, as in a CMETHOD line, for
+// example "socks5".
+func (c *Client) SocksVersion() string {
+	return c.ln.Version()
+}
+
+// Close the listener and all connections.
+func (c *Client) Stop() {
+	stop(c.m)
+}
+
+// Server is the server side of a transport, running in process. It accepts
+// transport connections and relays each one to a tor ORPort.
+type Server struct {
+	methodName string
+	ln         net.Listener
+	args       pt.Args
+	m          *pt.ShutdownManager
+}
+
+// Start the server side of the transport registered as methodName, listening
+// on listenAddr (for example, "0.0.0.0:443", or "0.0.0.0:0" for any port) and
+// relaying to the ORPort at orAddr. stateDir is a directory in which the
+// transport may keep persistent state, or "". options are the transport's
+// options, in the format of TOR_PT_SERVER_TRANSPORT_OPTIONS (for example,
+// "obfs4:iat-mode=1"), or "".
+func StartServer(methodName, listenAddr, orAddr, stateDir, options string) (*Server, error) {
+	opts, err := pt.ParseServerTransportOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	or, err := pt.ParseAddrPort(orAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse ORPort address %q: %s", orAddr, err.Error())
+	}
+	bindaddr, err := pt.ParseAddrPort(listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse listen address %q: %s", listenAddr, err.Error())
+	}
+	f, err := pt.ServerFactoryFor(methodName, &pt.ServerConfig{
+		StateDir: stateDir,
+		Options:  opts[methodName],
+		Bindaddr: pt.Bindaddr{MethodName: methodName, Addr: bindaddr, Options: opts[methodName]},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ln, err := f.Listen("tcp", bindaddr.String())
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{methodName: methodName, m: new(pt.ShutdownManager)}
+	s.ln, err = s.m.TrackListener(ln)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if a, ok := f.(pt.ServerArgser); ok {
+		s.args = a.ServerArgs()
+	}
+	info := &pt.ServerInfo{OrAddr: or}
+	go pt.AcceptLoop(s.ln, 0, func(conn net.Conn) {
+		or, err := pt.DialOr(info, conn.RemoteAddr().String(), methodName)
+		if err != nil {
+			return
+		}
+		defer or.Close()
+		relay(conn, or)
+	})
+	return s, nil
+}
+
+// The address of the transport listener.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// The port of the transport listener.
+func (s *Server) Port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+// Return a Bridge line for clients, as with pt.BridgeLine, for the bridge at
+// addr, which is the server's public address and port, and that has the relay
+// identity fingerprint fingerprint ("" for none).
+func (s *Server) BridgeLine(addr, fingerprint string) (string, error) {
+	tcpAddr, err := pt.ParseAddrPort(addr)
+	if err != nil {
+		return "", err
+	}
+	return pt.BridgeLine(s.methodName, tcpAddr, fingerprint, s.args)
+}
+
+// Close the listener and all connections.
+func (s *Server) Stop() {
+	stop(s.m)
+}
+
+// Close everything tracked by m without waiting.
+func stop(m *pt.ShutdownManager) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.Shutdown(ctx)
+}
+
+// Copy data in both directions between a and b until both directions are
+// finished.
+func relay(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(b, a)
+		b.Close()
+		close(done)
+	}()
+	io.Copy(a, b)
+	a.Close()
+	<-done
+}
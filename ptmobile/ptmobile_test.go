@@ -0,0 +1,170 @@
+package ptmobile
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// A transport that does no obfuscation.
+type plainTransport struct{}
+
+func (plainTransport) Name() string { return "plain" }
+
+func (plainTransport) ClientFactory(config *pt.ClientConfig) (pt.ClientFactory, error) {
+	return pt.DialerFunc(func(network, address string, args pt.Args) (net.Conn, error) {
+		return net.Dial(network, address)
+	}), nil
+}
+
+func (plainTransport) ServerFactory(config *pt.ServerConfig) (pt.ServerFactory, error) {
+	return plainServer{config.Options}, nil
+}
+
+type plainServer struct {
+	options pt.Args
+}
+
+func (s plainServer) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+func (s plainServer) ServerArgs() pt.Args {
+	return s.options
+}
+
+func startEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln
+}
+
+// Do a SOCKS5 CONNECT to the IPv4 address target.
+func socks5Connect(conn net.Conn, target *net.TCPAddr) error {
+	_, err := conn.Write([]byte{5, 1, 0})
+	if err != nil {
+		return err
+	}
+	p := make([]byte, 2)
+	if _, err := io.ReadFull(conn, p); err != nil {
+		return err
+	}
+	req := []byte{5, 1, 0, 1}
+	req = append(req, target.IP.To4()...)
+	req = append(req, byte(target.Port>>8), byte(target.Port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	resp := make([]byte, 10)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+type logRecorder chan string
+
+func (r logRecorder) Log(severity, message string) {
+	r <- severity + " " + message
+}
+
+func TestClientServer(t *testing.T) {
+	RegisterTransport(plainTransport{})
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	server, err := StartServer("plain", "127.0.0.1:0", echo.Addr().String(), "", "plain:key=value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	line, err := server.BridgeLine("192.0.2.1:443", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "Bridge plain 192.0.2.1:443 key=value" {
+		t.Errorf("unexpected bridge line %q", line)
+	}
+
+	client, err := StartClient("plain", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	if client.Addr() != "127.0.0.1:"+strconv.Itoa(client.Port()) {
+		t.Errorf("Addr %q does not match Port %d", client.Addr(), client.Port())
+	}
+
+	conn, err := net.Dial("tcp", client.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = socks5Connect(conn, server.ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 5)
+	_, err = io.ReadFull(conn, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "hello" {
+		t.Errorf("got %q, expected %q", p, "hello")
+	}
+
+	// After Stop, the client accepts no more connections.
+	client.Stop()
+	c, err := net.Dial("tcp", client.Addr())
+	if err == nil {
+		c.Close()
+		t.Error("client still listening after Stop")
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	if _, err := StartClient("nonexistent", "", ""); err == nil {
+		t.Error("unregistered client transport unexpectedly succeeded")
+	}
+	if _, err := StartServer("nonexistent", "127.0.0.1:0", "127.0.0.1:9001", "", ""); err == nil {
+		t.Error("unregistered server transport unexpectedly succeeded")
+	}
+	RegisterTransport(plainTransport{})
+	if _, err := StartServer("plain", "127.0.0.1:0", "localhost:9001", "", ""); err == nil {
+		t.Error("ORPort host name unexpectedly succeeded")
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	logs := make(logRecorder, 1)
+	SetLogger(logs)
+	defer SetLogger(nil)
+	pt.Log(pt.LogSeverityWarning, "test message")
+	if msg := <-logs; msg != "warning test message" {
+		t.Errorf("got %q", msg)
+	}
+}
@@ -0,0 +1,225 @@
+// Package ptotel records spans, in the manner of OpenTelemetry tracing, for the
+// connections handled by goptlib's RunClient, RunServer, ServeTransports, and
+// standalone modes. For each connection there is a "goptlib.conn" span lasting
+// from accept to close, with child spans for the stages of the connection:
+//
+//	goptlib.socks_handshake   SOCKS negotiation with the client (client side)
+//	goptlib.transport_dial    the Dialer making the transport connection (client side)
+//	goptlib.or_dial           connecting to the ORPort or extended ORPort (server side)
+//	goptlib.extorport_auth    extended ORPort authentication and metadata (server side)
+//	goptlib.relay             relaying data, with the byte counts
+//
+// Addresses in span attributes are passed through pt.SafeAddr, so they obey
+// pt.SafeLogging.
+//
+// The package does not depend on the OpenTelemetry modules; instead it uses
+// the small Tracer and Span interfaces, which a program adapts from the Tracer
+// of its configured TracerProvider in a few lines:
+//
+//	import (
+//		"fmt"
+//
+//		"go.opentelemetry.io/otel"
+//		"go.opentelemetry.io/otel/attribute"
+//		"go.opentelemetry.io/otel/codes"
+//		"go.opentelemetry.io/otel/trace"
+//	)
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, start time.Time) (context.Context, ptotel.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name, trace.WithTimestamp(start))
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ trace.Span }
+//
+//	func (s otelSpan) SetAttribute(key string, value interface{}) {
+//		s.Span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+//	}
+//	func (s otelSpan) RecordError(err error) {
+//		s.Span.RecordError(err)
+//		s.Span.SetStatus(codes.Error, err.Error())
+//	}
+//	func (s otelSpan) End(t time.Time) { s.Span.End(trace.WithTimestamp(t)) }
+//
+//	func main() {
+//		ptotel.Install(otelTracer{otel.GetTracerProvider().Tracer(ptotel.InstrumentationName)})
+//		...
+//	}
+//
+// The context of each connection carries its goptlib.conn span, so a
+// transport's ContextDialer, or code that calls pt.DialOrContext, can make
+// child spans of it with the same Tracer.
+package ptotel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// The name to pass to a TracerProvider when getting a Tracer for Install.
+const InstrumentationName = "git.torproject.org/pluggable-transports/goptlib.git"
+
+// Tracer starts spans. It corresponds to the Tracer of OpenTelemetry, with the
+// start time given explicitly.
+type Tracer interface {
+	// Start a span called spanName, beginning at start, as a child of the
+	// span in ctx, if any. Return a context carrying the new span.
+	Start(ctx context.Context, spanName string, start time.Time) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// Mark the span as failed with err.
+	RecordError(err error)
+	// Finish the span at end.
+	End(end time.Time)
+}
+
+// The error recorded on a dial span that was not finished when its connection
+// was.
+var errNotRelayed = errors.New("connection finished before relaying began")
+
+// Set pt.ConnContext so that spans are recorded with tracer for every
+// connection, keeping any ConnContext that was set before.
+func Install(tracer Tracer) {
+	pt.ConnContext = ConnContext(tracer, pt.ConnContext)
+}
+
+// Return a function, for use as pt.ConnContext, that records spans with tracer
+// for the connection of each context. If next is not nil, it is applied to the
+// context first.
+func ConnContext(tracer Tracer, next func(context.Context) context.Context) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		if next != nil {
+			ctx = next(ctx)
+		}
+		start := time.Now()
+		var methodName string
+		var remoteAddr net.Addr
+		if info, ok := pt.ConnInfoFromContext(ctx); ok {
+			methodName = info.MethodName
+			remoteAddr = info.RemoteAddr
+			if !info.Accepted.IsZero() {
+				start = info.Accepted
+			}
+		}
+		ctx, span := tracer.Start(ctx, "goptlib.conn", start)
+		span.SetAttribute("pt.method", methodName)
+		if remoteAddr != nil {
+			span.SetAttribute("net.peer.addr", pt.SafeAddr(remoteAddr))
+		}
+		c := &connSpans{tracer: tracer, ctx: ctx, accepted: start, conn: span}
+		ctx = pt.WithPTTrace(ctx, c.trace())
+		go func() {
+			<-ctx.Done()
+			c.finish()
+		}()
+		return ctx
+	}
+}
+
+// The spans of one connection.
+type connSpans struct {
+	tracer   Tracer
+	ctx      context.Context
+	accepted time.Time
+
+	mu    sync.Mutex
+	conn  Span
+	dial  Span
+	auth  Span
+	relay Span
+}
+
+// Start a child span of the connection span.
+func (c *connSpans) start(name string, start time.Time) Span {
+	_, span := c.tracer.Start(c.ctx, name, start)
+	return span
+}
+
+// End *span, if it is not nil, recording err if it is not nil, and set *span
+// to nil.
+func endSpan(span *Span, err error, end time.Time) {
+	if *span == nil {
+		return
+	}
+	if err != nil {
+		(*span).RecordError(err)
+	}
+	(*span).End(end)
+	*span = nil
+}
+
+func (c *connSpans) trace() *pt.PTTrace {
+	return &pt.PTTrace{
+		GotSocksRequest: func(req *pt.SocksRequest) {
+			now := time.Now()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			span := c.start("goptlib.socks_handshake", c.accepted)
+			span.SetAttribute("socks.target", pt.SafeAddrString(req.Target))
+			span.End(now)
+			c.dial = c.start("goptlib.transport_dial", now)
+		},
+		ORDialStart: func(addr *net.TCPAddr) {
+			now := time.Now()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// With more than one address there is one span for all
+			// the attempts.
+			if c.dial == nil {
+				c.dial = c.start("goptlib.or_dial", now)
+				c.dial.SetAttribute("or.addr", addr.String())
+			}
+		},
+		ORAuthStart: func() {
+			now := time.Now()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			endSpan(&c.dial, nil, now)
+			c.auth = c.start("goptlib.extorport_auth", now)
+		},
+		ORAuthDone: func(err error) {
+			now := time.Now()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			endSpan(&c.auth, err, now)
+		},
+		RelayStart: func() {
+			now := time.Now()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			endSpan(&c.dial, nil, now)
+			c.relay = c.start("goptlib.relay", now)
+		},
+		ConnClosed: func(sent, received int64) {
+			now := time.Now()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.relay != nil {
+				c.relay.SetAttribute("pt.bytes_sent", sent)
+				c.relay.SetAttribute("pt.bytes_received", received)
+			}
+			endSpan(&c.relay, nil, now)
+		},
+	}
+}
+
+// End all spans, when the connection is finished.
+func (c *connSpans) finish() {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	endSpan(&c.dial, errNotRelayed, now)
+	endSpan(&c.auth, errNotRelayed, now)
+	endSpan(&c.relay, nil, now)
+	endSpan(&c.conn, nil, now)
+}
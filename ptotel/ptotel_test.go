@@ -0,0 +1,151 @@
+package ptotel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[string]interface{}
+	err        error
+	start, end time.Time
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *recordedSpan) RecordError(err error)                      { s.err = err }
+func (s *recordedSpan) End(end time.Time)                          { s.end = end }
+
+type spanKey struct{}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+	ended chan struct{}
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, start time.Time) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attributes: make(map[string]interface{}), start: start}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	if name == "goptlib.conn" {
+		return context.WithValue(ctx, spanKey{}, span), connSpanEnder{span, t.ended}
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Signals when the connection span ends.
+type connSpanEnder struct {
+	*recordedSpan
+	ended chan struct{}
+}
+
+func (s connSpanEnder) End(end time.Time) {
+	s.recordedSpan.End(end)
+	close(s.ended)
+}
+
+func (t *recordingTracer) find(name string) *recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func startConn(t *testing.T, tracer Tracer) (context.Context, context.CancelFunc) {
+	accepted := time.Now().Add(-time.Second)
+	ctx, cancel := context.WithCancel(pt.ContextWithConnInfo(context.Background(), &pt.ConnInfo{
+		MethodName: "foo",
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+		Accepted:   accepted,
+	}))
+	return ConnContext(tracer, nil)(ctx), cancel
+}
+
+func TestServerSpans(t *testing.T) {
+	tracer := &recordingTracer{ended: make(chan struct{})}
+	ctx, cancel := startConn(t, tracer)
+	trace := pt.ContextPTTrace(ctx)
+	trace.ORDialStart(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9001})
+	trace.ORDialStart(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 9001})
+	trace.ORAuthStart()
+	trace.ORAuthDone(nil)
+	trace.RelayStart()
+	trace.ConnClosed(10, 20)
+	cancel()
+	<-tracer.ended
+
+	conn := tracer.find("goptlib.conn")
+	if conn == nil || conn.attributes["pt.method"] != "foo" || conn.end.IsZero() {
+		t.Fatalf("conn span %+v", conn)
+	}
+	if conn.attributes["net.peer.addr"] != "[scrubbed]" {
+		t.Errorf("peer address %v", conn.attributes["net.peer.addr"])
+	}
+	for _, name := range []string{"goptlib.or_dial", "goptlib.extorport_auth", "goptlib.relay"} {
+		s := tracer.find(name)
+		if s == nil {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if s.parent != conn || s.end.IsZero() || s.err != nil {
+			t.Errorf("%s span %+v", name, s)
+		}
+	}
+	if n := len(tracer.spans); n != 4 {
+		t.Errorf("%d spans", n)
+	}
+	relay := tracer.find("goptlib.relay")
+	if relay.attributes["pt.bytes_sent"] != int64(10) || relay.attributes["pt.bytes_received"] != int64(20) {
+		t.Errorf("relay attributes %v", relay.attributes)
+	}
+}
+
+func TestClientSpansDialFailure(t *testing.T) {
+	tracer := &recordingTracer{ended: make(chan struct{})}
+	ctx, cancel := startConn(t, tracer)
+	pt.ContextPTTrace(ctx).GotSocksRequest(&pt.SocksRequest{Target: "192.0.2.2:443"})
+	cancel()
+	<-tracer.ended
+
+	conn := tracer.find("goptlib.conn")
+	socks := tracer.find("goptlib.socks_handshake")
+	if socks == nil || !socks.start.Equal(conn.start) || socks.end.IsZero() {
+		t.Errorf("socks span %+v", socks)
+	}
+	dial := tracer.find("goptlib.transport_dial")
+	if dial == nil || !errors.Is(dial.err, errNotRelayed) {
+		t.Errorf("dial span %+v", dial)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	called := false
+	pt.ConnContext = func(ctx context.Context) context.Context {
+		called = true
+		return ctx
+	}
+	defer func() { pt.ConnContext = nil }()
+	tracer := &recordingTracer{ended: make(chan struct{})}
+	Install(tracer)
+	ctx, cancel := context.WithCancel(context.Background())
+	pt.ConnContext(ctx)
+	cancel()
+	<-tracer.ended
+	if !called {
+		t.Error("previous ConnContext not called")
+	}
+}
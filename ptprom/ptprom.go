@@ -0,0 +1,158 @@
+// Package ptprom exports goptlib's counters to Prometheus.
+//
+// The counters returned by pt.Stats are served in the Prometheus text
+// exposition format, which is what promhttp produces and what Prometheus and
+// compatible scrapers expect. The package has no dependencies outside the
+// standard library.
+//
+// Sample usage, in a server transport's main function:
+//
+//	ln, err := ptprom.Listen("127.0.0.1:9052")
+//	if err != nil {
+//		pt.Log(pt.LogSeverityWarning, "cannot start metrics listener: "+err.Error())
+//	} else {
+//		defer ln.Close()
+//	}
+//
+// and in prometheus.yml:
+//
+//	scrape_configs:
+//	  - job_name: 'goptlib'
+//	    static_configs:
+//	      - targets: ['127.0.0.1:9052']
+//
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package ptprom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// The path at which the listener started by Listen serves metrics.
+const MetricsPath = "/metrics"
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metric struct {
+	name string
+	typ  string
+	help string
+	get  func(*pt.MethodStats) float64
+}
+
+var metrics = []metric{
+	{
+		"goptlib_conns_accepted_total", "counter",
+		"Client connections accepted.",
+		func(s *pt.MethodStats) float64 { return float64(s.ConnsAccepted) },
+	},
+	{
+		"goptlib_conns_active", "gauge",
+		"Accepted client connections not yet closed.",
+		func(s *pt.MethodStats) float64 { return float64(s.ConnsActive) },
+	},
+	{
+		"goptlib_or_conns_dialed_total", "counter",
+		"Successful connections to the ORPort or extended ORPort.",
+		func(s *pt.MethodStats) float64 { return float64(s.OrConnsDialed) },
+	},
+	{
+		"goptlib_or_dial_failures_total", "counter",
+		"Failed TCP connections to the ORPort or extended ORPort.",
+		func(s *pt.MethodStats) float64 { return float64(s.OrDialFailures) },
+	},
+	{
+		"goptlib_or_auth_failures_total", "counter",
+		"Extended ORPort connections that failed authentication or were denied.",
+		func(s *pt.MethodStats) float64 { return float64(s.OrAuthFailures) },
+	},
+	{
+		"goptlib_bytes_sent_total", "counter",
+		"Bytes relayed from client connections to the other side, counted when a connection is finished.",
+		func(s *pt.MethodStats) float64 { return float64(s.BytesSent) },
+	},
+	{
+		"goptlib_bytes_received_total", "counter",
+		"Bytes relayed from the other side back to client connections, counted when a connection is finished.",
+		func(s *pt.MethodStats) float64 { return float64(s.BytesReceived) },
+	},
+}
+
+// Escape a label value: "label_value can be any sequence of UTF-8 characters,
+// but the backslash (\), double-quote ("), and line feed (\n) characters have
+// to be escaped as \\, \", and \n, respectively."
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Write the current values of pt.Stats to w in the Prometheus text exposition
+// format, followed by the goptlib_or_healthy gauge if pt.StartORHealthCheck
+// has made a check.
+func WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	stats := pt.Stats()
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.typ)
+		for i := range stats {
+			fmt.Fprintf(bw, "%s{method=\"%s\"} %g\n",
+				m.name, labelValueReplacer.Replace(stats[i].MethodName), m.get(&stats[i]))
+		}
+	}
+	if h := pt.ORHealth(); !h.Checked.IsZero() {
+		healthy := 0
+		if h.Healthy {
+			healthy = 1
+		}
+		fmt.Fprintf(bw, "# HELP goptlib_or_healthy Whether the most recent ORPort health check succeeded.\n")
+		fmt.Fprintf(bw, "# TYPE goptlib_or_healthy gauge\n")
+		fmt.Fprintf(bw, "goptlib_or_healthy %d\n", healthy)
+	}
+	return bw.Flush()
+}
+
+// Return an http.Handler that responds to every request with the output of
+// WriteMetrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		WriteMetrics(w)
+	})
+}
+
+// Return true iff host is a loopback IP address or "localhost".
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Listen on the TCP address addr and serve Handler at MetricsPath in a
+// separate goroutine. addr must have a loopback host part (for example
+// "127.0.0.1:9052" or "[::1]:0"); metrics reveal information about a bridge's
+// users and must not be exposed to the network. Close the returned listener to
+// stop serving.
+func Listen(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !isLoopback(host) {
+		return nil, fmt.Errorf("metrics address %q is not a loopback address", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, Handler())
+	go http.Serve(ln, mux)
+	return ln, nil
+}
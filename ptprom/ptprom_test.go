@@ -0,0 +1,82 @@
+package ptprom
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteMetrics(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range metrics {
+		if !strings.Contains(buf.String(), "# TYPE "+m.name+" "+m.typ+"\n") {
+			t.Errorf("missing TYPE line for %s in %q", m.name, buf.String())
+		}
+	}
+}
+
+func TestLabelValueReplacer(t *testing.T) {
+	tests := [...]struct {
+		input, expected string
+	}{
+		{"", ""},
+		{"obfs4", "obfs4"},
+		{`a"b`, `a\"b`},
+		{`a\b`, `a\\b`},
+		{"a\nb", `a\nb`},
+	}
+	for _, test := range tests {
+		output := labelValueReplacer.Replace(test.input)
+		if output != test.expected {
+			t.Errorf("%q → %q (expected %q)", test.input, output, test.expected)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	badTests := [...]string{
+		"",
+		"127.0.0.1",
+		"0.0.0.0:0",
+		"[::]:0",
+		"192.0.2.1:0",
+		"example.com:0",
+	}
+	for _, input := range badTests {
+		ln, err := Listen(input)
+		if err == nil {
+			ln.Close()
+			t.Errorf("%q unexpectedly succeeded", input)
+		}
+	}
+
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	resp, err := http.Get("http://" + ln.Addr().String() + MetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != contentType {
+		t.Errorf("got Content-Type %q (expected %q)", resp.Header.Get("Content-Type"), contentType)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "# TYPE goptlib_conns_active gauge\n") {
+		t.Errorf("unexpected body %q", body)
+	}
+}
@@ -0,0 +1,96 @@
+package pttest
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// Benchmark helpers, to be called from a transport's own benchmarks with a
+// Loopback, so that they measure the transport together with goptlib's relay:
+//
+//	func BenchmarkFooThroughput(b *testing.B) {
+//		l := pttest.NewLoopback(b, "foo", pt.DialerFunc(dialFoo), serverFoo)
+//		pttest.BenchmarkThroughput(b, l, 32*1024)
+//	}
+//
+// All of them report allocations, as with b.ReportAllocs.
+
+// Measure the time to make a connection and complete its first round trip of
+// one byte, including the client and server handshakes and the extended ORPort
+// authentication.
+func BenchmarkHandshake(b *testing.B, l *Loopback) {
+	b.ReportAllocs()
+	buf := make([]byte, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, err := l.Dial()
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = c.Write(buf)
+		if err == nil {
+			_, err = io.ReadFull(c, buf)
+		}
+		c.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Measure the latency of round trips of one byte over an established
+// connection.
+func BenchmarkRoundTrip(b *testing.B, l *Loopback) {
+	c, err := l.Dial()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+	b.ReportAllocs()
+	buf := make([]byte, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = c.Write(buf)
+		if err == nil {
+			_, err = io.ReadFull(c, buf)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Measure the steady-state throughput of an established connection, writing
+// b.N chunks of chunkSize bytes while reading them back concurrently. The
+// reported rate counts each byte once.
+func BenchmarkThroughput(b *testing.B, l *Loopback, chunkSize int) {
+	c, err := l.Dial()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+	b.ReportAllocs()
+	b.SetBytes(int64(chunkSize))
+	chunk := make([]byte, chunkSize)
+	writeErr := make(chan error, 1)
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			_, err := c.Write(chunk)
+			if err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+	_, err = io.CopyN(ioutil.Discard, c, int64(b.N)*int64(chunkSize))
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = <-writeErr
+	if err != nil {
+		b.Fatal(err)
+	}
+}
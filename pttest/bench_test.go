@@ -0,0 +1,26 @@
+package pttest
+
+import (
+	"net"
+	"testing"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Benchmarks of goptlib itself, with a transport that does nothing.
+
+func dialIdentity(network, address string, args pt.Args) (net.Conn, error) {
+	return net.Dial(network, address)
+}
+
+func BenchmarkIdentityHandshake(b *testing.B) {
+	BenchmarkHandshake(b, NewLoopback(b, "identity", pt.DialerFunc(dialIdentity), nil))
+}
+
+func BenchmarkIdentityRoundTrip(b *testing.B) {
+	BenchmarkRoundTrip(b, NewLoopback(b, "identity", pt.DialerFunc(dialIdentity), nil))
+}
+
+func BenchmarkIdentityThroughput(b *testing.B) {
+	BenchmarkThroughput(b, NewLoopback(b, "identity", pt.DialerFunc(dialIdentity), nil), 32*1024)
+}
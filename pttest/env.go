@@ -0,0 +1,260 @@
+// Package pttest provides utilities for testing pluggable transports built
+// with goptlib.
+//
+// Env constructs a complete fake managed-transport environment, as tor would
+// set it up, so that a transport's startup path can be tested without tor:
+//
+//	func TestStartup(t *testing.T) {
+//		env := pttest.NewServerEnv("foo")
+//		info, output, err := pttest.ServerSetup(t, env)
+//		if err != nil {
+//			t.Fatalf("ServerSetup: %v (output %q)", err, output)
+//		}
+//		// ... open listeners for info.Bindaddrs ...
+//	}
+//
+// Launch plays the role of tor for a whole transport executable: it runs the
+// executable in such an environment and parses the methods it reports, so that
+// transports can be tested as black boxes.
+//
+// ORPort is a fake ORPort or extended ORPort that echoes or discards what it
+// receives, so that a client and server transport can be run end to end on
+// the local host without tor:
+//
+//	orport := pttest.NewExtORPort(t, pttest.ORPortEcho)
+//	env := pttest.NewServerEnv("foo")
+//	orport.ConfigureEnv(env)
+//	p, err := pttest.Launch(t, env, 10*time.Second, "./foo-server")
+//
+// EnvVectors is a corpus of environments and their expected parse results,
+// published in JSON for other pluggable transport libraries as
+// testdata/env_vectors.json; CheckEnvVectors checks goptlib against a corpus.
+//
+// Functions that modify the process environment or pt.Stdout must not be
+// used from parallel tests.
+package pttest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// The header that begins an extended ORPort auth cookie file.
+const authCookieHeader = "! Extended ORPort Auth Cookie !\x0a"
+
+// BindaddrSpec is one element of TOR_PT_SERVER_BINDADDR.
+type BindaddrSpec struct {
+	MethodName string
+	Addr       string
+}
+
+// Env describes the TOR_PT_* environment of a managed transport. Empty fields
+// leave the corresponding variable unset.
+type Env struct {
+	// TOR_PT_MANAGED_TRANSPORT_VER. If nil, "1" is used.
+	Versions []string
+	// TOR_PT_STATE_LOCATION. If "", Set uses a new temporary directory.
+	StateDir string
+	// TOR_PT_EXIT_ON_STDIN_CLOSE=1.
+	ExitOnStdinClose bool
+
+	// TOR_PT_CLIENT_TRANSPORTS.
+	ClientTransports []string
+	// TOR_PT_PROXY.
+	Proxy string
+
+	// TOR_PT_SERVER_TRANSPORTS.
+	ServerTransports []string
+	// TOR_PT_SERVER_BINDADDR.
+	Bindaddrs []BindaddrSpec
+	// TOR_PT_SERVER_TRANSPORT_OPTIONS, already encoded.
+	ServerTransportOptions string
+	// TOR_PT_ORPORT.
+	ORPort string
+	// TOR_PT_EXTENDED_SERVER_PORT.
+	ExtendedORPort string
+	// The 32-byte auth cookie. If ExtendedORPort is set and AuthCookie is
+	// nil, Set generates a random cookie. If non-nil, Set writes it to a
+	// cookie file in a temporary directory and sets
+	// TOR_PT_AUTH_COOKIE_FILE.
+	AuthCookie []byte
+	// TOR_PT_AUTH_COOKIE_FILE. Filled in by Set when AuthCookie is
+	// written; may be set directly to point at an existing file.
+	AuthCookiePath string
+
+	// Additional variables, which override those above.
+	Extra map[string]string
+}
+
+// Return an Env for a client transport that offers the given method names.
+func NewClientEnv(methodNames ...string) *Env {
+	return &Env{ClientTransports: methodNames}
+}
+
+// Return an Env for a server transport that offers the given method names,
+// each listening on an ephemeral loopback port, and with an extended ORPort
+// at 127.0.0.1:9002 protected by a random auth cookie.
+func NewServerEnv(methodNames ...string) *Env {
+	env := &Env{
+		ServerTransports: methodNames,
+		ExtendedORPort:   "127.0.0.1:9002",
+	}
+	for _, methodName := range methodNames {
+		env.Bindaddrs = append(env.Bindaddrs, BindaddrSpec{methodName, "127.0.0.1:0"})
+	}
+	return env
+}
+
+// Return the environment described by env, as a list of "key=value" strings
+// suitable for exec.Cmd.Env. Unlike Set, Environ does not create any files;
+// call it after Set for the StateDir and AuthCookiePath fields to be filled in.
+func (env *Env) Environ() []string {
+	vars := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" {
+			vars[key] = value
+		}
+	}
+	versions := env.Versions
+	if versions == nil {
+		versions = []string{"1"}
+	}
+	set("TOR_PT_MANAGED_TRANSPORT_VER", strings.Join(versions, ","))
+	set("TOR_PT_STATE_LOCATION", env.StateDir)
+	if env.ExitOnStdinClose {
+		set("TOR_PT_EXIT_ON_STDIN_CLOSE", "1")
+	}
+	set("TOR_PT_CLIENT_TRANSPORTS", strings.Join(env.ClientTransports, ","))
+	set("TOR_PT_PROXY", env.Proxy)
+	set("TOR_PT_SERVER_TRANSPORTS", strings.Join(env.ServerTransports, ","))
+	var bindaddrs []string
+	for _, ba := range env.Bindaddrs {
+		bindaddrs = append(bindaddrs, ba.MethodName+"-"+ba.Addr)
+	}
+	set("TOR_PT_SERVER_BINDADDR", strings.Join(bindaddrs, ","))
+	set("TOR_PT_SERVER_TRANSPORT_OPTIONS", env.ServerTransportOptions)
+	set("TOR_PT_ORPORT", env.ORPort)
+	set("TOR_PT_EXTENDED_SERVER_PORT", env.ExtendedORPort)
+	set("TOR_PT_AUTH_COOKIE_FILE", env.AuthCookiePath)
+	for key, value := range env.Extra {
+		vars[key] = value
+	}
+
+	result := make([]string, 0, len(vars))
+	for key, value := range vars {
+		result = append(result, key+"="+value)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Write an auth cookie file containing cookie to dir and return its path.
+func WriteAuthCookieFile(dir string, cookie []byte) (string, error) {
+	path := filepath.Join(dir, "extended_orport_auth_cookie")
+	err := ioutil.WriteFile(path, append([]byte(authCookieHeader), cookie...), 0600)
+	return path, err
+}
+
+// Create the state directory and auth cookie file (if needed) in temporary
+// directories, filling in env.StateDir, env.AuthCookie, and
+// env.AuthCookiePath.
+func (env *Env) prepare(t testing.TB) {
+	t.Helper()
+	if env.StateDir == "" {
+		env.StateDir = filepath.Join(t.TempDir(), "pt_state")
+	}
+	if env.AuthCookie == nil && env.ExtendedORPort != "" && env.AuthCookiePath == "" {
+		env.AuthCookie = make([]byte, 32)
+		_, err := io.ReadFull(rand.Reader, env.AuthCookie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if env.AuthCookie != nil && env.AuthCookiePath == "" {
+		path, err := WriteAuthCookieFile(t.TempDir(), env.AuthCookie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.AuthCookiePath = path
+	}
+}
+
+// Replace the TOR_PT_* variables of the process environment with those
+// described by env, creating temporary files as needed. The previous
+// environment is restored when the test finishes.
+func (env *Env) Set(t testing.TB) {
+	t.Helper()
+	env.prepare(t)
+	setEnviron(t, env.Environ())
+}
+
+// Replace the TOR_PT_* variables of the process environment with those in
+// environ, a list of "key=value" strings. The previous environment is restored
+// when the test finishes.
+func setEnviron(t testing.TB, environ []string) {
+	saved := os.Environ()
+	t.Cleanup(func() {
+		os.Clearenv()
+		for _, kv := range saved {
+			parts := strings.SplitN(kv, "=", 2)
+			os.Setenv(parts[0], parts[1])
+		}
+	})
+	for _, kv := range saved {
+		parts := strings.SplitN(kv, "=", 2)
+		if strings.HasPrefix(parts[0], "TOR_PT_") {
+			os.Unsetenv(parts[0])
+		}
+	}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		os.Setenv(parts[0], parts[1])
+	}
+}
+
+// Run f with pt.Stdout redirected to a buffer, and return the lines written,
+// without their terminating newlines.
+func captureOutput(f func()) []string {
+	var buf bytes.Buffer
+	saved := pt.Stdout
+	pt.Stdout = &buf
+	defer func() {
+		pt.Stdout = saved
+	}()
+	f()
+	if buf.Len() == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+// Set the environment described by env and call pt.ClientSetup. Returns the
+// result of pt.ClientSetup along with the lines it wrote to pt.Stdout.
+func ClientSetup(t testing.TB, env *Env) (info pt.ClientInfo, output []string, err error) {
+	t.Helper()
+	env.Set(t)
+	output = captureOutput(func() {
+		info, err = pt.ClientSetup(nil)
+	})
+	return
+}
+
+// Set the environment described by env and call pt.ServerSetup. Returns the
+// result of pt.ServerSetup along with the lines it wrote to pt.Stdout.
+func ServerSetup(t testing.TB, env *Env) (info pt.ServerInfo, output []string, err error) {
+	t.Helper()
+	env.Set(t)
+	output = captureOutput(func() {
+		info, err = pt.ServerSetup(nil)
+	})
+	return
+}
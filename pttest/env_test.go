@@ -0,0 +1,91 @@
+package pttest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestClientSetup(t *testing.T) {
+	env := NewClientEnv("alpha", "beta")
+	env.Proxy = "socks5://127.0.0.1:1080"
+	info, output, err := ClientSetup(t, env)
+	if err != nil {
+		t.Fatalf("ClientSetup: %v (output %q)", err, output)
+	}
+	if !stringSlicesEqual(info.MethodNames, []string{"alpha", "beta"}) {
+		t.Errorf("unexpected MethodNames %q", info.MethodNames)
+	}
+	if info.ProxyURL == nil || info.ProxyURL.String() != env.Proxy {
+		t.Errorf("unexpected ProxyURL %v", info.ProxyURL)
+	}
+	if !stringSlicesEqual(output, []string{"VERSION 1"}) {
+		t.Errorf("unexpected output %q", output)
+	}
+	if os.Getenv("TOR_PT_STATE_LOCATION") != env.StateDir || env.StateDir == "" {
+		t.Errorf("TOR_PT_STATE_LOCATION is %q, StateDir is %q", os.Getenv("TOR_PT_STATE_LOCATION"), env.StateDir)
+	}
+}
+
+func TestServerSetup(t *testing.T) {
+	env := NewServerEnv("alpha")
+	env.ServerTransportOptions = "alpha:key=value"
+	info, output, err := ServerSetup(t, env)
+	if err != nil {
+		t.Fatalf("ServerSetup: %v (output %q)", err, output)
+	}
+	if len(info.Bindaddrs) != 1 || info.Bindaddrs[0].MethodName != "alpha" {
+		t.Fatalf("unexpected Bindaddrs %+v", info.Bindaddrs)
+	}
+	if value, _ := info.Bindaddrs[0].Options.Get("key"); value != "value" {
+		t.Errorf("unexpected Options %+v", info.Bindaddrs[0].Options)
+	}
+	if info.ExtendedOrAddr == nil || info.ExtendedOrAddr.String() != env.ExtendedORPort {
+		t.Errorf("unexpected ExtendedOrAddr %v", info.ExtendedOrAddr)
+	}
+	contents, err := ioutil.ReadFile(info.AuthCookiePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, append([]byte(authCookieHeader), env.AuthCookie...)) {
+		t.Errorf("unexpected cookie file contents %q", contents)
+	}
+}
+
+func TestServerSetupError(t *testing.T) {
+	env := NewServerEnv("alpha")
+	env.ExtendedORPort = ""
+	_, output, err := ServerSetup(t, env)
+	if err == nil {
+		t.Fatalf("ServerSetup without an ORPort unexpectedly succeeded")
+	}
+	if len(output) != 2 || output[1] != err.Error() {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
+func TestEnvRestored(t *testing.T) {
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "original")
+	t.Run("set", func(t *testing.T) {
+		NewClientEnv("alpha").Set(t)
+		if os.Getenv("TOR_PT_CLIENT_TRANSPORTS") != "alpha" {
+			t.Errorf("TOR_PT_CLIENT_TRANSPORTS is %q", os.Getenv("TOR_PT_CLIENT_TRANSPORTS"))
+		}
+	})
+	if os.Getenv("TOR_PT_CLIENT_TRANSPORTS") != "original" {
+		t.Errorf("TOR_PT_CLIENT_TRANSPORTS is %q after test", os.Getenv("TOR_PT_CLIENT_TRANSPORTS"))
+	}
+}
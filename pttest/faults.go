@@ -0,0 +1,155 @@
+package pttest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// The error returned by a connection that Faults has reset.
+var ErrInjectedReset = errors.New("connection reset by fault injection")
+
+// Faults describes hostile network conditions to simulate on a connection. The
+// zero value injects no faults.
+type Faults struct {
+	// Each Write to the underlying connection is delayed by Latency plus a
+	// random duration less than Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// If positive, a limit on the bytes per second read and written, counting
+	// both directions together.
+	BytesPerSecond float64
+	// If positive, each Write is split into pieces of random sizes between 1
+	// and MaxWrite bytes, each written to the underlying connection
+	// separately, so that the peer sees the data fragmented.
+	MaxWrite int
+	// If positive, the connection is reset after this many bytes have been
+	// read and written.
+	ResetAfter int64
+	// The probability that any Read or Write resets the connection.
+	ResetProbability float64
+	// The seed of the random choices. If 0, a seed is chosen from the time.
+	Seed int64
+}
+
+// Return c wrapped so that it suffers the faults described by f. A reset
+// closes c (with a TCP RST, if c is a *net.TCPConn) and makes all later Reads
+// and Writes fail with ErrInjectedReset.
+func (f Faults) Wrap(c net.Conn) net.Conn {
+	seed := f.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fc := &faultConn{Conn: c, f: f, rng: rand.New(rand.NewSource(seed))}
+	if f.BytesPerSecond > 0 {
+		return pt.WithRateLimit(fc, pt.NewRateLimiter(f.BytesPerSecond, 0))
+	}
+	return fc
+}
+
+// Return a Dialer that wraps the connections made by d with f.Wrap, for
+// simulating faults between a client transport and its server, as with
+// NewLoopback.
+func FaultyDialer(d pt.Dialer, f Faults) pt.Dialer {
+	return pt.DialerFunc(func(network, address string, args pt.Args) (net.Conn, error) {
+		c, err := d.Dial(network, address, args)
+		if err != nil {
+			return nil, err
+		}
+		return f.Wrap(c), nil
+	})
+}
+
+type faultConn struct {
+	net.Conn
+	f Faults
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	count int64
+	reset bool
+}
+
+// Decide whether the connection should be reset now, and reset it if so.
+// Returns the maximum number of bytes that may be transferred before the next
+// reset, or -1 for no limit, or an error if the connection is reset.
+func (c *faultConn) check() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.reset && (c.f.ResetAfter > 0 && c.count >= c.f.ResetAfter ||
+		c.f.ResetProbability > 0 && c.rng.Float64() < c.f.ResetProbability) {
+		c.reset = true
+		if tc, ok := c.Conn.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+		c.Conn.Close()
+	}
+	if c.reset {
+		return 0, ErrInjectedReset
+	}
+	if c.f.ResetAfter > 0 {
+		return c.f.ResetAfter - c.count, nil
+	}
+	return -1, nil
+}
+
+// Record that n bytes have been transferred.
+func (c *faultConn) add(n int) {
+	c.mu.Lock()
+	c.count += int64(n)
+	c.mu.Unlock()
+}
+
+// Return a random number in [0, max), or 0 if max is not positive.
+func (c *faultConn) randInt63n(max int64) int64 {
+	if max <= 0 {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Int63n(max)
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	limit, err := c.check()
+	if err != nil {
+		return 0, err
+	}
+	if limit >= 0 && int64(len(p)) > limit {
+		p = p[:limit]
+	}
+	n, err := c.Conn.Read(p)
+	c.add(n)
+	return n, err
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	var total int
+	for total < len(p) {
+		limit, err := c.check()
+		if err != nil {
+			return total, err
+		}
+		b := p[total:]
+		if c.f.MaxWrite > 0 && len(b) > c.f.MaxWrite {
+			b = b[:1+c.randInt63n(int64(c.f.MaxWrite))]
+		}
+		if limit >= 0 && int64(len(b)) > limit {
+			b = b[:limit]
+		}
+		if delay := c.f.Latency + time.Duration(c.randInt63n(int64(c.f.Jitter))); delay > 0 {
+			time.Sleep(delay)
+		}
+		n, err := c.Conn.Write(b)
+		c.add(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,80 @@
+package pttest
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Return the two ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	a, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ln.Accept()
+	if err != nil {
+		a.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestFaultsMaxWrite(t *testing.T) {
+	a, b := tcpPair(t)
+	w := Faults{MaxWrite: 3, Latency: time.Millisecond, Seed: 1}.Wrap(a)
+	data := []byte("0123456789")
+	start := time.Now()
+	n, err := w.Write(data)
+	if err != nil || n != len(data) {
+		t.Fatalf("Write returned (%d, %v)", n, err)
+	}
+	// At least four pieces, each delayed.
+	if elapsed := time.Since(start); elapsed < 4*time.Millisecond {
+		t.Errorf("Write took only %v", elapsed)
+	}
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(b, buf)
+	if err != nil || string(buf) != string(data) {
+		t.Errorf("read (%q, %v)", buf, err)
+	}
+}
+
+func TestFaultsResetAfter(t *testing.T) {
+	a, b := tcpPair(t)
+	w := Faults{ResetAfter: 5}.Wrap(a)
+	n, err := w.Write([]byte("0123456789"))
+	if n != 5 || err != ErrInjectedReset {
+		t.Fatalf("Write returned (%d, %v), expected (5, ErrInjectedReset)", n, err)
+	}
+	_, err = w.Read(make([]byte, 1))
+	if err != ErrInjectedReset {
+		t.Errorf("Read after reset returned %v", err)
+	}
+	// The peer gets the first 5 bytes and then an error or EOF.
+	buf, _ := ioutil.ReadAll(b)
+	if string(buf) != "01234" {
+		t.Errorf("peer read %q", buf)
+	}
+}
+
+func TestFaultyLoopback(t *testing.T) {
+	f := Faults{MaxWrite: 100, Jitter: time.Millisecond, BytesPerSecond: 1e7}
+	l := NewLoopback(t, "identity", FaultyDialer(pt.DialerFunc(dialIdentity), f), func(c net.Conn) (net.Conn, error) {
+		return f.Wrap(c), nil
+	})
+	l.Check(t, 10000)
+}
@@ -0,0 +1,183 @@
+package pttest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Loopback runs the client and server halves of a transport in one process,
+// connected through real loopback TCP sockets:
+//
+//	Dial -> client Dialer -> server listener -> server handler -> ORPort
+//
+// The server half relays with pt.HandleServerConn, as pt.RunServer does. The
+// ORPort is a fake extended ORPort that echoes what it receives, so that
+// whatever is written to a connection returned by Dial should be read back
+// from it unchanged.
+//
+//	func TestTransport(t *testing.T) {
+//		l := pttest.NewLoopback(t, "foo", pt.DialerFunc(dialFoo), serverFoo)
+//		l.Check(t, 1<<20)
+//	}
+type Loopback struct {
+	MethodName string
+	// The args passed to the client Dialer, as from a bridge line.
+	Args pt.Args
+	// The address the server half listens on.
+	ServerAddr *net.TCPAddr
+	// The fake extended ORPort that the server half connects to.
+	ORPort *ORPort
+
+	dialer  pt.Dialer
+	handler func(net.Conn) (net.Conn, error)
+	info    pt.ServerInfo
+	ln      *net.TCPListener
+
+	mu     sync.Mutex
+	open   map[net.Conn]struct{}
+	errors []error
+}
+
+// Start the server half of a transport, with handler wrapping each accepted
+// connection as in pt.RunServer (a nil handler passes it through unchanged),
+// and return a Loopback whose Dial connects to it through dialer. Everything is
+// closed when the test finishes.
+func NewLoopback(t testing.TB, methodName string, dialer pt.Dialer, handler func(net.Conn) (net.Conn, error)) *Loopback {
+	t.Helper()
+	l := &Loopback{
+		MethodName: methodName,
+		ORPort:     NewExtORPort(t, ORPortEcho),
+		dialer:     dialer,
+		handler:    handler,
+		open:       make(map[net.Conn]struct{}),
+	}
+	l.ORPort.ConfigureServerInfo(&l.info)
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.ln = ln
+	l.ServerAddr = ln.Addr().(*net.TCPAddr)
+	t.Cleanup(l.Close)
+	go l.acceptLoop()
+	return l
+}
+
+// Stop the server half and close all its connections.
+func (l *Loopback) Close() {
+	l.ln.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c := range l.open {
+		c.Close()
+	}
+}
+
+// Return the errors that have made the server half drop connections.
+func (l *Loopback) Errors() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]error(nil), l.errors...)
+}
+
+func (l *Loopback) logError(err error) {
+	l.mu.Lock()
+	l.errors = append(l.errors, err)
+	l.mu.Unlock()
+}
+
+// Make a connection through the client Dialer to the server half.
+func (l *Loopback) Dial() (net.Conn, error) {
+	return l.dialer.Dial("tcp", l.ServerAddr.String(), l.Args)
+}
+
+// Keep track of c so that Close closes it.
+func (l *Loopback) track(c net.Conn) {
+	l.mu.Lock()
+	l.open[c] = struct{}{}
+	l.mu.Unlock()
+}
+
+func (l *Loopback) untrack(c net.Conn) {
+	l.mu.Lock()
+	delete(l.open, c)
+	l.mu.Unlock()
+	c.Close()
+}
+
+func (l *Loopback) acceptLoop() {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			return
+		}
+		go l.handle(conn)
+	}
+}
+
+func (l *Loopback) handle(conn net.Conn) {
+	l.track(conn)
+	defer l.untrack(conn)
+	err := pt.HandleServerConn(conn, &l.info, l.MethodName, l.handler)
+	if err != nil {
+		l.logError(err)
+	}
+}
+
+// Send n random bytes through a new connection, and read them back after
+// their round trip through the ORPort. Returns the time taken, or an error if
+// what was read back differs from what was sent.
+func (l *Loopback) Transfer(n int) (time.Duration, error) {
+	data := make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, data)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	c, err := l.Dial()
+	if err != nil {
+		return 0, fmt.Errorf("dial: %s", err.Error())
+	}
+	defer c.Close()
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := c.Write(data)
+		writeErr <- err
+	}()
+	received := make([]byte, n)
+	_, err = io.ReadFull(c, received)
+	if err != nil {
+		return 0, fmt.Errorf("read: %s", err.Error())
+	}
+	err = <-writeErr
+	if err != nil {
+		return 0, fmt.Errorf("write: %s", err.Error())
+	}
+	elapsed := time.Since(start)
+	if !bytes.Equal(received, data) {
+		return 0, fmt.Errorf("data corrupted in transfer")
+	}
+	return elapsed, nil
+}
+
+// Call Transfer, failing the test on error. Logs and returns the throughput in
+// bytes per second, counting each byte once.
+func (l *Loopback) Check(t testing.TB, n int) float64 {
+	t.Helper()
+	elapsed, err := l.Transfer(n)
+	if err != nil {
+		t.Fatalf("%s loopback transfer of %d bytes: %v (server errors %v)", l.MethodName, n, err, l.Errors())
+	}
+	rate := float64(n) / elapsed.Seconds()
+	t.Logf("%s loopback: %d bytes in %v (%.0f bytes/s)", l.MethodName, n, elapsed, rate)
+	return rate
+}
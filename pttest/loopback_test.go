@@ -0,0 +1,62 @@
+package pttest
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// A transport that XORs every byte with 0x55.
+type xorConn struct {
+	net.Conn
+}
+
+func (c xorConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0x55
+	}
+	return n, err
+}
+
+func (c xorConn) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	for i := range p {
+		b[i] = p[i] ^ 0x55
+	}
+	return c.Conn.Write(b)
+}
+
+func dialXor(network, address string, args pt.Args) (net.Conn, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return xorConn{c}, nil
+}
+
+func TestLoopback(t *testing.T) {
+	l := NewLoopback(t, "xor", pt.DialerFunc(dialXor), func(c net.Conn) (net.Conn, error) {
+		return xorConn{c}, nil
+	})
+	l.Check(t, 1<<20)
+	conns := l.ORPort.Conns()
+	if len(conns) != 1 || conns[0].Transport != "xor" {
+		t.Errorf("unexpected ORPort conns %+v", conns)
+	}
+}
+
+func TestLoopbackHandlerError(t *testing.T) {
+	l := NewLoopback(t, "xor", pt.DialerFunc(dialXor), func(c net.Conn) (net.Conn, error) {
+		return nil, errors.New("bad handshake")
+	})
+	_, err := l.Transfer(1000)
+	if err == nil {
+		t.Fatal("Transfer succeeded despite the handler failing")
+	}
+	if errs := l.Errors(); len(errs) != 1 {
+		t.Errorf("server errors %v, expected 1", errs)
+	}
+}
@@ -0,0 +1,284 @@
+package pttest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// ORPortMode says what a fake ORPort does with the data it receives.
+type ORPortMode int
+
+const (
+	// Write everything received back to the sender.
+	ORPortEcho ORPortMode = iota
+	// Read and discard everything received.
+	ORPortSink
+)
+
+// Extended ORPort commands (217-ext-orport-auth.txt section 4.2.2).
+const (
+	extOrCmdDone      = 0x0000
+	extOrCmdUserAddr  = 0x0001
+	extOrCmdTransport = 0x0002
+	extOrCmdOkay      = 0x1000
+)
+
+// ORConn is what a fake ORPort has learned about one of its connections.
+type ORConn struct {
+	// The USERADDR and TRANSPORT sent by the transport; empty for a plain
+	// ORPort.
+	UserAddr  string
+	Transport string
+	// The number of bytes received after the handshake.
+	Received int64
+	// The error that ended the extended ORPort handshake, if any.
+	Err error
+}
+
+// ORPort is a loopback listener that plays the role of tor's ORPort, or of its
+// extended ORPort if AuthCookie is not nil, for local testing of a server
+// transport. It accepts any number of connections, performs the extended
+// ORPort authentication and metadata exchange if applicable, and then echoes or
+// sinks the data it receives.
+type ORPort struct {
+	Mode ORPortMode
+	// The 32-byte auth cookie of an extended ORPort, or nil.
+	AuthCookie []byte
+	// The auth cookie file containing AuthCookie, or "".
+	AuthCookiePath string
+
+	ln *net.TCPListener
+
+	mu    sync.Mutex
+	conns []*ORConn
+	open  map[net.Conn]struct{}
+}
+
+// Start a fake plain ORPort. It is closed when the test finishes.
+func NewORPort(t testing.TB, mode ORPortMode) *ORPort {
+	t.Helper()
+	return startORPort(t, &ORPort{Mode: mode})
+}
+
+// Start a fake extended ORPort, with a random auth cookie written to a cookie
+// file in a temporary directory. It is closed when the test finishes.
+func NewExtORPort(t testing.TB, mode ORPortMode) *ORPort {
+	t.Helper()
+	cookie := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := WriteAuthCookieFile(t.TempDir(), cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startORPort(t, &ORPort{Mode: mode, AuthCookie: cookie, AuthCookiePath: path})
+}
+
+func startORPort(t testing.TB, o *ORPort) *ORPort {
+	t.Helper()
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.ln = ln
+	o.open = make(map[net.Conn]struct{})
+	t.Cleanup(o.Close)
+	go o.acceptLoop()
+	return o
+}
+
+// Return the address the ORPort is listening on.
+func (o *ORPort) Addr() *net.TCPAddr {
+	return o.ln.Addr().(*net.TCPAddr)
+}
+
+// Set the ORPort or extended ORPort fields of env to point at o, for use with
+// ServerSetup or Launch.
+func (o *ORPort) ConfigureEnv(env *Env) {
+	if o.AuthCookie == nil {
+		env.ORPort = o.Addr().String()
+		env.ExtendedORPort = ""
+		return
+	}
+	env.ORPort = ""
+	env.ExtendedORPort = o.Addr().String()
+	env.AuthCookie = o.AuthCookie
+	env.AuthCookiePath = o.AuthCookiePath
+}
+
+// Set the ORPort or extended ORPort fields of info to point at o, so that
+// pt.DialOr connects to it.
+func (o *ORPort) ConfigureServerInfo(info *pt.ServerInfo) {
+	if o.AuthCookie == nil {
+		info.OrAddr = o.Addr()
+		info.ExtendedOrAddr = nil
+		info.AuthCookiePath = ""
+		return
+	}
+	info.ExtendedOrAddr = o.Addr()
+	info.AuthCookiePath = o.AuthCookiePath
+}
+
+// Return a copy of what o has learned about each connection it has accepted, in
+// the order they were accepted.
+func (o *ORPort) Conns() []ORConn {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	result := make([]ORConn, len(o.conns))
+	for i, c := range o.conns {
+		result[i] = *c
+	}
+	return result
+}
+
+// Stop listening and close all open connections.
+func (o *ORPort) Close() {
+	o.ln.Close()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for c := range o.open {
+		c.Close()
+	}
+}
+
+func (o *ORPort) acceptLoop() {
+	for {
+		c, err := o.ln.Accept()
+		if err != nil {
+			return
+		}
+		record := &ORConn{}
+		o.mu.Lock()
+		o.conns = append(o.conns, record)
+		o.open[c] = struct{}{}
+		o.mu.Unlock()
+		go o.handle(c, record)
+	}
+}
+
+func (o *ORPort) handle(c net.Conn, record *ORConn) {
+	defer func() {
+		o.mu.Lock()
+		delete(o.open, c)
+		o.mu.Unlock()
+		c.Close()
+	}()
+
+	if o.AuthCookie != nil {
+		err := o.extOrPortHandshake(c, record)
+		if err != nil {
+			o.mu.Lock()
+			record.Err = err
+			o.mu.Unlock()
+			return
+		}
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			o.mu.Lock()
+			record.Received += int64(n)
+			o.mu.Unlock()
+			if o.Mode == ORPortEcho {
+				_, werr := c.Write(buf[:n])
+				if werr != nil {
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// See 217-ext-orport-auth.txt section 4.2.1.3.
+func extOrPortHash(authCookie []byte, label string, clientNonce, serverNonce []byte) []byte {
+	h := hmac.New(sha256.New, authCookie)
+	io.WriteString(h, label)
+	h.Write(clientNonce)
+	h.Write(serverNonce)
+	return h.Sum([]byte{})
+}
+
+// Do the server side of extended ORPort authentication and receive commands
+// until DONE, recording USERADDR and TRANSPORT in record.
+func (o *ORPort) extOrPortHandshake(c net.Conn, record *ORConn) error {
+	// Offer only SAFE_COOKIE.
+	_, err := c.Write([]byte{1, 0})
+	if err != nil {
+		return err
+	}
+	var buf [64]byte
+	_, err = io.ReadFull(c, buf[:33])
+	if err != nil {
+		return err
+	}
+	if buf[0] != 1 {
+		return fmt.Errorf("client chose auth type %d", buf[0])
+	}
+	clientNonce := append([]byte(nil), buf[1:33]...)
+	serverNonce := make([]byte, 32)
+	_, err = io.ReadFull(rand.Reader, serverNonce)
+	if err != nil {
+		return err
+	}
+	serverHash := extOrPortHash(o.AuthCookie, "ExtORPort authentication server-to-client hash", clientNonce, serverNonce)
+	_, err = c.Write(append(serverHash, serverNonce...))
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(c, buf[:32])
+	if err != nil {
+		return err
+	}
+	clientHash := extOrPortHash(o.AuthCookie, "ExtORPort authentication client-to-server hash", clientNonce, serverNonce)
+	if !hmac.Equal(buf[:32], clientHash) {
+		c.Write([]byte{0})
+		return fmt.Errorf("mismatch in client hash")
+	}
+	_, err = c.Write([]byte{1})
+	if err != nil {
+		return err
+	}
+
+	for {
+		var header [4]byte
+		_, err = io.ReadFull(c, header[:])
+		if err != nil {
+			return err
+		}
+		cmd := binary.BigEndian.Uint16(header[0:2])
+		body := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+		_, err = io.ReadFull(c, body)
+		if err != nil {
+			return err
+		}
+		o.mu.Lock()
+		switch cmd {
+		case extOrCmdUserAddr:
+			record.UserAddr = string(body)
+		case extOrCmdTransport:
+			record.Transport = string(body)
+		}
+		o.mu.Unlock()
+		if cmd == extOrCmdDone {
+			break
+		}
+	}
+	_, err = c.Write([]byte{extOrCmdOkay >> 8, extOrCmdOkay & 0xff, 0, 0})
+	return err
+}
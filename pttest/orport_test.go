@@ -0,0 +1,96 @@
+package pttest
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// Wait until f returns true, or fail after a few seconds.
+func waitFor(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExtORPortEcho(t *testing.T) {
+	orport := NewExtORPort(t, ORPortEcho)
+	var info pt.ServerInfo
+	orport.ConfigureServerInfo(&info)
+	c, err := pt.DialOr(&info, "1.2.3.4:5678", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data := []byte("hello")
+	_, err = c.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("echoed %q, expected %q", buf, data)
+	}
+
+	conns := orport.Conns()
+	if len(conns) != 1 {
+		t.Fatalf("%d conns, expected 1", len(conns))
+	}
+	if conns[0].UserAddr != "1.2.3.4:5678" || conns[0].Transport != "foo" || conns[0].Err != nil {
+		t.Errorf("unexpected conn %+v", conns[0])
+	}
+}
+
+func TestORPortSink(t *testing.T) {
+	orport := NewORPort(t, ORPortSink)
+	var info pt.ServerInfo
+	orport.ConfigureServerInfo(&info)
+	c, err := pt.DialOr(&info, "1.2.3.4:5678", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = c.Write(make([]byte, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		conns := orport.Conns()
+		return len(conns) == 1 && conns[0].Received == 1000
+	})
+	if conns := orport.Conns(); conns[0].UserAddr != "" {
+		t.Errorf("plain ORPort got USERADDR %q", conns[0].UserAddr)
+	}
+}
+
+func TestExtORPortBadCookie(t *testing.T) {
+	orport := NewExtORPort(t, ORPortEcho)
+	var info pt.ServerInfo
+	orport.ConfigureServerInfo(&info)
+	path, err := WriteAuthCookieFile(t.TempDir(), make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.AuthCookiePath = path
+	c, err := pt.DialOr(&info, "1.2.3.4:5678", "foo")
+	if err == nil {
+		c.Close()
+		t.Fatal("DialOr succeeded with the wrong cookie")
+	}
+	waitFor(t, func() bool {
+		conns := orport.Conns()
+		return len(conns) == 1 && conns[0].Err != nil
+	})
+}
@@ -0,0 +1,220 @@
+package pttest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A method announced with a CMETHOD or SMETHOD line.
+type Method struct {
+	MethodName string
+	// For CMETHOD, "socks4" or "socks5". Empty for SMETHOD.
+	Protocol string
+	Addr     string
+	// Any fields after the address, such as "ARGS:..." in SMETHOD.
+	Options []string
+}
+
+// A method that could not be launched, from a CMETHOD-ERROR or SMETHOD-ERROR
+// line.
+type MethodError struct {
+	MethodName string
+	Message    string
+}
+
+// Process is a pluggable transport process started by Launch, playing the
+// role of tor.
+type Process struct {
+	Cmd *exec.Cmd
+	// The argument of the VERSION line.
+	Version string
+	// Parsed CMETHOD and CMETHOD-ERROR lines.
+	Cmethods      []Method
+	CmethodErrors []MethodError
+	// Parsed SMETHOD and SMETHOD-ERROR lines.
+	Smethods      []Method
+	SmethodErrors []MethodError
+
+	stdin io.WriteCloser
+	lines chan string
+
+	mu     sync.Mutex
+	output []string
+
+	// Closed after the process has exited and all its output has been
+	// read. waitErr is the result of Cmd.Wait.
+	exited  chan struct{}
+	waitErr error
+}
+
+// Start the pluggable transport executable name with the given arguments, in
+// the environment described by env (which is prepared as by Env.Set, but the
+// test's own environment is not modified). Launch reads the process's stdout
+// until it reports that setup is complete (CMETHODS DONE or SMETHODS DONE),
+// reports a fatal error (ENV-ERROR, VERSION-ERROR, or PROXY-ERROR), exits, or
+// timeout elapses. Only in the first case is the returned error nil; in all
+// cases, the process is killed when the test finishes, if it has not exited
+// by then.
+func Launch(t testing.TB, env *Env, timeout time.Duration, name string, arg ...string) (*Process, error) {
+	t.Helper()
+	env.prepare(t)
+
+	p := &Process{
+		Cmd:    exec.Command(name, arg...),
+		lines:  make(chan string),
+		exited: make(chan struct{}),
+	}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "TOR_PT_") {
+			p.Cmd.Env = append(p.Cmd.Env, kv)
+		}
+	}
+	p.Cmd.Env = append(p.Cmd.Env, env.Environ()...)
+	p.Cmd.Stderr = os.Stderr
+	var err error
+	p.stdin, err = p.Cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.Cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = p.Cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(p.Close)
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			p.mu.Lock()
+			p.output = append(p.output, scanner.Text())
+			p.mu.Unlock()
+			p.lines <- scanner.Text()
+		}
+		close(p.lines)
+		// Cmd.Wait must not be called until reading from stdout is
+		// finished.
+		p.waitErr = p.Cmd.Wait()
+		close(p.exited)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return p, fmt.Errorf("process exited before finishing setup")
+			}
+			done, err := p.parseLine(line)
+			if err != nil || done {
+				go p.drain()
+				return p, err
+			}
+		case <-timer.C:
+			go p.drain()
+			return p, fmt.Errorf("process did not finish setup within %v", timeout)
+		}
+	}
+}
+
+// Interpret a line of output. Returns done == true when the end of setup has
+// been reached, or an error if the line is a fatal error.
+func (p *Process) parseLine(line string) (done bool, err error) {
+	fields := strings.Split(line, " ")
+	keyword, args := fields[0], fields[1:]
+	message := func(i int) string {
+		if len(args) <= i {
+			return ""
+		}
+		return strings.Join(args[i:], " ")
+	}
+	switch keyword {
+	case "VERSION":
+		p.Version = message(0)
+	case "ENV-ERROR", "VERSION-ERROR", "PROXY-ERROR":
+		return true, fmt.Errorf("%s", line)
+	case "CMETHOD":
+		if len(args) < 3 {
+			return true, fmt.Errorf("malformed line %q", line)
+		}
+		p.Cmethods = append(p.Cmethods, Method{args[0], args[1], args[2], args[3:]})
+	case "SMETHOD":
+		if len(args) < 2 {
+			return true, fmt.Errorf("malformed line %q", line)
+		}
+		p.Smethods = append(p.Smethods, Method{args[0], "", args[1], args[2:]})
+	case "CMETHOD-ERROR":
+		if len(args) < 1 {
+			return true, fmt.Errorf("malformed line %q", line)
+		}
+		p.CmethodErrors = append(p.CmethodErrors, MethodError{args[0], message(1)})
+	case "SMETHOD-ERROR":
+		if len(args) < 1 {
+			return true, fmt.Errorf("malformed line %q", line)
+		}
+		p.SmethodErrors = append(p.SmethodErrors, MethodError{args[0], message(1)})
+	case "CMETHODS", "SMETHODS":
+		if message(0) == "DONE" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Discard lines after setup, so that the process does not block writing to
+// stdout. They remain available from Output.
+func (p *Process) drain() {
+	for range p.lines {
+	}
+}
+
+// Return all lines that the process has written to stdout so far.
+func (p *Process) Output() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.output...)
+}
+
+// Close the process's stdin, which transports running with
+// TOR_PT_EXIT_ON_STDIN_CLOSE=1 take as a request to exit. Returns immediately;
+// use Wait to wait for the process to exit.
+func (p *Process) CloseStdin() error {
+	return p.stdin.Close()
+}
+
+// Wait for the process to exit, killing it after timeout. Returns the result
+// of exec.Cmd.Wait.
+func (p *Process) Wait(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-p.exited:
+	case <-timer.C:
+		p.Cmd.Process.Kill()
+		<-p.exited
+	}
+	return p.waitErr
+}
+
+// Kill the process, if it is still running, and wait for it to exit. Close is
+// called automatically when the test that called Launch finishes.
+func (p *Process) Close() {
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+	default:
+		p.Cmd.Process.Kill()
+		<-p.exited
+	}
+}
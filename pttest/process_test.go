@@ -0,0 +1,87 @@
+package pttest
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"git.torproject.org/pluggable-transports/goptlib.git"
+)
+
+// TestHelperProcess is not a real test. It is run as a subprocess by the other
+// tests in this file, acting as a pluggable transport.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("PTTEST_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+	info, err := pt.ClientSetup(nil)
+	if err != nil {
+		os.Exit(1)
+	}
+	for _, methodName := range info.MethodNames {
+		switch methodName {
+		case "good":
+			pt.Cmethod(methodName, "socks5", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1080})
+		default:
+			pt.CmethodError(methodName, "no such method")
+		}
+	}
+	pt.CmethodsDone()
+	pt.Log(pt.LogSeverityNotice, "started")
+	<-pt.WatchParent()
+}
+
+func launchHelper(t *testing.T, env *Env) (*Process, error) {
+	if env.Extra == nil {
+		env.Extra = make(map[string]string)
+	}
+	env.Extra["PTTEST_HELPER_PROCESS"] = "1"
+	return Launch(t, env, 10*time.Second, os.Args[0], "-test.run=^TestHelperProcess$")
+}
+
+func TestLaunch(t *testing.T) {
+	p, err := launchHelper(t, NewClientEnv("good", "bad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != "1" {
+		t.Errorf("unexpected Version %q", p.Version)
+	}
+	if len(p.Cmethods) != 1 || p.Cmethods[0].MethodName != "good" ||
+		p.Cmethods[0].Protocol != "socks5" || p.Cmethods[0].Addr != "127.0.0.1:1080" {
+		t.Errorf("unexpected Cmethods %+v", p.Cmethods)
+	}
+	if len(p.CmethodErrors) != 1 || p.CmethodErrors[0] != (MethodError{"bad", "no such method"}) {
+		t.Errorf("unexpected CmethodErrors %+v", p.CmethodErrors)
+	}
+
+	err = p.CloseStdin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Wait(10 * time.Second)
+	if err != nil {
+		t.Errorf("process exited with %v", err)
+	}
+	output := p.Output()
+	if len(output) == 0 || output[len(output)-1] != `LOG SEVERITY=notice MESSAGE="started"` {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
+func TestLaunchError(t *testing.T) {
+	env := NewClientEnv("good")
+	env.Versions = []string{"2"}
+	p, err := launchHelper(t, env)
+	if err == nil {
+		t.Fatal("Launch unexpectedly succeeded")
+	}
+	if err.Error() != "VERSION-ERROR no-version" {
+		t.Errorf("unexpected error %q", err)
+	}
+	if p == nil {
+		t.Fatal("Launch returned a nil Process")
+	}
+}
@@ -0,0 +1,59 @@
+package pttest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const envVectorsFile = "testdata/env_vectors.json"
+
+func TestEnvVectors(t *testing.T) {
+	CheckEnvVectors(t, EnvVectors)
+}
+
+// The published file must match EnvVectors. To regenerate it, run the test
+// with PTTEST_UPDATE_VECTORS=1.
+func TestEnvVectorsFile(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteEnvVectors(&buf, EnvVectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("PTTEST_UPDATE_VECTORS") == "1" {
+		err = ioutil.WriteFile(envVectorsFile, buf.Bytes(), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(envVectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("%s is out of date", envVectorsFile)
+	}
+	vectors, err := ReadEnvVectors(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vectors, EnvVectors) {
+		t.Errorf("%s does not decode to EnvVectors", envVectorsFile)
+	}
+}
+
+func TestReadEnvVectorsInvalid(t *testing.T) {
+	for _, input := range []string{
+		`[{"name": "x", "side": "middle", "error": true}]`,
+		`[{"name": "x", "side": "client"}]`,
+		`[{"name": "x", "side": "client", "error": true, "expect": {}}]`,
+		`{`,
+	} {
+		_, err := ReadEnvVectors(bytes.NewReader([]byte(input)))
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", input)
+		}
+	}
+}
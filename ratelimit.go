@@ -0,0 +1,155 @@
+package pt
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that limits a rate of bytes. It may be shared
+// by any number of connections wrapped with WithRateLimit, which then share the
+// rate between them.
+type RateLimiter struct {
+	rate  float64
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Return a RateLimiter that allows bytesPerSecond bytes per second on average,
+// and up to burst bytes at once. If burst is not positive, it is one second's
+// worth of bytes.
+func NewRateLimiter(bytesPerSecond float64, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = int(bytesPerSecond)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &RateLimiter{rate: bytesPerSecond, burst: burst, tokens: float64(burst)}
+}
+
+// Take n tokens from the bucket, and return how long to wait before they are
+// available. The bucket may go into debt, so that later callers wait their turn.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait until n bytes may pass every limiter in limiters.
+func waitRateLimiters(limiters []*RateLimiter, n int) {
+	var delay time.Duration
+	for _, l := range limiters {
+		if d := l.reserve(n); d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// RateLimit is a limit on the bandwidth of a connection. The zero value is no
+// limit.
+type RateLimit struct {
+	// If positive, the average number of bytes per second, counting both
+	// directions together.
+	BytesPerSecond float64
+	// The number of bytes that may pass at once, as in NewRateLimiter.
+	Burst int
+}
+
+// Bandwidth limits applied by RunClient, RunServer, ServeTransports, and the
+// standalone modes to the connections they accept. Each accepted connection
+// gets its own token bucket with the limits of PerConnRateLimit, so that no
+// single client can use more than that. If GlobalRateLimiter is not nil, all
+// accepted connections also share it, limiting the transport's total
+// bandwidth.
+var (
+	PerConnRateLimit  RateLimit
+	GlobalRateLimiter *RateLimiter
+)
+
+// Return conn wrapped with the per-connection and global limits, or conn itself
+// if there are none.
+func rateLimitAccepted(conn net.Conn) net.Conn {
+	var limiters []*RateLimiter
+	if PerConnRateLimit.BytesPerSecond > 0 {
+		limiters = append(limiters, NewRateLimiter(PerConnRateLimit.BytesPerSecond, PerConnRateLimit.Burst))
+	}
+	if GlobalRateLimiter != nil {
+		limiters = append(limiters, GlobalRateLimiter)
+	}
+	if len(limiters) == 0 {
+		return conn
+	}
+	return WithRateLimit(conn, limiters...)
+}
+
+// Return c wrapped so that every byte read from or written to it is counted
+// against each of limiters, and Read and Write wait as necessary to stay within
+// their rates. Reads return at most as many bytes as the smallest burst of
+// limiters, and larger Writes are split into pieces of that size.
+func WithRateLimit(c net.Conn, limiters ...*RateLimiter) net.Conn {
+	chunk := 0
+	for _, l := range limiters {
+		if chunk == 0 || l.burst < chunk {
+			chunk = l.burst
+		}
+	}
+	return &rateLimitedConn{Conn: c, limiters: limiters, chunk: chunk}
+}
+
+type rateLimitedConn struct {
+	net.Conn
+	limiters []*RateLimiter
+	chunk    int
+}
+
+// Half-close the underlying conn, if it supports it.
+func (c *rateLimitedConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+func (c *rateLimitedConn) Read(p []byte) (int, error) {
+	if c.chunk > 0 && len(p) > c.chunk {
+		p = p[:c.chunk]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		waitRateLimiters(c.limiters, n)
+	}
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	var total int
+	for total < len(p) {
+		b := p[total:]
+		if c.chunk > 0 && len(b) > c.chunk {
+			b = b[:c.chunk]
+		}
+		waitRateLimiters(c.limiters, len(b))
+		n, err := c.Conn.Write(b)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,69 @@
+package pt
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(1000, 100)
+	if d := l.reserve(100); d != 0 {
+		t.Errorf("full bucket: wait %s", d)
+	}
+	// 50 bytes in debt at 1000 bytes per second.
+	d := l.reserve(50)
+	if d < 40*time.Millisecond || d > 50*time.Millisecond {
+		t.Errorf("empty bucket: wait %s", d)
+	}
+	if l := NewRateLimiter(0.5, 0); l.burst != 1 {
+		t.Errorf("default burst %d", l.burst)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	global := NewRateLimiter(1, 1000000)
+	c := WithRateLimit(a, NewRateLimiter(100000, 10000), global)
+
+	go io.Copy(ioutil.Discard, b)
+	begin := time.Now()
+	n, err := c.Write(make([]byte, 60000))
+	if err != nil || n != 60000 {
+		t.Fatalf("Write: %d %v", n, err)
+	}
+	// The first 10000 bytes are the burst; the rest take half a second.
+	if elapsed := time.Since(begin); elapsed < 400*time.Millisecond {
+		t.Errorf("60000 bytes at 100000 bytes per second took only %s", elapsed)
+	}
+	// The global limiter counted the bytes too.
+	if global.tokens > 1000000-60000+1 {
+		t.Errorf("global limiter has %f tokens", global.tokens)
+	}
+
+	go b.Write(make([]byte, 20000))
+	n, err = c.Read(make([]byte, 20000))
+	if err != nil || n > 10000 {
+		t.Errorf("Read: %d %v", n, err)
+	}
+}
+
+func TestRateLimitAccepted(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if c := rateLimitAccepted(a); c != a {
+		t.Errorf("no limits wrapped the conn")
+	}
+	saved := PerConnRateLimit
+	PerConnRateLimit = RateLimit{BytesPerSecond: 1000}
+	defer func() { PerConnRateLimit = saved }()
+	c := rateLimitAccepted(a).(*rateLimitedConn)
+	if len(c.limiters) != 1 || c.chunk != 1000 {
+		t.Errorf("limiters %+v chunk %d", c.limiters, c.chunk)
+	}
+}
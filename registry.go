@@ -0,0 +1,96 @@
+package pt
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// The transports registered with RegisterTransport, by name.
+var registry struct {
+	sync.Mutex
+	transports map[string]Transport
+}
+
+// Make t available, under its Name, to ServeRegisteredTransports,
+// ClientFactoryFor, and ServerFactoryFor. A transport package may register
+// itself from an init function, so that a program can include transports just
+// by importing them:
+//
+//	import _ "example.com/obfs4/transport"
+//
+//	func main() {
+//		err := pt.ServeRegisteredTransports()
+//		...
+//	}
+//
+// Registering a transport with the same name as an existing one replaces it.
+// Returns an error if the name cannot be used as a method name.
+func RegisterTransport(t Transport) error {
+	name := t.Name()
+	if name == "" || !keywordIsSafe(name) {
+		return fmt.Errorf("invalid transport name %q", name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.transports == nil {
+		registry.transports = make(map[string]Transport)
+	}
+	registry.transports[name] = t
+	return nil
+}
+
+// Return the registered transport called name, if any.
+func LookupTransport(name string) (Transport, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	t, ok := registry.transports[name]
+	return t, ok
+}
+
+// Return all registered transports, sorted by name.
+func RegisteredTransports() []Transport {
+	registry.Lock()
+	defer registry.Unlock()
+	result := make([]Transport, 0, len(registry.transports))
+	for _, t := range registry.transports {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result
+}
+
+// Run the registered transports under the managed-proxy protocol, as
+// ServeTransports does.
+func ServeRegisteredTransports() error {
+	return ServeTransports(RegisteredTransports())
+}
+
+// Return a ClientFactory for the registered transport called name, for a
+// program that makes connections through the transport in its own process,
+// without the managed-proxy protocol. A nil config means an empty one.
+func ClientFactoryFor(name string, config *ClientConfig) (ClientFactory, error) {
+	t, ok := LookupTransport(name)
+	if !ok {
+		return nil, fmt.Errorf("no transport named %q is registered", name)
+	}
+	if config == nil {
+		config = &ClientConfig{}
+	}
+	return t.ClientFactory(config)
+}
+
+// Like ClientFactoryFor, but return a ServerFactory. A nil config means one
+// with only the Bindaddr's MethodName set.
+func ServerFactoryFor(name string, config *ServerConfig) (ServerFactory, error) {
+	t, ok := LookupTransport(name)
+	if !ok {
+		return nil, fmt.Errorf("no transport named %q is registered", name)
+	}
+	if config == nil {
+		config = &ServerConfig{Bindaddr: Bindaddr{MethodName: name}}
+	}
+	return t.ServerFactory(config)
+}
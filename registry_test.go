@@ -0,0 +1,64 @@
+package pt
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRegisterTransport(t *testing.T) {
+	defer func(saved map[string]Transport) {
+		registry.Lock()
+		registry.transports = saved
+		registry.Unlock()
+	}(registry.transports)
+	registry.transports = nil
+
+	for _, name := range []string{"b", "a"} {
+		err := RegisterTransport(identityTransport{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"", "a b", "a,b"} {
+		err := RegisterTransport(identityTransport{name})
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", name)
+		}
+	}
+
+	transports := RegisteredTransports()
+	if len(transports) != 2 || transports[0].Name() != "a" || transports[1].Name() != "b" {
+		t.Errorf("unexpected transports %v", transports)
+	}
+	if _, ok := LookupTransport("a"); !ok {
+		t.Error("transport not found")
+	}
+	if _, ok := LookupTransport("c"); ok {
+		t.Error("unregistered transport found")
+	}
+
+	f, err := ClientFactoryFor("a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.(identityTransport).name != "a" {
+		t.Errorf("got factory %v", f)
+	}
+	// The config is passed to the transport.
+	_, err = ClientFactoryFor("a", &ClientConfig{ProxyURL: &url.URL{Scheme: "socks5", Host: "127.0.0.1:1080"}})
+	if err == nil {
+		t.Error("proxy unexpectedly accepted")
+	}
+	_, err = ClientFactoryFor("c", nil)
+	if err == nil {
+		t.Error("unregistered client transport unexpectedly succeeded")
+	}
+	_, err = ServerFactoryFor("b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ServerFactoryFor("c", nil)
+	if err == nil {
+		t.Error("unregistered server transport unexpectedly succeeded")
+	}
+}
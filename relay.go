@@ -0,0 +1,129 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+)
+
+// The number of goroutines running in copyLoop.
+var copyLoopGoroutines int64
+
+// The sizes in bytes of the buffers that RunClient, RunServer, and the other
+// relaying loops use to copy data between an accepted connection and its
+// remote connection. RelayBufferSize applies to both directions;
+// RelayUpstreamBufferSize (for data from the accepted connection to the remote
+// one) and RelayDownstreamBufferSize (for the reverse) override it for one
+// direction if positive. If no size is set for a direction, the io.Copy default
+// of 32 KiB is used. Larger buffers may help throughput on a fast bridge;
+// smaller ones reduce memory use on a small device, with two buffers for each
+// connection. Between two *net.TCPConns, the kernel may copy data without any
+// buffer at all. (The socket buffers are set separately, with TCPOptions.)
+//
+// Set these before starting to relay; they are read once per connection.
+// Buffers of a set size are taken when a direction starts copying and are
+// reused by later connections once it ends.
+var (
+	RelayBufferSize           int
+	RelayUpstreamBufferSize   int
+	RelayDownstreamBufferSize int
+)
+
+// Pools of relay buffers, keyed by size.
+var relayBufferPools sync.Map
+
+// Return a buffer of the size for a direction of relaying, or nil for the
+// io.Copy default. Give it back with releaseRelayBuffer.
+func relayBuffer(directionSize int) []byte {
+	size := RelayBufferSize
+	if directionSize > 0 {
+		size = directionSize
+	}
+	if size <= 0 {
+		return nil
+	}
+	p, ok := relayBufferPools.Load(size)
+	if !ok {
+		p, _ = relayBufferPools.LoadOrStore(size, &sync.Pool{New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}})
+	}
+	return *p.(*sync.Pool).Get().(*[]byte)
+}
+
+// Return buf, from relayBuffer, to its pool.
+func releaseRelayBuffer(buf []byte) {
+	if p, ok := relayBufferPools.Load(len(buf)); ok {
+		p.(*sync.Pool).Put(&buf)
+	}
+}
+
+// Returned by closeWrite for a connection that cannot be half-closed.
+var errCannotCloseWrite = errors.New("connection does not support CloseWrite")
+
+// Shut down the writing side of c, if c has a CloseWrite method, as
+// *net.TCPConn does.
+func closeWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errCannotCloseWrite
+}
+
+// Copy data in both directions between a and b until both directions reach
+// EOF or an error, then close both. Returns the number of bytes copied from a to
+// b and from b to a. The copying goroutines have the pprof labels of ctx, and a
+// "direction" label of "upstream" for a to b and "downstream" for b to a.
+//
+// When one direction reaches EOF, the connection being written to is
+// half-closed with CloseWrite, if it supports it, so that its peer sees the EOF
+// while the other direction goes on. If it does not support CloseWrite, or the
+// direction ends with an error, the connection is closed entirely, which ends
+// the other direction too.
+func copyLoop(ctx context.Context, a, b net.Conn) (aToB, bToA int64) {
+	upSize, downSize := RelayUpstreamBufferSize, RelayDownstreamBufferSize
+	var wg sync.WaitGroup
+	wg.Add(2)
+	atomic.AddInt64(&copyLoopGoroutines, 2)
+
+	// A panic in either direction, such as in the Read or Write of a
+	// transport's connection, closes both connections.
+	closeBoth := func() {
+		a.Close()
+		b.Close()
+	}
+	go pprof.Do(ctx, pprof.Labels("direction", "upstream"), func(context.Context) {
+		defer wg.Done()
+		defer atomic.AddInt64(&copyLoopGoroutines, -1)
+		defer recoverPanic("upstream relay", closeBoth)
+		buf := relayBuffer(upSize)
+		var err error
+		aToB, err = io.CopyBuffer(b, a, buf)
+		releaseRelayBuffer(buf)
+		if err != nil || closeWrite(b) != nil {
+			b.Close()
+		}
+	})
+	go pprof.Do(ctx, pprof.Labels("direction", "downstream"), func(context.Context) {
+		defer wg.Done()
+		defer atomic.AddInt64(&copyLoopGoroutines, -1)
+		defer recoverPanic("downstream relay", closeBoth)
+		buf := relayBuffer(downSize)
+		var err error
+		bToA, err = io.CopyBuffer(a, b, buf)
+		releaseRelayBuffer(buf)
+		if err != nil || closeWrite(a) != nil {
+			a.Close()
+		}
+	})
+
+	wg.Wait()
+	a.Close()
+	b.Close()
+	return aToB, bToA
+}
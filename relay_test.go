@@ -0,0 +1,159 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyLoopLabels(t *testing.T) {
+	a, a2 := net.Pipe()
+	b, b2 := net.Pipe()
+	defer a2.Close()
+	defer b2.Close()
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("method", "labeltest"))
+	done := make(chan struct{})
+	go func() {
+		copyLoop(ctx, a, b)
+		close(done)
+	}()
+
+	// Wait for the copying goroutines to appear in the goroutine profile,
+	// with their labels.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var buf bytes.Buffer
+		pprof.Lookup("goroutine").WriteTo(&buf, 1)
+		profile := buf.String()
+		if strings.Contains(profile, `labels: {"direction":"upstream", "method":"labeltest"}`) &&
+			strings.Contains(profile, `labels: {"direction":"downstream", "method":"labeltest"}`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("labels not found in goroutine profile:\n%s", profile)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a2.Close()
+	<-done
+}
+
+// Return the two ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c1, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ln.AcceptTCP()
+	if err != nil {
+		c1.Close()
+		t.Fatal(err)
+	}
+	return c1, c2
+}
+
+func TestCopyLoopHalfClose(t *testing.T) {
+	client, a := tcpPair(t)
+	defer client.Close()
+	b, peer := tcpPair(t)
+	defer peer.Close()
+	done := make(chan struct{})
+	go func() {
+		// Wrapped as relayTraced does.
+		copyLoop(context.Background(), ConnTimeouts{Idle: time.Minute}.Wrap(a), WithRateLimit(b, NewRateLimiter(1e9, 0)))
+		close(done)
+	}()
+
+	// The client sends its request and half-closes.
+	_, err := client.Write([]byte("request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.CloseWrite()
+	// The peer reads to EOF, and can still reply.
+	peer.SetDeadline(time.Now().Add(5 * time.Second))
+	request, err := ioutil.ReadAll(peer)
+	if err != nil || string(request) != "request" {
+		t.Fatalf("peer read %q %v", request, err)
+	}
+	_, err = peer.Write([]byte("reply"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reply, err := ioutil.ReadAll(client)
+	if err != nil || string(reply) != "reply" {
+		t.Fatalf("client read %q %v", reply, err)
+	}
+	<-done
+}
+
+func TestRelayBuffer(t *testing.T) {
+	defer func() { RelayBufferSize = 0 }()
+	RelayBufferSize = 0
+	if buf := relayBuffer(0); buf != nil {
+		t.Errorf("got buffer of %d bytes with no size set", len(buf))
+	}
+	if buf := relayBuffer(100); len(buf) != 100 {
+		t.Errorf("got buffer of %d bytes, expected 100", len(buf))
+	}
+	RelayBufferSize = 1000
+	if buf := relayBuffer(0); len(buf) != 1000 {
+		t.Errorf("got buffer of %d bytes, expected 1000", len(buf))
+	}
+	if buf := relayBuffer(100); len(buf) != 100 {
+		t.Errorf("got buffer of %d bytes, expected 100", len(buf))
+	}
+}
+
+// A net.Conn whose Read panics.
+type panicReadConn struct {
+	net.Conn
+}
+
+func (c panicReadConn) Read(p []byte) (int, error) {
+	panic("read oops")
+}
+
+func TestCopyLoopPanic(t *testing.T) {
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	a, a2 := tcpPair(t)
+	defer a2.Close()
+	b, b2 := tcpPair(t)
+	defer b2.Close()
+	done := make(chan struct{})
+	go func() {
+		copyLoop(context.Background(), panicReadConn{a}, b)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("copyLoop did not return after a panic")
+	}
+	// Both connections were closed.
+	b2.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(b2); err != nil {
+		t.Errorf("expected EOF at b, got %v", err)
+	}
+	output := buf.String()
+	if !strings.Contains(output, `LOG SEVERITY=error MESSAGE="panic in upstream relay: read oops"`) ||
+		!strings.Contains(output, `LOG SEVERITY=error MESSAGE="stack of panic in upstream relay: goroutine`) {
+		t.Errorf("panic not logged: %q", output)
+	}
+}
@@ -0,0 +1,111 @@
+package pt
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Reloader may be implemented by a ClientFactory or ServerFactory whose
+// parameters can be changed while it runs. When the process is asked to reload
+// (see Reload), ServeTransports calls the Reload method of each such factory,
+// which may read its parameters again from the StateDir of its configuration,
+// for example with ReadStateJSON. Listeners stay open, and connections already
+// made are not affected.
+type Reloader interface {
+	// Read the factory's parameters again. If it returns an error, the
+	// factory must go on with its previous parameters.
+	Reload() error
+}
+
+var reloadHooks struct {
+	mu    sync.Mutex
+	hooks []*func() error
+	// Held while the hooks run, so that reloads do not overlap.
+	running sync.Mutex
+}
+
+// Register f to be called by Reload. Hooks are called in the order in which
+// they were registered. Call the returned function to unregister f.
+func OnReload(f func() error) (remove func()) {
+	hook := &f
+	reloadHooks.mu.Lock()
+	reloadHooks.hooks = append(reloadHooks.hooks, hook)
+	reloadHooks.mu.Unlock()
+	return func() {
+		reloadHooks.mu.Lock()
+		defer reloadHooks.mu.Unlock()
+		for i, h := range reloadHooks.hooks {
+			if h == hook {
+				reloadHooks.hooks = append(reloadHooks.hooks[:i:i], reloadHooks.hooks[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Like OnReload, but unregister f when m begins to shut down.
+func onReloadUntil(m *ShutdownManager, f func() error) {
+	remove := OnReload(f)
+	closed := m.closed()
+	go func() {
+		<-closed
+		remove()
+	}()
+}
+
+// Call every hook registered with OnReload, including those that
+// ServeTransports registers for Reloader factories and that
+// RunClientStandalone registers to read its configuration file again. A hook
+// that fails is logged with a LOG line and does not stop the others. Returns
+// the error of the first hook that failed, or nil.
+func Reload() error {
+	reloadHooks.running.Lock()
+	defer reloadHooks.running.Unlock()
+	reloadHooks.mu.Lock()
+	hooks := append([]*func() error(nil), reloadHooks.hooks...)
+	reloadHooks.mu.Unlock()
+
+	var first error
+	for _, hook := range hooks {
+		err := (*hook)()
+		if err != nil {
+			Log(LogSeverityWarning, fmt.Sprintf("reload failed: %s", err.Error()))
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Call Reload on each SIGHUP, until m begins to shut down, instead of exiting
+// as a SIGHUP otherwise would. Returns immediately. On platforms without
+// SIGHUP, such as Windows, it does nothing, and Reload must be called in some
+// other way. RunClient, RunServer, RunClientStandalone, RunServerStandalone,
+// and ServeTransports call HandleReloadSignals(DefaultShutdownManager).
+func HandleReloadSignals(m *ShutdownManager) {
+	if len(reloadSignals) == 0 {
+		return
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, reloadSignals...)
+	go func() {
+		defer signal.Stop(sigChan)
+		handleReloadSignals(m, sigChan)
+	}()
+}
+
+func handleReloadSignals(m *ShutdownManager, sigChan <-chan os.Signal) {
+	closed := m.closed()
+	for {
+		select {
+		case sig := <-sigChan:
+			Log(LogSeverityNotice, fmt.Sprintf("received %s; reloading configuration", sig))
+			Reload()
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	var calls []int
+	errFailed := errors.New("failed")
+	remove1 := OnReload(func() error { calls = append(calls, 1); return nil })
+	remove2 := OnReload(func() error { calls = append(calls, 2); return errFailed })
+	remove3 := OnReload(func() error { calls = append(calls, 3); return nil })
+	defer remove1()
+	defer remove3()
+
+	err := Reload()
+	if err != errFailed {
+		t.Errorf("Reload returned %v (expected %v)", err, errFailed)
+	}
+	if len(calls) != 3 || calls[0] != 1 || calls[1] != 2 || calls[2] != 3 {
+		t.Errorf("hooks called as %v (expected [1 2 3])", calls)
+	}
+
+	remove2()
+	calls = nil
+	err = Reload()
+	if err != nil {
+		t.Errorf("Reload returned %v after removing failing hook", err)
+	}
+	if len(calls) != 2 || calls[0] != 1 || calls[1] != 3 {
+		t.Errorf("hooks called as %v (expected [1 3])", calls)
+	}
+}
+
+func TestHandleReloadSignals(t *testing.T) {
+	reloaded := make(chan struct{}, 1)
+	remove := OnReload(func() error {
+		reloaded <- struct{}{}
+		return nil
+	})
+	defer remove()
+
+	m := new(ShutdownManager)
+	sigChan := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		handleReloadSignals(m, sigChan)
+		close(done)
+	}()
+
+	// Any signal on sigChan causes a reload; which ones are sent there is
+	// decided by HandleReloadSignals.
+	sigChan <- os.Interrupt
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal did not cause a reload")
+	}
+
+	m.Shutdown(context.Background())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleReloadSignals did not return after shutdown")
+	}
+}
+
+// A reload of a standalone client config file changes the Options passed to
+// the Dialer of a running tunnel, without closing its listener.
+func TestStandaloneClientReload(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := ln.Addr().String()
+	ln.Close()
+
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "config.json")
+	writeConfig := func(value string) {
+		err := ioutil.WriteFile(filename, []byte(`{"tunnels": [{"transport": "foo", "listen": "`+listen+
+			`", "destination": "`+echo.Addr().String()+`", "options": {"key": ["`+value+`"]}}]}`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("old")
+	config, err := LoadStandaloneConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var gotValue string
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	listeners, err := runClientStandalone(m, config, map[string]Dialer{
+		"foo": DialerFunc(func(network, address string, args Args) (net.Conn, error) {
+			mu.Lock()
+			gotValue, _ = args.Get("key")
+			mu.Unlock()
+			return net.Dial(network, address)
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(expected string) {
+		conn, err := net.Dial("tcp", listeners[0].Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		checkEcho(t, conn)
+		mu.Lock()
+		defer mu.Unlock()
+		if gotValue != expected {
+			t.Errorf("dialed with key=%q (expected %q)", gotValue, expected)
+		}
+	}
+	check("old")
+
+	writeConfig("new")
+	err = Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("new")
+
+	// A config that cannot be read leaves the running one alone.
+	err = ioutil.WriteFile(filename, []byte(`{`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Reload() == nil {
+		t.Error("Reload of a bad config file unexpectedly succeeded")
+	}
+	check("new")
+}
@@ -0,0 +1,181 @@
+package pt
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReplayFilter remembers recently seen handshake values (for example, the MACs
+// of client handshakes) so that a server transport can reject a handshake that
+// is replayed. It holds at most a fixed number of values, each for at most a
+// fixed time; when it is full, the oldest value is forgotten first. A replay of
+// a forgotten value is not detected, so the capacity and time to live should be
+// chosen to cover the window in which the transport otherwise accepts a
+// handshake.
+//
+// A ReplayFilter made by OpenReplayFilter is backed by a file in
+// TOR_PT_STATE_LOCATION, so that replays are detected across restarts. Its
+// contents are written by Save, which should be called periodically and before
+// the transport exits.
+//
+// The methods of a ReplayFilter may be called concurrently.
+type ReplayFilter struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	// Maps a value to its element in order.
+	entries map[string]*list.Element
+	// replayEntry values, oldest first.
+	order *list.List
+	// If not "", the file that Save writes.
+	filename string
+}
+
+type replayEntry struct {
+	value string
+	seen  time.Time
+}
+
+// Return a new, empty ReplayFilter that holds up to capacity values, each for
+// up to ttl. A ttl of 0 means values expire only when the filter is full.
+func NewReplayFilter(capacity int, ttl time.Duration) *ReplayFilter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ReplayFilter{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Like NewReplayFilter, but the filter is backed by the file called name in the
+// state directory (see MakeStateDir). Values saved in the file are loaded, apart
+// from those that have expired. A missing file is not an error, but a file that
+// cannot be parsed is. name must not be empty or contain a path separator.
+func OpenReplayFilter(name string, capacity int, ttl time.Duration) (*ReplayFilter, error) {
+	filename, err := stateFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	f := NewReplayFilter(capacity, ttl)
+	f.filename = filename
+	err = f.load(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Record value as seen at now. Returns true if value was already present, which
+// means the handshake is a replay.
+func (f *ReplayFilter) TestAndSet(now time.Time, value []byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(now)
+	if _, ok := f.entries[string(value)]; ok {
+		return true
+	}
+	f.add(string(value), now)
+	return false
+}
+
+// Return the number of values held.
+func (f *ReplayFilter) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.order.Len()
+}
+
+// Add a value that is not already present, evicting the oldest if the filter is
+// full. f.mu must be held.
+func (f *ReplayFilter) add(value string, seen time.Time) {
+	for f.order.Len() >= f.capacity {
+		f.remove(f.order.Front())
+	}
+	f.entries[value] = f.order.PushBack(replayEntry{value, seen})
+}
+
+func (f *ReplayFilter) remove(e *list.Element) {
+	delete(f.entries, e.Value.(replayEntry).value)
+	f.order.Remove(e)
+}
+
+// Remove values that were seen ttl or longer before now. f.mu must be held.
+func (f *ReplayFilter) expire(now time.Time) {
+	if f.ttl <= 0 {
+		return
+	}
+	for e := f.order.Front(); e != nil; e = f.order.Front() {
+		if now.Sub(e.Value.(replayEntry).seen) < f.ttl {
+			break
+		}
+		f.remove(e)
+	}
+}
+
+// The format of a replay filter file.
+type replayFile struct {
+	// Values, oldest first.
+	Entries []replayFileEntry `json:"entries"`
+}
+
+type replayFileEntry struct {
+	Value []byte `json:"value"`
+	// Unix time in seconds.
+	Seen int64 `json:"seen"`
+}
+
+// Write the values held to the filter's file, replacing it atomically. Does
+// nothing for a filter made by NewReplayFilter.
+func (f *ReplayFilter) Save() error {
+	f.mu.Lock()
+	var file replayFile
+	for e := f.order.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(replayEntry)
+		file.Entries = append(file.Entries, replayFileEntry{[]byte(entry.value), entry.seen.Unix()})
+	}
+	filename := f.filename
+	f.mu.Unlock()
+	if filename == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(&file)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// Load the values in the filter's file that have not expired as of now.
+func (f *ReplayFilter) load(now time.Time) error {
+	data, err := ioutil.ReadFile(f.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var file replayFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return fmt.Errorf("%s: %s", f.filename, err.Error())
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, entry := range file.Entries {
+		if _, ok := f.entries[string(entry.Value)]; ok {
+			continue
+		}
+		f.add(string(entry.Value), time.Unix(entry.Seen, 0))
+	}
+	f.expire(now)
+	return nil
+}
@@ -0,0 +1,81 @@
+package pt
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayFilter(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	f := NewReplayFilter(2, time.Minute)
+	if f.TestAndSet(now, []byte("a")) {
+		t.Error("new value reported as replay")
+	}
+	if !f.TestAndSet(now, []byte("a")) {
+		t.Error("replay not detected")
+	}
+
+	// Values expire after the time to live.
+	if !f.TestAndSet(now.Add(59*time.Second), []byte("a")) {
+		t.Error("value expired early")
+	}
+	if f.TestAndSet(now.Add(time.Minute), []byte("a")) {
+		t.Error("value did not expire")
+	}
+
+	// When full, the oldest value is evicted.
+	f.TestAndSet(now.Add(time.Minute), []byte("b"))
+	f.TestAndSet(now.Add(time.Minute), []byte("c"))
+	if f.Len() != 2 {
+		t.Errorf("len %d, expected 2", f.Len())
+	}
+	if f.TestAndSet(now.Add(time.Minute), []byte("a")) {
+		t.Error("oldest value not evicted")
+	}
+}
+
+func TestOpenReplayFilter(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TOR_PT_STATE_LOCATION", dir)
+
+	f, err := OpenReplayFilter("replay", 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	f.TestAndSet(now.Add(-2*time.Hour), []byte("expired"))
+	f.TestAndSet(now, []byte("\x00\xffmac"))
+	err = f.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = OpenReplayFilter("replay", 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Len() != 1 {
+		t.Errorf("loaded %d values, expected 1", f.Len())
+	}
+	if !f.TestAndSet(now, []byte("\x00\xffmac")) {
+		t.Error("replay across restart not detected")
+	}
+
+	for _, name := range []string{"", "../replay", `a\b`} {
+		_, err = OpenReplayFilter(name, 10, time.Hour)
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", name)
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "corrupt"), []byte("{"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenReplayFilter("corrupt", 10, time.Hour)
+	if err == nil {
+		t.Error("corrupt file unexpectedly succeeded")
+	}
+}
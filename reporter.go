@@ -0,0 +1,112 @@
+package pt
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// Event is a message from the transport to its parent process, such as a
+// CMETHOD, SMETHOD, or LOG line. Every function that writes a pluggable
+// transports protocol line, from Cmethod to Log, passes an Event to
+// EventReporter, if set, instead of writing to Stdout.
+type Event struct {
+	// The protocol keyword, for example "CMETHOD", "SMETHOD-ERROR", "LOG",
+	// or "CMETHODS" (for CMETHODS DONE).
+	Keyword string
+	// The method name, for CMETHOD, CMETHOD-ERROR, SMETHOD, and
+	// SMETHOD-ERROR.
+	MethodName string
+	// "socks4" or "socks5", for CMETHOD.
+	Protocol string
+	// The listening address, for CMETHOD or SMETHOD.
+	Addr net.Addr
+	// The ARGS option of SMETHOD, if any.
+	Args Args
+	// The severity, for LOG ("error", "warning", "notice", "info", or
+	// "debug").
+	Severity string
+	// The explanation text of an error, the message of LOG, or the
+	// version number of VERSION.
+	Message string
+	// The reason code, for CMETHOD-ERROR and SMETHOD-ERROR lines emitted by
+	// CmethodErrorReason and SmethodErrorReason. Message does not include
+	// it.
+	Reason string
+	// The arguments that follow Keyword in the protocol line, for example
+	// {"DONE"} for CMETHODS DONE.
+	LineArgs []string
+}
+
+// Return the event formatted as a protocol line, without a trailing newline.
+func (e Event) String() string {
+	return formatline(e.Keyword, e.LineArgs...)
+}
+
+// A Reporter receives the Events emitted by the transport, for example to
+// display the status of a bridge in a graphical interface.
+type Reporter interface {
+	Report(e Event)
+}
+
+// ReporterFunc is an adapter that allows an ordinary function to be used as a
+// Reporter.
+type ReporterFunc func(e Event)
+
+// Call f(e).
+func (f ReporterFunc) Report(e Event) {
+	f(e)
+}
+
+// LineReporter is the default Reporter. It writes each Event to Stdout as a
+// protocol line, as specified in pt-spec.txt. A Reporter that captures events
+// programmatically can pass them on to a LineReporter in order to still inform
+// tor:
+//
+//	pt.EventReporter = pt.ReporterFunc(func(e pt.Event) {
+//		gui.ShowStatus(e)
+//		pt.LineReporter{}.Report(e)
+//	})
+type LineReporter struct{}
+
+// Write e to Stdout.
+func (LineReporter) Report(e Event) {
+	writeLine(Stdout, e.Keyword, e.LineArgs)
+}
+
+// Buffers for writeLine.
+var lineBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+// Write the protocol line made of keyword and args, with a trailing newline, to
+// w with a single Write.
+func writeLine(w io.Writer, keyword string, args []string) {
+	bp := lineBufPool.Get().(*[]byte)
+	b := append(appendLine((*bp)[:0], keyword, args...), '\n')
+	w.Write(b)
+	*bp = b
+	lineBufPool.Put(bp)
+}
+
+// If EventReporter is not nil, protocol messages are passed to it, rather than
+// being written to Stdout. Set it before calling ClientSetup or ServerSetup.
+var EventReporter Reporter
+
+// Send e to EventReporter, or write it to Stdout. Panics if there are forbidden
+// bytes in the keyword or the line args (pt-spec.txt 2.2.1), whether or not
+// EventReporter is set.
+func emit(e Event) {
+	if EventReporter != nil {
+		// Check the line even though it is not written.
+		bp := lineBufPool.Get().(*[]byte)
+		*bp = appendLine((*bp)[:0], e.Keyword, e.LineArgs...)
+		lineBufPool.Put(bp)
+		EventReporter.Report(e)
+		return
+	}
+	writeLine(Stdout, e.Keyword, e.LineArgs)
+}
@@ -0,0 +1,126 @@
+package pt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestEventReporter(t *testing.T) {
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+	var events []Event
+	saved := EventReporter
+	EventReporter = ReporterFunc(func(e Event) {
+		events = append(events, e)
+	})
+	defer func() { EventReporter = saved }()
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1080}
+	Cmethod("alpha", "socks5", addr)
+	CmethodError("beta", "no such method")
+	args := Args{}
+	args.Add("key", "value")
+	SmethodArgs("gamma", addr, args)
+	Log(LogSeverityNotice, "hello")
+	SmethodsDone()
+
+	if buf.Len() != 0 {
+		t.Errorf("output written with EventReporter set: %q", buf.String())
+	}
+	if len(events) != 5 {
+		t.Fatalf("got %d events: %+v", len(events), events)
+	}
+	if e := events[0]; e.Keyword != "CMETHOD" || e.MethodName != "alpha" || e.Protocol != "socks5" || e.Addr != addr {
+		t.Errorf("unexpected CMETHOD event %+v", e)
+	}
+	if e := events[1]; e.Keyword != "CMETHOD-ERROR" || e.MethodName != "beta" || e.Message != "no such method" {
+		t.Errorf("unexpected CMETHOD-ERROR event %+v", e)
+	}
+	if e := events[2]; e.Keyword != "SMETHOD" || e.MethodName != "gamma" || !argsEqual(e.Args, args) {
+		t.Errorf("unexpected SMETHOD event %+v", e)
+	}
+	if e := events[3]; e.Keyword != "LOG" || e.Severity != "notice" || e.Message != "hello" {
+		t.Errorf("unexpected LOG event %+v", e)
+	}
+
+	// The lines that LineReporter would write.
+	expected := []string{
+		"CMETHOD alpha socks5 127.0.0.1:1080",
+		"CMETHOD-ERROR beta no such method",
+		"SMETHOD gamma 127.0.0.1:1080 ARGS:key=value",
+		`LOG SEVERITY=notice MESSAGE="hello"`,
+		"SMETHODS DONE",
+	}
+	for i, e := range events {
+		LineReporter{}.Report(e)
+		if e.String() != expected[i] {
+			t.Errorf("event %d → %q (expected %q)", i, e.String(), expected[i])
+		}
+	}
+	var expectedOutput string
+	for _, s := range expected {
+		expectedOutput += s + "\n"
+	}
+	if buf.String() != expectedOutput {
+		t.Errorf("LineReporter wrote %q (expected %q)", buf.String(), expectedOutput)
+	}
+}
+
+func TestEventReporterForbiddenBytes(t *testing.T) {
+	saved := EventReporter
+	EventReporter = ReporterFunc(func(e Event) {
+		t.Errorf("Reporter called with invalid event %+v", e)
+	})
+	defer func() { EventReporter = saved }()
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for forbidden bytes")
+		}
+	}()
+	CmethodError("alpha", "bad\nmessage")
+}
+
+// Counts calls to Write.
+type writeCounter struct {
+	writes int
+	buf    bytes.Buffer
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func TestEmitSingleWrite(t *testing.T) {
+	var w writeCounter
+	savedStdout := Stdout
+	Stdout = &w
+	defer func() { Stdout = savedStdout }()
+
+	Log(LogSeverityNotice, "hello\tworld")
+	if w.writes != 1 {
+		t.Errorf("%d writes", w.writes)
+	}
+	expected := "LOG SEVERITY=notice MESSAGE=\"hello\\011world\"\n"
+	if w.buf.String() != expected {
+		t.Errorf("got %q, expected %q", w.buf.String(), expected)
+	}
+}
+
+func TestEmitAllocs(t *testing.T) {
+	savedStdout := Stdout
+	Stdout = ioutil.Discard
+	defer func() { Stdout = savedStdout }()
+
+	e := Event{Keyword: "STATUS", LineArgs: []string{"TRANSPORT=foo", "CONNECT=Success"}}
+	allocs := testing.AllocsPerRun(100, func() {
+		emit(e)
+	})
+	if allocs != 0 {
+		t.Errorf("emit made %v allocations", allocs)
+	}
+}
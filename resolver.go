@@ -0,0 +1,47 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Resolver, if not nil, is called to look up host names in
+// TOR_PT_SERVER_BINDADDR, TOR_PT_ORPORT, and TOR_PT_EXTENDED_SERVER_PORT, and by
+// ParseBindaddrs and ListenPacket. If nil, which is the default, only literal IP
+// addresses are accepted and no DNS lookups are ever made.
+//
+// The signature matches the LookupIPAddr method of *net.Resolver, so a custom
+// resolver, for example one whose Dial function sends queries over a specific
+// interface, can be used directly:
+//
+//	pt.Resolver = net.DefaultResolver.LookupIPAddr
+//
+// Set Resolver before calling ServerSetup.
+var Resolver func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+// How long to wait for Resolver to return.
+const resolveTimeout = 30 * time.Second
+
+// Look up host with Resolver and return the resulting addresses with the given
+// port, in the order returned.
+func lookupTCPAddrs(host string, port int) ([]*net.TCPAddr, error) {
+	if Resolver == nil {
+		return nil, net.InvalidAddrError(fmt.Sprintf("not an IP string: %q", host))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	ips, err := Resolver(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	addrs := make([]*net.TCPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, &net.TCPAddr{IP: ip.IP, Port: port, Zone: ip.Zone})
+	}
+	return addrs, nil
+}
@@ -0,0 +1,100 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func fakeResolver(ctx context.Context, host string) ([]net.IPAddr, error) {
+	switch host {
+	case "orport.example":
+		return []net.IPAddr{
+			{IP: net.ParseIP("2001:db8::1")},
+			{IP: net.ParseIP("192.0.2.1")},
+		}, nil
+	case "empty.example":
+		return nil, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestResolveAddrsResolver(t *testing.T) {
+	saved := Resolver
+	defer func() { Resolver = saved }()
+
+	// With no Resolver, host names are rejected.
+	Resolver = nil
+	_, err := resolveAddrs("orport.example:9001")
+	if err == nil {
+		t.Error("host name accepted with nil Resolver")
+	}
+
+	Resolver = fakeResolver
+	addrs, err := resolveAddrs("orport.example:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*net.TCPAddr{
+		{IP: net.ParseIP("2001:db8::1"), Port: 9001},
+		{IP: net.ParseIP("192.0.2.1"), Port: 9001},
+	}
+	if len(addrs) != len(expected) {
+		t.Fatalf("got %v, expected %v", addrs, expected)
+	}
+	for i := range addrs {
+		if !tcpAddrsEqual(addrs[i], expected[i]) {
+			t.Fatalf("got %v, expected %v", addrs, expected)
+		}
+	}
+	addr, err := resolveAddr("orport.example:9001")
+	if err != nil || !tcpAddrsEqual(addr, expected[0]) {
+		t.Errorf("resolveAddr got %v %v, expected %v", addr, err, expected[0])
+	}
+
+	// Literal addresses are not passed to Resolver.
+	Resolver = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		t.Errorf("Resolver called for %q", host)
+		return nil, errors.New("unexpected")
+	}
+	_, err = resolveAddrs("127.0.0.1:9001")
+	if err != nil {
+		t.Error(err)
+	}
+
+	Resolver = fakeResolver
+	for _, input := range []string{"empty.example:9001", "bogus.example:9001", "orport.example:bogus"} {
+		_, err = resolveAddrs(input)
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestServerSetupResolver(t *testing.T) {
+	saved := Resolver
+	defer func() { Resolver = saved }()
+	Resolver = fakeResolver
+
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	t.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	t.Setenv("TOR_PT_SERVER_TRANSPORTS", "alpha")
+	t.Setenv("TOR_PT_SERVER_BINDADDR", "alpha-127.0.0.1:0")
+	t.Setenv("TOR_PT_ORPORT", "orport.example:9001")
+	info, err := ServerSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.OrAddrs) != 2 {
+		t.Fatalf("OrAddrs is %v", info.OrAddrs)
+	}
+	if info.OrAddr != info.OrAddrs[0] {
+		t.Errorf("OrAddr %v is not the first of OrAddrs %v", info.OrAddr, info.OrAddrs)
+	}
+}
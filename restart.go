@@ -0,0 +1,105 @@
+package pt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The environment variable in which Restart passes its state argument, base64
+// encoded.
+const restartStateEnv = "PT_RESTART_STATE"
+
+var restartState struct {
+	once  sync.Once
+	state []byte
+}
+
+// Return the state passed by the process that started this one with Restart,
+// or nil if this process was not started by Restart. The environment variable
+// carrying the state is unset on the first call, so that it is not inherited
+// by child processes.
+func RestartState() []byte {
+	restartState.once.Do(func() {
+		s := os.Getenv(restartStateEnv)
+		os.Unsetenv(restartStateEnv)
+		if s != "" {
+			state, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				Log(LogSeverityWarning, fmt.Sprintf("cannot decode %s: %s", restartStateEnv, err.Error()))
+			} else {
+				restartState.state = state
+			}
+		}
+	})
+	return restartState.state
+}
+
+// Return the files of listeners, in a stable order, and the corresponding
+// names.
+func restartFiles(listeners map[string][]net.Listener) ([]*os.File, []string, error) {
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		if name == "" || strings.ContainsRune(name, ':') {
+			return nil, nil, fmt.Errorf("invalid listener name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []*os.File
+	var fileNames []string
+	for _, name := range names {
+		for _, ln := range listeners[name] {
+			filer, ok := ln.(interface {
+				File() (*os.File, error)
+			})
+			if !ok {
+				closeFiles(files)
+				return nil, nil, fmt.Errorf("listener for %s of type %T has no file descriptor", name, ln)
+			}
+			f, err := filer.File()
+			if err != nil {
+				closeFiles(files)
+				return nil, nil, err
+			}
+			files = append(files, f)
+			fileNames = append(fileNames, name)
+		}
+	}
+	return files, fileNames, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// Return env with the socket activation variables removed and the variables
+// for passing names and state set.
+func restartEnv(env []string, names []string, state []byte) []string {
+	var result []string
+	for _, kv := range env {
+		key := kv
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			key = kv[:i]
+		}
+		switch key {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", inheritedFDsEnv, restartStateEnv:
+			continue
+		}
+		result = append(result, kv)
+	}
+	if len(names) > 0 {
+		result = append(result, inheritedFDsEnv+"="+strings.Join(names, ":"))
+	}
+	if len(state) > 0 {
+		result = append(result, restartStateEnv+"="+base64.StdEncoding.EncodeToString(state))
+	}
+	return result
+}
@@ -0,0 +1,16 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package pt
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+)
+
+// Restart is not supported on this platform; it always returns an error.
+func Restart(path string, listeners map[string][]net.Listener, state []byte) (*os.Process, error) {
+	return nil, fmt.Errorf("restart is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,88 @@
+package pt
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestRestartEnv(t *testing.T) {
+	env := restartEnv([]string{
+		"PATH=/bin",
+		"LISTEN_PID=1",
+		"LISTEN_FDS=1",
+		inheritedFDsEnv + "=old",
+		"TOR_PT_STATE_LOCATION=/var/lib/pt",
+	}, []string{"foo", "foo", "bar"}, []byte("\x00state"))
+	expected := []string{
+		"PATH=/bin",
+		"TOR_PT_STATE_LOCATION=/var/lib/pt",
+		inheritedFDsEnv + "=foo:foo:bar",
+		restartStateEnv + "=AHN0YXRl",
+	}
+	if !stringSlicesEqual(env, expected) {
+		t.Errorf("got %q, expected %q", env, expected)
+	}
+
+	n, names := parseInheritedFDs("foo:foo:bar")
+	if n != 3 || !stringSlicesEqual(names, []string{"foo", "foo", "bar"}) {
+		t.Errorf("got %d %q", n, names)
+	}
+}
+
+func TestRestartFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("listener files are not supported on Windows")
+	}
+	var lns []net.Listener
+	for i := 0; i < 3; i++ {
+		ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		lns = append(lns, ln)
+	}
+
+	files, names, err := restartFiles(map[string][]net.Listener{
+		"foo": {lns[0], lns[1]},
+		"bar": {lns[2]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFiles(files)
+	if !stringSlicesEqual(names, []string{"bar", "foo", "foo"}) {
+		t.Errorf("got names %q", names)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files", len(files))
+	}
+
+	// The files can be made back into listeners on the same addresses, as
+	// in the new process.
+	listeners, err := activationListeners([]*os.File{files[0]}, names[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners["bar"][0].Close()
+	if listeners["bar"][0].Addr().String() != lns[2].Addr().String() {
+		t.Errorf("got %v, expected %v", listeners["bar"][0].Addr(), lns[2].Addr())
+	}
+
+	// Wrapped listeners have no file descriptor.
+	m := new(ShutdownManager)
+	tln, err := m.TrackListener(lns[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = restartFiles(map[string][]net.Listener{"foo": {tln}})
+	if err == nil {
+		t.Error("wrapped listener unexpectedly succeeded")
+	}
+	_, _, err = restartFiles(map[string][]net.Listener{"a:b": {lns[0]}})
+	if err == nil {
+		t.Error("name with ':' unexpectedly succeeded")
+	}
+}
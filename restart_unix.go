@@ -0,0 +1,56 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package pt
+
+import (
+	"net"
+	"os"
+	"os/exec"
+)
+
+// Start a new instance of the transport, for example after its executable has
+// been upgraded, and hand it the listening sockets in listeners, keyed by method
+// name, so that it accepts new connections on the same ports without a gap.
+// The new process runs the executable at path (the current executable if path
+// is ""), with the same arguments, environment, and standard streams as this
+// one. In the new process, Bindaddr.Listen (and so RunServer) takes the passed
+// sockets in the manner of socket activation (see ActivationListeners), and
+// RestartState returns state, which should be small.
+//
+// The listeners must have file descriptors, as *net.TCPListener and
+// *net.UnixListener do; wrappers such as those of TrackListener do not. After
+// Restart returns, this process should stop accepting connections, for
+// example by calling Shutdown, and exit once its established connections have
+// finished; they are not affected by the restart.
+//
+// A transport that is run as a managed proxy by tor cannot usefully restart
+// itself this way, because tor treats the exit of the process it started as
+// the failure of the transport. Restart is meant for transports that run
+// outside tor's control, as in standalone mode or under a service manager.
+func Restart(path string, listeners map[string][]net.Listener, state []byte) (*os.Process, error) {
+	if path == "" {
+		var err error
+		path, err = os.Executable()
+		if err != nil {
+			return nil, err
+		}
+	}
+	files, names, err := restartFiles(listeners)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFiles(files)
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = restartEnv(os.Environ(), names, state)
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
@@ -0,0 +1,52 @@
+package pt
+
+import (
+	"net"
+)
+
+// If ListenersPerBindaddr is greater than 1, RunServer opens that many
+// listeners for each bindaddr with Bindaddr.ListenReusePort, each with its own
+// accept loop, so that accepting connections on a busy bridge is spread over
+// several sockets by the kernel. The SMETHOD line reports the one address they
+// share. Where SO_REUSEPORT is not supported, the bindaddr gets an
+// SMETHOD-ERROR.
+var ListenersPerBindaddr int
+
+// Open n TCP listeners on the bind address, all with the SO_REUSEPORT socket
+// option, so that the kernel distributes incoming connections among them. If
+// the address has port 0, the listeners share the port chosen for the first,
+// which observes PersistAutoPorts as Listen does. If a socket was passed by
+// socket activation for the bindaddr's method, only that one listener is
+// returned. Returns an error on platforms without SO_REUSEPORT.
+func (bindaddr Bindaddr) ListenReusePort(n int) ([]*net.TCPListener, error) {
+	if ln, err := takeActivationTCPListener(bindaddr.MethodName); ln != nil || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return []*net.TCPListener{ln}, nil
+	}
+	var lns []*net.TCPListener
+	err := listenAutoPort("tcp", bindaddr.MethodName, bindaddr.Addr, func(addr *net.TCPAddr) (net.Addr, error) {
+		ln, err := listenReusePort(addr)
+		if err != nil {
+			return nil, err
+		}
+		lns = append(lns, ln)
+		return ln.Addr(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	addr := lns[0].Addr().(*net.TCPAddr)
+	for len(lns) < n {
+		ln, err := listenReusePort(addr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
@@ -0,0 +1,9 @@
+//go:build linux && (386 || amd64 || arm)
+// +build linux
+// +build 386 amd64 arm
+
+package pt
+
+// SO_REUSEPORT from <asm-generic/socket.h>, which package syscall lacks on these
+// platforms.
+const soReusePort = 0xf
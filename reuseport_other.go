@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package pt
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// SO_REUSEPORT is not supported on this platform; always return an error.
+func listenReusePort(addr *net.TCPAddr) (*net.TCPListener, error) {
+	return nil, fmt.Errorf("SO_REUSEPORT is not supported on %s", runtime.GOOS)
+}
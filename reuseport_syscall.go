@@ -0,0 +1,9 @@
+//go:build (darwin || dragonfly || freebsd || linux || netbsd || openbsd) && !(linux && (386 || amd64 || arm))
+// +build darwin dragonfly freebsd linux netbsd openbsd
+// +build !linux !386,!amd64,!arm
+
+package pt
+
+import "syscall"
+
+const soReusePort = syscall.SO_REUSEPORT
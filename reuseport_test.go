@@ -0,0 +1,59 @@
+package pt
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	bindaddr := Bindaddr{MethodName: "foo", Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}}
+	lns, err := bindaddr.ListenReusePort(3)
+	if err != nil {
+		t.Skipf("SO_REUSEPORT not available: %v", err)
+	}
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+	if len(lns) != 3 {
+		t.Fatalf("got %d listeners, expected 3", len(lns))
+	}
+	port := lns[0].Addr().(*net.TCPAddr).Port
+	if port == 0 {
+		t.Fatal("port 0 after listening")
+	}
+	for _, ln := range lns[1:] {
+		if p := ln.Addr().(*net.TCPAddr).Port; p != port {
+			t.Errorf("listener on port %d, expected %d", p, port)
+		}
+	}
+
+	// Every connection is accepted by one of the listeners.
+	accepted := make(chan net.Conn)
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- c
+			}
+		}(ln)
+	}
+	for i := 0; i < 10; i++ {
+		c, err := net.DialTCP("tcp", nil, lns[0].Addr().(*net.TCPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		(<-accepted).Close()
+	}
+
+	// A plain listener on the same port conflicts.
+	_, err = net.ListenTCP("tcp", lns[0].Addr().(*net.TCPAddr))
+	if err == nil {
+		t.Error("listener without SO_REUSEPORT unexpectedly bound the port")
+	}
+}
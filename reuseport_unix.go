@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package pt
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// Open a TCP listener on addr with SO_REUSEPORT set, and the options of
+// ListenTCPOptions.
+func listenReusePort(addr *net.TCPAddr) (*net.TCPListener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+			})
+			if err != nil {
+				return err
+			}
+			if sockErr != nil {
+				return sockErr
+			}
+			return ListenTCPOptions.listenControl(network, address, c)
+		},
+	}
+	ln, err := lc.Listen(context.Background(), "tcp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return ln.(*net.TCPListener), nil
+}
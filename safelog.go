@@ -0,0 +1,79 @@
+package pt
+
+import (
+	"net"
+	"strconv"
+)
+
+// SafeLoggingMode says how client addresses are written in LOG lines emitted
+// by the library, in the manner of tor's SafeLogging option. Bridge operators'
+// logs must not record who their clients are.
+type SafeLoggingMode int
+
+const (
+	// Replace addresses with "[scrubbed]".
+	SafeLoggingScrub SafeLoggingMode = iota
+	// Keep only a network prefix of IP addresses: the first 16 bits of an
+	// IPv4 address and the first 32 bits of an IPv6 address. Ports are
+	// removed.
+	SafeLoggingTruncate
+	// Write addresses in full.
+	SafeLoggingOff
+)
+
+// The mode used by SafeAddr and SafeAddrString, and so by the library's own LOG
+// lines.
+var SafeLogging = SafeLoggingScrub
+
+const scrubbed = "[scrubbed]"
+
+// Return a form of addr that is safe to log according to SafeLogging.
+func SafeAddr(addr net.Addr) string {
+	if addr == nil {
+		return SafeAddrString("")
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && SafeLogging == SafeLoggingTruncate {
+		return truncateIP(tcpAddr.IP)
+	}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && SafeLogging == SafeLoggingTruncate {
+		return truncateIP(udpAddr.IP)
+	}
+	return SafeAddrString(addr.String())
+}
+
+// Return a form of s, an address in "host", "host:port", or "[host]:port" form,
+// that is safe to log according to SafeLogging. Under SafeLoggingTruncate, an
+// s that is not an IP address is scrubbed.
+func SafeAddrString(s string) string {
+	switch SafeLogging {
+	case SafeLoggingOff:
+		return s
+	case SafeLoggingTruncate:
+		host := s
+		if h, _, err := net.SplitHostPort(s); err == nil {
+			host = h
+		}
+		ip, _, _ := parseIPZone(host)
+		if ip == nil {
+			return scrubbed
+		}
+		return truncateIP(ip)
+	default:
+		return scrubbed
+	}
+}
+
+// Return the network prefix of ip, in CIDR notation, that SafeLoggingTruncate
+// keeps.
+func truncateIP(ip net.IP) string {
+	bits, size := 16, 32
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if len(ip) == net.IPv6len {
+		bits, size = 32, 128
+	} else {
+		return scrubbed
+	}
+	prefix := ip.Mask(net.CIDRMask(bits, size))
+	return prefix.String() + "/" + strconv.Itoa(bits)
+}
@@ -0,0 +1,51 @@
+package pt
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSafeAddr(t *testing.T) {
+	defer func(mode SafeLoggingMode) { SafeLogging = mode }(SafeLogging)
+
+	tests := []struct {
+		mode     SafeLoggingMode
+		addr     net.Addr
+		expected string
+	}{
+		{SafeLoggingScrub, mustTCPAddr("192.0.2.1:1234"), "[scrubbed]"},
+		{SafeLoggingScrub, nil, "[scrubbed]"},
+		{SafeLoggingTruncate, mustTCPAddr("192.0.2.1:1234"), "192.0.0.0/16"},
+		{SafeLoggingTruncate, mustTCPAddr("[2001:db8:1:2::1]:1234"), "2001:db8::/32"},
+		{SafeLoggingTruncate, &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 53}, "198.51.0.0/16"},
+		{SafeLoggingTruncate, &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, "[scrubbed]"},
+		{SafeLoggingOff, mustTCPAddr("192.0.2.1:1234"), "192.0.2.1:1234"},
+	}
+	for _, test := range tests {
+		SafeLogging = test.mode
+		if output := SafeAddr(test.addr); output != test.expected {
+			t.Errorf("mode %d, %v → %q (expected %q)", test.mode, test.addr, output, test.expected)
+		}
+	}
+}
+
+func TestSafeAddrString(t *testing.T) {
+	defer func(mode SafeLoggingMode) { SafeLogging = mode }(SafeLogging)
+
+	SafeLogging = SafeLoggingTruncate
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"192.0.2.1", "192.0.0.0/16"},
+		{"192.0.2.1:1234", "192.0.0.0/16"},
+		{"[fe80::1%eth0]:1234", "fe80::/32"},
+		{"example.com:1234", "[scrubbed]"},
+		{"", "[scrubbed]"},
+	}
+	for _, test := range tests {
+		if output := SafeAddrString(test.input); output != test.expected {
+			t.Errorf("%q → %q (expected %q)", test.input, output, test.expected)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package pt
+
+import "path/filepath"
+
+// Sandbox restricts what the process can do, so that a bug in a transport
+// exposes less of the system it runs on. Apply it once setup is complete:
+// after the listeners are bound, the auth cookie is read, and anything else
+// the transport needs from the file system has been opened.
+//
+//	info, err := pt.ServerSetup(nil)
+//	...
+//	// open listeners, emit SMETHOD lines
+//	pt.SmethodsDone()
+//	err = pt.NewServerSandbox(&info).Apply()
+//
+// On Linux, Apply installs a seccomp filter that allows only the system calls
+// that the Go runtime, the C library, and a transport need to use files,
+// sockets, timers, and threads, and makes all others fail with EPERM. Among
+// those that fail are running programs (execve), tracing other processes,
+// loading kernel modules or BPF programs, mounting, and changing namespaces. A
+// transport that needs a system call outside the list cannot use the sandbox.
+// Apply also limits file system access, with Landlock, to ReadPaths and
+// WritePaths and the paths a transport commonly needs for name resolution and
+// certificates (see DefaultSandboxReadPaths); it is skipped if the kernel does
+// not support Landlock, or if the program is built with cgo, unless
+// RequireLandlock is set. Seccomp filtering is available on amd64 and arm64.
+//
+// A sandbox cannot be removed, and is inherited by child processes. Restart does
+// not work in a sandboxed process, because it cannot run a new one. On other
+// systems, Apply returns an error.
+type Sandbox struct {
+	// Files and directories that may be read. A directory gives access to
+	// everything beneath it.
+	ReadPaths []string
+	// Files and directories that may be read, written, and, for
+	// directories, have files created and removed beneath them.
+	WritePaths []string
+	// Make Apply fail if file system access cannot be limited.
+	RequireLandlock bool
+}
+
+// Paths that a Sandbox may always read, in addition to its ReadPaths. Missing
+// paths are skipped. /dev/null may always be read and written.
+var DefaultSandboxReadPaths = []string{
+	"/etc/resolv.conf",
+	"/etc/hosts",
+	"/etc/nsswitch.conf",
+	"/etc/services",
+	"/etc/ssl",
+	"/etc/pki",
+	"/dev/urandom",
+	"/proc/self",
+	"/sys/fs/cgroup",
+}
+
+// Return a Sandbox for a server transport set up with info, that may read the
+// directory of the auth cookie file and write the state directory
+// TOR_PT_STATE_LOCATION, if they are set. The whole directory of the cookie is
+// readable, because tor replaces the file by renaming a new one into place,
+// and a rule for a file covers only the file it was made for.
+func NewServerSandbox(info *ServerInfo) *Sandbox {
+	s := new(Sandbox)
+	if info.AuthCookiePath != "" {
+		s.ReadPaths = append(s.ReadPaths, filepath.Dir(info.AuthCookiePath))
+	}
+	if dir := getenv("TOR_PT_STATE_LOCATION"); dir != "" {
+		s.WritePaths = append(s.WritePaths, filepath.Clean(dir))
+	}
+	return s
+}
+
+// Apply the sandbox to the whole process.
+func (s *Sandbox) Apply() error {
+	_, err := applySandbox(s)
+	return err
+}
@@ -0,0 +1,275 @@
+package pt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// From <linux/prctl.h>, <linux/seccomp.h>, <linux/filter.h>, and
+// <linux/landlock.h>.
+const (
+	prSetNoNewPrivs = 38
+
+	seccompSetModeFilter = 1
+	seccompFlagTsync     = 1
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	bpfLdWAbs  = 0x20
+	bpfJeqK    = 0x15
+	bpfJgeK    = 0x35
+	bpfRetK    = 0x06
+	bpfMaxJump = 0xff
+
+	// The landlock system calls have the same numbers on all architectures
+	// with the common system call table. On those without it (MIPS), the
+	// calls fail with ENOSYS and are skipped.
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+
+	landlockAccessFSExecute    = 1 << 0
+	landlockAccessFSWriteFile  = 1 << 1
+	landlockAccessFSReadFile   = 1 << 2
+	landlockAccessFSReadDir    = 1 << 3
+	landlockAccessFSRemoveDir  = 1 << 4
+	landlockAccessFSRemoveFile = 1 << 5
+	landlockAccessFSMakeChar   = 1 << 6
+	landlockAccessFSMakeDir    = 1 << 7
+	landlockAccessFSMakeReg    = 1 << 8
+	landlockAccessFSMakeSock   = 1 << 9
+	landlockAccessFSMakeFifo   = 1 << 10
+	landlockAccessFSMakeBlock  = 1 << 11
+	landlockAccessFSMakeSym    = 1 << 12
+	landlockAccessFSRefer      = 1 << 13
+	landlockAccessFSTruncate   = 1 << 14
+
+	oPath = 0x200000
+)
+
+// The seccomp filter for an architecture.
+type seccompArch struct {
+	// The AUDIT_ARCH_* value of the architecture.
+	audit uint32
+	// The number of the seccomp system call.
+	sysSeccomp uintptr
+	// Whether to deny system calls of the x32 ABI.
+	x32 bool
+	// The numbers of the allowed system calls.
+	allowed []uint32
+}
+
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// struct landlock_path_beneath_attr is packed, 12 bytes; this has the same
+// layout, followed by 4 bytes of padding that the kernel does not read.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// Apply s, and return whether Landlock was applied.
+func applySandbox(s *Sandbox) (bool, error) {
+	// no_new_privs and the seccomp filter are set on the calling thread,
+	// then synchronized to the others.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	_, _, e := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0)
+	if e == syscall.ENOTSUP {
+		// Built with cgo. Seccomp's TSYNC sets no_new_privs on the other
+		// threads.
+		_, _, e = syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0)
+	}
+	if e != 0 {
+		return false, fmt.Errorf("cannot set no_new_privs: %s", e.Error())
+	}
+
+	// Landlock comes first, because the seccomp filter does not allow its
+	// system calls.
+	landlock := true
+	err := applyLandlock(s)
+	if err != nil {
+		if s.RequireLandlock {
+			return false, err
+		}
+		Log(LogSeverityNotice, "not limiting file system access: "+err.Error())
+		landlock = false
+	}
+
+	if sandboxArch != nil {
+		err = applySeccomp(sandboxArch)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		Log(LogSeverityNotice, fmt.Sprintf("seccomp filtering is not supported on %s", runtime.GOARCH))
+	}
+	return landlock, nil
+}
+
+// Return the BPF program that checks the architecture, then allows the system
+// calls in arch.allowed and returns EPERM for all others.
+func seccompFilter(arch *seccompArch) []sockFilter {
+	var prog []sockFilter
+	// struct seccomp_data has nr at offset 0 and arch at offset 4.
+	prog = append(prog,
+		sockFilter{code: bpfLdWAbs, k: 4},
+		sockFilter{code: bpfJeqK, jt: 1, k: arch.audit},
+		sockFilter{code: bpfRetK, k: seccompRetKillProcess},
+		sockFilter{code: bpfLdWAbs, k: 0},
+	)
+	var checks []sockFilter
+	if arch.x32 {
+		// Jumps over the other checks to the deny.
+		checks = append(checks, sockFilter{code: bpfJgeK, k: 0x40000000})
+	}
+	for _, nr := range arch.allowed {
+		// Jumps over the checks after it and the deny to the allow.
+		checks = append(checks, sockFilter{code: bpfJeqK, jt: 1, k: nr})
+	}
+	for i := range checks {
+		checks[i].jt += uint8(len(checks) - i - 1)
+	}
+	prog = append(prog, checks...)
+	prog = append(prog,
+		sockFilter{code: bpfRetK, k: seccompRetErrno | uint32(syscall.EPERM)},
+		sockFilter{code: bpfRetK, k: seccompRetAllow},
+	)
+	return prog
+}
+
+// Install the seccomp filter for arch on all threads. The calling thread must
+// be locked and have no_new_privs set.
+func applySeccomp(arch *seccompArch) error {
+	filter := seccompFilter(arch)
+	if len(filter)-6 > bpfMaxJump {
+		return errors.New("seccomp filter is too long")
+	}
+	prog := sockFprog{len: uint16(len(filter)), filter: &filter[0]}
+	r, _, e := syscall.RawSyscall(arch.sysSeccomp, seccompSetModeFilter, seccompFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if e != 0 {
+		return fmt.Errorf("cannot install seccomp filter: %s", e.Error())
+	}
+	if r != 0 {
+		return fmt.Errorf("cannot install seccomp filter: thread %d cannot be synchronized", r)
+	}
+	return nil
+}
+
+// Limit file system access to the paths of s and DefaultSandboxReadPaths on
+// all threads.
+func applyLandlock(s *Sandbox) error {
+	abi, _, e := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if e != 0 {
+		return fmt.Errorf("Landlock is not available: %s", e.Error())
+	}
+
+	fileRead := uint64(landlockAccessFSReadFile)
+	fileWrite := uint64(landlockAccessFSReadFile | landlockAccessFSWriteFile)
+	dirRead := uint64(landlockAccessFSReadFile | landlockAccessFSReadDir)
+	dirWrite := dirRead | landlockAccessFSWriteFile | landlockAccessFSRemoveDir |
+		landlockAccessFSRemoveFile | landlockAccessFSMakeDir | landlockAccessFSMakeReg
+	handled := uint64(landlockAccessFSExecute | landlockAccessFSWriteFile |
+		landlockAccessFSReadFile | landlockAccessFSReadDir | landlockAccessFSRemoveDir |
+		landlockAccessFSRemoveFile | landlockAccessFSMakeChar | landlockAccessFSMakeDir |
+		landlockAccessFSMakeReg | landlockAccessFSMakeSock | landlockAccessFSMakeFifo |
+		landlockAccessFSMakeBlock | landlockAccessFSMakeSym)
+	if abi >= 2 {
+		handled |= landlockAccessFSRefer
+	}
+	if abi >= 3 {
+		handled |= landlockAccessFSTruncate
+		fileWrite |= landlockAccessFSTruncate
+		dirWrite |= landlockAccessFSTruncate
+	}
+
+	attr := landlockRulesetAttr{handledAccessFS: handled}
+	r, _, e := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if e != 0 {
+		return fmt.Errorf("cannot create Landlock ruleset: %s", e.Error())
+	}
+	ruleset := int(r)
+	defer syscall.Close(ruleset)
+
+	err := addLandlockRule(ruleset, "/dev/null", fileWrite, dirWrite)
+	if err != nil {
+		return err
+	}
+	var readPaths []string
+	readPaths = append(readPaths, DefaultSandboxReadPaths...)
+	readPaths = append(readPaths, s.ReadPaths...)
+	for _, path := range readPaths {
+		err = addLandlockRule(ruleset, path, fileRead, dirRead)
+		if err != nil {
+			return err
+		}
+	}
+	for _, path := range s.WritePaths {
+		err = addLandlockRule(ruleset, path, fileWrite, dirWrite)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, _, e = syscall.AllThreadsSyscall(sysLandlockRestrictSelf, uintptr(ruleset), 0, 0)
+	if e == syscall.ENOTSUP {
+		return errors.New("Landlock cannot be applied to all threads when built with cgo")
+	}
+	if e != 0 {
+		return fmt.Errorf("cannot apply Landlock ruleset: %s", e.Error())
+	}
+	return nil
+}
+
+// Add a rule to ruleset allowing fileAccess to path if it is a file, or
+// dirAccess to what is beneath it if it is a directory. A missing path is
+// skipped.
+func addLandlockRule(ruleset int, path string, fileAccess, dirAccess uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer syscall.Close(fd)
+	var st syscall.Stat_t
+	err = syscall.Fstat(fd, &st)
+	if err != nil {
+		return &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	attr := landlockPathBeneathAttr{allowedAccess: fileAccess, parentFd: int32(fd)}
+	if st.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		attr.allowedAccess = dirAccess
+	}
+	_, _, e := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0)
+	if e != 0 {
+		return fmt.Errorf("cannot add Landlock rule for %s: %s", path, e.Error())
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package pt
+
+var sandboxArch = &seccompArch{
+	audit:      0xc000003e, // AUDIT_ARCH_X86_64
+	sysSeccomp: 317,
+	x32:        true,
+	allowed: []uint32{
+		// Files and file descriptors.
+		0,   // read
+		1,   // write
+		19,  // readv
+		20,  // writev
+		17,  // pread64
+		18,  // pwrite64
+		295, // preadv
+		296, // pwritev
+		2,   // open
+		257, // openat
+		3,   // close
+		436, // close_range
+		8,   // lseek
+		4,   // stat
+		5,   // fstat
+		6,   // lstat
+		262, // newfstatat
+		332, // statx
+		137, // statfs
+		138, // fstatfs
+		217, // getdents64
+		89,  // readlink
+		267, // readlinkat
+		21,  // access
+		269, // faccessat
+		439, // faccessat2
+		74,  // fsync
+		75,  // fdatasync
+		77,  // ftruncate
+		82,  // rename
+		264, // renameat
+		316, // renameat2
+		87,  // unlink
+		263, // unlinkat
+		83,  // mkdir
+		258, // mkdirat
+		84,  // rmdir
+		91,  // fchmod
+		268, // fchmodat
+		280, // utimensat
+		73,  // flock
+		32,  // dup
+		33,  // dup2
+		292, // dup3
+		72,  // fcntl
+		16,  // ioctl
+		22,  // pipe
+		293, // pipe2
+		79,  // getcwd
+		95,  // umask
+		40,  // sendfile
+		275, // splice
+		326, // copy_file_range
+		// Memory.
+		12,  // brk
+		9,   // mmap
+		11,  // munmap
+		10,  // mprotect
+		25,  // mremap
+		28,  // madvise
+		27,  // mincore
+		324, // membarrier
+		// Threads and processes, without exec.
+		56,  // clone
+		435, // clone3
+		60,  // exit
+		231, // exit_group
+		202, // futex
+		273, // set_robust_list
+		274, // get_robust_list
+		218, // set_tid_address
+		334, // rseq
+		158, // arch_prctl
+		157, // prctl
+		24,  // sched_yield
+		204, // sched_getaffinity
+		309, // getcpu
+		39,  // getpid
+		110, // getppid
+		186, // gettid
+		234, // tgkill
+		200, // tkill
+		62,  // kill
+		61,  // wait4
+		247, // waitid
+		434, // pidfd_open
+		424, // pidfd_send_signal
+		102, // getuid
+		107, // geteuid
+		104, // getgid
+		108, // getegid
+		118, // getresuid
+		120, // getresgid
+		115, // getgroups
+		97,  // getrlimit
+		160, // setrlimit
+		302, // prlimit64
+		98,  // getrusage
+		63,  // uname
+		99,  // sysinfo
+		// Signals and timers.
+		13,  // rt_sigaction
+		14,  // rt_sigprocmask
+		15,  // rt_sigreturn
+		131, // sigaltstack
+		219, // restart_syscall
+		38,  // setitimer
+		36,  // getitimer
+		222, // timer_create
+		223, // timer_settime
+		224, // timer_gettime
+		226, // timer_delete
+		// Time.
+		228, // clock_gettime
+		229, // clock_getres
+		230, // clock_nanosleep
+		96,  // gettimeofday
+		35,  // nanosleep
+		201, // time
+		// Polling.
+		7,   // poll
+		271, // ppoll
+		23,  // select
+		270, // pselect6
+		213, // epoll_create
+		291, // epoll_create1
+		233, // epoll_ctl
+		232, // epoll_wait
+		281, // epoll_pwait
+		441, // epoll_pwait2
+		284, // eventfd
+		290, // eventfd2
+		283, // timerfd_create
+		286, // timerfd_settime
+		287, // timerfd_gettime
+		// Sockets and random numbers.
+		41,  // socket
+		53,  // socketpair
+		42,  // connect
+		43,  // accept
+		288, // accept4
+		49,  // bind
+		50,  // listen
+		48,  // shutdown
+		51,  // getsockname
+		52,  // getpeername
+		54,  // setsockopt
+		55,  // getsockopt
+		44,  // sendto
+		45,  // recvfrom
+		46,  // sendmsg
+		47,  // recvmsg
+		307, // sendmmsg
+		299, // recvmmsg
+		318, // getrandom
+	},
+}
@@ -0,0 +1,146 @@
+package pt
+
+var sandboxArch = &seccompArch{
+	audit:      0xc00000b7, // AUDIT_ARCH_AARCH64
+	sysSeccomp: 277,
+	allowed: []uint32{
+		// Files and file descriptors.
+		63,  // read
+		64,  // write
+		65,  // readv
+		66,  // writev
+		67,  // pread64
+		68,  // pwrite64
+		69,  // preadv
+		70,  // pwritev
+		56,  // openat
+		57,  // close
+		436, // close_range
+		62,  // lseek
+		80,  // fstat
+		79,  // newfstatat
+		291, // statx
+		43,  // statfs
+		44,  // fstatfs
+		61,  // getdents64
+		78,  // readlinkat
+		48,  // faccessat
+		439, // faccessat2
+		82,  // fsync
+		83,  // fdatasync
+		46,  // ftruncate
+		38,  // renameat
+		276, // renameat2
+		35,  // unlinkat
+		34,  // mkdirat
+		52,  // fchmod
+		53,  // fchmodat
+		88,  // utimensat
+		32,  // flock
+		23,  // dup
+		24,  // dup3
+		25,  // fcntl
+		29,  // ioctl
+		59,  // pipe2
+		17,  // getcwd
+		166, // umask
+		71,  // sendfile
+		76,  // splice
+		285, // copy_file_range
+		// Memory.
+		214, // brk
+		222, // mmap
+		215, // munmap
+		226, // mprotect
+		216, // mremap
+		233, // madvise
+		232, // mincore
+		283, // membarrier
+		// Threads and processes, without exec.
+		220, // clone
+		435, // clone3
+		93,  // exit
+		94,  // exit_group
+		98,  // futex
+		99,  // set_robust_list
+		100, // get_robust_list
+		96,  // set_tid_address
+		293, // rseq
+		167, // prctl
+		124, // sched_yield
+		123, // sched_getaffinity
+		168, // getcpu
+		172, // getpid
+		173, // getppid
+		178, // gettid
+		131, // tgkill
+		130, // tkill
+		129, // kill
+		260, // wait4
+		95,  // waitid
+		434, // pidfd_open
+		424, // pidfd_send_signal
+		174, // getuid
+		175, // geteuid
+		176, // getgid
+		177, // getegid
+		148, // getresuid
+		150, // getresgid
+		158, // getgroups
+		163, // getrlimit
+		164, // setrlimit
+		261, // prlimit64
+		165, // getrusage
+		160, // uname
+		179, // sysinfo
+		// Signals and timers.
+		134, // rt_sigaction
+		135, // rt_sigprocmask
+		139, // rt_sigreturn
+		132, // sigaltstack
+		128, // restart_syscall
+		103, // setitimer
+		102, // getitimer
+		107, // timer_create
+		110, // timer_settime
+		108, // timer_gettime
+		111, // timer_delete
+		// Time.
+		113, // clock_gettime
+		114, // clock_getres
+		115, // clock_nanosleep
+		169, // gettimeofday
+		101, // nanosleep
+		// Polling.
+		73,  // ppoll
+		72,  // pselect6
+		20,  // epoll_create1
+		21,  // epoll_ctl
+		22,  // epoll_pwait
+		441, // epoll_pwait2
+		19,  // eventfd2
+		85,  // timerfd_create
+		86,  // timerfd_settime
+		87,  // timerfd_gettime
+		// Sockets and random numbers.
+		198, // socket
+		199, // socketpair
+		203, // connect
+		202, // accept
+		242, // accept4
+		200, // bind
+		201, // listen
+		210, // shutdown
+		204, // getsockname
+		205, // getpeername
+		208, // setsockopt
+		209, // getsockopt
+		206, // sendto
+		207, // recvfrom
+		211, // sendmsg
+		212, // recvmsg
+		269, // sendmmsg
+		243, // recvmmsg
+		278, // getrandom
+	},
+}
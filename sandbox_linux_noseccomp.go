@@ -0,0 +1,7 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package pt
+
+// Seccomp filtering is not supported on this architecture.
+var sandboxArch *seccompArch
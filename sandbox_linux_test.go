@@ -0,0 +1,167 @@
+package pt
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	arch := &seccompArch{audit: 0xc000003e, x32: true, allowed: []uint32{0, 1}}
+	prog := seccompFilter(arch)
+	if len(prog) != 4+3+2 {
+		t.Fatalf("filter has %d instructions", len(prog))
+	}
+	allow := len(prog) - 1
+	deny := allow - 1
+	if prog[allow].k != seccompRetAllow || prog[deny].k != seccompRetErrno|uint32(syscall.EPERM) {
+		t.Errorf("unexpected returns %+v %+v", prog[deny], prog[allow])
+	}
+	if 4+1+int(prog[4].jt) != deny || prog[4].k != 0x40000000 {
+		t.Errorf("x32 check %+v does not jump to the deny", prog[4])
+	}
+	for i := 5; i < deny; i++ {
+		if i+1+int(prog[i].jt) != allow || prog[i].jf != 0 {
+			t.Errorf("check %d %+v does not jump to the allow", i, prog[i])
+		}
+	}
+	if prog[5].k != 0 || prog[6].k != 1 {
+		t.Errorf("unexpected checks %+v", prog[4:7])
+	}
+}
+
+// TestSandboxHelperProcess is not a real test. It is run as a subprocess by
+// TestSandbox, which must not apply the sandbox to the test process itself.
+func TestSandboxHelperProcess(t *testing.T) {
+	dir := os.Getenv("PT_SANDBOX_HELPER_DIR")
+	if dir == "" {
+		return
+	}
+	defer os.Exit(0)
+	fail := func(msg string) {
+		os.Stdout.WriteString("FAIL " + msg + "\n")
+		os.Exit(1)
+	}
+	s := NewServerSandbox(&ServerInfo{AuthCookiePath: filepath.Join(dir, "cookie", "auth_cookie")})
+	s.ReadPaths = append(s.ReadPaths, filepath.Join(dir, "allowed"))
+	s.WritePaths = append(s.WritePaths, filepath.Join(dir, "state"))
+	landlock, err := applySandbox(s)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	// Wait for TestSandbox to replace the cookie.
+	os.Stdout.WriteString("READY\n")
+	_, err = bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fail(err.Error())
+	}
+	cookie, err := ioutil.ReadFile(filepath.Join(dir, "cookie", "auth_cookie"))
+	if err != nil {
+		fail(err.Error())
+	}
+	if string(cookie) != "new" {
+		fail(fmt.Sprintf("read cookie %q", cookie))
+	}
+
+	err = exec.Command("/bin/sh", "-c", "true").Run()
+	if err == nil || !strings.Contains(err.Error(), syscall.EPERM.Error()) {
+		fail("exec was not denied: " + fmt.Sprint(err))
+	}
+
+	if landlock {
+		_, err = ioutil.ReadFile(filepath.Join(dir, "allowed"))
+		if err != nil {
+			fail(err.Error())
+		}
+		_, err = ioutil.ReadFile(filepath.Join(dir, "denied"))
+		if err == nil {
+			fail("reading a file outside the sandbox was allowed")
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, "state", "file"), []byte("x"), 0600)
+		if err != nil {
+			fail(err.Error())
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0600)
+		if err == nil {
+			fail("writing a file outside the sandbox was allowed")
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	if sandboxArch == nil {
+		t.Skip("seccomp filtering is not supported")
+	}
+	dir, err := ioutil.TempDir("", "sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"allowed", "denied"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"state", "cookie"} {
+		err = os.Mkdir(filepath.Join(dir, name), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cookiePath := filepath.Join(dir, "cookie", "auth_cookie")
+	err = ioutil.WriteFile(cookiePath, []byte("old"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSandboxHelperProcess$")
+	cmd.Env = append(os.Environ(), "PT_SANDBOX_HELPER_DIR="+dir)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The helper may log before it is ready.
+	r := bufio.NewReader(stdout)
+	var out string
+	for {
+		line, err := r.ReadString('\n')
+		out += line
+		if err != nil || line == "READY\n" {
+			break
+		}
+	}
+	if strings.HasSuffix(out, "READY\n") {
+		// Replace the cookie the way tor does, after the sandbox is
+		// applied.
+		err = ioutil.WriteFile(cookiePath+".tmp", []byte("new"), 0600)
+		if err == nil {
+			err = os.Rename(cookiePath+".tmp", cookiePath)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+		stdin.Write([]byte("\n"))
+	}
+	stdin.Close()
+	rest, _ := ioutil.ReadAll(r)
+	err = cmd.Wait()
+	if err != nil {
+		t.Fatalf("%s\n%s%s", err, out, rest)
+	}
+}
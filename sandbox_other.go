@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package pt
+
+import "errors"
+
+func applySandbox(s *Sandbox) (bool, error) {
+	return false, errors.New("sandbox is not supported on this system")
+}
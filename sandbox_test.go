@@ -0,0 +1,18 @@
+package pt
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewServerSandbox(t *testing.T) {
+	os.Setenv("TOR_PT_STATE_LOCATION", "/var/lib/tor/pt_state/")
+	defer os.Unsetenv("TOR_PT_STATE_LOCATION")
+	s := NewServerSandbox(&ServerInfo{AuthCookiePath: "/var/lib/tor/extended_orport_auth_cookie"})
+	if !stringSlicesEqual(s.ReadPaths, []string{"/var/lib/tor"}) {
+		t.Errorf("unexpected ReadPaths %q", s.ReadPaths)
+	}
+	if !stringSlicesEqual(s.WritePaths, []string{"/var/lib/tor/pt_state"}) {
+		t.Errorf("unexpected WritePaths %q", s.WritePaths)
+	}
+}
@@ -0,0 +1,92 @@
+package pt
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// If SystemdNotify is true, the library reports the transport's state to the
+// service manager over the socket named by NOTIFY_SOCKET, as described in
+// sd_notify(3): READY=1 after CmethodsDone or SmethodsDone, and STOPPING=1 when
+// HandleShutdownSignals begins shutting down. If the service manager asks for
+// watchdog keep-alives with WATCHDOG_USEC, the library sends WATCHDOG=1 at half
+// that interval from the time the transport is ready. There is no effect when
+// NOTIFY_SOCKET is unset, as when the transport is run by tor.
+var SystemdNotify bool
+
+var watchdogOnce sync.Once
+
+// Send state, a newline-separated list of assignments such as "READY=1", to the
+// service manager. Returns false, with no error, if NOTIFY_SOCKET is unset.
+func SdNotify(state string) (bool, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false, nil
+	}
+	// A leading '@' means the abstract namespace.
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Send state if SystemdNotify is set, logging any error.
+func sdNotify(state string) {
+	if !SystemdNotify {
+		return
+	}
+	_, err := SdNotify(state)
+	if err != nil {
+		Log(LogSeverityWarning, "cannot notify service manager: "+err.Error())
+	}
+}
+
+// Tell the service manager that the transport is ready, and start the watchdog
+// if one is requested.
+func sdNotifyReady() {
+	if !SystemdNotify {
+		return
+	}
+	sdNotify("READY=1")
+	interval := watchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"), os.Getpid())
+	if interval > 0 {
+		watchdogOnce.Do(func() {
+			go func() {
+				for range time.Tick(interval) {
+					sdNotify("WATCHDOG=1")
+				}
+			}()
+		})
+	}
+}
+
+// Return the interval at which to send watchdog keep-alives, half of the
+// timeout in usec, or 0 if no watchdog is requested for the process pid.
+func watchdogInterval(usec, watchdogPID string, pid int) time.Duration {
+	if usec == "" {
+		return 0
+	}
+	if watchdogPID != "" {
+		p, err := strconv.Atoi(watchdogPID)
+		if err != nil || p != pid {
+			return 0
+		}
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Microsecond / 2
+}
@@ -0,0 +1,70 @@
+package pt
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	ok, err := SdNotify("READY=1")
+	if ok || err != nil {
+		t.Errorf("with no NOTIFY_SOCKET: got %v %v", ok, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported on Windows")
+	}
+	name := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", name)
+
+	saved := SystemdNotify
+	SystemdNotify = true
+	defer func() { SystemdNotify = saved }()
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	SmethodsDone()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p := make([]byte, 100)
+	n, err := conn.Read(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p[:n]) != "READY=1" {
+		t.Errorf("got %q, expected %q", p[:n], "READY=1")
+	}
+	if buf.String() != "SMETHODS DONE\n" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		expected  time.Duration
+	}{
+		{"", "", 0},
+		{"10000000", "", 5 * time.Second},
+		{"10000000", "1234", 5 * time.Second},
+		{"10000000", "99", 0},
+		{"0", "", 0},
+		{"x", "", 0},
+	}
+	for _, test := range tests {
+		if d := watchdogInterval(test.usec, test.pid, 1234); d != test.expected {
+			t.Errorf("%q %q → %v (expected %v)", test.usec, test.pid, d, test.expected)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package pt
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Run a server transport. RunServer calls ServerSetup, then, for each Bindaddr
+// requested by tor, opens a TCP listener on the bind address if there is a
+// handler for the method in handlers, and emits SMETHOD or SMETHOD-ERROR
+// accordingly, followed by SMETHODS DONE. For each accepted connection, it
+// calls the method's handler, which must remove the transport's obfuscation
+// and return a connection that carries the unobfuscated data; it then connects
+// to tor with DialOr and relays data between the two connections until both
+// sides are closed. Finally it waits for a signal to shut down, as
+// HandleShutdownSignals does.
+//
+//	func main() {
+//		err := pt.RunServer(map[string]func(net.Conn) (net.Conn, error){
+//			"foo": unwrapFoo,
+//		})
+//		if err != nil {
+//			os.Exit(1)
+//		}
+//	}
+//
+// A handler that returns an error causes the client connection to be closed.
+// Handlers are called in their own goroutines and may block. Returns an error
+// if setup fails; otherwise, returns when shutdown is complete.
+func RunServer(handlers map[string]func(net.Conn) (net.Conn, error)) error {
+	err := runServer(DefaultShutdownManager, handlers)
+	if err != nil {
+		return err
+	}
+	HandleReloadSignals(DefaultShutdownManager)
+	HandleDiagnosticsSignals(DefaultShutdownManager)
+	HandleShutdownSignals(DefaultShutdownManager, 0)
+	return nil
+}
+
+// Do the setup part of RunServer, with listeners and connections tracked by m.
+func runServer(m *ShutdownManager, handlers map[string]func(net.Conn) (net.Conn, error)) error {
+	info, err := ServerSetup(nil)
+	if err != nil {
+		return err
+	}
+	startORPoolFor(m, &info)
+	startORHealthCheckFor(m, &info)
+
+	var mux ServerMux
+	for methodName, unwrap := range handlers {
+		methodName, unwrap := methodName, unwrap
+		mux.Handle(methodName, func(bindaddr Bindaddr) (net.Listener, error) {
+			if ListenersPerBindaddr > 1 {
+				lns, err := bindaddr.ListenReusePort(ListenersPerBindaddr)
+				if err != nil {
+					return nil, err
+				}
+				tlns := make([]net.Listener, 0, len(lns))
+				for _, ln := range lns {
+					tln, err := m.TrackListener(DefaultAdmissionControl.Wrap(ln))
+					if err != nil {
+						for _, tln := range tlns {
+							tln.Close()
+						}
+						for _, ln := range lns[len(tlns):] {
+							ln.Close()
+						}
+						return nil, err
+					}
+					tlns = append(tlns, tln)
+				}
+				for _, tln := range tlns {
+					go serverAcceptLoop(tln, &info, methodName, unwrap)
+				}
+				return lns[0], nil
+			}
+			ln, err := bindaddr.Listen()
+			if err != nil {
+				return nil, err
+			}
+			tln, err := m.TrackListener(DefaultAdmissionControl.Wrap(ln))
+			if err != nil {
+				ln.Close()
+				return nil, err
+			}
+			go serverAcceptLoop(tln, &info, methodName, unwrap)
+			return ln, nil
+		})
+	}
+	mux.Listen(info.Bindaddrs)
+	return nil
+}
+
+// Accept connections from ln and relay each one to the ORPort, with
+// DefaultWorkerPool if it is set. If unwrap is not nil, it is applied to each
+// connection first.
+func serverAcceptLoop(ln net.Listener, info *ServerInfo, methodName string, unwrap func(net.Conn) (net.Conn, error)) error {
+	handler := func(conn net.Conn) {
+		serverHandler(conn, info, methodName, unwrap)
+	}
+	if DefaultWorkerPool != nil {
+		return DefaultWorkerPool.AcceptLoop(ln, handler)
+	}
+	return AcceptLoop(ln, 0, handler)
+}
+
+// Handle a connection accepted by a server transport's own accept loop as
+// RunServer would: apply unwrap, if not nil, connect to tor with DialOrContext,
+// and relay data between the two connections, with the timeouts, bandwidth
+// limits, statistics, and tracing of RunServer, until both sides are closed.
+// conn is closed before HandleServerConn returns. Returns an error if unwrap or
+// DialOrContext fails.
+func HandleServerConn(conn net.Conn, info *ServerInfo, methodName string, unwrap func(net.Conn) (net.Conn, error)) error {
+	return serverHandler(conn, info, methodName, unwrap)
+}
+
+func serverHandler(conn net.Conn, info *ServerInfo, methodName string, unwrap func(net.Conn) (net.Conn, error)) error {
+	accepted := time.Now()
+	defer conn.Close()
+	// The client's address, which an unwrapped conn may not have.
+	remoteAddr := conn.RemoteAddr()
+	if unwrap != nil {
+		release := acquireHandshake()
+		c, err := unwrap(conn)
+		release()
+		if err != nil {
+			if methodLogEnabled(methodName, LogSeverityDebug) {
+				LogMethod(methodName, LogSeverityDebug, fmt.Sprintf("handshake with %s failed: %s", SafeAddr(conn.RemoteAddr()), err.Error()))
+			}
+			return err
+		}
+		defer c.Close()
+		conn = c
+	}
+	ctx, cancel := newConnContext(methodName, remoteAddr, nil, accepted)
+	defer cancel()
+	or, err := DialOrContext(ctx, info, remoteAddr.String(), methodName)
+	if err != nil {
+		if methodLogEnabled(methodName, LogSeverityDebug) {
+			LogMethod(methodName, LogSeverityDebug, "cannot connect to ORPort: "+err.Error())
+		}
+		return err
+	}
+	defer or.Close()
+
+	relayTraced(ctx, conn, or)
+
+	return nil
+}
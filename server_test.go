@@ -0,0 +1,236 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A connection whose incoming bytes are XORed with a constant.
+type xorConn struct {
+	net.Conn
+	key byte
+}
+
+func (c *xorConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.key
+	}
+	return n, err
+}
+
+func TestRunServer(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_SERVER_TRANSPORTS", "xor,bogus")
+	os.Setenv("TOR_PT_SERVER_BINDADDR", "xor-127.0.0.1:0,bogus-127.0.0.1:0")
+	os.Setenv("TOR_PT_ORPORT", echo.Addr().String())
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := runServer(m, map[string]func(net.Conn) (net.Conn, error){
+		"xor": func(conn net.Conn) (net.Conn, error) {
+			return &xorConn{conn, 0xff}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "SMETHOD-ERROR bogus unknown-method: no such method\n") ||
+		!strings.HasSuffix(buf.String(), "SMETHODS DONE\n") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "SMETHOD xor "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte{'a' ^ 0xff, 'b' ^ 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 2)
+	_, err = io.ReadFull(conn, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "ab" {
+		t.Errorf("got %q (expected %q)", p, "ab")
+	}
+}
+
+func TestRunServerUnwrapError(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_SERVER_TRANSPORTS", "foo")
+	os.Setenv("TOR_PT_SERVER_BINDADDR", "foo-127.0.0.1:0")
+	os.Setenv("TOR_PT_ORPORT", echo.Addr().String())
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := runServer(m, map[string]func(net.Conn) (net.Conn, error){
+		"foo": func(conn net.Conn) (net.Conn, error) {
+			return nil, fmt.Errorf("bad handshake")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "SMETHOD foo "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("expected EOF after handler error, got %v", err)
+	}
+}
+
+// The USERADDR is the address of the accepted connection, not of the one
+// returned by unwrap.
+func TestHandleServerConnUnwrapAddr(t *testing.T) {
+	cookie := make([]byte, 32)
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	writeTestAuthCookie(t, cookiePath, cookie, time.Now())
+	var accepted int32
+	extOr, userAddrs := startFakeExtOrPort(t, cookie, &accepted)
+	defer extOr.Close()
+	info := &ServerInfo{
+		ExtendedOrAddr: extOr.Addr().(*net.TCPAddr),
+		AuthCookiePath: cookiePath,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner, peer := net.Pipe()
+	defer peer.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- HandleServerConn(conn, info, "foo", func(net.Conn) (net.Conn, error) {
+			return inner, nil
+		})
+	}()
+	if addr := <-userAddrs; addr != client.LocalAddr().String() {
+		t.Errorf("got USERADDR %q, expected %q", addr, client.LocalAddr())
+	}
+	peer.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Start a server that writes "hello" to each connection and half-closes it,
+// then reads the connection to EOF and sends what it read on the returned
+// channel.
+func startHalfClosingServer(t *testing.T) (net.Listener, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("hello"))
+				conn.(*net.TCPConn).CloseWrite()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				request, _ := ioutil.ReadAll(conn)
+				received <- string(request)
+			}()
+		}
+	}()
+	return ln, received
+}
+
+// Check that conn reads "hello" and EOF, and can still write to the server
+// of startHalfClosingServer, through whatever connections are between them.
+func checkHalfClose(t *testing.T, conn *net.TCPConn, received <-chan string) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	hello, err := ioutil.ReadAll(conn)
+	if err != nil || string(hello) != "hello" {
+		t.Fatalf("read %q %v (expected %q)", hello, err, "hello")
+	}
+	_, err = conn.Write([]byte("request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.CloseWrite()
+	if request := <-received; request != "request" {
+		t.Errorf("server read %q (expected %q)", request, "request")
+	}
+}
+
+// A half-close by the ORPort passes through the listener wrappers of RunServer
+// to the client, which still gets to write.
+func TestRunServerHalfClose(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	server, received := startHalfClosingServer(t)
+	defer server.Close()
+	defer func() { DefaultAdmissionControl = nil }()
+	DefaultAdmissionControl = &AdmissionControl{MaxConns: 10}
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_SERVER_TRANSPORTS", "plain")
+	os.Setenv("TOR_PT_SERVER_BINDADDR", "plain-127.0.0.1:0")
+	os.Setenv("TOR_PT_ORPORT", server.Addr().String())
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := runServer(m, map[string]func(net.Conn) (net.Conn, error){
+		"plain": nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", findMethodAddr(t, buf.String(), "SMETHOD plain "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	checkHalfClose(t, conn, received)
+}
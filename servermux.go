@@ -0,0 +1,73 @@
+package pt
+
+import (
+	"net"
+)
+
+// A ListenFunc opens the listener for one server bindaddr. It is registered
+// with a ServerMux.
+type ListenFunc func(bindaddr Bindaddr) (net.Listener, error)
+
+// ServerMux opens the listeners of a server transport and reports them to tor.
+// It is for programs that accept and handle connections themselves, but want
+// the bookkeeping of SMETHOD lines done for them. Register a ListenFunc for
+// each supported method with Handle, then pass the Bindaddrs from ServerSetup to
+// Listen:
+//
+//	var mux pt.ServerMux
+//	mux.Handle("foo", func(bindaddr pt.Bindaddr) (net.Listener, error) {
+//		return bindaddr.Listen()
+//	})
+//	listeners := mux.Listen(ptInfo.Bindaddrs)
+//	for _, ln := range listeners {
+//		go acceptLoop(ln)
+//	}
+//
+// The zero value is an empty ServerMux ready to use.
+type ServerMux struct {
+	funcs map[string]ListenFunc
+}
+
+// Register f as the ListenFunc for methodName, replacing any previous one.
+func (mux *ServerMux) Handle(methodName string, f ListenFunc) {
+	if mux.funcs == nil {
+		mux.funcs = make(map[string]ListenFunc)
+	}
+	mux.funcs[methodName] = f
+}
+
+// For each of bindaddrs, call the ListenFunc registered for its method, and
+// emit an SMETHOD line with the listener's address, or an SMETHOD-ERROR line if
+// there is no ListenFunc for the method or if the ListenFunc returns an error.
+// If a listener implements ServerArgser and its ServerArgs are not nil, they are
+// emitted in the SMETHOD line's ARGS option. Finally, emit SMETHODS DONE.
+//
+// Returns the listeners that were opened, in the order of bindaddrs. Closing
+// them is the caller's responsibility.
+func (mux *ServerMux) Listen(bindaddrs []Bindaddr) []net.Listener {
+	var listeners []net.Listener
+	for _, bindaddr := range bindaddrs {
+		f, ok := mux.funcs[bindaddr.MethodName]
+		if !ok {
+			SmethodErrorReason(bindaddr.MethodName, ReasonUnknownMethod, "no such method")
+			continue
+		}
+		ln, err := f(bindaddr)
+		if err != nil {
+			SmethodErrorReason(bindaddr.MethodName, ReasonBindFailed, err.Error())
+			continue
+		}
+		var args Args
+		if a, ok := ln.(ServerArgser); ok {
+			args = a.ServerArgs()
+		}
+		if args != nil {
+			SmethodArgs(bindaddr.MethodName, ln.Addr(), args)
+		} else {
+			Smethod(bindaddr.MethodName, ln.Addr())
+		}
+		listeners = append(listeners, ln)
+	}
+	SmethodsDone()
+	return listeners
+}
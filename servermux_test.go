@@ -0,0 +1,70 @@
+package pt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// A net.Listener that implements ServerArgser.
+type argsListener struct {
+	net.Listener
+	args Args
+}
+
+func (ln argsListener) ServerArgs() Args {
+	return ln.args
+}
+
+func TestServerMux(t *testing.T) {
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	var mux ServerMux
+	mux.Handle("alpha", func(bindaddr Bindaddr) (net.Listener, error) {
+		return bindaddr.Listen()
+	})
+	mux.Handle("beta", func(bindaddr Bindaddr) (net.Listener, error) {
+		return nil, errors.New("beta failed")
+	})
+	mux.Handle("gamma", func(bindaddr Bindaddr) (net.Listener, error) {
+		ln, err := bindaddr.Listen()
+		if err != nil {
+			return nil, err
+		}
+		return argsListener{ln, Args{"key": []string{"value"}}}, nil
+	})
+
+	bindaddrs, err := ParseBindaddrs("alpha-127.0.0.1:0,beta-127.0.0.1:0,gamma-127.0.0.1:0,delta-127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners := mux.Listen(bindaddrs)
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+	if len(listeners) != 2 {
+		t.Fatalf("got %d listeners", len(listeners))
+	}
+	expected := fmt.Sprintf("SMETHOD alpha %s\n", listeners[0].Addr()) +
+		"SMETHOD-ERROR beta bind-failed: beta failed\n" +
+		fmt.Sprintf("SMETHOD gamma %s ARGS:key=value\n", listeners[1].Addr()) +
+		"SMETHOD-ERROR delta unknown-method: no such method\n" +
+		"SMETHODS DONE\n"
+	if buf.String() != expected {
+		t.Errorf("got output %q, expected %q", buf.String(), expected)
+	}
+
+	// An empty ServerMux still emits SMETHODS DONE.
+	buf.Reset()
+	var empty ServerMux
+	if len(empty.Listen(nil)) != 0 || buf.String() != "SMETHODS DONE\n" {
+		t.Errorf("unexpected output from empty ServerMux: %q", buf.String())
+	}
+}
@@ -0,0 +1,36 @@
+package pt
+
+import (
+	"os"
+)
+
+// A request to stop from a service manager, delivered to HandleShutdownSignals
+// as if it were a signal.
+type serviceControl string
+
+func (c serviceControl) String() string {
+	return string(c)
+}
+
+func (c serviceControl) Signal() {}
+
+// Stop requests from the Windows service control manager, received while
+// RunWindowsService is running. HandleShutdownSignals treats each one as it
+// does SIGTERM.
+var serviceControls = make(chan os.Signal, 2)
+
+// Pass requests from serviceControls to sigChan until done is closed.
+func forwardServiceControls(sigChan chan<- os.Signal, done <-chan struct{}) {
+	for {
+		select {
+		case sig := <-serviceControls:
+			select {
+			case sigChan <- sig:
+			case <-done:
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package pt
+
+// Call run. There is no Windows service control manager on this platform.
+func RunWindowsService(name string, run func() error) error {
+	return run()
+}
@@ -0,0 +1,29 @@
+package pt
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// A stop request from the service control manager shuts down as a signal does.
+func TestHandleShutdownSignalsServiceControl(t *testing.T) {
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- HandleShutdownSignals(m, 0)
+	}()
+	serviceControls <- serviceControl("service stop request")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("HandleShutdownSignals returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleShutdownSignals did not return after a stop request")
+	}
+	if !m.ShuttingDown() {
+		t.Error("not shutting down after a stop request")
+	}
+}
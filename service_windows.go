@@ -0,0 +1,195 @@
+package pt
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                         = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW  = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerEx = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus             = advapi32.NewProc("SetServiceStatus")
+)
+
+// Constants from winsvc.h and winerror.h.
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 1
+	serviceAcceptShutdown = 4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented             = 120
+	errorFailedServiceControllerConnect = 1063
+
+	// How long the service control manager is told to wait for each step
+	// of stopping.
+	serviceStopWaitHint = 30000 // milliseconds
+)
+
+// SERVICE_STATUS.
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// SERVICE_TABLE_ENTRYW.
+type serviceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+// The state of the running service. There is only one per process.
+var service struct {
+	sync.Mutex
+	name   *uint16
+	run    func() error
+	handle uintptr
+	status serviceStatus
+	err    error
+}
+
+var (
+	serviceCallbacksOnce sync.Once
+	serviceMainCallback  uintptr
+	serviceCtrlCallback  uintptr
+)
+
+// Run run as the Windows service called name, so that the service control
+// manager's stop and shutdown requests begin a graceful shutdown: the request
+// is delivered to HandleShutdownSignals (and so to RunClient, RunServer,
+// ServeTransports, and the standalone modes) as SIGTERM would be. The service
+// is reported as running when run is called, as stopping while connections
+// finish, and as stopped, with an error exit code if run returned an error,
+// when run returns, which it must do once shutdown is complete:
+//
+//	err := pt.RunWindowsService("obfs4proxy", func() error {
+//		return pt.RunServerStandalone(config, handlers)
+//	})
+//
+// If the process was not started by the service control manager, as when it
+// is run from a console, run is called directly. Returns the error from run.
+func RunWindowsService(name string, run func() error) error {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	serviceCallbacksOnce.Do(func() {
+		serviceMainCallback = syscall.NewCallback(serviceMain)
+		serviceCtrlCallback = syscall.NewCallback(serviceCtrlHandler)
+	})
+	service.Lock()
+	service.name = namePtr
+	service.run = run
+	service.err = nil
+	service.Unlock()
+
+	table := []serviceTableEntry{
+		{ServiceName: namePtr, ServiceProc: serviceMainCallback},
+		{},
+	}
+	// StartServiceCtrlDispatcherW returns after serviceMain has returned.
+	r, _, e := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 {
+		if e == syscall.Errno(errorFailedServiceControllerConnect) {
+			return run()
+		}
+		return fmt.Errorf("StartServiceCtrlDispatcher: %s", e.Error())
+	}
+	service.Lock()
+	defer service.Unlock()
+	return service.err
+}
+
+// Set the state of the service and report it to the service control manager.
+func setServiceState(state uint32, exitCode uint32) {
+	service.Lock()
+	defer service.Unlock()
+	service.status.ServiceType = serviceWin32OwnProcess
+	service.status.CurrentState = state
+	service.status.Win32ExitCode = exitCode
+	service.status.ControlsAccepted = 0
+	service.status.WaitHint = 0
+	switch state {
+	case serviceRunning:
+		service.status.ControlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+		service.status.CheckPoint = 0
+	case serviceStartPending, serviceStopPending:
+		service.status.CheckPoint++
+		service.status.WaitHint = serviceStopWaitHint
+	default:
+		service.status.CheckPoint = 0
+	}
+	procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&service.status)))
+}
+
+// The ServiceMain function, called by the service control manager on a thread
+// of its own.
+func serviceMain(argc uint32, argv **uint16) uintptr {
+	service.Lock()
+	name, run := service.name, service.run
+	service.Unlock()
+	h, _, e := procRegisterServiceCtrlHandlerEx.Call(uintptr(unsafe.Pointer(name)), serviceCtrlCallback, 0)
+	if h == 0 {
+		service.Lock()
+		service.err = fmt.Errorf("RegisterServiceCtrlHandlerEx: %s", e.Error())
+		service.Unlock()
+		return 0
+	}
+	service.Lock()
+	service.handle = h
+	service.Unlock()
+
+	setServiceState(serviceStartPending, 0)
+	setServiceState(serviceRunning, 0)
+	err := func() (err error) {
+		defer recoverPanic("Windows service", func() { err = fmt.Errorf("panic in Windows service") })
+		return run()
+	}()
+	service.Lock()
+	service.err = err
+	service.Unlock()
+	var exitCode uint32
+	if err != nil {
+		exitCode = 1
+	}
+	setServiceState(serviceStopped, exitCode)
+	return 0
+}
+
+// The HandlerEx function, called by the service control manager for each
+// control request.
+func serviceCtrlHandler(control, eventType uint32, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceState(serviceStopPending, 0)
+		name := "service stop request"
+		if control == serviceControlShutdown {
+			name = "system shutdown request"
+		}
+		select {
+		case serviceControls <- serviceControl(name):
+		default:
+		}
+		return 0
+	case serviceControlInterrogate:
+		return 0
+	}
+	return errorCallNotImplemented
+}
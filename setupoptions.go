@@ -0,0 +1,224 @@
+package pt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A SetupOption changes the behavior of ClientSetupOptions or
+// ServerSetupOptions, for that call only.
+type SetupOption func(*setupOptions)
+
+type setupOptions struct {
+	methods     []string
+	versions    map[string]func(version string) error
+	output      io.Writer
+	environ     []string
+	haveEnviron bool
+	strict      bool
+}
+
+// Use only the named methods. Requested methods not in names are removed from
+// ClientInfo.MethodNames or ServerInfo.Bindaddrs, and a CMETHOD-ERROR or
+// SMETHOD-ERROR is emitted for each, so that tor does not wait for them.
+func WithMethods(names ...string) SetupOption {
+	return func(o *setupOptions) {
+		if o.methods == nil {
+			o.methods = []string{}
+		}
+		o.methods = append(o.methods, names...)
+	}
+}
+
+// Understand version of the managed transport protocol, calling handler if
+// it is negotiated, as with RegisterVersion.
+func WithVersionHandler(version string, handler func(version string) error) SetupOption {
+	return func(o *setupOptions) {
+		if o.versions == nil {
+			o.versions = make(map[string]func(version string) error)
+		}
+		o.versions[version] = handler
+	}
+}
+
+// Write the messages emitted during setup to w instead of Stdout. Messages
+// emitted afterward, such as CMETHOD and SMETHOD lines, still go to Stdout.
+func WithOutput(w io.Writer) SetupOption {
+	return func(o *setupOptions) {
+		o.output = w
+	}
+}
+
+// Read the TOR_PT_* variables from environ, a list of "key=value" strings as
+// returned by os.Environ, instead of from the process environment. If a key
+// appears more than once, the last value is used.
+func WithEnviron(environ []string) SetupOption {
+	return func(o *setupOptions) {
+		o.environ = environ
+		o.haveEnviron = true
+	}
+}
+
+// Warn about unknown and malformed TOR_PT_* variables, as if StrictEnv were
+// set.
+func WithStrictValidation() SetupOption {
+	return func(o *setupOptions) {
+		o.strict = true
+	}
+}
+
+// Return the value of key in environ, or "" if it is absent.
+func environGet(environ []string, key string) string {
+	var value string
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, key+"=") {
+			value = kv[len(key)+1:]
+		}
+	}
+	return value
+}
+
+func newSetupOptions(opts []SetupOption) *setupOptions {
+	o := &setupOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// The methods below implement the options for one setup, without changing
+// package variables that other goroutines may be using. They may be called on
+// a nil *setupOptions, which behaves as no options.
+
+// Get an environment variable, from the environment given by WithEnviron, or
+// else as getenv does.
+func (o *setupOptions) getenv(key string) string {
+	if o != nil && o.haveEnviron {
+		return environGet(o.environ, key)
+	}
+	return getenv(key)
+}
+
+// Like getenvRequired, but using o.getenv and o.envError.
+func (o *setupOptions) getenvRequired(key string) (string, error) {
+	value := o.getenv(key)
+	if value == "" {
+		return "", o.envError(fmt.Sprintf("no %s environment variable", key))
+	}
+	return value, nil
+}
+
+// Return the environment given by WithEnviron, or else ptEnviron().
+func (o *setupOptions) ptEnviron() []string {
+	if o != nil && o.haveEnviron {
+		return o.environ
+	}
+	return ptEnviron()
+}
+
+// Return the handler registered for version, by WithVersionHandler or by
+// RegisterVersion, and whether there is one.
+func (o *setupOptions) versionHandler(version string) (func(version string) error, bool) {
+	if o != nil {
+		if handler, ok := o.versions[version]; ok {
+			return handler, true
+		}
+	}
+	handler, ok := versionHandlers[version]
+	return handler, ok
+}
+
+// Return true if StrictEnv or WithStrictValidation is set.
+func (o *setupOptions) strictEnv() bool {
+	return StrictEnv || (o != nil && o.strict)
+}
+
+// Emit e to the output given by WithOutput, or else as emit does. An
+// EventReporter takes precedence over both.
+func (o *setupOptions) emit(e Event) {
+	if o == nil || o.output == nil || EventReporter != nil {
+		emit(e)
+		return
+	}
+	writeLine(o.output, e.Keyword, e.LineArgs)
+}
+
+// Like doError, but using o.emit.
+func (o *setupOptions) doError(e Event) *ptErr {
+	o.emit(e)
+	return &ptErr{e.Keyword, e.LineArgs}
+}
+
+// Like envError, but using o.emit.
+func (o *setupOptions) envError(msg string) error {
+	return o.doError(Event{Keyword: "ENV-ERROR", Message: msg, LineArgs: []string{msg}})
+}
+
+// Like versionError, but using o.emit.
+func (o *setupOptions) versionError(msg string) error {
+	return o.doError(Event{Keyword: "VERSION-ERROR", Message: msg, LineArgs: []string{msg}})
+}
+
+// Like Log, but using o.emit.
+func (o *setupOptions) log(severity logSeverity, message string) {
+	o.emit(logEvent(severity, message))
+}
+
+// Return true if name is allowed by the WithMethods options.
+func (o *setupOptions) allowsMethod(name string) bool {
+	for _, m := range o.methods {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Like ClientSetup, but with options:
+//
+//	info, err := pt.ClientSetupOptions(pt.WithMethods("obfs4"), pt.WithStrictValidation())
+func ClientSetupOptions(opts ...SetupOption) (info ClientInfo, err error) {
+	o := newSetupOptions(opts)
+	info, err = clientSetup(o)
+	if err != nil || o.methods == nil {
+		return
+	}
+	var methodNames []string
+	for _, methodName := range info.MethodNames {
+		if !o.allowsMethod(methodName) {
+			msg := "no such method"
+			o.doError(Event{Keyword: "CMETHOD-ERROR", MethodName: methodName, Reason: string(ReasonUnknownMethod), Message: msg,
+				LineArgs: []string{methodName, reasonMessage(ReasonUnknownMethod, msg)}})
+			continue
+		}
+		methodNames = append(methodNames, methodName)
+	}
+	info.MethodNames = methodNames
+	return info, nil
+}
+
+// Like ServerSetup, but with options:
+//
+//	info, err := pt.ServerSetupOptions(pt.WithMethods("obfs4"), pt.WithStrictValidation())
+func ServerSetupOptions(opts ...SetupOption) (info ServerInfo, err error) {
+	o := newSetupOptions(opts)
+	info, err = serverSetup(o)
+	if err != nil || o.methods == nil {
+		return
+	}
+	var bindaddrs []Bindaddr
+	for _, bindaddr := range info.Bindaddrs {
+		if !o.allowsMethod(bindaddr.MethodName) {
+			msg := "no such method"
+			o.doError(Event{Keyword: "SMETHOD-ERROR", MethodName: bindaddr.MethodName, Reason: string(ReasonUnknownMethod), Message: msg,
+				LineArgs: []string{bindaddr.MethodName, reasonMessage(ReasonUnknownMethod, msg)}})
+			continue
+		}
+		bindaddrs = append(bindaddrs, bindaddr)
+	}
+	info.Bindaddrs = bindaddrs
+	return info, nil
+}
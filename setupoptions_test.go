@@ -0,0 +1,107 @@
+package pt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestServerSetupOptions(t *testing.T) {
+	// The process environment must not be consulted.
+	t.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "")
+	t.Setenv("TOR_PT_SERVER_TRANSPORTS", "")
+
+	var called string
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	info, err := ServerSetupOptions(
+		WithEnviron([]string{
+			"TOR_PT_MANAGED_TRANSPORT_VER=1,7",
+			"TOR_PT_SERVER_TRANSPORTS=alpha",
+			"TOR_PT_SERVER_TRANSPORTS=alpha,beta",
+			"TOR_PT_SERVER_BINDADDR=alpha-127.0.0.1:1111,beta-127.0.0.1:2222",
+			"TOR_PT_ORPORT=127.0.0.1:9001",
+			"TOR_PT_SERVR_TRANSPORTS=typo",
+		}),
+		WithOutput(&buf),
+		WithVersionHandler("7", func(version string) error {
+			called = version
+			return nil
+		}),
+		WithMethods("beta"),
+		WithStrictValidation(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Stdout != savedStdout || StrictEnv || versionHandlers["7"] != nil {
+		t.Errorf("options were not undone")
+	}
+	if info.Version != "7" || called != "7" {
+		t.Errorf("negotiated %q, handler called with %q", info.Version, called)
+	}
+	if len(info.Bindaddrs) != 1 || info.Bindaddrs[0].MethodName != "beta" {
+		t.Errorf("unexpected Bindaddrs %+v", info.Bindaddrs)
+	}
+	output := buf.String()
+	for _, expected := range []string{
+		"VERSION 7\n",
+		"TOR_PT_SERVR_TRANSPORTS",
+		"SMETHOD-ERROR alpha unknown-method: no such method\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("output %q does not contain %q", output, expected)
+		}
+	}
+
+	// Without WithEnviron, the process environment is used again.
+	_, err = ServerSetupOptions(WithOutput(&buf))
+	if err == nil {
+		t.Errorf("setup with empty process environment succeeded")
+	}
+}
+
+// Setups with options may run at the same time as each other and as other
+// output, without their lines being mixed up.
+func TestSetupOptionsConcurrent(t *testing.T) {
+	var stdout bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &stdout
+	defer func() { Stdout = savedStdout }()
+
+	done := make(chan string)
+	for _, version := range []string{"1", "7"} {
+		go func(version string) {
+			var buf bytes.Buffer
+			_, err := ClientSetupOptions(
+				WithEnviron([]string{
+					"TOR_PT_MANAGED_TRANSPORT_VER=" + version,
+					"TOR_PT_CLIENT_TRANSPORTS=alpha",
+				}),
+				WithOutput(&buf),
+				WithVersionHandler("7", nil),
+			)
+			if err != nil {
+				t.Error(err)
+			}
+			done <- buf.String()
+		}(version)
+	}
+	go func() {
+		Log(LogSeverityNotice, "elsewhere")
+		done <- ""
+	}()
+	var outputs []string
+	for i := 0; i < 3; i++ {
+		if output := <-done; output != "" {
+			outputs = append(outputs, output)
+		}
+	}
+	if len(outputs) != 2 || (outputs[0] != "VERSION 1\n" && outputs[0] != "VERSION 7\n") ||
+		(outputs[1] != "VERSION 1\n" && outputs[1] != "VERSION 7\n") || outputs[0] == outputs[1] {
+		t.Errorf("unexpected setup output %q", outputs)
+	}
+	if stdout.String() != "LOG SEVERITY=notice MESSAGE=\"elsewhere\"\n" {
+		t.Errorf("unexpected Stdout %q", stdout.String())
+	}
+}
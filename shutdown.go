@@ -0,0 +1,272 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ShutdownManager keeps track of listeners and connections so that they can
+// all be shut down together. Listeners opened by ListenSocks, and the
+// connections they accept, are tracked by DefaultShutdownManager
+// automatically. Other listeners and connections may be added with
+// TrackListener and TrackConn.
+//
+// A typical server transport wraps each of its listeners:
+//
+//	ln, err := net.ListenTCP("tcp", bindaddr.Addr)
+//	if err != nil {
+//		pt.SmethodError(bindaddr.MethodName, err.Error())
+//		break
+//	}
+//	tln, err := pt.DefaultShutdownManager.TrackListener(ln)
+//	if err != nil {
+//		ln.Close()
+//		pt.SmethodError(bindaddr.MethodName, err.Error())
+//		break
+//	}
+//	go acceptLoop(tln)
+//
+// and, on receiving a signal, gives active connections some time to finish:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//	pt.Shutdown(ctx)
+//
+// The zero value is ready to use.
+type ShutdownManager struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closing   bool
+	// Closed when closing is true and there are no conns left.
+	drained chan struct{}
+	// Closed when closing becomes true. Made on demand by closed.
+	closingCh chan struct{}
+}
+
+// The ShutdownManager used by ListenSocks and by the package-level Shutdown
+// function.
+var DefaultShutdownManager = new(ShutdownManager)
+
+// Add ln to the set of tracked listeners. Returns false if m has already been
+// shut down.
+func (m *ShutdownManager) addListener(ln net.Listener) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closing {
+		return false
+	}
+	if m.listeners == nil {
+		m.listeners = make(map[net.Listener]struct{})
+	}
+	m.listeners[ln] = struct{}{}
+	return true
+}
+
+func (m *ShutdownManager) removeListener(ln net.Listener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.listeners, ln)
+}
+
+// Add c to the set of tracked connections. Returns false if m has already been
+// shut down.
+func (m *ShutdownManager) addConn(c net.Conn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closing {
+		return false
+	}
+	if m.conns == nil {
+		m.conns = make(map[net.Conn]struct{})
+	}
+	m.conns[c] = struct{}{}
+	return true
+}
+
+func (m *ShutdownManager) removeConn(c net.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conns, c)
+	m.checkDrained()
+}
+
+// Close m.drained if shutdown has started and no connections remain. m.mu must
+// be held.
+func (m *ShutdownManager) checkDrained() {
+	if !m.closing || len(m.conns) > 0 {
+		return
+	}
+	select {
+	case <-m.drained:
+	default:
+		close(m.drained)
+	}
+}
+
+// Return the number of tracked connections that have not yet been closed.
+func (m *ShutdownManager) ActiveConns() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns)
+}
+
+// Return the number of tracked listeners that have not yet been closed.
+func (m *ShutdownManager) ActiveListeners() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.listeners)
+}
+
+// Return a channel that is closed when Shutdown is called.
+func (m *ShutdownManager) closed() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closingCh == nil {
+		m.closingCh = make(chan struct{})
+		if m.closing {
+			close(m.closingCh)
+		}
+	}
+	return m.closingCh
+}
+
+// Return true iff Shutdown has been called.
+func (m *ShutdownManager) ShuttingDown() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closing
+}
+
+// ErrShuttingDown is returned by TrackListener and ListenSocks when Shutdown has
+// already been called.
+var ErrShuttingDown = errors.New("shutting down")
+
+// Track ln and every connection accepted from it. Closing the returned
+// listener stops tracking it; likewise closing an accepted connection. If m has
+// already been shut down, TrackListener returns nil and ErrShuttingDown, and
+// the caller remains responsible for closing ln.
+func (m *ShutdownManager) TrackListener(ln net.Listener) (net.Listener, error) {
+	tln := &trackedListener{Listener: ln, m: m}
+	if !m.addListener(tln) {
+		return nil, ErrShuttingDown
+	}
+	return tln, nil
+}
+
+// Track c as an active connection. Closing the returned net.Conn stops
+// tracking it. If m has already been shut down, c is closed immediately.
+func (m *ShutdownManager) TrackConn(c net.Conn) net.Conn {
+	tc := &trackedConn{Conn: c, m: m}
+	if !m.addConn(tc) {
+		c.Close()
+	}
+	return tc
+}
+
+// Shut down all tracked listeners and connections. Listeners are closed
+// immediately, so that no new connections are accepted. Shutdown then waits
+// for active connections to close on their own, until ctx is done, at which
+// point it closes the remaining connections itself and returns ctx.Err().
+// Returns nil if all connections closed before ctx was done. To close
+// everything without waiting, pass an already-canceled context.
+//
+// After Shutdown has been called, TrackListener fails, and connections passed
+// to TrackConn are closed immediately.
+func (m *ShutdownManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closing {
+		m.closing = true
+		m.drained = make(chan struct{})
+		if m.closingCh != nil {
+			close(m.closingCh)
+		}
+	}
+	listeners := make([]net.Listener, 0, len(m.listeners))
+	for ln := range m.listeners {
+		listeners = append(listeners, ln)
+	}
+	m.checkDrained()
+	drained := m.drained
+	m.mu.Unlock()
+
+	for _, ln := range listeners {
+		ln.Close()
+	}
+
+	select {
+	case <-drained:
+		return nil
+	default:
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	conns := make([]net.Conn, 0, len(m.conns))
+	for c := range m.conns {
+		conns = append(conns, c)
+	}
+	m.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return ctx.Err()
+}
+
+// Call Shutdown on DefaultShutdownManager.
+func Shutdown(ctx context.Context) error {
+	return DefaultShutdownManager.Shutdown(ctx)
+}
+
+type trackedListener struct {
+	net.Listener
+	m    *ShutdownManager
+	once sync.Once
+}
+
+func (ln *trackedListener) Accept() (net.Conn, error) {
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		tc := &trackedConn{Conn: c, m: ln.m}
+		if ln.m.addConn(tc) {
+			return tc, nil
+		}
+		// Shutting down; the listener is about to be closed, if it
+		// hasn't been already.
+		c.Close()
+	}
+}
+
+func (ln *trackedListener) Close() error {
+	ln.once.Do(func() {
+		ln.m.removeListener(ln)
+	})
+	return ln.Listener.Close()
+}
+
+type trackedConn struct {
+	net.Conn
+	m    *ShutdownManager
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.m.removeConn(c)
+	})
+	return c.Conn.Close()
+}
+
+// Half-close the underlying conn, if it supports it.
+func (c *trackedConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
@@ -0,0 +1,120 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// Accept one connection from a listener tracked by m and return both ends.
+func acceptTracked(t *testing.T, ln net.Listener) (client, server net.Conn) {
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestShutdownDrain(t *testing.T) {
+	m := new(ShutdownManager)
+	rawLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := m.TrackListener(rawLn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := acceptTracked(t, ln)
+	defer client.Close()
+	if m.ActiveConns() != 1 {
+		t.Fatalf("ActiveConns is %d (expected 1)", m.ActiveConns())
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.Close()
+	}()
+	err = m.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+	if m.ActiveConns() != 0 {
+		t.Errorf("ActiveConns is %d after Shutdown", m.ActiveConns())
+	}
+	if !m.ShuttingDown() {
+		t.Errorf("ShuttingDown is false after Shutdown")
+	}
+	_, err = ln.Accept()
+	if err == nil {
+		t.Errorf("Accept after Shutdown unexpectedly succeeded")
+	}
+}
+
+func TestShutdownForce(t *testing.T) {
+	m := new(ShutdownManager)
+	rawLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := m.TrackListener(rawLn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := acceptTracked(t, ln)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = m.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Shutdown returned %v (expected %v)", err, context.DeadlineExceeded)
+	}
+	// The server side must have been closed by Shutdown.
+	_, err = server.Write([]byte("x"))
+	if err == nil {
+		t.Errorf("Write after forced Shutdown unexpectedly succeeded")
+	}
+	if m.ActiveConns() != 0 {
+		t.Errorf("ActiveConns is %d after Shutdown", m.ActiveConns())
+	}
+}
+
+func TestShutdownTrackAfterShutdown(t *testing.T) {
+	m := new(ShutdownManager)
+	err := m.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown with nothing tracked returned %v", err)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	m.TrackConn(c1)
+	_, err = c1.Write([]byte("x"))
+	if err == nil {
+		t.Errorf("conn tracked after Shutdown was not closed")
+	}
+
+	rawLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawLn.Close()
+	ln, err := m.TrackListener(rawLn)
+	if ln != nil || err != ErrShuttingDown {
+		t.Errorf("TrackListener after Shutdown returned %v, %v", ln, err)
+	}
+
+	saved := DefaultShutdownManager
+	DefaultShutdownManager = m
+	defer func() { DefaultShutdownManager = saved }()
+	sln, err := ListenSocks("tcp", "127.0.0.1:0")
+	if sln != nil || err != ErrShuttingDown {
+		t.Errorf("ListenSocks after Shutdown returned %v, %v", sln, err)
+	}
+}
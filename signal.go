@@ -0,0 +1,81 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Wait for SIGTERM or SIGINT, then shut down m in the way that tor expects of
+// a pluggable transport. On the first signal, listeners are closed and active
+// connections are given time to finish; on a second signal, or after grace has
+// elapsed (if grace is greater than zero), the remaining connections are
+// closed. The function returns after all connections are closed, and main
+// should then return. Each step is reported with a LOG line.
+//
+// If TOR_PT_EXIT_ON_STDIN_CLOSE is set to "1", the parent process going away
+// (as reported by WatchParent) is treated the same as the first signal.
+// Under RunWindowsService, a stop or shutdown request from the service control
+// manager is treated the same as a signal.
+//
+//	ptInfo, err = pt.ServerSetup(nil)
+//	...
+//	pt.SmethodsDone()
+//	pt.HandleShutdownSignals(pt.DefaultShutdownManager, 0)
+//
+// Returns nil if all connections closed on their own, or an error if some had
+// to be closed forcibly.
+func HandleShutdownSignals(m *ShutdownManager, grace time.Duration) error {
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigChan)
+	done := make(chan struct{})
+	defer close(done)
+	go forwardServiceControls(sigChan, done)
+	var parentGone <-chan struct{}
+	if getenv("TOR_PT_EXIT_ON_STDIN_CLOSE") == "1" {
+		parentGone = WatchParent()
+	}
+	return handleShutdownSignals(m, grace, sigChan, parentGone)
+}
+
+func handleShutdownSignals(m *ShutdownManager, grace time.Duration, sigChan <-chan os.Signal, parentGone <-chan struct{}) error {
+	var reason string
+	select {
+	case sig := <-sigChan:
+		reason = "received " + sig.String()
+	case <-parentGone:
+		reason = "parent process exited"
+	}
+	Log(LogSeverityNotice, fmt.Sprintf("%s; closing listeners and waiting for %d connections to finish", reason, m.ActiveConns()))
+	sdNotify("STOPPING=1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if grace > 0 {
+		ctx, cancel = context.WithTimeout(ctx, grace)
+		defer cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer recoverPanic("shutdown", func() { done <- fmt.Errorf("panic during shutdown") })
+		done <- m.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			Log(LogSeverityNotice, "grace period expired; closed remaining connections and exiting")
+			return err
+		}
+		Log(LogSeverityNotice, "all connections finished; exiting")
+		return nil
+	case sig := <-sigChan:
+		Log(LogSeverityNotice, fmt.Sprintf("received second %s; closing remaining connections and exiting", sig))
+		cancel()
+		return <-done
+	}
+}
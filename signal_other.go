@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package pt
+
+import "os"
+
+var (
+	reloadSignals      []os.Signal
+	diagnosticsSignals []os.Signal
+)
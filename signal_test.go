@@ -0,0 +1,95 @@
+package pt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleShutdownSignalsDrain(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+
+	m := new(ShutdownManager)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	tc := m.TrackConn(c1)
+
+	sigChan := make(chan os.Signal, 2)
+	sigChan <- syscall.SIGTERM
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tc.Close()
+	}()
+	err := handleShutdownSignals(m, 0, sigChan, nil)
+	if err != nil {
+		t.Errorf("returned %v", err)
+	}
+	if !strings.Contains(buf.String(), "LOG SEVERITY=notice MESSAGE=\"received terminated; closing listeners and waiting for 1 connections to finish\"\n") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestHandleShutdownSignalsSecond(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+
+	m := new(ShutdownManager)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	m.TrackConn(c1)
+
+	sigChan := make(chan os.Signal, 2)
+	sigChan <- syscall.SIGINT
+	sigChan <- syscall.SIGINT
+	err := handleShutdownSignals(m, 0, sigChan, nil)
+	if err == nil {
+		t.Errorf("returned nil after second signal")
+	}
+	if m.ActiveConns() != 0 {
+		t.Errorf("ActiveConns is %d after second signal", m.ActiveConns())
+	}
+	if !strings.Contains(buf.String(), "received second interrupt") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestHandleShutdownSignalsGrace(t *testing.T) {
+	Stdout = ioutil.Discard
+
+	m := new(ShutdownManager)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	m.TrackConn(c1)
+
+	sigChan := make(chan os.Signal, 2)
+	sigChan <- syscall.SIGTERM
+	err := handleShutdownSignals(m, 50*time.Millisecond, sigChan, nil)
+	if err == nil {
+		t.Errorf("returned nil after grace period expired")
+	}
+	if m.ActiveConns() != 0 {
+		t.Errorf("ActiveConns is %d after grace period", m.ActiveConns())
+	}
+}
+
+func TestHandleShutdownSignalsParentGone(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+
+	m := new(ShutdownManager)
+	parentGone := make(chan struct{})
+	close(parentGone)
+	err := handleShutdownSignals(m, 0, make(chan os.Signal), parentGone)
+	if err != nil {
+		t.Errorf("returned %v", err)
+	}
+	if !strings.Contains(buf.String(), "MESSAGE=\"parent process exited; closing listeners") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
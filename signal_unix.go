@@ -0,0 +1,16 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package pt
+
+import (
+	"os"
+	"syscall"
+)
+
+// The signals that HandleReloadSignals treats as a request to reload.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// The signals that HandleDiagnosticsSignals treats as a request for a
+// diagnostics dump.
+var diagnosticsSignals = []os.Signal{syscall.SIGUSR1}
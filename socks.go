@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,8 +18,9 @@ const (
 	socksAuthUsernamePassword    = 0x02
 	socksAuthNoAcceptableMethods = 0xff
 
-	socksCmdConnect = 0x01
-	socksRsv        = 0x00
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+	socksRsv             = 0x00
 
 	socksAtypeV4         = 0x01
 	socksAtypeDomainName = 0x03
@@ -45,31 +49,85 @@ const (
 	SocksRepAddressNotSupported = 0x08
 )
 
-// Put a sanity timeout on how long we wait for a SOCKS request.
-const socksRequestTimeout = 5 * time.Second
+// The longest a SocksListener waits for a client to finish SOCKS negotiation
+// (method selection, authentication, and the request), unless its
+// HandshakeTimeout is set. A client that takes longer is disconnected, so that
+// a misbehaving client cannot keep AcceptSocks waiting.
+var SocksHandshakeTimeout = 5 * time.Second
+
+// The most bytes a client may send during SOCKS5 negotiation. It is the size of
+// the longest valid SOCKS5 negotiation: method selection with 255 methods,
+// RFC 1929 authentication with a 255-byte username and password, and a request
+// with a 255-byte domain name. SOCKS4a has its own limit,
+// socks4MaxHandshakeBytes.
+const socksMaxHandshakeBytes = (2 + 255) + (3 + 255 + 255) + (5 + 255 + 2)
 
 // SocksRequest describes a SOCKS request.
 type SocksRequest struct {
-	// The endpoint requested by the client as a "host:port" string.
+	// The endpoint requested by the client as a "host:port" string, as
+	// made by net.JoinHostPort, so an IPv6 address (or a domain name
+	// containing a colon) is in brackets.
 	Target string
+	// The host and port of Target, separately. Host is an IPv4 or IPv6
+	// address in textual form, without brackets, or a domain name exactly
+	// as the client sent it, not resolved.
+	Host string
+	Port int
 	// The userid string sent by the client.
 	Username string
 	// The password string sent by the client.
 	Password string
 	// The parsed contents of Username as a key–value mapping.
 	Args Args
+	// True if the request is a UDP ASSOCIATE rather than a CONNECT. In that
+	// case Target is the address from which the client expects to send
+	// datagrams, often "0.0.0.0:0", and the request must be answered with
+	// GrantUDP or Reject. UDP ASSOCIATE requests are only accepted by a
+	// SocksListener with EnableUDP set.
+	UDPAssociate bool
 }
 
 // SocksConn encapsulates a net.Conn and information associated with a SOCKS request.
 type SocksConn struct {
 	net.Conn
 	Req SocksRequest
+
+	closeOnce sync.Once
+	counters  *methodCounters
+	manager   *ShutdownManager
+	accepted  time.Time
+	// True if the request was SOCKS4a, so responses must be too.
+	socks4 bool
+}
+
+// Close the underlying net.Conn. The first call also removes the connection
+// from the active connection count reported by Stats, and from the
+// ShutdownManager tracking it, if any.
+func (conn *SocksConn) Close() error {
+	conn.closeOnce.Do(func() {
+		if conn.counters != nil {
+			atomic.AddInt64(&conn.counters.connsActive, -1)
+		}
+		if conn.manager != nil {
+			conn.manager.removeConn(conn)
+		}
+	})
+	return conn.Conn.Close()
+}
+
+// Half-close the underlying net.Conn, if it supports it, so that the client
+// reads EOF while still being able to write.
+func (conn *SocksConn) CloseWrite() error {
+	return closeWrite(conn.Conn)
 }
 
 // Send a message to the proxy client that access to the given address is
 // granted. Addr is ignored, and "0.0.0.0:0" is always sent back for
 // BND.ADDR/BND.PORT in the SOCKS response.
 func (conn *SocksConn) Grant(addr *net.TCPAddr) error {
+	if conn.socks4 {
+		return sendSocks4Response(conn, socks4Granted)
+	}
 	return sendSocks5ResponseGranted(conn)
 }
 
@@ -81,8 +139,12 @@ func (conn *SocksConn) Reject() error {
 }
 
 // Send a message to the proxy client that access was rejected, with the
-// specific error code indicating the reason behind the rejection.
+// specific error code indicating the reason behind the rejection. SOCKS4a has
+// only one code for rejection, which is sent whatever the reason.
 func (conn *SocksConn) RejectReason(reason byte) error {
+	if conn.socks4 {
+		return sendSocks4Response(conn, socks4Rejected)
+	}
 	return sendSocks5ResponseRejected(conn, reason)
 }
 
@@ -121,21 +183,50 @@ func (conn *SocksConn) RejectReason(reason byte) error {
 // 	}
 type SocksListener struct {
 	net.Listener
+	// The transport method name under which accepted connections are
+	// counted in Stats. It may be left empty.
+	MethodName string
+	// If true, accept SOCKS5 UDP ASSOCIATE requests in addition to
+	// CONNECT. Otherwise they are rejected with "Command not supported".
+	EnableUDP bool
+	// If true, accept SOCKS4a (and SOCKS4) requests in addition to SOCKS5,
+	// telling them apart by the first byte of the connection. Grant and
+	// Reject answer in the version of the request. This lets a transport
+	// declare a single "socks5" CMETHOD and still work with a tor that uses
+	// SOCKS4a for it.
+	DetectSocks4a bool
+	// If positive, overrides SocksHandshakeTimeout for this listener.
+	HandshakeTimeout time.Duration
+	// Restrictions on who may use the listener, or nil for none.
+	Access *SocksAccess
+
+	manager *ShutdownManager
 }
 
 // Open a net.Listener according to network and laddr, and return it as a
-// SocksListener.
+// SocksListener. The listener and the connections it accepts are tracked by
+// DefaultShutdownManager. If DefaultShutdownManager has already been shut
+// down, no listener is opened and the error is ErrShuttingDown.
 func ListenSocks(network, laddr string) (*SocksListener, error) {
+	if DefaultShutdownManager.ShuttingDown() {
+		return nil, ErrShuttingDown
+	}
 	ln, err := net.Listen(network, laddr)
 	if err != nil {
 		return nil, err
 	}
-	return NewSocksListener(ln), nil
+	sln := NewSocksListener(ln)
+	sln.manager = DefaultShutdownManager
+	if !sln.manager.addListener(sln) {
+		ln.Close()
+		return nil, ErrShuttingDown
+	}
+	return sln, nil
 }
 
 // Create a new SocksListener wrapping the given net.Listener.
 func NewSocksListener(ln net.Listener) *SocksListener {
-	return &SocksListener{ln}
+	return &SocksListener{Listener: ln}
 }
 
 // Accept is the same as AcceptSocks, except that it returns a generic net.Conn.
@@ -144,6 +235,15 @@ func (ln *SocksListener) Accept() (net.Conn, error) {
 	return ln.AcceptSocks()
 }
 
+// Close the underlying net.Listener, and stop tracking it in its
+// ShutdownManager, if any.
+func (ln *SocksListener) Close() error {
+	if ln.manager != nil {
+		ln.manager.removeListener(ln)
+	}
+	return ln.Listener.Close()
+}
+
 // Call Accept on the wrapped net.Listener, do SOCKS negotiation, and return a
 // SocksConn. After accepting, you must call either conn.Grant or conn.Reject
 // (presumably after trying to connect to conn.Req.Target).
@@ -172,14 +272,34 @@ retry:
 	if err != nil {
 		return nil, err
 	}
+	if !ln.Access.allows(c.RemoteAddr()) {
+		c.Close()
+		goto retry
+	}
 	conn := new(SocksConn)
 	conn.Conn = c
-	err = conn.SetDeadline(time.Now().Add(socksRequestTimeout))
+	conn.accepted = time.Now()
+	timeout := ln.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = SocksHandshakeTimeout
+	}
+	err = conn.SetDeadline(time.Now().Add(timeout))
 	if err != nil {
 		conn.Close()
 		goto retry
 	}
-	conn.Req, err = socks5Handshake(conn)
+	if ln.DetectSocks4a {
+		// The limit on what the client may send depends on the
+		// protocol, so socksDetectHandshake applies it.
+		conn.Req, conn.socks4, err = socksDetectHandshake(conn.Conn, ln.EnableUDP, ln.Access)
+	} else {
+		// Cap what the client may send during the handshake.
+		s := struct {
+			io.Reader
+			io.Writer
+		}{io.LimitReader(conn.Conn, socksMaxHandshakeBytes), conn.Conn}
+		conn.Req, err = socks5Handshake(s, ln.EnableUDP, ln.Access)
+	}
 	if err != nil {
 		conn.Close()
 		goto retry
@@ -189,39 +309,71 @@ retry:
 		conn.Close()
 		goto retry
 	}
+	if ln.manager != nil {
+		if !ln.manager.addConn(conn) {
+			// Shutting down.
+			conn.Conn.Close()
+			goto retry
+		}
+		conn.manager = ln.manager
+	}
+	conn.counters = statsFor(ln.MethodName)
+	atomic.AddUint64(&conn.counters.connsAccepted, 1)
+	atomic.AddInt64(&conn.counters.connsActive, 1)
 	return conn, nil
 }
 
-// Returns "socks5", suitable to be included in a call to Cmethod.
+// Returns "socks5", suitable to be included in a call to Cmethod. This is so
+// even with DetectSocks4a set.
 func (ln *SocksListener) Version() string {
 	return "socks5"
 }
 
 // socks5handshake conducts the SOCKS5 handshake up to the point where the
 // client command is read and the proxy must open the outgoing connection.
-// Returns a SocksRequest.
-func socks5Handshake(s io.ReadWriter) (req SocksRequest, err error) {
+// Returns a SocksRequest. UDP ASSOCIATE requests are rejected unless allowUDP
+// is true. If access requires authentication, the client must authenticate
+// with its credentials.
+func socks5Handshake(s io.ReadWriter, allowUDP bool, access *SocksAccess) (req SocksRequest, err error) {
 	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
 
 	// Negotiate the authentication method.
 	var method byte
-	if method, err = socksNegotiateAuth(rw); err != nil {
+	if method, err = socksNegotiateAuthMethods(rw, access.requiresAuth()); err != nil {
 		return
 	}
 
 	// Authenticate the client.
-	if err = socksAuthenticate(rw, method, &req); err != nil {
+	if access.requiresAuth() {
+		err = socksAuthStatic(rw, method, access)
+	} else {
+		err = socksAuthenticate(rw, method, &req)
+	}
+	if err != nil {
 		return
 	}
 
 	// Read the command.
-	err = socksReadCommand(rw, &req)
+	if err = socksReadCommand(rw, &req); err != nil {
+		return
+	}
+	if req.UDPAssociate && !allowUDP {
+		sendSocks5ResponseRejected(rw, SocksRepCommandNotSupported)
+		socksFlushBuffers(rw)
+		err = fmt.Errorf("SOCKS UDP ASSOCIATE is not enabled")
+	}
 	return
 }
 
 // socksNegotiateAuth negotiates the authentication method and returns the
 // selected method as a byte.  On negotiation failures an error is returned.
 func socksNegotiateAuth(rw *bufio.ReadWriter) (method byte, err error) {
+	return socksNegotiateAuthMethods(rw, false)
+}
+
+// Like socksNegotiateAuth, but if requireAuth is true, refuse NO
+// AUTHENTICATION REQUIRED.
+func socksNegotiateAuthMethods(rw *bufio.ReadWriter, requireAuth bool) (method byte, err error) {
 	// Validate the version.
 	if err = socksReadByteVerify(rw, "version", socksVersion); err != nil {
 		return
@@ -246,7 +398,7 @@ func socksNegotiateAuth(rw *bufio.ReadWriter) (method byte, err error) {
 		case socksAuthNoneRequired:
 			// Pick Username/Password over None if the client happens to
 			// send both.
-			if method == socksAuthNoAcceptableMethods {
+			if method == socksAuthNoAcceptableMethods && !requireAuth {
 				method = m
 			}
 
@@ -301,17 +453,63 @@ func socksAuthenticate(rw *bufio.ReadWriter, method byte, req *SocksRequest) (er
 // field is primarily used as an out-of-band argument passing mechanism for
 // pluggable transports.
 func socksAuthRFC1929(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
-	sendErrResp := func() {
-		// Swallow the write/flush error here, we are going to close the
-		// connection and the original failure is more useful.
-		resp := []byte{socksAuthRFC1929Ver, socksAuthRFC1929Fail}
-		rw.Write(resp[:])
-		socksFlushBuffers(rw)
+	var uname, passwd []byte
+	if uname, passwd, err = socksReadRFC1929(rw); err != nil {
+		return
+	}
+	req.Username = string(uname)
+	if !(len(passwd) == 1 && passwd[0] == 0x00) {
+		// tor will set the password to 'NUL' if there are no arguments.
+		req.Password = string(passwd)
 	}
 
+	// Mash the username/password together and parse it as a pluggable
+	// transport argument string.
+	if req.Args, err = parseClientParameters(req.Username + req.Password); err != nil {
+		socksSendRFC1929Fail(rw)
+	} else {
+		resp := []byte{socksAuthRFC1929Ver, socksAuthRFC1929Success}
+		_, err = rw.Write(resp[:])
+	}
+	return
+}
+
+// socksAuthStatic authenticates the client with RFC 1929 against the fixed
+// credentials of access, which are not parsed as arguments.
+func socksAuthStatic(rw *bufio.ReadWriter, method byte, access *SocksAccess) (err error) {
+	if method != socksAuthUsernamePassword {
+		return fmt.Errorf("SOCKS method select had no compatible methods")
+	}
+	var uname, passwd []byte
+	if uname, passwd, err = socksReadRFC1929(rw); err != nil {
+		return
+	}
+	if !access.checkCredentials(uname, passwd) {
+		socksSendRFC1929Fail(rw)
+		return fmt.Errorf("SOCKS client sent wrong credentials")
+	}
+	resp := []byte{socksAuthRFC1929Ver, socksAuthRFC1929Success}
+	if _, err = rw.Write(resp[:]); err != nil {
+		return
+	}
+	return socksFlushBuffers(rw)
+}
+
+// Send an RFC 1929 failure response.
+func socksSendRFC1929Fail(rw *bufio.ReadWriter) {
+	// Swallow the write/flush error here, we are going to close the
+	// connection and the original failure is more useful.
+	resp := []byte{socksAuthRFC1929Ver, socksAuthRFC1929Fail}
+	rw.Write(resp[:])
+	socksFlushBuffers(rw)
+}
+
+// socksReadRFC1929 reads an RFC 1929 username/password request, sending a
+// failure response if it is malformed.
+func socksReadRFC1929(rw *bufio.ReadWriter) (uname, passwd []byte, err error) {
 	// Validate the fixed parts of the command message.
 	if err = socksReadByteVerify(rw, "auth version", socksAuthRFC1929Ver); err != nil {
-		sendErrResp()
+		socksSendRFC1929Fail(rw)
 		return
 	}
 
@@ -321,15 +519,13 @@ func socksAuthRFC1929(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 		return
 	}
 	if ulen < 1 {
-		sendErrResp()
+		socksSendRFC1929Fail(rw)
 		err = fmt.Errorf("RFC1929 username with 0 length")
 		return
 	}
-	var uname []byte
 	if uname, err = socksReadBytes(rw, int(ulen)); err != nil {
 		return
 	}
-	req.Username = string(uname)
 
 	// Read the password.
 	var plen byte
@@ -337,32 +533,19 @@ func socksAuthRFC1929(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 		return
 	}
 	if plen < 1 {
-		sendErrResp()
+		socksSendRFC1929Fail(rw)
 		err = fmt.Errorf("RFC1929 password with 0 length")
 		return
 	}
-	var passwd []byte
 	if passwd, err = socksReadBytes(rw, int(plen)); err != nil {
 		return
 	}
-	if !(plen == 1 && passwd[0] == 0x00) {
-		// tor will set the password to 'NUL' if there are no arguments.
-		req.Password = string(passwd)
-	}
-
-	// Mash the username/password together and parse it as a pluggable
-	// transport argument string.
-	if req.Args, err = parseClientParameters(req.Username + req.Password); err != nil {
-		sendErrResp()
-	} else {
-		resp := []byte{socksAuthRFC1929Ver, socksAuthRFC1929Success}
-		_, err = rw.Write(resp[:])
-	}
 	return
 }
 
 // socksReadCommand reads a SOCKS5 client command and parses out the relevant
-// fields into a SocksRequest.  Only CMD_CONNECT is supported.
+// fields into a SocksRequest.  Only CMD_CONNECT and CMD_UDP_ASSOCIATE are
+// supported.
 func socksReadCommand(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 	sendErrResp := func(reason byte) {
 		// Swallow errors that occur when writing/flushing the response,
@@ -376,8 +559,17 @@ func socksReadCommand(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 		sendErrResp(SocksRepGeneralFailure)
 		return
 	}
-	if err = socksReadByteVerify(rw, "command", socksCmdConnect); err != nil {
+	var cmd byte
+	if cmd, err = socksReadByte(rw); err != nil {
+		return
+	}
+	switch cmd {
+	case socksCmdConnect:
+	case socksCmdUDPAssociate:
+		req.UDPAssociate = true
+	default:
 		sendErrResp(SocksRepCommandNotSupported)
+		err = fmt.Errorf("SOCKS message field command was 0x%02x, not 0x%02x or 0x%02x", cmd, socksCmdConnect, socksCmdUDPAssociate)
 		return
 	}
 	if err = socksReadByteVerify(rw, "reserved", socksRsv); err != nil {
@@ -423,7 +615,7 @@ func socksReadCommand(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 		}
 		addr := make(net.IP, net.IPv6len)
 		copy(addr[:], rawAddr[:])
-		host = fmt.Sprintf("[%s]", addr.String())
+		host = addr.String()
 
 	default:
 		sendErrResp(SocksRepAddressNotSupported)
@@ -440,10 +632,17 @@ func socksReadCommand(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 		return
 	}
 
-	req.Target = fmt.Sprintf("%s:%d", host, port)
+	req.setTarget(host, port)
 	return
 }
 
+// Set the Target, Host, and Port of req.
+func (req *SocksRequest) setTarget(host string, port int) {
+	req.Host = host
+	req.Port = port
+	req.Target = net.JoinHostPort(host, strconv.Itoa(port))
+}
+
 // Send a SOCKS5 response with the given code. BND.ADDR/BND.PORT is always the
 // IPv4 address/port "0.0.0.0:0".
 func sendSocks5Response(w io.Writer, code byte) error {
@@ -0,0 +1,152 @@
+package pt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+)
+
+// SOCKS4 and SOCKS4a, which a SocksListener with DetectSocks4a set accepts as
+// well as SOCKS5.
+// https://www.openssh.com/txt/socks4.protocol
+// https://www.openssh.com/txt/socks4a.protocol
+const (
+	socks4Version = 0x04
+
+	socks4CmdConnect = 0x01
+
+	socks4ReplyVersion = 0x00
+	socks4Granted      = 0x5a
+	socks4Rejected     = 0x5b
+
+	// The longest user ID accepted in a SOCKS4a request. tor puts all the
+	// arguments of a bridge line in the user ID, without a limit of its
+	// own, so this is far longer than any bridge line; it only bounds the
+	// memory a client can make the handshake use.
+	socks4MaxUserID = 65535
+	// The longest host name accepted in a SOCKS4a request, as in SOCKS5.
+	socks4MaxHostName = 255
+	// The most bytes a client may send in a SOCKS4a request: the fixed
+	// part, and the user ID and host name with their terminators.
+	socks4MaxHandshakeBytes = 8 + (socks4MaxUserID + 1) + (socks4MaxHostName + 1)
+)
+
+// Read the version byte of a SOCKS request and do a SOCKS4a handshake if it is
+// 4, or a SOCKS5 handshake otherwise. Returns the request and whether it was
+// SOCKS4a. SOCKS4a is refused if access requires authentication. What the
+// client may send is limited to socks4MaxHandshakeBytes or
+// socksMaxHandshakeBytes, according to the version.
+func socksDetectHandshake(s io.ReadWriter, allowUDP bool, access *SocksAccess) (req SocksRequest, socks4 bool, err error) {
+	// Read only the one byte, so that nothing after the request is
+	// consumed, and put it back in front of the rest.
+	var version [1]byte
+	_, err = io.ReadFull(s, version[:])
+	if err != nil {
+		return
+	}
+	limit := int64(socksMaxHandshakeBytes)
+	if version[0] == socks4Version {
+		limit = socks4MaxHandshakeBytes
+	}
+	r := io.MultiReader(bytes.NewReader(version[:]), io.LimitReader(s, limit-1))
+	if version[0] != socks4Version {
+		req, err = socks5Handshake(struct {
+			io.Reader
+			io.Writer
+		}{r, s}, allowUDP, access)
+		return
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(r), bufio.NewWriter(s))
+	if access.requiresAuth() {
+		sendSocks4Response(rw, socks4Rejected)
+		socksFlushBuffers(rw)
+		return req, true, fmt.Errorf("SOCKS4a cannot authenticate")
+	}
+	req, err = socks4aHandshake(rw)
+	return req, true, err
+}
+
+// Read a NUL-terminated field of a SOCKS4a request, of at most max bytes.
+func socks4ReadString(rw *bufio.ReadWriter, descr string, max int) (string, error) {
+	var buf []byte
+	for {
+		b, err := socksReadByte(rw)
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(buf), nil
+		}
+		if len(buf) >= max {
+			return "", fmt.Errorf("SOCKS4a %s is too long", descr)
+		}
+		buf = append(buf, b)
+	}
+}
+
+// socks4aHandshake reads a SOCKS4 or SOCKS4a CONNECT request. The user ID is
+// parsed as pluggable transport arguments, as with the username and password
+// of SOCKS5.
+func socks4aHandshake(rw *bufio.ReadWriter) (req SocksRequest, err error) {
+	sendErrResp := func() {
+		// Swallow errors that occur when writing/flushing the response,
+		// connection will be closed anyway.
+		sendSocks4Response(rw, socks4Rejected)
+		socksFlushBuffers(rw)
+	}
+
+	if err = socksReadByteVerify(rw, "version", socks4Version); err != nil {
+		return
+	}
+	var cmd byte
+	if cmd, err = socksReadByte(rw); err != nil {
+		return
+	}
+	if cmd != socks4CmdConnect {
+		sendErrResp()
+		err = fmt.Errorf("SOCKS4a message field command was 0x%02x, not 0x%02x", cmd, socks4CmdConnect)
+		return
+	}
+	var rawPort, ip []byte
+	if rawPort, err = socksReadBytes(rw, 2); err != nil {
+		return
+	}
+	if ip, err = socksReadBytes(rw, net.IPv4len); err != nil {
+		return
+	}
+	if req.Username, err = socks4ReadString(rw, "user ID", socks4MaxUserID); err != nil {
+		return
+	}
+	host := net.IPv4(ip[0], ip[1], ip[2], ip[3]).String()
+	// An address of 0.0.0.x, with x nonzero, means that a host name
+	// follows.
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		if host, err = socks4ReadString(rw, "host name", socks4MaxHostName); err != nil {
+			return
+		}
+		if host == "" {
+			sendErrResp()
+			err = fmt.Errorf("SOCKS4a request had host name with 0 length")
+			return
+		}
+	}
+	port := int(rawPort[0])<<8 | int(rawPort[1])<<0
+	req.setTarget(host, port)
+
+	if req.Args, err = parseClientParameters(req.Username); err != nil {
+		sendErrResp()
+		return
+	}
+	err = socksFlushBuffers(rw)
+	return
+}
+
+// Send a SOCKS4a response with the given code. DSTPORT and DSTIP are always
+// zero.
+func sendSocks4Response(w io.Writer, code byte) error {
+	resp := [8]byte{socks4ReplyVersion, code}
+	_, err := w.Write(resp[:])
+	return err
+}
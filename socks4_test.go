@@ -0,0 +1,132 @@
+package pt
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSocksDetectSocks4a(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sln := NewSocksListener(ln)
+	sln.DetectSocks4a = true
+	defer sln.Close()
+
+	tests := []struct {
+		req    []byte
+		target string
+		args   Args
+		grant  bool
+		reply  byte
+	}{
+		// SOCKS4a with a host name and args in the user ID.
+		{[]byte("\x04\x01\x00\x50\x00\x00\x00\x01a=b;c=d\x00example.com\x00"), "example.com:80", Args{"a": []string{"b"}, "c": []string{"d"}}, true, socks4Granted},
+		// A user ID longer than 255 bytes, as tor sends for a bridge
+		// line with long args.
+		{[]byte("\x04\x01\x00\x50\x00\x00\x00\x01front=" + strings.Repeat("x", 400) + "\x00example.com\x00"), "example.com:80", Args{"front": []string{strings.Repeat("x", 400)}}, true, socks4Granted},
+		// A user ID longer than the SOCKS5 handshake limit.
+		{[]byte("\x04\x01\x00\x50\x00\x00\x00\x01front=" + strings.Repeat("y", 4000) + "\x00example.com\x00"), "example.com:80", Args{"front": []string{strings.Repeat("y", 4000)}}, true, socks4Granted},
+		// SOCKS4 with an IP address and no user ID.
+		{[]byte("\x04\x01\x04\xd2\x01\x02\x03\x04\x00"), "1.2.3.4:1234", Args{}, false, socks4Rejected},
+	}
+	for _, test := range tests {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Write(test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := sln.AcceptSocks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.Req.Target != test.target {
+			t.Errorf("%q: target %q, expected %q", test.req, conn.Req.Target, test.target)
+		}
+		if !argsEqual(conn.Req.Args, test.args) {
+			t.Errorf("%q: args %q, expected %q", test.req, conn.Req.Args, test.args)
+		}
+		if test.grant {
+			err = conn.Grant(nil)
+		} else {
+			err = conn.RejectReason(SocksRepConnectionRefused)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := make([]byte, 8)
+		_, err = io.ReadFull(c, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(resp, []byte{0x00, test.reply, 0, 0, 0, 0, 0, 0}) {
+			t.Errorf("%q: response %x", test.req, resp)
+		}
+		c.Close()
+		conn.Close()
+	}
+
+	// SOCKS5 still works on the same listener.
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- socks5Connect(c, &net.TCPAddr{IP: net.ParseIP("5.6.7.8"), Port: 9})
+	}()
+	conn, err := sln.AcceptSocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Req.Target != "5.6.7.8:9" {
+		t.Errorf("SOCKS5 target %q", conn.Req.Target)
+	}
+	err = conn.Grant(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-errCh
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSocks4aHandshakeErrors(t *testing.T) {
+	for _, req := range []string{
+		// BIND command.
+		"\x04\x02\x00\x50\x01\x02\x03\x04\x00",
+		// Missing user ID terminator.
+		"\x04\x01\x00\x50\x01\x02\x03\x04abc",
+		// Empty host name.
+		"\x04\x01\x00\x50\x00\x00\x00\x01\x00\x00",
+		// Bad args.
+		"\x04\x01\x00\x50\x01\x02\x03\x04noequals\x00",
+		// Overlong host name.
+		"\x04\x01\x00\x50\x00\x00\x00\x01\x00" + strings.Repeat("a", 300) + "\x00",
+		// Extra bytes after the request.
+		"\x04\x01\x00\x50\x01\x02\x03\x04\x00extra",
+	} {
+		var out bytes.Buffer
+		s := struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader([]byte(req)), &out}
+		_, socks4, err := socksDetectHandshake(s, false, nil)
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", req)
+		}
+		if !socks4 {
+			t.Errorf("%q not detected as SOCKS4a", req)
+		}
+	}
+}
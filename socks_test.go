@@ -7,6 +7,8 @@ import (
 	"errors"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -472,3 +474,99 @@ func TestResponseNil(t *testing.T) {
 }
 
 var _ io.ReadWriter = (*testReadWriter)(nil)
+
+// A client that stalls during negotiation must not keep AcceptSocks from
+// accepting other clients for longer than the handshake timeout.
+func TestSocksHandshakeTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sln := NewSocksListener(ln)
+	sln.HandshakeTimeout = 100 * time.Millisecond
+	defer sln.Close()
+
+	slow, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	_, err = slow.Write([]byte{0x05})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer good.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- socks5Connect(good, &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 80})
+	}()
+
+	start := time.Now()
+	conn, err := sln.AcceptSocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("AcceptSocks took %v", elapsed)
+	}
+	if conn.Req.Target != "1.2.3.4:80" {
+		t.Errorf("accepted target %q", conn.Req.Target)
+	}
+	conn.Grant(nil)
+	err = <-errCh
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stalled client has been disconnected.
+	slow.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = slow.Read(make([]byte, 1))
+	if err == nil {
+		t.Error("stalled client was not disconnected")
+	} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		t.Error("stalled client was not disconnected")
+	}
+}
+
+// TestRequestTargetHostPort tests the Target, Host, and Port of SOCKS5
+// requests of each address type.
+func TestRequestTargetHostPort(t *testing.T) {
+	for _, test := range []struct {
+		hex    string
+		target string
+		host   string
+		port   int
+	}{
+		// 1.2.3.4:443
+		{"0501000101020304 01bb", "1.2.3.4:443", "1.2.3.4", 443},
+		// [2001:db8::1]:443
+		{"0501000420010db8000000000000000000000001 01bb", "[2001:db8::1]:443", "2001:db8::1", 443},
+		// [::ffff:1.2.3.4]:80, an IPv4-mapped address.
+		{"0501000400000000000000000000ffff01020304 0050", "1.2.3.4:80", "1.2.3.4", 80},
+		// bridge.example:65535
+		{"050100030e6272696467652e6578616d706c65 ffff", "bridge.example:65535", "bridge.example", 65535},
+		// A domain name containing a colon is kept as sent.
+		{"05010003043a3a3a31 0001", "[:::1]:1", ":::1", 1},
+	} {
+		c := new(testReadWriter)
+		var req SocksRequest
+		c.writeHex(strings.Replace(test.hex, " ", "", -1))
+		if err := socksReadCommand(c.toBufio(), &req); err != nil {
+			t.Errorf("%s: %v", test.hex, err)
+			continue
+		}
+		if req.Target != test.target || req.Host != test.host || req.Port != test.port {
+			t.Errorf("%s: got (%q, %q, %d), expected (%q, %q, %d)", test.hex, req.Target, req.Host, req.Port, test.target, test.host, test.port)
+		}
+		host, port, err := net.SplitHostPort(req.Target)
+		if err != nil || host != req.Host || port != strconv.Itoa(req.Port) {
+			t.Errorf("%s: Target %q does not split into Host and Port", test.hex, req.Target)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package pt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// SocksUDPSession is the relay side of a SOCKS5 UDP association, returned by
+// SocksConn.GrantUDP. The client sends datagrams to the relay address, each
+// prefixed with a SOCKS UDP request header naming its destination; ReadFrom
+// strips the header and returns the destination, and WriteTo adds a header
+// naming the source of a reply.
+//
+//	sess, err := conn.GrantUDP()
+//	if err != nil {
+//		return err
+//	}
+//	defer sess.Close()
+//	buf := make([]byte, 65536)
+//	for {
+//		n, target, err := sess.ReadFrom(buf)
+//		if err != nil {
+//			return err
+//		}
+//		// Send buf[:n] to target over the transport.
+//	}
+//
+// The association ends when the client closes the SOCKS control connection,
+// at which point the session is closed and ReadFrom returns an error.
+type SocksUDPSession struct {
+	pc      net.PacketConn
+	control *SocksConn
+	// IP address from which client datagrams are accepted.
+	clientIP net.IP
+
+	mu sync.Mutex
+	// The client's UDP address, learned from the first datagram it sends.
+	client net.Addr
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Allocate a UDP relay on the same local IP address as the SOCKS control
+// connection, and send a message to the proxy client that the UDP ASSOCIATE
+// request is granted, with the relay's address in BND.ADDR/BND.PORT. The
+// request must have conn.Req.UDPAssociate set.
+//
+// After GrantUDP returns successfully, the session owns conn: it reads and
+// discards anything the client sends on it, and closes the session when the
+// client closes it. The caller should not read from conn.
+func (conn *SocksConn) GrantUDP() (*SocksUDPSession, error) {
+	if !conn.Req.UDPAssociate {
+		return nil, fmt.Errorf("SOCKS request is not a UDP ASSOCIATE")
+	}
+	localIP := net.IPv4zero
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		localIP = addr.IP
+	}
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		conn.RejectReason(SocksRepGeneralFailure)
+		return nil, err
+	}
+	err = sendSocks5ResponseAddr(conn, socksRepSucceeded, pc.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	sess := &SocksUDPSession{pc: pc, control: conn}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		sess.clientIP = addr.IP
+	}
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		sess.Close()
+	}()
+	return sess, nil
+}
+
+// Return the local address of the UDP relay.
+func (sess *SocksUDPSession) LocalAddr() net.Addr {
+	return sess.pc.LocalAddr()
+}
+
+// Read a datagram sent by the client into p, and return the length of its
+// payload and the destination requested in its header, as a "host:port"
+// string. Datagrams from an address other than the client's, fragmented
+// datagrams, and datagrams with a malformed header are silently dropped.
+func (sess *SocksUDPSession) ReadFrom(p []byte) (n int, target string, err error) {
+	buf := make([]byte, len(p)+socksUDPMaxHeaderLen)
+	for {
+		var m int
+		var addr net.Addr
+		m, addr, err = sess.pc.ReadFrom(buf)
+		if err != nil {
+			return 0, "", err
+		}
+		if !sess.acceptFrom(addr) {
+			continue
+		}
+		var payload []byte
+		target, payload, err = socksParseUDPHeader(buf[:m])
+		if err != nil {
+			continue
+		}
+		return copy(p, payload), target, nil
+	}
+}
+
+// Send p to the client as a datagram whose header gives from, a "host:port"
+// string, as its source. Returns an error if the client has not yet sent a
+// datagram, because until then its UDP address is not known.
+func (sess *SocksUDPSession) WriteTo(p []byte, from string) (int, error) {
+	sess.mu.Lock()
+	client := sess.client
+	sess.mu.Unlock()
+	if client == nil {
+		return 0, fmt.Errorf("SOCKS UDP client address is not yet known")
+	}
+	buf, err := socksAppendUDPHeader(make([]byte, 0, socksUDPMaxHeaderLen+len(p)), from)
+	if err != nil {
+		return 0, err
+	}
+	buf = append(buf, p...)
+	if _, err := sess.pc.WriteTo(buf, client); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close the UDP relay and the SOCKS control connection.
+func (sess *SocksUDPSession) Close() error {
+	sess.closeOnce.Do(func() {
+		sess.closeErr = sess.pc.Close()
+		sess.control.Close()
+	})
+	return sess.closeErr
+}
+
+// Return true if a datagram from addr should be accepted as coming from the
+// client. The first acceptable datagram fixes the client's address; later
+// datagrams must come from the same address.
+func (sess *SocksUDPSession) acceptFrom(addr net.Addr) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.client != nil {
+		return addr.String() == sess.client.String()
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	if sess.clientIP != nil && !sess.clientIP.Equal(udpAddr.IP) {
+		return false
+	}
+	sess.client = addr
+	return true
+}
+
+// RSV(2) FRAG(1) ATYP(1) DST.ADDR(1+255) DST.PORT(2)
+const socksUDPMaxHeaderLen = 2 + 1 + 1 + 1 + 255 + 2
+
+// Parse the SOCKS UDP request header at the start of b. Returns the
+// destination address as a "host:port" string, and the payload following the
+// header. Fragmented datagrams (FRAG ≠ 0) are not supported.
+func socksParseUDPHeader(b []byte) (target string, payload []byte, err error) {
+	if len(b) < 4 {
+		return "", nil, fmt.Errorf("SOCKS UDP header is too short")
+	}
+	if b[0] != socksRsv || b[1] != socksRsv {
+		return "", nil, fmt.Errorf("SOCKS UDP header has nonzero RSV")
+	}
+	if b[2] != 0x00 {
+		return "", nil, fmt.Errorf("SOCKS UDP fragmentation is not supported")
+	}
+	atype := b[3]
+	b = b[4:]
+	var host string
+	switch atype {
+	case socksAtypeV4:
+		if len(b) < net.IPv4len {
+			return "", nil, fmt.Errorf("SOCKS UDP header is too short")
+		}
+		host = net.IP(b[:net.IPv4len]).String()
+		b = b[net.IPv4len:]
+	case socksAtypeDomainName:
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return "", nil, fmt.Errorf("SOCKS UDP header is too short")
+		}
+		host = string(b[1 : 1+int(b[0])])
+		b = b[1+int(b[0]):]
+	case socksAtypeV6:
+		if len(b) < net.IPv6len {
+			return "", nil, fmt.Errorf("SOCKS UDP header is too short")
+		}
+		host = net.IP(b[:net.IPv6len]).String()
+		b = b[net.IPv6len:]
+	default:
+		return "", nil, fmt.Errorf("SOCKS UDP header has unsupported address type 0x%02x", atype)
+	}
+	if len(b) < 2 {
+		return "", nil, fmt.Errorf("SOCKS UDP header is too short")
+	}
+	port := binary.BigEndian.Uint16(b[:2])
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), b[2:], nil
+}
+
+// Append a SOCKS UDP request header for the "host:port" address addr to b.
+func socksAppendUDPHeader(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+	b = append(b, socksRsv, socksRsv, 0x00)
+	b, err = socksAppendAddr(b, host)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// Append ATYP and DST.ADDR for host, an IP address or domain name, to b.
+func socksAppendAddr(b []byte, host string) ([]byte, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return append(append(b, socksAtypeV4), ip4...), nil
+		}
+		return append(append(b, socksAtypeV6), ip.To16()...), nil
+	}
+	if len(host) == 0 || len(host) > 255 {
+		return nil, fmt.Errorf("invalid domain name %q", host)
+	}
+	b = append(b, socksAtypeDomainName, byte(len(host)))
+	return append(b, host...), nil
+}
+
+// Send a SOCKS5 response with the given code and with addr in
+// BND.ADDR/BND.PORT.
+func sendSocks5ResponseAddr(w io.Writer, code byte, addr *net.UDPAddr) error {
+	resp := []byte{socksVersion, code, socksRsv}
+	resp, err := socksAppendAddr(resp, addr.IP.String())
+	if err != nil {
+		return err
+	}
+	resp = append(resp, byte(addr.Port>>8), byte(addr.Port))
+	_, err = w.Write(resp)
+	return err
+}
@@ -0,0 +1,223 @@
+package pt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestRequestUDPAssociate tests UDP ASSOCIATE SOCKS5 requests.
+func TestRequestUDPAssociate(t *testing.T) {
+	c := new(testReadWriter)
+	var req SocksRequest
+
+	// VER = 05, CMD = 03, RSV = 00, ATYPE = 01, DST.ADDR = 0.0.0.0, DST.PORT = 0
+	c.writeHex("05030001000000000000")
+	if err := socksReadCommand(c.toBufio(), &req); err != nil {
+		t.Fatal("socksReadCommand(UDP ASSOCIATE) failed:", err)
+	}
+	if !req.UDPAssociate {
+		t.Error("UDPAssociate not set")
+	}
+	if req.Target != "0.0.0.0:0" {
+		t.Error("Unexpected target:", req.Target)
+	}
+}
+
+// Connect to a SOCKS listener at addr and send a UDP ASSOCIATE request.
+// Returns the control connection and the 10-byte reply.
+func socksUDPAssociate(t *testing.T, addr string) (net.Conn, []byte) {
+	control, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	control.SetDeadline(time.Now().Add(5 * time.Second))
+	// VER = 05, NMETHODS = 01, METHODS = [00]
+	control.Write([]byte{0x05, 0x01, 0x00})
+	resp := make([]byte, 10)
+	if _, err := io.ReadFull(control, resp[:2]); err != nil {
+		t.Fatal(err)
+	}
+	// VER = 05, CMD = 03, RSV = 00, ATYPE = 01, DST.ADDR = 0.0.0.0, DST.PORT = 0
+	control.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	if _, err := io.ReadFull(control, resp); err != nil {
+		t.Fatal(err)
+	}
+	return control, resp
+}
+
+// TestUDPAssociateDisabled tests that UDP ASSOCIATE is rejected when not
+// enabled.
+func TestUDPAssociateDisabled(t *testing.T) {
+	ln, err := ListenSocks("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ln.AcceptSocks()
+
+	control, resp := socksUDPAssociate(t, ln.Addr().String())
+	defer control.Close()
+	// VER = 05, REP = 07, RSV = 00, ATYPE = 01, BND.ADDR = 0.0.0.0, BND.PORT = 0
+	if msg := hex.EncodeToString(resp); msg != "05070001000000000000" {
+		t.Error("Unexpected response:", msg)
+	}
+}
+
+func TestSocksUDPHeader(t *testing.T) {
+	tests := []struct {
+		addr string
+		hex  string
+	}{
+		{"127.0.0.1:9050", "000000017f000001235a"},
+		{"[102:304:506:708:90a:b0c:d0e:f10]:9050", "000000040102030405060708090a0b0c0d0e0f10235a"},
+		{"example.com:9050", "000000030b6578616d706c652e636f6d235a"},
+	}
+	for _, test := range tests {
+		b, err := socksAppendUDPHeader(nil, test.addr)
+		if err != nil {
+			t.Errorf("socksAppendUDPHeader(%q) unexpectedly returned an error: %s", test.addr, err)
+			continue
+		}
+		if h := hex.EncodeToString(b); h != test.hex {
+			t.Errorf("socksAppendUDPHeader(%q) → %s (expected %s)", test.addr, h, test.hex)
+		}
+		target, payload, err := socksParseUDPHeader(append(b, "payload"...))
+		if err != nil {
+			t.Errorf("socksParseUDPHeader(%s) unexpectedly returned an error: %s", test.hex, err)
+			continue
+		}
+		if target != test.addr || string(payload) != "payload" {
+			t.Errorf("socksParseUDPHeader(%s) → %q, %q (expected %q, %q)",
+				test.hex, target, payload, test.addr, "payload")
+		}
+	}
+
+	badTests := []string{
+		"",
+		"000000",
+		// nonzero RSV
+		"010000017f000001235a",
+		// FRAG = 1
+		"000001017f000001235a",
+		// unknown ATYPE
+		"000000057f000001235a",
+		// truncated addresses and ports
+		"000000017f0000",
+		"000000017f000001",
+		"00000004010203",
+		"000000030b6578616d706c65",
+	}
+	for _, h := range badTests {
+		b, _ := hex.DecodeString(h)
+		if _, _, err := socksParseUDPHeader(b); err == nil {
+			t.Errorf("socksParseUDPHeader(%s) unexpectedly succeeded", h)
+		}
+	}
+
+	if _, err := socksAppendUDPHeader(nil, "example.com"); err == nil {
+		t.Error("socksAppendUDPHeader with no port unexpectedly succeeded")
+	}
+}
+
+func TestSocksUDPSession(t *testing.T) {
+	ln, err := ListenSocks("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ln.EnableUDP = true
+
+	type result struct {
+		sess *SocksUDPSession
+		err  error
+	}
+	ch := make(chan result)
+	go func() {
+		conn, err := ln.AcceptSocks()
+		if err != nil {
+			ch <- result{nil, err}
+			return
+		}
+		sess, err := conn.GrantUDP()
+		ch <- result{sess, err}
+	}()
+
+	control, resp := socksUDPAssociate(t, ln.Addr().String())
+	defer control.Close()
+	if !bytes.Equal(resp[:4], []byte{0x05, 0x00, 0x00, 0x01}) {
+		t.Fatalf("unexpected response %x", resp)
+	}
+	relayAddr := &net.UDPAddr{
+		IP:   net.IP(resp[4:8]),
+		Port: int(resp[8])<<8 | int(resp[9]),
+	}
+
+	r := <-ch
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	sess := r.sess
+	if sess.LocalAddr().String() != relayAddr.String() {
+		t.Errorf("BND.ADDR %s does not match relay address %s", relayAddr, sess.LocalAddr())
+	}
+
+	client, err := net.DialUDP("udp", nil, relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := sess.WriteTo([]byte("early"), "192.0.2.1:53"); err == nil {
+		t.Error("WriteTo before the client's address is known unexpectedly succeeded")
+	}
+
+	// A fragmented datagram is dropped; the following one is delivered.
+	client.Write([]byte{0x00, 0x00, 0x01, 0x01, 192, 0, 2, 1, 0, 53, 'x'})
+	dgram, _ := socksAppendUDPHeader(nil, "192.0.2.1:53")
+	client.Write(append(dgram, "query"...))
+
+	buf := make([]byte, 100)
+	n, target, err := sess.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "192.0.2.1:53" || string(buf[:n]) != "query" {
+		t.Errorf("ReadFrom → %q, %q", target, buf[:n])
+	}
+
+	if _, err := sess.WriteTo([]byte("answer"), "192.0.2.1:53"); err != nil {
+		t.Fatal(err)
+	}
+	n, err = client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, payload, err := socksParseUDPHeader(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "192.0.2.1:53" || string(payload) != "answer" {
+		t.Errorf("client received %q, %q", from, payload)
+	}
+
+	// Closing the control connection ends the association.
+	control.Close()
+	done := make(chan error)
+	go func() {
+		_, _, err := sess.ReadFrom(buf)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("ReadFrom after closing the control connection unexpectedly succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not closed after the control connection was closed")
+	}
+}
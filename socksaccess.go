@@ -0,0 +1,108 @@
+package pt
+
+import (
+	"fmt"
+	"net"
+)
+
+// SocksAccess restricts who may use a SocksListener. RunClient's listeners are
+// on a loopback address, but on a machine with more than one user, any local
+// user can connect to them; SocksAccess lets a transport refuse clients other
+// than its own tor:
+//
+//	pt.DefaultSocksAccess = &pt.SocksAccess{
+//		AllowedNetworks: pt.LoopbackNetworks(),
+//		Username:        "tor",
+//		Password:        secret,
+//	}
+//
+// A nil *SocksAccess allows everyone.
+type SocksAccess struct {
+	// If not nil, only clients whose IP address is in one of these
+	// networks are accepted; others are disconnected before negotiation.
+	AllowedNetworks []*net.IPNet
+	// If Username is not empty, clients must authenticate with SOCKS5 RFC
+	// 1929 username and password equal to Username and Password. The
+	// credentials are then a password for the listener, not transport
+	// arguments, and Req.Args is empty; transport arguments must come
+	// from elsewhere, such as a StandaloneConfig. SOCKS4a requests, which
+	// cannot carry a password, are rejected. Password must not be empty.
+	Username string
+	Password string
+}
+
+// If DefaultSocksAccess is not nil, the SOCKS listeners opened by RunClient and
+// ClientMux use it as their Access.
+var DefaultSocksAccess *SocksAccess
+
+// Return the IPv4 and IPv6 loopback networks, 127.0.0.0/8 and ::1/128.
+func LoopbackNetworks() []*net.IPNet {
+	return []*net.IPNet{
+		{IP: net.IPv4(127, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+		{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+	}
+}
+
+// Return the networks of the addresses of the named network interfaces, for
+// use in AllowedNetworks.
+func InterfaceNetworks(names ...string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, name := range names {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %s", name, err.Error())
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				networks = append(networks, ipnet)
+			}
+		}
+	}
+	return networks, nil
+}
+
+// Return true if a client at addr may connect.
+func (a *SocksAccess) allows(addr net.Addr) bool {
+	if a == nil || a.AllowedNetworks == nil {
+		return true
+	}
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.AllowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if clients must authenticate.
+func (a *SocksAccess) requiresAuth() bool {
+	return a != nil && a.Username != ""
+}
+
+// Return true if username and password are the required credentials.
+func (a *SocksAccess) checkCredentials(username, password []byte) bool {
+	// Compare both, even if the username is wrong.
+	userOK := SecretEqual(username, []byte(a.Username))
+	passOK := SecretEqual(password, []byte(a.Password))
+	return userOK && passOK
+}
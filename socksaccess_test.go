@@ -0,0 +1,155 @@
+package pt
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSocksAccessAllows(t *testing.T) {
+	a := &SocksAccess{AllowedNetworks: LoopbackNetworks()}
+	for _, test := range []struct {
+		addr     net.Addr
+		expected bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("127.5.6.7"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1}, false},
+		{&net.TCPAddr{IP: net.ParseIP("::2"), Port: 1}, false},
+	} {
+		if a.allows(test.addr) != test.expected {
+			t.Errorf("%v: expected %v", test.addr, test.expected)
+		}
+	}
+	var none *SocksAccess
+	if !none.allows(&net.TCPAddr{IP: net.ParseIP("192.168.0.1")}) {
+		t.Error("nil SocksAccess refused a client")
+	}
+	a = &SocksAccess{AllowedNetworks: []*net.IPNet{}}
+	if a.allows(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}) {
+		t.Error("empty AllowedNetworks allowed a client")
+	}
+}
+
+// Do SOCKS5 method selection and RFC 1929 authentication on c, returning the
+// first two responses.
+func socks5Auth(c net.Conn, offer []byte, username, password string) ([]byte, []byte, error) {
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.Write(append([]byte{0x05, byte(len(offer))}, offer...))
+	if err != nil {
+		return nil, nil, err
+	}
+	method := make([]byte, 2)
+	_, err = io.ReadFull(c, method)
+	if err != nil || method[1] != socksAuthUsernamePassword {
+		return method, nil, err
+	}
+	msg := []byte{socksAuthRFC1929Ver, byte(len(username))}
+	msg = append(msg, username...)
+	msg = append(msg, byte(len(password)))
+	msg = append(msg, password...)
+	_, err = c.Write(msg)
+	if err != nil {
+		return method, nil, err
+	}
+	status := make([]byte, 2)
+	_, err = io.ReadFull(c, status)
+	return method, status, err
+}
+
+func TestSocksAccessCredentials(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sln := NewSocksListener(ln)
+	sln.Access = &SocksAccess{Username: "user", Password: "secret"}
+	defer sln.Close()
+	accepted := make(chan *SocksConn, 1)
+	go func() {
+		for {
+			conn, err := sln.AcceptSocks()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// No authentication offered.
+	c := dial()
+	method, _, err := socks5Auth(c, []byte{socksAuthNoneRequired}, "", "")
+	if err != nil || !bytes.Equal(method, []byte{0x05, socksAuthNoAcceptableMethods}) {
+		t.Errorf("without auth: method %x, %v", method, err)
+	}
+	c.Close()
+
+	// Wrong password.
+	c = dial()
+	_, status, err := socks5Auth(c, []byte{socksAuthNoneRequired, socksAuthUsernamePassword}, "user", "wrong")
+	if err != nil || status[1] != socksAuthRFC1929Fail {
+		t.Errorf("wrong password: status %x, %v", status, err)
+	}
+	c.Close()
+
+	// Right credentials, which are not parsed as args (they are not valid
+	// args).
+	c = dial()
+	defer c.Close()
+	_, status, err = socks5Auth(c, []byte{socksAuthUsernamePassword}, "user", "secret")
+	if err != nil || status[1] != socksAuthRFC1929Success {
+		t.Fatalf("right credentials: status %x, %v", status, err)
+	}
+	_, err = c.Write([]byte{0x05, 0x01, 0x00, 0x01, 1, 2, 3, 4, 0, 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+		if conn.Req.Target != "1.2.3.4:80" || len(conn.Req.Args) != 0 || conn.Req.Username != "" {
+			t.Errorf("unexpected request %+v", conn.Req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the authenticated connection")
+	}
+	select {
+	case conn := <-accepted:
+		t.Errorf("unauthenticated connection accepted: %+v", conn.Req)
+	default:
+	}
+}
+
+func TestSocksAccessNetworks(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sln := NewSocksListener(ln)
+	sln.Access = &SocksAccess{AllowedNetworks: []*net.IPNet{{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)}}}
+	defer sln.Close()
+	go sln.AcceptSocks()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.Read(make([]byte, 1))
+	if nerr, ok := err.(net.Error); err == nil || ok && nerr.Timeout() {
+		t.Errorf("client outside AllowedNetworks was not disconnected: %v", err)
+	}
+}
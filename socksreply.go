@@ -0,0 +1,47 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Return the SOCKS5 reply code that best describes err, an error from dialing
+// the target of a SOCKS request or from checking its Args, for use with
+// SocksConn.RejectReason:
+//
+//	connection refused                   SocksRepConnectionRefused
+//	network unreachable                  SocksRepNetworkUnreachable
+//	host unreachable or down, DNS error  SocksRepHostUnreachable
+//	timeout                              SocksRepTTLExpired
+//	permission denied                    SocksRepConnectionNotAllowed
+//	address family not supported         SocksRepAddressNotSupported
+//	*ArgsError                           SocksRepConnectionNotAllowed
+//
+// Any other error is SocksRepGeneralFailure. (SOCKS4a has only a single
+// rejection code, which RejectReason sends for all of these.)
+func SocksReplyForError(err error) byte {
+	var argsErr *ArgsError
+	if errors.As(err, &argsErr) {
+		return SocksRepConnectionNotAllowed
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return SocksRepHostUnreachable
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if code, ok := dialErrnoReplies[errno]; ok {
+			return code
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return SocksRepTTLExpired
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return SocksRepTTLExpired
+	}
+	return SocksRepGeneralFailure
+}
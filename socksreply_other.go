@@ -0,0 +1,9 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package pt
+
+import "syscall"
+
+// No errnos are recognized on other platforms.
+var dialErrnoReplies = map[syscall.Errno]byte{}
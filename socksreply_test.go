@@ -0,0 +1,48 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestSocksReplyForError(t *testing.T) {
+	// Get a port with nothing listening on it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	_, refusedErr := net.Dial("tcp", addr)
+	if refusedErr == nil {
+		t.Fatal("dial to closed port succeeded")
+	}
+
+	tests := []struct {
+		err      error
+		expected byte
+	}{
+		{refusedErr, SocksRepConnectionRefused},
+		{fmt.Errorf("wrapped: %w", refusedErr), SocksRepConnectionRefused},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}}, SocksRepHostUnreachable},
+		{context.DeadlineExceeded, SocksRepTTLExpired},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &timeoutError{}}, SocksRepTTLExpired},
+		{&ArgsError{Key: "cert", Err: errors.New("missing")}, SocksRepConnectionNotAllowed},
+		{fmt.Errorf("dialing: %w", &ArgsError{Key: "cert", Err: errors.New("missing")}), SocksRepConnectionNotAllowed},
+		{errors.New("something else"), SocksRepGeneralFailure},
+	}
+	for _, test := range tests {
+		if code := SocksReplyForError(test.err); code != test.expected {
+			t.Errorf("%v: got 0x%02x, expected 0x%02x", test.err, code, test.expected)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
@@ -0,0 +1,18 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package pt
+
+import "syscall"
+
+// SOCKS5 reply codes for the errnos of failed dials.
+var dialErrnoReplies = map[syscall.Errno]byte{
+	syscall.ECONNREFUSED: SocksRepConnectionRefused,
+	syscall.ENETUNREACH:  SocksRepNetworkUnreachable,
+	syscall.EHOSTUNREACH: SocksRepHostUnreachable,
+	syscall.EHOSTDOWN:    SocksRepHostUnreachable,
+	syscall.ETIMEDOUT:    SocksRepTTLExpired,
+	syscall.EACCES:       SocksRepConnectionNotAllowed,
+	syscall.EPERM:        SocksRepConnectionNotAllowed,
+	syscall.EAFNOSUPPORT: SocksRepAddressNotSupported,
+}
@@ -0,0 +1,15 @@
+package pt
+
+import "syscall"
+
+// SOCKS5 reply codes for the Winsock errors of failed dials. Package syscall
+// does not define most of these.
+var dialErrnoReplies = map[syscall.Errno]byte{
+	10061: SocksRepConnectionRefused,    // WSAECONNREFUSED
+	10051: SocksRepNetworkUnreachable,   // WSAENETUNREACH
+	10065: SocksRepHostUnreachable,      // WSAEHOSTUNREACH
+	10064: SocksRepHostUnreachable,      // WSAEHOSTDOWN
+	10060: SocksRepTTLExpired,           // WSAETIMEDOUT
+	10013: SocksRepConnectionNotAllowed, // WSAEACCES
+	10047: SocksRepAddressNotSupported,  // WSAEAFNOSUPPORT
+}
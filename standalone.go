@@ -0,0 +1,308 @@
+package pt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Standalone mode runs the same client and server loops as RunClient and
+// RunServer, but configured by a StandaloneConfig rather than by TOR_PT_*
+// environment variables, so that a transport can be used as a generic tunnel
+// without tor:
+//
+//	if !pt.Managed() {
+//		config, err := pt.LoadStandaloneConfig(os.Args[1])
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		err = pt.RunClientStandalone(config, dialers)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		return
+//	}
+//	pt.RunClient(dialers)
+//
+// A client tunnel accepts plain TCP connections (not SOCKS) on its Listen
+// address and dials Destination through the transport for each one. A server
+// tunnel accepts transport connections on its Listen address, removes the
+// obfuscation, and connects to Destination with plain TCP for each one. No
+// CMETHOD or SMETHOD lines are written.
+
+// Return true iff the process was started as a managed transport, that is,
+// TOR_PT_MANAGED_TRANSPORT_VER is set.
+func Managed() bool {
+	return getenv("TOR_PT_MANAGED_TRANSPORT_VER") != ""
+}
+
+// IsManaged is another name for Managed.
+func IsManaged() bool {
+	return Managed()
+}
+
+// StandaloneTunnel is one listener in a StandaloneConfig.
+type StandaloneTunnel struct {
+	// The transport method name, a key in the map passed to
+	// RunClientStandalone or RunServerStandalone.
+	MethodName string `json:"transport"`
+	// The "host:port" address to listen on.
+	Listen string `json:"listen"`
+	// The "host:port" address to forward each accepted connection to: the
+	// transport server, for a client tunnel, or the application server,
+	// for a server tunnel.
+	Destination string `json:"destination"`
+	// Per-connection arguments passed to the Dialer of a client tunnel, as
+	// they would be in a bridge line. Ignored for server tunnels.
+	Options Args `json:"options,omitempty"`
+}
+
+// StandaloneConfig is the configuration for RunClientStandalone and
+// RunServerStandalone. In JSON form, it looks like
+//
+//	{
+//		"tunnels": [
+//			{
+//				"transport": "foo",
+//				"listen": "127.0.0.1:1080",
+//				"destination": "192.0.2.1:443",
+//				"options": {"key": ["value"]}
+//			}
+//		]
+//	}
+type StandaloneConfig struct {
+	Tunnels []StandaloneTunnel `json:"tunnels"`
+
+	// The file the config was loaded from by LoadStandaloneConfig, or "".
+	filename string
+}
+
+// Decode a JSON StandaloneConfig from r.
+func ReadStandaloneConfig(r io.Reader) (*StandaloneConfig, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var config StandaloneConfig
+	err := dec.Decode(&config)
+	if err != nil {
+		return nil, err
+	}
+	for _, tunnel := range config.Tunnels {
+		if tunnel.MethodName == "" || tunnel.Listen == "" || tunnel.Destination == "" {
+			return nil, fmt.Errorf("tunnel must have transport, listen, and destination")
+		}
+	}
+	return &config, nil
+}
+
+// Read a JSON StandaloneConfig from the named file.
+func LoadStandaloneConfig(filename string) (*StandaloneConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, err := ReadStandaloneConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	config.filename = filename
+	return config, nil
+}
+
+// Run the client tunnels of config, using the Dialers in dialers, and wait for
+// a signal to shut down, as HandleShutdownSignals does. Returns an error
+// without opening any listeners if a tunnel names a method not in dialers, or
+// if a listener cannot be opened; otherwise, returns when shutdown is
+// complete.
+//
+// If config was read by LoadStandaloneConfig, a Reload (as on SIGHUP) reads the
+// file again and applies the new Destination and Options of each tunnel whose
+// transport and listen address are unchanged, without closing its listener.
+// Tunnels that were added or removed are logged and take effect only on
+// restart. If the file cannot be read, the running configuration is kept.
+func RunClientStandalone(config *StandaloneConfig, dialers map[string]Dialer) error {
+	_, err := runClientStandalone(DefaultShutdownManager, config, dialers)
+	if err != nil {
+		return err
+	}
+	HandleReloadSignals(DefaultShutdownManager)
+	HandleDiagnosticsSignals(DefaultShutdownManager)
+	HandleShutdownSignals(DefaultShutdownManager, 0)
+	return nil
+}
+
+// Run the server tunnels of config, using the handlers in handlers as RunServer
+// does, and wait for a signal to shut down, as HandleShutdownSignals does.
+// Returns an error without opening any listeners if a tunnel names a method not
+// in handlers, or if a listener cannot be opened; otherwise, returns when
+// shutdown is complete.
+func RunServerStandalone(config *StandaloneConfig, handlers map[string]func(net.Conn) (net.Conn, error)) error {
+	_, err := runServerStandalone(DefaultShutdownManager, config, handlers)
+	if err != nil {
+		return err
+	}
+	HandleReloadSignals(DefaultShutdownManager)
+	HandleDiagnosticsSignals(DefaultShutdownManager)
+	HandleShutdownSignals(DefaultShutdownManager, 0)
+	return nil
+}
+
+// Open a listener tracked by m, and wrapped by ac, for each tunnel in config,
+// with the socket options of opts if it is not nil. If any cannot be opened,
+// close those that were and return an error.
+func listenStandalone(m *ShutdownManager, ac *AdmissionControl, opts *TCPOptions, config *StandaloneConfig) ([]net.Listener, error) {
+	lc := net.ListenConfig{}
+	if opts != nil && opts.hasControl() {
+		lc.Control = opts.listenControl
+	}
+	listeners := make([]net.Listener, 0, len(config.Tunnels))
+	for _, tunnel := range config.Tunnels {
+		ln, err := lc.Listen(context.Background(), "tcp", tunnel.Listen)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("%s: %s", tunnel.MethodName, err.Error())
+		}
+		tln, err := m.TrackListener(ac.Wrap(ln))
+		if err != nil {
+			ln.Close()
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, tln)
+	}
+	return listeners, nil
+}
+
+// Do the setup part of RunClientStandalone, with listeners and connections
+// tracked by m. Returns the listeners in the order of config.Tunnels.
+func runClientStandalone(m *ShutdownManager, config *StandaloneConfig, dialers map[string]Dialer) ([]net.Listener, error) {
+	for _, tunnel := range config.Tunnels {
+		if _, ok := dialers[tunnel.MethodName]; !ok {
+			return nil, fmt.Errorf("%s: no such method", tunnel.MethodName)
+		}
+	}
+	listeners, err := listenStandalone(m, nil, nil, config)
+	if err != nil {
+		return nil, err
+	}
+	tunnels := make([]*standaloneTunnelState, len(config.Tunnels))
+	for i, tunnel := range config.Tunnels {
+		tunnels[i] = &standaloneTunnelState{tunnel: tunnel}
+		go standaloneClientAcceptLoop(listeners[i], tunnels[i], dialers[tunnel.MethodName])
+	}
+	if config.filename != "" {
+		filename := config.filename
+		onReloadUntil(m, func() error {
+			return reloadStandaloneClient(filename, tunnels)
+		})
+	}
+	return listeners, nil
+}
+
+// A running client tunnel, whose Destination and Options may be replaced by a
+// reload.
+type standaloneTunnelState struct {
+	mu     sync.Mutex
+	tunnel StandaloneTunnel
+}
+
+func (s *standaloneTunnelState) get() StandaloneTunnel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tunnel
+}
+
+func (s *standaloneTunnelState) set(tunnel StandaloneTunnel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tunnel = tunnel
+}
+
+// Read the client configuration in filename again and apply it to the running
+// tunnels that have the same transport and listen address.
+func reloadStandaloneClient(filename string, tunnels []*standaloneTunnelState) error {
+	config, err := LoadStandaloneConfig(filename)
+	if err != nil {
+		return err
+	}
+	seen := make(map[*standaloneTunnelState]bool)
+	for _, tunnel := range config.Tunnels {
+		var state *standaloneTunnelState
+		for _, s := range tunnels {
+			old := s.get()
+			if !seen[s] && old.MethodName == tunnel.MethodName && old.Listen == tunnel.Listen {
+				state = s
+				break
+			}
+		}
+		if state == nil {
+			LogMethod(tunnel.MethodName, LogSeverityWarning, fmt.Sprintf("%s: new tunnel on %s is not opened until restart", tunnel.MethodName, tunnel.Listen))
+			continue
+		}
+		seen[state] = true
+		state.set(tunnel)
+	}
+	for _, s := range tunnels {
+		if !seen[s] {
+			old := s.get()
+			LogMethod(old.MethodName, LogSeverityWarning, fmt.Sprintf("%s: removed tunnel on %s stays open until restart", old.MethodName, old.Listen))
+		}
+	}
+	return nil
+}
+
+// Do the setup part of RunServerStandalone, with listeners and connections
+// tracked by m. Returns the listeners in the order of config.Tunnels.
+func runServerStandalone(m *ShutdownManager, config *StandaloneConfig, handlers map[string]func(net.Conn) (net.Conn, error)) ([]net.Listener, error) {
+	infos := make([]ServerInfo, len(config.Tunnels))
+	for i, tunnel := range config.Tunnels {
+		if _, ok := handlers[tunnel.MethodName]; !ok {
+			return nil, fmt.Errorf("%s: no such method", tunnel.MethodName)
+		}
+		addr, err := net.ResolveTCPAddr("tcp", tunnel.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", tunnel.MethodName, err.Error())
+		}
+		// With no ExtendedOrAddr, DialOr makes a plain TCP connection to
+		// OrAddr.
+		infos[i] = ServerInfo{OrAddr: addr}
+	}
+	listeners, err := listenStandalone(m, DefaultAdmissionControl, &ListenTCPOptions, config)
+	if err != nil {
+		return nil, err
+	}
+	for i, tunnel := range config.Tunnels {
+		go serverAcceptLoop(listeners[i], &infos[i], tunnel.MethodName, handlers[tunnel.MethodName])
+	}
+	return listeners, nil
+}
+
+func standaloneClientAcceptLoop(ln net.Listener, state *standaloneTunnelState, d Dialer) error {
+	return AcceptLoop(ln, 0, func(conn net.Conn) {
+		standaloneClientHandler(conn, state.get(), d)
+	})
+}
+
+func standaloneClientHandler(conn net.Conn, tunnel StandaloneTunnel, d Dialer) error {
+	defer conn.Close()
+	ctx, cancel := newConnContext(tunnel.MethodName, conn.RemoteAddr(), tunnel.Options, time.Now())
+	defer cancel()
+	remote, err := dialContext(ctx, d, "tcp", tunnel.Destination, tunnel.Options)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	relayTraced(ctx, conn, remote)
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadStandaloneConfig(t *testing.T) {
+	config, err := ReadStandaloneConfig(strings.NewReader(`{
+		"tunnels": [
+			{"transport": "foo", "listen": "127.0.0.1:1080", "destination": "192.0.2.1:443", "options": {"key": ["value"]}},
+			{"transport": "bar", "listen": "127.0.0.1:1081", "destination": "192.0.2.2:443"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []StandaloneTunnel{
+		{"foo", "127.0.0.1:1080", "192.0.2.1:443", Args{"key": []string{"value"}}},
+		{"bar", "127.0.0.1:1081", "192.0.2.2:443", nil},
+	}
+	if len(config.Tunnels) != len(expected) {
+		t.Fatalf("got %d tunnels (expected %d)", len(config.Tunnels), len(expected))
+	}
+	for i, tunnel := range config.Tunnels {
+		e := expected[i]
+		if tunnel.MethodName != e.MethodName || tunnel.Listen != e.Listen ||
+			tunnel.Destination != e.Destination || !argsEqual(tunnel.Options, e.Options) {
+			t.Errorf("tunnel %d: got %+v (expected %+v)", i, tunnel, e)
+		}
+	}
+
+	badTests := [...]string{
+		``,
+		`{"tunnels": [{"transport": "foo", "listen": "127.0.0.1:1080"}]}`,
+		`{"tunnels": [{"listen": "127.0.0.1:1080", "destination": "192.0.2.1:443"}]}`,
+		`{"tunnels": [], "unknown": 1}`,
+	}
+	for _, input := range badTests {
+		_, err := ReadStandaloneConfig(strings.NewReader(input))
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestManaged(t *testing.T) {
+	os.Clearenv()
+	if Managed() || IsManaged() {
+		t.Error("Managed() with no TOR_PT_MANAGED_TRANSPORT_VER")
+	}
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	if !Managed() || !IsManaged() {
+		t.Error("!Managed() with TOR_PT_MANAGED_TRANSPORT_VER")
+	}
+}
+
+// Run a client and server tunnel back to back, the client applying an XOR
+// obfuscation that the server removes, and check that data passes through to
+// an echo server.
+func TestStandalone(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+
+	serverListeners, err := runServerStandalone(m, &StandaloneConfig{
+		Tunnels: []StandaloneTunnel{
+			{MethodName: "xor", Listen: "127.0.0.1:0", Destination: echo.Addr().String()},
+		},
+	}, map[string]func(net.Conn) (net.Conn, error){
+		"xor": func(conn net.Conn) (net.Conn, error) {
+			return &xorWriteConn{xorConn{conn, 0xff}}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	argsChan := make(chan Args, 1)
+	clientListeners, err := runClientStandalone(m, &StandaloneConfig{
+		Tunnels: []StandaloneTunnel{
+			{
+				MethodName:  "xor",
+				Listen:      "127.0.0.1:0",
+				Destination: serverListeners[0].Addr().String(),
+				Options:     Args{"key": []string{"value"}},
+			},
+		},
+	}, map[string]Dialer{
+		"xor": DialerFunc(func(network, address string, args Args) (net.Conn, error) {
+			argsChan <- args
+			conn, err := net.Dial(network, address)
+			if err != nil {
+				return nil, err
+			}
+			return &xorWriteConn{xorConn{conn, 0xff}}, nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", clientListeners[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	checkEcho(t, conn)
+	if gotArgs := <-argsChan; !argsEqual(gotArgs, Args{"key": []string{"value"}}) {
+		t.Errorf("Dialer got args %q", gotArgs)
+	}
+
+	_, err = runClientStandalone(m, &StandaloneConfig{
+		Tunnels: []StandaloneTunnel{
+			{MethodName: "bogus", Listen: "127.0.0.1:0", Destination: "127.0.0.1:1"},
+		},
+	}, map[string]Dialer{})
+	if err == nil {
+		t.Error("runClientStandalone with an unknown method unexpectedly succeeded")
+	}
+}
+
+// An xorConn that XORs outgoing data as well as incoming.
+type xorWriteConn struct {
+	xorConn
+}
+
+func (c *xorWriteConn) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i := range p {
+		q[i] = p[i] ^ c.key
+	}
+	return c.Conn.Write(q)
+}
@@ -0,0 +1,115 @@
+package pt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Return the path of the file called name in the state directory, creating the
+// directory if necessary. name must not be empty or contain a path separator.
+func stateFilePath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid state file name %q", name)
+	}
+	dir, err := MakeStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// Read the file called name in the state directory (see MakeStateDir). If the
+// file does not exist, the error satisfies os.IsNotExist.
+func ReadStateFile(name string) ([]byte, error) {
+	filename, err := stateFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// Write data to the file called name in the state directory, readable and
+// writable only by the owner. The file is replaced atomically: after a crash,
+// it has either its old contents or data, never a mixture. Use it for identity
+// keys and other state that must survive restarts.
+func WriteStateFile(name string, data []byte) error {
+	filename, err := stateFilePath(name)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// Decode the JSON in the file called name in the state directory into v. If the
+// file does not exist, the error satisfies os.IsNotExist.
+func ReadStateJSON(name string, v interface{}) error {
+	data, err := ReadStateFile(name)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return nil
+}
+
+// Encode v as JSON and write it with WriteStateFile.
+func WriteStateJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return WriteStateFile(name, data)
+}
+
+// Replace filename with data, with mode 0600: write a temporary file in the same
+// directory, flush it to disk, and rename it over filename, then flush the
+// directory so that the rename itself is durable.
+func writeFileAtomic(filename string, data []byte) error {
+	dir := filepath.Dir(filename)
+	f, err := ioutil.TempFile(dir, filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// Remove the temporary file if anything goes wrong before the rename.
+	defer os.Remove(tmp)
+	err = f.Chmod(0600)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, filename)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Flush the directory entries of dir to disk. Directories cannot be synced on
+// Windows, where this does nothing.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,86 @@
+package pt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestStateFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TOR_PT_STATE_LOCATION", filepath.Join(dir, "state"))
+
+	_, err := ReadStateFile("key")
+	if !os.IsNotExist(err) {
+		t.Errorf("missing file: got error %v", err)
+	}
+
+	for _, data := range [][]byte{[]byte("first"), []byte("second")} {
+		err = WriteStateFile("key", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadStateFile("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(data) {
+			t.Errorf("got %q, expected %q", got, data)
+		}
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "state", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("mode %v, expected 0600", fi.Mode().Perm())
+	}
+	// No temporary files are left behind.
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("state directory has %d entries, expected 1", len(entries))
+	}
+
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`} {
+		err = WriteStateFile(name, nil)
+		if err == nil {
+			t.Errorf("%q unexpectedly succeeded", name)
+		}
+	}
+}
+
+func TestStateJSON(t *testing.T) {
+	t.Setenv("TOR_PT_STATE_LOCATION", t.TempDir())
+
+	type state struct {
+		Key  []byte
+		Port int
+	}
+	err := WriteStateJSON("state.json", state{[]byte{1, 2, 3}, 443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got state
+	err = ReadStateJSON("state.json", &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Key) != "\x01\x02\x03" || got.Port != 443 {
+		t.Errorf("got %+v", got)
+	}
+
+	err = WriteStateFile("bad.json", []byte("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ReadStateJSON("bad.json", &got)
+	if err == nil {
+		t.Error("bad JSON unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,155 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// TCPOptions are socket options to apply to a TCP connection. The zero value
+// leaves every option at its default: for connections made by the net package,
+// that means TCP_NODELAY on, keepalives every 15 seconds, and buffer sizes
+// chosen by the operating system.
+//
+// TOS, Mark, Device, and FastOpen are set on the socket before it connects or
+// listens. A connection accepted from a listener has the TOS, Mark, and Device
+// of the listener.
+type TCPOptions struct {
+	// If not nil, TCP_NODELAY is set to *NoDelay.
+	NoDelay *bool
+	// If positive, keepalives are enabled with this period. If negative,
+	// keepalives are disabled.
+	KeepAlivePeriod time.Duration
+	// If positive, the size of the socket's receive and send buffers.
+	ReadBuffer  int
+	WriteBuffer int
+	// If positive, the IP_TOS (IPv4) or IPV6_TCLASS (IPv6) of the socket.
+	// The DSCP is the upper six bits: DSCP d is TOS d<<2.
+	TOS int
+	// If positive, the SO_MARK of the socket, for policy routing and
+	// firewall rules. Setting it requires CAP_NET_ADMIN. Linux only.
+	Mark int
+	// If not empty, the name of a network interface (such as "eth0") to
+	// which the socket is bound, so that its traffic goes only through that
+	// interface: SO_BINDTODEVICE on Linux, IP_BOUND_IF or IPV6_BOUND_IF on
+	// macOS. On Linux before 5.7, setting it requires CAP_NET_RAW.
+	Device string
+	// If true, use TCP Fast Open (RFC 7413), which lets a client that has
+	// connected to the same server before send data with its SYN, saving a
+	// round trip. Listeners accept Fast Open connections on Linux, macOS,
+	// and FreeBSD; connections are made with it on Linux 4.11 and later.
+	// Elsewhere, or if the kernel has Fast Open disabled (see the
+	// net.ipv4.tcp_fastopen sysctl on Linux), FastOpen is ignored and
+	// sockets work as without it. A connection made with Fast Open may not
+	// reach the server until something is written to it; DialOr writes
+	// first on the extended ORPort when OrTCPOptions has FastOpen set.
+	FastOpen bool
+}
+
+// Socket options for the TCP listeners opened by Bindaddr.Listen and
+// Bindaddr.ListenReusePort, and so by RunServer and ServeTransports, and for
+// the server tunnels of RunServerStandalone. Of the fields of TCPOptions, only
+// those set before a socket listens are used; see TCPOptions. A listener whose
+// options cannot be set is not opened.
+var ListenTCPOptions TCPOptions
+
+// Return whether opts has options that must be set before a socket connects or
+// listens.
+func (opts *TCPOptions) hasControl() bool {
+	return opts.TOS > 0 || opts.Mark > 0 || opts.Device != "" || opts.FastOpen
+}
+
+// Set the options of opts that must be set before a socket connects. It has the
+// signature of net.Dialer.Control.
+func (opts *TCPOptions) control(network, address string, c syscall.RawConn) error {
+	return opts.setControl(network, c, false)
+}
+
+// Like control, but for a socket that is to listen.
+func (opts *TCPOptions) listenControl(network, address string, c syscall.RawConn) error {
+	return opts.setControl(network, c, true)
+}
+
+func (opts *TCPOptions) setControl(network string, c syscall.RawConn, listening bool) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if opts.FastOpen {
+			// An error means Fast Open is unsupported or disabled;
+			// the socket works without it.
+			setFastOpen(fd, listening)
+		}
+		if opts.TOS > 0 {
+			if err := setTOS(fd, network, opts.TOS); err != nil {
+				sockErr = fmt.Errorf("cannot set TOS: %s", err.Error())
+				return
+			}
+		}
+		if opts.Mark > 0 {
+			if err := setMark(fd, opts.Mark); err != nil {
+				sockErr = fmt.Errorf("cannot set SO_MARK: %s", err.Error())
+				return
+			}
+		}
+		if opts.Device != "" {
+			if err := bindToDevice(fd, network, opts.Device); err != nil {
+				sockErr = fmt.Errorf("cannot bind to device %s: %s", opts.Device, err.Error())
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// Open a TCP listener on addr with the options of opts.
+func listenTCPOptions(network string, addr *net.TCPAddr, opts *TCPOptions) (*net.TCPListener, error) {
+	if !opts.hasControl() {
+		return net.ListenTCP(network, addr)
+	}
+	lc := net.ListenConfig{Control: opts.listenControl}
+	var address string
+	if addr != nil {
+		address = addr.String()
+	}
+	ln, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	return ln.(*net.TCPListener), nil
+}
+
+// Apply opts to c.
+func (opts *TCPOptions) apply(c *net.TCPConn) error {
+	if opts.NoDelay != nil {
+		if err := c.SetNoDelay(*opts.NoDelay); err != nil {
+			return err
+		}
+	}
+	if opts.KeepAlivePeriod > 0 {
+		if err := c.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := c.SetKeepAlivePeriod(opts.KeepAlivePeriod); err != nil {
+			return err
+		}
+	} else if opts.KeepAlivePeriod < 0 {
+		if err := c.SetKeepAlive(false); err != nil {
+			return err
+		}
+	}
+	if opts.ReadBuffer > 0 {
+		if err := c.SetReadBuffer(opts.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.WriteBuffer > 0 {
+		if err := c.SetWriteBuffer(opts.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
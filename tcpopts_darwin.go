@@ -0,0 +1,31 @@
+package pt
+
+import (
+	"net"
+	"syscall"
+)
+
+// Set IP_BOUND_IF or, if network is "tcp6", IPV6_BOUND_IF on fd to the index of
+// the interface named device.
+func bindToDevice(fd uintptr, network, device string) error {
+	ifi, err := net.InterfaceByName(device)
+	if err != nil {
+		return err
+	}
+	if network == "tcp6" {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_BOUND_IF, ifi.Index)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_BOUND_IF, ifi.Index)
+}
+
+// TCP_FASTOPEN from <netinet/tcp.h>.
+const tcpFastOpen = 0x105
+
+// Set TCP_FASTOPEN on fd if listening. Making connections with Fast Open needs
+// connectx, which package net does not use.
+func setFastOpen(fd uintptr, listening bool) error {
+	if !listening {
+		return nil
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpen, 1)
+}
@@ -0,0 +1,31 @@
+package pt
+
+import "syscall"
+
+// From <linux/tcp.h>, which package syscall lacks on some architectures.
+const (
+	tcpFastOpen        = 23
+	tcpFastOpenConnect = 30
+)
+
+// The length of the queue of Fast Open connections not yet accepted, for
+// TCP_FASTOPEN on a listener.
+const fastOpenQueueLength = 256
+
+// Set SO_MARK on fd.
+func setMark(fd uintptr, mark int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+}
+
+// Set SO_BINDTODEVICE on fd.
+func bindToDevice(fd uintptr, network, device string) error {
+	return syscall.BindToDevice(int(fd), device)
+}
+
+// Set TCP_FASTOPEN on fd if listening, or TCP_FASTOPEN_CONNECT if not.
+func setFastOpen(fd uintptr, listening bool) error {
+	if listening {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpen, fastOpenQueueLength)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+}
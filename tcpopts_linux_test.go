@@ -0,0 +1,150 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Return the integer socket option of c at level with name.
+func getsockoptInt(t *testing.T, c syscall.Conn, level, name int) int {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		v, sockErr = syscall.GetsockoptInt(int(fd), level, name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return v
+}
+
+func TestTCPOptionsTOSMark(t *testing.T) {
+	opts := TCPOptions{TOS: 0x20, Mark: 3}
+	// Setting SO_MARK needs CAP_NET_ADMIN.
+	ln, err := listenTCPOptions("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, &opts)
+	if errors.Is(err, os.ErrPermission) {
+		opts.Mark = 0
+		ln, err = listenTCPOptions("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, &opts)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if tos := getsockoptInt(t, ln, syscall.IPPROTO_IP, syscall.IP_TOS); tos != opts.TOS {
+		t.Errorf("listener has TOS %#x (expected %#x)", tos, opts.TOS)
+	}
+	if opts.Mark != 0 {
+		if mark := getsockoptInt(t, ln, syscall.SOL_SOCKET, syscall.SO_MARK); mark != opts.Mark {
+			t.Errorf("listener has mark %d (expected %d)", mark, opts.Mark)
+		}
+	}
+
+	dialOpts := TCPOptions{TOS: 0xb8}
+	if opts.Mark != 0 {
+		dialOpts.Mark = 5
+	}
+	c, err := dialTCPAddrs(context.Background(), []*net.TCPAddr{ln.Addr().(*net.TCPAddr)}, &dialOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if tos := getsockoptInt(t, c, syscall.IPPROTO_IP, syscall.IP_TOS); tos != dialOpts.TOS {
+		t.Errorf("connection has TOS %#x (expected %#x)", tos, dialOpts.TOS)
+	}
+	if dialOpts.Mark != 0 {
+		if mark := getsockoptInt(t, c, syscall.SOL_SOCKET, syscall.SO_MARK); mark != dialOpts.Mark {
+			t.Errorf("connection has mark %d (expected %d)", mark, dialOpts.Mark)
+		}
+	}
+
+	// IPv6 sockets get IPV6_TCLASS.
+	ln6, err := listenTCPOptions("tcp", &net.TCPAddr{IP: net.IPv6loopback}, &TCPOptions{TOS: 0x20})
+	if err != nil {
+		t.Skipf("cannot listen on IPv6: %v", err)
+	}
+	defer ln6.Close()
+	if tclass := getsockoptInt(t, ln6, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS); tclass != 0x20 {
+		t.Errorf("IPv6 listener has traffic class %#x (expected %#x)", tclass, 0x20)
+	}
+}
+
+func TestTCPOptionsDevice(t *testing.T) {
+	ln, err := listenTCPOptions("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, &TCPOptions{Device: "lo"})
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addrs := []*net.TCPAddr{ln.Addr().(*net.TCPAddr)}
+
+	c, err := dialTCPAddrs(context.Background(), addrs, &TCPOptions{Device: "lo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	_, err = dialTCPAddrs(context.Background(), addrs, &TCPOptions{Device: "nonexistent0"})
+	if err == nil {
+		t.Error("dial bound to a nonexistent device unexpectedly succeeded")
+	}
+}
+
+// With FastOpen, extended ORPort authentication writes first, so that it works
+// whether or not the kernel defers the connection until the first write.
+func TestTCPOptionsFastOpen(t *testing.T) {
+	cookie := bytes.Repeat([]byte{'C'}, 32)
+	opts := TCPOptions{FastOpen: true}
+	ln, err := listenTCPOptions("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if qlen := getsockoptInt(t, ln, syscall.IPPROTO_TCP, tcpFastOpen); qlen != fastOpenQueueLength {
+		t.Errorf("listener has TCP_FASTOPEN %d (expected %d)", qlen, fastOpenQueueLength)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				simulateServerExtOrPortAuth(c, c, cookie)
+			}()
+		}
+	}()
+
+	info := &ServerInfo{
+		OrTCPOptions: opts,
+		AuthCookie:   func() ([]byte, error) { return append([]byte(nil), cookie...), nil },
+	}
+	// The first connection gets a Fast Open cookie, if the kernel allows
+	// it; later ones use it.
+	for i := 0; i < 3; i++ {
+		c, err := dialTCPAddrs(context.Background(), []*net.TCPAddr{ln.Addr().(*net.TCPAddr)}, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = extOrPortAuthOnly(c, 5*time.Second, info)
+		c.Close()
+		if err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package pt
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func bindToDevice(fd uintptr, network, device string) error {
+	return fmt.Errorf("not supported on %s", runtime.GOOS)
+}
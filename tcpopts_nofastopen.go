@@ -0,0 +1,8 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package pt
+
+func setFastOpen(fd uintptr, listening bool) error {
+	return nil
+}
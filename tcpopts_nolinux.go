@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package pt
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setMark(fd uintptr, mark int) error {
+	return fmt.Errorf("not supported on %s", runtime.GOOS)
+}
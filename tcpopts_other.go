@@ -0,0 +1,13 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package pt
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setTOS(fd uintptr, network string, tos int) error {
+	return fmt.Errorf("not supported on %s", runtime.GOOS)
+}
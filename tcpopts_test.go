@@ -0,0 +1,61 @@
+package pt
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialOrTCPOptions(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	noDelay := false
+	for _, opts := range []TCPOptions{
+		{},
+		{NoDelay: &noDelay, KeepAlivePeriod: 30 * time.Second, ReadBuffer: 65536, WriteBuffer: 65536},
+		{KeepAlivePeriod: -1},
+	} {
+		info := &ServerInfo{OrAddr: ln.Addr().(*net.TCPAddr), OrTCPOptions: opts}
+		s, err := DialOr(info, "", "")
+		if err != nil {
+			t.Errorf("%+v: %v", opts, err)
+			continue
+		}
+		s.Close()
+	}
+}
+
+func TestTCPOptionsApplyError(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	noDelay := true
+	for _, opts := range []TCPOptions{
+		{NoDelay: &noDelay},
+		{KeepAlivePeriod: time.Second},
+		{KeepAlivePeriod: -1},
+		{ReadBuffer: 4096},
+		{WriteBuffer: 4096},
+	} {
+		if err := opts.apply(s); err == nil {
+			t.Errorf("%+v: apply to a closed connection unexpectedly succeeded", opts)
+		}
+	}
+	// Nothing to apply is not an error.
+	opts := TCPOptions{}
+	if err := opts.apply(s); err != nil {
+		t.Errorf("zero TCPOptions: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package pt
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnTimeouts are limits on how long a connection may be used. The zero value
+// sets no limits.
+type ConnTimeouts struct {
+	// If positive, the connection fails when it has had no activity, in
+	// either direction, for this long. Each successful Read or Write
+	// postpones the timeout.
+	Idle time.Duration
+	// If positive, the connection fails this long after it is wrapped,
+	// regardless of activity.
+	Lifetime time.Duration
+}
+
+// Timeouts applied by RunClient, RunServer, ServeTransports, and the
+// standalone modes to the connections they relay. AcceptedConnTimeouts apply
+// to accepted connections: SOCKS connections from tor on the client side, and
+// transport connections from clients on the server side. RemoteConnTimeouts
+// apply to the connections made for them: through the transport on the client
+// side, and to the ORPort on the server side. When a timeout expires on either
+// connection, both are closed.
+var (
+	AcceptedConnTimeouts ConnTimeouts
+	RemoteConnTimeouts   ConnTimeouts
+)
+
+// Return c wrapped so that it enforces t, or c itself if t sets no limits.
+func (t ConnTimeouts) Wrap(c net.Conn) net.Conn {
+	if t.Idle <= 0 && t.Lifetime <= 0 {
+		return c
+	}
+	var deadline time.Time
+	if t.Lifetime > 0 {
+		deadline = time.Now().Add(t.Lifetime)
+	}
+	return WithTimeouts(c, t.Idle, deadline)
+}
+
+// Return c wrapped so that Read and Write fail with a timeout error when c has
+// had no successful Read or Write for the idle duration, or after the absolute
+// time deadline, whichever is first. An idle of 0 means no idle timeout, and a
+// zero deadline means no absolute timeout. Deadlines set with SetDeadline,
+// SetReadDeadline, and SetWriteDeadline on the returned conn still apply, in
+// addition to these.
+//
+// Activity in one direction resets the idle timeout for the other: a Read that
+// is blocked while Writes are succeeding does not time out.
+func WithTimeouts(c net.Conn, idle time.Duration, deadline time.Time) net.Conn {
+	tc := &timeoutConn{Conn: c, idle: idle, deadline: deadline}
+	tc.touch()
+	return tc
+}
+
+type timeoutConn struct {
+	net.Conn
+	idle     time.Duration
+	deadline time.Time
+
+	mu            sync.Mutex
+	last          time.Time
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// Half-close the underlying conn, if it supports it.
+func (c *timeoutConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// Record activity.
+func (c *timeoutConn) touch() {
+	c.mu.Lock()
+	c.last = time.Now()
+	c.mu.Unlock()
+}
+
+// Return the earliest of the nonzero times in ts, or the zero Time if there are
+// none.
+func earliest(ts ...time.Time) time.Time {
+	var result time.Time
+	for _, t := range ts {
+		if !t.IsZero() && (result.IsZero() || t.Before(result)) {
+			result = t
+		}
+	}
+	return result
+}
+
+// Return the deadline to set on the underlying conn, given the deadline set by
+// the caller for the direction.
+func (c *timeoutConn) effectiveDeadline(user time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var idle time.Time
+	if c.idle > 0 {
+		idle = c.last.Add(c.idle)
+	}
+	return earliest(user, c.deadline, idle)
+}
+
+// Return true if err is a timeout that happened only because of the idle
+// timeout, and there has been activity since the deadline was set, so that the
+// operation should be tried again.
+func (c *timeoutConn) shouldRetry(err error, user time.Time) bool {
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() || c.idle <= 0 {
+		return false
+	}
+	now := time.Now()
+	if (!user.IsZero() && !now.Before(user)) || (!c.deadline.IsZero() && !now.Before(c.deadline)) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now.Before(c.last.Add(c.idle))
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		user := c.readDeadline
+		c.mu.Unlock()
+		err := c.Conn.SetReadDeadline(c.effectiveDeadline(user))
+		if err != nil {
+			return 0, err
+		}
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.touch()
+		}
+		if n == 0 && err != nil && c.shouldRetry(err, user) {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	var total int
+	for {
+		c.mu.Lock()
+		user := c.writeDeadline
+		c.mu.Unlock()
+		err := c.Conn.SetWriteDeadline(c.effectiveDeadline(user))
+		if err != nil {
+			return total, err
+		}
+		n, err := c.Conn.Write(p[total:])
+		total += n
+		if n > 0 {
+			c.touch()
+		}
+		if err != nil && c.shouldRetry(err, user) {
+			continue
+		}
+		return total, err
+	}
+}
+
+func (c *timeoutConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(c.effectiveDeadline(t))
+}
+
+func (c *timeoutConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(c.effectiveDeadline(t))
+}
+
+func (c *timeoutConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(c.effectiveDeadline(t))
+}
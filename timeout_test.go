@@ -0,0 +1,105 @@
+package pt
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func isTimeout(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
+}
+
+func TestConnTimeoutsZero(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if c := (ConnTimeouts{}).Wrap(a); c != a {
+		t.Errorf("zero ConnTimeouts wrapped the conn")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := WithTimeouts(a, 50*time.Millisecond, time.Time{})
+
+	begin := time.Now()
+	_, err := c.Read(make([]byte, 1))
+	if !isTimeout(err) {
+		t.Fatalf("idle Read: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed < 50*time.Millisecond {
+		t.Errorf("idle Read timed out after only %s", elapsed)
+	}
+}
+
+func TestIdleTimeoutOtherDirection(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := WithTimeouts(a, 50*time.Millisecond, time.Time{})
+
+	// Writes keep a blocked Read from timing out.
+	go io.Copy(ioutil.Discard, b)
+	go func() {
+		for i := 0; i < 10; i++ {
+			c.Write([]byte("x"))
+			time.Sleep(20 * time.Millisecond)
+		}
+		b.Write([]byte("y"))
+	}()
+	p := make([]byte, 1)
+	_, err := c.Read(p)
+	if err != nil {
+		t.Fatalf("Read with activity in the other direction: %v", err)
+	}
+	if string(p) != "y" {
+		t.Errorf("got %q", p)
+	}
+}
+
+func TestLifetimeTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := (ConnTimeouts{Idle: time.Hour, Lifetime: 50 * time.Millisecond}).Wrap(a)
+
+	go func() {
+		for {
+			if _, err := b.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	begin := time.Now()
+	for {
+		_, err := c.Read(make([]byte, 1))
+		if err != nil {
+			if !isTimeout(err) {
+				t.Fatalf("Read: %v", err)
+			}
+			break
+		}
+		if time.Since(begin) > 5*time.Second {
+			t.Fatal("no timeout despite lifetime")
+		}
+	}
+}
+
+func TestTimeoutConnUserDeadline(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := WithTimeouts(a, time.Hour, time.Time{})
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := c.Read(make([]byte, 1))
+	if !isTimeout(err) {
+		t.Errorf("Read past caller's deadline: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime/pprof"
+	"sync/atomic"
+)
+
+// PTTrace is a set of hooks to run at stages of a connection's life, in the
+// manner of net/http/httptrace. Any hook may be nil. Attach a PTTrace to a
+// context with WithPTTrace; the library calls the hooks of the PTTrace attached
+// to the context of each connection it handles (see ConnContext) and to the
+// context passed to DialOrContext.
+//
+// Hooks for one connection may be called from different goroutines, and
+// ORDialStart may be called concurrently with itself.
+type PTTrace struct {
+	// Called by RunClient and ServeTransports with the SOCKS request of a
+	// client connection, before the method's Dialer is called.
+	GotSocksRequest func(req *SocksRequest)
+	// Called by DialOrContext as it starts each attempt to connect to addr,
+	// an ORPort or extended ORPort address. When there is more than one
+	// address, attempts may overlap.
+	ORDialStart func(addr *net.TCPAddr)
+	// Called by DialOrContext when it has connected to the extended ORPort
+	// and authentication begins, or, with an ORPoolSize, as it starts
+	// sending the metadata on each pooled connection it tries. Not called
+	// for connections to a plain ORPort.
+	ORAuthStart func()
+	// Called by DialOrContext when authentication and metadata exchange on
+	// the extended ORPort is finished, with the error, if any. Not called
+	// for connections to a plain ORPort.
+	ORAuthDone func(err error)
+	// Called when both sides of a connection are open and data begins to
+	// be relayed between them.
+	RelayStart func()
+	// Called when relaying is finished and both sides of the connection are
+	// closed. sent is the number of bytes read from the accepted connection
+	// and written to the other side; received is the number written back.
+	ConnClosed func(sent, received int64)
+}
+
+type ptTraceKey struct{}
+
+// Return a copy of ctx that carries trace. If ctx already carries a PTTrace,
+// both are called, the hooks of trace first.
+func WithPTTrace(ctx context.Context, trace *PTTrace) context.Context {
+	if old := ContextPTTrace(ctx); old != nil {
+		trace = composeTraces(trace, old)
+	}
+	return context.WithValue(ctx, ptTraceKey{}, trace)
+}
+
+// Return the PTTrace carried by ctx, or nil if there is none.
+func ContextPTTrace(ctx context.Context) *PTTrace {
+	trace, _ := ctx.Value(ptTraceKey{}).(*PTTrace)
+	return trace
+}
+
+// Return a PTTrace whose hooks call those of a, then those of b.
+func composeTraces(a, b *PTTrace) *PTTrace {
+	return &PTTrace{
+		GotSocksRequest: func(req *SocksRequest) {
+			a.gotSocksRequest(req)
+			b.gotSocksRequest(req)
+		},
+		ORDialStart: func(addr *net.TCPAddr) {
+			a.orDialStart(addr)
+			b.orDialStart(addr)
+		},
+		ORAuthStart: func() {
+			a.orAuthStart()
+			b.orAuthStart()
+		},
+		ORAuthDone: func(err error) {
+			a.orAuthDone(err)
+			b.orAuthDone(err)
+		},
+		RelayStart: func() {
+			a.relayStart()
+			b.relayStart()
+		},
+		ConnClosed: func(sent, received int64) {
+			a.connClosed(sent, received)
+			b.connClosed(sent, received)
+		},
+	}
+}
+
+// The following call the corresponding hook, if trace and the hook are not nil.
+
+func (trace *PTTrace) gotSocksRequest(req *SocksRequest) {
+	if trace != nil && trace.GotSocksRequest != nil {
+		trace.GotSocksRequest(req)
+	}
+}
+
+func (trace *PTTrace) orDialStart(addr *net.TCPAddr) {
+	if trace != nil && trace.ORDialStart != nil {
+		trace.ORDialStart(addr)
+	}
+}
+
+func (trace *PTTrace) orAuthStart() {
+	if trace != nil && trace.ORAuthStart != nil {
+		trace.ORAuthStart()
+	}
+}
+
+func (trace *PTTrace) orAuthDone(err error) {
+	if trace != nil && trace.ORAuthDone != nil {
+		trace.ORAuthDone(err)
+	}
+}
+
+func (trace *PTTrace) relayStart() {
+	if trace != nil && trace.RelayStart != nil {
+		trace.RelayStart()
+	}
+}
+
+func (trace *PTTrace) connClosed(sent, received int64) {
+	if trace != nil && trace.ConnClosed != nil {
+		trace.ConnClosed(sent, received)
+	}
+}
+
+// Relay between conn, the accepted connection, and remote, calling the
+// RelayStart and ConnClosed hooks of the PTTrace in ctx and counting the bytes
+// under the method name of the ConnInfo in ctx. AcceptedConnTimeouts and
+// RemoteConnTimeouts are applied to conn and remote, and the bandwidth limits
+// to conn. The relaying goroutines have a pprof "method" label with the method
+// name, so that profiles show the work done for each transport.
+func relayTraced(ctx context.Context, conn, remote net.Conn) {
+	var methodName string
+	var remoteAddr net.Addr
+	if info, ok := ConnInfoFromContext(ctx); ok {
+		methodName = info.MethodName
+		remoteAddr = info.RemoteAddr
+	}
+	trace := ContextPTTrace(ctx)
+	trace.relayStart()
+	labelCtx := pprof.WithLabels(ctx, pprof.Labels("method", methodName))
+	sent, received := copyLoop(labelCtx, AcceptedConnTimeouts.Wrap(rateLimitAccepted(conn)), RemoteConnTimeouts.Wrap(remote))
+	counters := statsFor(methodName)
+	atomic.AddUint64(&counters.bytesSent, uint64(sent))
+	atomic.AddUint64(&counters.bytesReceived, uint64(received))
+	trace.connClosed(sent, received)
+	if methodLogEnabled(methodName, LogSeverityDebug) {
+		LogMethod(methodName, LogSeverityDebug, fmt.Sprintf("connection from %s closed; %d bytes sent, %d received", SafeAddr(remoteAddr), sent, received))
+	}
+}
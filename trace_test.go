@@ -0,0 +1,158 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithPTTraceCompose(t *testing.T) {
+	var calls []string
+	ctx := WithPTTrace(context.Background(), &PTTrace{
+		RelayStart: func() { calls = append(calls, "old") },
+	})
+	ctx = WithPTTrace(ctx, &PTTrace{
+		RelayStart: func() { calls = append(calls, "new") },
+	})
+	trace := ContextPTTrace(ctx)
+	trace.relayStart()
+	// Hooks that are nil in both must not panic.
+	trace.connClosed(0, 0)
+	if !stringSlicesEqual(calls, []string{"new", "old"}) {
+		t.Errorf("got calls %q", calls)
+	}
+
+	if ContextPTTrace(context.Background()) != nil {
+		t.Error("PTTrace in empty context")
+	}
+	// Calling hooks of a nil PTTrace is a no-op.
+	var nilTrace *PTTrace
+	nilTrace.orAuthDone(nil)
+}
+
+func TestRunServerTrace(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	var mu sync.Mutex
+	var events []string
+	var sent, received int64
+	closed := make(chan struct{})
+	record := func(event string) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+	ConnContext = func(ctx context.Context) context.Context {
+		return WithPTTrace(ctx, &PTTrace{
+			ORDialStart: func(addr *net.TCPAddr) { record("ORDialStart " + addr.String()) },
+			ORAuthStart: func() { record("ORAuthStart") },
+			ORAuthDone:  func(err error) { record("ORAuthDone") },
+			RelayStart:  func() { record("RelayStart") },
+			ConnClosed: func(s, r int64) {
+				record("ConnClosed")
+				sent, received = s, r
+				close(closed)
+			},
+		})
+	}
+	defer func() { ConnContext = nil }()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_SERVER_TRANSPORTS", "foo")
+	os.Setenv("TOR_PT_SERVER_BINDADDR", "foo-127.0.0.1:0")
+	os.Setenv("TOR_PT_ORPORT", echo.Addr().String())
+	before := statsForMethod("foo")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := runServer(m, map[string]func(net.Conn) (net.Conn, error){
+		"foo": nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "SMETHOD foo "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write([]byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(conn, make([]byte, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnClosed not called")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"ORDialStart " + echo.Addr().String(), "RelayStart", "ConnClosed"}
+	if !stringSlicesEqual(events, expected) {
+		t.Errorf("got events %q, expected %q", events, expected)
+	}
+	if sent != 3 || received != 3 {
+		t.Errorf("got sent=%d received=%d, expected 3 and 3", sent, received)
+	}
+	after := statsForMethod("foo")
+	if after.BytesSent-before.BytesSent != 3 || after.BytesReceived-before.BytesReceived != 3 {
+		t.Errorf("byte counters went from %+v to %+v", before, after)
+	}
+}
+
+func TestRunClientTraceSocksRequest(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	targets := make(chan string, 1)
+	ConnContext = func(ctx context.Context) context.Context {
+		return WithPTTrace(ctx, &PTTrace{
+			GotSocksRequest: func(req *SocksRequest) { targets <- req.Target },
+		})
+	}
+	defer func() { ConnContext = nil }()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "foo")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := runClient(m, map[string]Dialer{
+		"foo": DialerFunc(func(network, address string, args Args) (net.Conn, error) {
+			return net.Dial(network, address)
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "CMETHOD foo socks5 "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = socks5Connect(conn, echo.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := <-targets; !strings.HasSuffix(target, echo.Addr().String()) {
+		t.Errorf("got target %q, expected %q", target, echo.Addr())
+	}
+}
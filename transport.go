@@ -0,0 +1,253 @@
+package pt
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// Transport is a pluggable transport, described in the manner of the Go API of
+// version 2 of the Pluggable Transport Specification. A Transport that is
+// written against these interfaces can be run under tor's version 1
+// managed-proxy protocol with ServeTransports, or used directly by a program
+// that dials and listens itself.
+//
+// https://github.com/Pluggable-Transports/Pluggable-Transports-spec/tree/main/releases
+type Transport interface {
+	// The transport method name, as used in CMETHOD and SMETHOD lines.
+	Name() string
+	// Return a ClientFactory for the client side of the transport. Returns
+	// an error if the transport has no client side or cannot work with
+	// the given configuration.
+	ClientFactory(config *ClientConfig) (ClientFactory, error)
+	// Return a ServerFactory for the server side of the transport. Returns
+	// an error if the transport has no server side or cannot work with
+	// the given configuration.
+	ServerFactory(config *ServerConfig) (ServerFactory, error)
+}
+
+// ClientConfig is configuration common to all client connections of a
+// Transport.
+type ClientConfig struct {
+	// A directory in which the transport may keep persistent state, or ""
+	// if there is none.
+	StateDir string
+	// The upstream proxy through which the transport must make its
+	// outgoing connections, or nil. A ClientFactory that cannot use the
+	// proxy must return an error rather than ignore it.
+	ProxyURL *url.URL
+}
+
+// ServerConfig is configuration for one server listener of a Transport.
+type ServerConfig struct {
+	// A directory in which the transport may keep persistent state, or ""
+	// if there is none.
+	StateDir string
+	// Options for this transport, as from TOR_PT_SERVER_TRANSPORT_OPTIONS,
+	// with defaults filled in if the Transport is an OptionSchemer.
+	Options Args
+	// The Bindaddr that the listener is for. Its Options are the same as
+	// Options.
+	Bindaddr Bindaddr
+}
+
+// ClientFactory makes outgoing connections for the client side of a
+// Transport. Every ClientFactory is also a Dialer.
+type ClientFactory interface {
+	// Connect to the transport server at address, with per-connection
+	// arguments args (for tor, those from the bridge line), and return a
+	// connection that carries unobfuscated data.
+	Dial(network, address string, args Args) (net.Conn, error)
+}
+
+// ServerFactory opens listeners for the server side of a Transport.
+type ServerFactory interface {
+	// Listen for transport clients at address. The listener's Accept
+	// method must return connections that carry unobfuscated data.
+	Listen(network, address string) (net.Listener, error)
+}
+
+// ServerArgser may be implemented by a ServerFactory that needs to publish
+// arguments for clients (such as a public key) in an SMETHOD ARGS option.
+type ServerArgser interface {
+	// The arguments for an SMETHOD ARGS option, or nil.
+	ServerArgs() Args
+}
+
+// Run transports under the version 1 managed-proxy protocol of pt-spec, acting
+// as either a client or a server according to the environment set by tor.
+// ServeTransports does the work that is otherwise done in a transport's main
+// function: calling ClientSetup or ServerSetup, opening a listener for each
+// method that tor requested and that is among transports, emitting CMETHOD or
+// SMETHOD lines (or their error counterparts) as appropriate, accepting
+// connections and relaying them through the transport, and shutting down on
+// signals with HandleShutdownSignals. Factories that implement Reloader are
+// reloaded on SIGHUP or a call to Reload.
+//
+//	func main() {
+//		err := pt.ServeTransports([]pt.Transport{foo.Transport{}})
+//		if err != nil {
+//			os.Exit(1)
+//		}
+//	}
+//
+// Returns an error if setup fails; otherwise, returns when shutdown is
+// complete.
+func ServeTransports(transports []Transport) error {
+	var err error
+	if getenv("TOR_PT_CLIENT_TRANSPORTS") != "" {
+		err = serveClientTransports(DefaultShutdownManager, transports)
+	} else {
+		err = serveServerTransports(DefaultShutdownManager, transports)
+	}
+	if err != nil {
+		return err
+	}
+	HandleReloadSignals(DefaultShutdownManager)
+	HandleDiagnosticsSignals(DefaultShutdownManager)
+	HandleShutdownSignals(DefaultShutdownManager, 0)
+	return nil
+}
+
+// Return the value of TOR_PT_STATE_LOCATION, creating the directory if it
+// doesn't exist. Returns "" if the variable is unset or the directory cannot be
+// created.
+func stateDirIfAny() string {
+	if getenv("TOR_PT_STATE_LOCATION") == "" {
+		return ""
+	}
+	dir, err := MakeStateDir()
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+func findTransport(transports []Transport, methodName string) Transport {
+	for _, t := range transports {
+		if t.Name() == methodName {
+			return t
+		}
+	}
+	return nil
+}
+
+// Do client setup and open a SOCKS listener for each requested method in
+// transports; the listeners and their connections are tracked by m. Returns
+// once CMETHODS DONE has been emitted, or if setup fails.
+func serveClientTransports(m *ShutdownManager, transports []Transport) error {
+	info, err := ClientSetup(nil)
+	if err != nil {
+		return err
+	}
+	config := &ClientConfig{
+		StateDir: stateDirIfAny(),
+		ProxyURL: info.ProxyURL,
+	}
+
+	// Create all the factories first, because any PROXY line must come
+	// before the CMETHOD lines.
+	factories := make(map[string]Dialer)
+	factoryErrors := make(map[string]error)
+	for _, methodName := range info.MethodNames {
+		t := findTransport(transports, methodName)
+		if t == nil {
+			continue
+		}
+		f, err := t.ClientFactory(config)
+		if err != nil {
+			factoryErrors[methodName] = err
+			continue
+		}
+		clientSchema, _ := optionSchemas(t)
+		factories[methodName] = schemaDialer(clientSchema, f)
+		if r, ok := f.(Reloader); ok {
+			onReloadUntil(m, r.Reload)
+		}
+	}
+	if info.ProxyURL != nil {
+		if len(factories) == 0 {
+			return ProxyError(fmt.Sprintf("proxy %s is not supported", info.ProxyURL))
+		}
+		ProxyDone()
+	}
+
+	openClientListeners(m, info.MethodNames, factories, factoryErrors)
+	return nil
+}
+
+// Do server setup and open a listener for each requested method in
+// transports; the listeners and their connections are tracked by m. Returns
+// once SMETHODS DONE has been emitted, or if setup fails.
+func serveServerTransports(m *ShutdownManager, transports []Transport) error {
+	info, err := ServerSetup(nil)
+	if err != nil {
+		return err
+	}
+	startORPoolFor(m, &info)
+	startORHealthCheckFor(m, &info)
+	stateDir := stateDirIfAny()
+
+	for _, bindaddr := range info.Bindaddrs {
+		t := findTransport(transports, bindaddr.MethodName)
+		if t == nil {
+			SmethodErrorReason(bindaddr.MethodName, ReasonUnknownMethod, "no such method")
+			continue
+		}
+		options := bindaddr.Options
+		_, serverSchema := optionSchemas(t)
+		if serverSchema != nil {
+			options, err = serverSchema.Apply(options)
+			if err != nil {
+				SmethodError(bindaddr.MethodName, err.Error())
+				continue
+			}
+			bindaddr.Options = options
+		}
+		f, err := t.ServerFactory(&ServerConfig{
+			StateDir: stateDir,
+			Options:  options,
+			Bindaddr: bindaddr,
+		})
+		if err != nil {
+			SmethodError(bindaddr.MethodName, err.Error())
+			continue
+		}
+		if r, ok := f.(Reloader); ok {
+			onReloadUntil(m, r.Reload)
+		}
+		var ln net.Listener
+		err = listenAutoPort("tcp", bindaddr.MethodName, bindaddr.Addr, func(addr *net.TCPAddr) (net.Addr, error) {
+			var err error
+			ln, err = f.Listen("tcp", addr.String())
+			if err != nil {
+				return nil, err
+			}
+			return ln.Addr(), nil
+		})
+		if err != nil {
+			SmethodErrorReason(bindaddr.MethodName, ReasonBindFailed, err.Error())
+			continue
+		}
+		tln, err := m.TrackListener(DefaultAdmissionControl.Wrap(ln))
+		if err != nil {
+			ln.Close()
+			SmethodError(bindaddr.MethodName, err.Error())
+			continue
+		}
+		go serverAcceptLoop(tln, &info, bindaddr.MethodName, nil)
+		var args Args
+		if a, ok := f.(ServerArgser); ok {
+			args = a.ServerArgs()
+		} else if serverSchema != nil {
+			args = serverSchema.ServerArgs(options)
+		}
+		if args != nil {
+			SmethodArgs(bindaddr.MethodName, ln.Addr(), args)
+		} else {
+			Smethod(bindaddr.MethodName, ln.Addr())
+		}
+	}
+	SmethodsDone()
+	return nil
+}
@@ -0,0 +1,203 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// identityTransport is a Transport that does nothing to the traffic.
+type identityTransport struct {
+	name string
+}
+
+func (t identityTransport) Name() string {
+	return t.name
+}
+
+func (t identityTransport) ClientFactory(config *ClientConfig) (ClientFactory, error) {
+	if config.ProxyURL != nil {
+		return nil, fmt.Errorf("proxy not supported")
+	}
+	return t, nil
+}
+
+func (t identityTransport) ServerFactory(config *ServerConfig) (ServerFactory, error) {
+	return t, nil
+}
+
+func (t identityTransport) Dial(network, address string, args Args) (net.Conn, error) {
+	return net.Dial(network, address)
+}
+
+func (t identityTransport) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+// Start a TCP echo server on a loopback port and return its listener.
+func startEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln
+}
+
+// Do a SOCKS5 CONNECT handshake to the IPv4 address target on conn.
+func socks5Connect(conn net.Conn, target *net.TCPAddr) error {
+	_, err := conn.Write([]byte{0x05, 0x01, 0x00})
+	if err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(resp, []byte{0x05, 0x00}) {
+		return fmt.Errorf("bad method selection %x", resp)
+	}
+	req := []byte{0x05, 0x01, 0x00, 0x01}
+	req = append(req, target.IP.To4()...)
+	req = append(req, byte(target.Port>>8), byte(target.Port))
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+	resp = make([]byte, 10)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+	if resp[1] != 0x00 {
+		return fmt.Errorf("SOCKS reply code 0x%02x", resp[1])
+	}
+	return nil
+}
+
+// Write a message to conn and check that it is echoed back.
+func checkEcho(t *testing.T, conn net.Conn) {
+	msg := []byte("hello world")
+	_, err := conn.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("got %q (expected %q)", buf, msg)
+	}
+}
+
+// Return the address in the first line of output that begins with prefix.
+func findMethodAddr(t *testing.T, output, prefix string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			fields := strings.Fields(line[len(prefix):])
+			return fields[0]
+		}
+	}
+	t.Fatalf("no %q line in %q", prefix, output)
+	return ""
+}
+
+func TestServeClientTransports(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "identity,bogus")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := serveClientTransports(m, []Transport{identityTransport{"identity"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "CMETHOD-ERROR bogus unknown-method: no such method\n") ||
+		!strings.HasSuffix(buf.String(), "CMETHODS DONE\n") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "CMETHOD identity socks5 "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = socks5Connect(conn, echo.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+}
+
+func TestServeClientTransportsProxy(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_CLIENT_TRANSPORTS", "identity")
+	os.Setenv("TOR_PT_PROXY", "socks5://127.0.0.1:1080")
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := serveClientTransports(m, []Transport{identityTransport{"identity"}})
+	if err == nil {
+		t.Fatalf("unsupported proxy unexpectedly succeeded")
+	}
+	if !strings.HasSuffix(buf.String(), "PROXY-ERROR proxy socks5://127.0.0.1:1080 is not supported\n") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestServeServerTransports(t *testing.T) {
+	var buf bytes.Buffer
+	Stdout = &buf
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	os.Clearenv()
+	os.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1")
+	os.Setenv("TOR_PT_SERVER_TRANSPORTS", "identity,bogus")
+	os.Setenv("TOR_PT_SERVER_BINDADDR", "identity-127.0.0.1:0,bogus-127.0.0.1:0")
+	os.Setenv("TOR_PT_ORPORT", echo.Addr().String())
+	m := new(ShutdownManager)
+	defer m.Shutdown(context.Background())
+	err := serveServerTransports(m, []Transport{identityTransport{"identity"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "SMETHOD-ERROR bogus unknown-method: no such method\n") ||
+		!strings.HasSuffix(buf.String(), "SMETHODS DONE\n") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+
+	conn, err := net.Dial("tcp", findMethodAddr(t, buf.String(), "SMETHOD identity "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	checkEcho(t, conn)
+}
@@ -0,0 +1,59 @@
+package pt
+
+import (
+	"net"
+)
+
+// Support for datagram-based transports. tor does not distinguish between
+// stream and datagram listeners in TOR_PT_SERVER_BINDADDR; a transport that
+// runs over UDP interprets the same address and port as a UDP address.
+
+// Return the bind address as a *net.UDPAddr, for transports whose
+// listeners are datagram-based.
+func (bindaddr Bindaddr) UDPAddr() *net.UDPAddr {
+	if bindaddr.Addr == nil {
+		return nil
+	}
+	return &net.UDPAddr{
+		IP:   bindaddr.Addr.IP,
+		Port: bindaddr.Addr.Port,
+		Zone: bindaddr.Addr.Zone,
+	}
+}
+
+// Open a UDP socket bound to the bind address. Pass the socket's LocalAddr to
+// Smethod:
+//
+//	pconn, err := bindaddr.ListenPacket()
+//	if err != nil {
+//		pt.SmethodError(bindaddr.MethodName, err.Error())
+//		break
+//	}
+//	go serve(pconn)
+//	pt.Smethod(bindaddr.MethodName, pconn.LocalAddr())
+func (bindaddr Bindaddr) ListenPacket() (net.PacketConn, error) {
+	var pconn *net.UDPConn
+	err := listenAutoPort("udp", bindaddr.MethodName, bindaddr.Addr, func(addr *net.TCPAddr) (net.Addr, error) {
+		var err error
+		pconn, err = net.ListenUDP("udp", Bindaddr{Addr: addr}.UDPAddr())
+		if err != nil {
+			return nil, err
+		}
+		return pconn.LocalAddr(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pconn, nil
+}
+
+// Open a UDP socket bound to laddr, which must be a literal IP address and
+// port as in TOR_PT_SERVER_BINDADDR, or a host name and port if Resolver is set
+// (see ParseBindaddrs).
+func ListenPacket(laddr string) (net.PacketConn, error) {
+	addr, err := resolveAddr(laddr)
+	if err != nil {
+		return nil, err
+	}
+	return Bindaddr{Addr: addr}.ListenPacket()
+}
@@ -0,0 +1,59 @@
+package pt
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBindaddrUDPAddr(t *testing.T) {
+	if (Bindaddr{}).UDPAddr() != nil {
+		t.Errorf("Bindaddr with nil Addr returned non-nil UDPAddr")
+	}
+	bindaddrs, err := ParseBindaddrs("alpha-1.2.3.4:1111,beta-[1:2::3:4]:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bindaddr := range bindaddrs {
+		addr := bindaddr.UDPAddr()
+		if !addr.IP.Equal(bindaddr.Addr.IP) || addr.Port != bindaddr.Addr.Port || addr.Zone != bindaddr.Addr.Zone {
+			t.Errorf("%+v → %+v", bindaddr.Addr, addr)
+		}
+	}
+}
+
+func TestListenPacket(t *testing.T) {
+	for _, input := range []string{"", "127.0.0.1", "localhost:0"} {
+		pconn, err := ListenPacket(input)
+		if err == nil {
+			pconn.Close()
+			t.Errorf("%q unexpectedly succeeded", input)
+		}
+	}
+
+	pconn, err := ListenPacket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pconn.Close()
+	if _, ok := pconn.LocalAddr().(*net.UDPAddr); !ok {
+		t.Fatalf("LocalAddr is %T, not *net.UDPAddr", pconn.LocalAddr())
+	}
+
+	c, err := net.DialUDP("udp", nil, pconn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = c.Write([]byte("datagram"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 100)
+	n, _, err := pconn.ReadFrom(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p[:n]) != "datagram" {
+		t.Errorf("got %q", p[:n])
+	}
+}
@@ -0,0 +1,124 @@
+package pt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The managed transport protocol versions understood by the application, and
+// the handler to call when each is negotiated. Version "1" is built in.
+var versionHandlers = map[string]func(version string) error{
+	"1": nil,
+}
+
+// Declare that the application understands version of the managed transport
+// protocol, in addition to version "1". ClientSetup and ServerSetup negotiate
+// the highest version that is both offered by tor in
+// TOR_PT_MANAGED_TRANSPORT_VER and registered, and emit it in the VERSION line.
+// If handler is not nil, it is called with the version, after the VERSION line
+// is emitted, when that version is negotiated; if it returns an error, setup
+// fails with that error. Registering a version that is already registered
+// replaces its handler.
+//
+// RegisterVersion is meant to be called before ClientSetup or ServerSetup,
+// usually from an init function. It is not safe to call concurrently with
+// them. Versions are compared as integers, or as strings if either is not an
+// integer.
+func RegisterVersion(version string, handler func(version string) error) error {
+	if version == "" || !argIsSafe(version) {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	versionHandlers[version] = handler
+	return nil
+}
+
+// The optional features announced by the application, and the minimum managed
+// transport protocol version with which each is announced.
+var featureVersions = map[string]string{}
+
+// Announce that the application supports the optional pluggable transports
+// feature name, in a FEATURES line after the VERSION line, when the negotiated
+// managed transport protocol version is version or greater. Version "1" does
+// not define FEATURES, so version must be greater than "1", and the feature is
+// never announced unless a version with which it is announced has been
+// registered with RegisterVersion and is offered by tor. Registering a feature
+// that is already registered replaces its version.
+//
+// Like RegisterVersion, RegisterFeature is meant to be called before
+// ClientSetup or ServerSetup, and is not safe to call concurrently with them.
+func RegisterFeature(name, version string) error {
+	if name == "" || !argIsSafe(name) {
+		return fmt.Errorf("invalid feature name %q", name)
+	}
+	if version == "" || !argIsSafe(version) || !versionGreater(version, "1") {
+		return fmt.Errorf("invalid version %q for feature %q", version, name)
+	}
+	featureVersions[name] = version
+	return nil
+}
+
+// Return the registered features that are announced with version, in sorted
+// order.
+func featuresFor(version string) []string {
+	var features []string
+	for name, minVersion := range featureVersions {
+		if version == minVersion || versionGreater(version, minVersion) {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// Return true iff version a is greater than version b.
+func versionGreater(a, b string) bool {
+	ai, aErr := strconv.Atoi(a)
+	bi, bErr := strconv.Atoi(b)
+	if aErr == nil && bErr == nil {
+		return ai > bi
+	}
+	return a > b
+}
+
+// Return the highest of the offered versions that has a handler, registered or
+// given in o, or "" if there is none.
+func chooseVersion(o *setupOptions, offered []string) string {
+	var best string
+	for _, v := range offered {
+		if _, ok := o.versionHandler(v); !ok {
+			continue
+		}
+		if best == "" || versionGreater(v, best) {
+			best = v
+		}
+	}
+	return best
+}
+
+// Negotiate a version with tor, emit the VERSION line and the FEATURES line, if
+// any, and call the version's handler, if any. Returns the negotiated version
+// and the full list of versions offered by tor.
+func negotiateVersion(o *setupOptions) (string, []string, error) {
+	managedTransportVer, err := o.getenvRequired("TOR_PT_MANAGED_TRANSPORT_VER")
+	if err != nil {
+		return "", nil, err
+	}
+	offered := ParseManagedTransportVersions(managedTransportVer)
+	ver := chooseVersion(o, offered)
+	if ver == "" {
+		return "", offered, o.versionError("no-version")
+	}
+	o.emit(Event{Keyword: "VERSION", Message: ver, LineArgs: []string{ver}})
+	if features := featuresFor(ver); len(features) > 0 {
+		o.emit(Event{Keyword: "FEATURES", Message: strings.Join(features, " "), LineArgs: features})
+	}
+	if handler, _ := o.versionHandler(ver); handler != nil {
+		err = handler(ver)
+		if err != nil {
+			return "", offered, err
+		}
+	}
+	return ver, offered, nil
+}
@@ -0,0 +1,146 @@
+package pt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChooseVersion(t *testing.T) {
+	saved := versionHandlers
+	defer func() { versionHandlers = saved }()
+	versionHandlers = map[string]func(string) error{"1": nil}
+
+	if v := chooseVersion(nil, []string{"2", "1"}); v != "1" {
+		t.Errorf("got %q, expected %q", v, "1")
+	}
+	if v := chooseVersion(nil, []string{"2", "3"}); v != "" {
+		t.Errorf("got %q, expected no version", v)
+	}
+
+	err := RegisterVersion("2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterVersion("10", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := [...]struct {
+		offered  []string
+		expected string
+	}{
+		{[]string{"1"}, "1"},
+		{[]string{"1", "2"}, "2"},
+		{[]string{"2", "1"}, "2"},
+		{[]string{"1", "10", "2"}, "10"},
+		{[]string{"3", "9"}, ""},
+	}
+	for _, test := range tests {
+		if v := chooseVersion(nil, test.offered); v != test.expected {
+			t.Errorf("%q → %q (expected %q)", test.offered, v, test.expected)
+		}
+	}
+
+	for _, bad := range []string{"", "a\nb"} {
+		if RegisterVersion(bad, nil) == nil {
+			t.Errorf("RegisterVersion(%q) unexpectedly succeeded", bad)
+		}
+	}
+}
+
+func TestNegotiateVersionHandler(t *testing.T) {
+	saved := versionHandlers
+	defer func() { versionHandlers = saved }()
+	versionHandlers = map[string]func(string) error{"1": nil}
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	var called string
+	RegisterVersion("2", func(version string) error {
+		called = version
+		return nil
+	})
+	t.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", "1,2,3")
+	t.Setenv("TOR_PT_CLIENT_TRANSPORTS", "alpha")
+	t.Setenv("TOR_PT_PROXY", "")
+	info, err := ClientSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "2" || called != "2" {
+		t.Errorf("negotiated %q, handler called with %q", info.Version, called)
+	}
+	if !stringSlicesEqual(info.OfferedVersions, []string{"1", "2", "3"}) {
+		t.Errorf("OfferedVersions is %q", info.OfferedVersions)
+	}
+	if info.OfferedTransports != "alpha" || info.ProxyURL != nil {
+		t.Errorf("OfferedTransports is %q, ProxyURL is %v", info.OfferedTransports, info.ProxyURL)
+	}
+	if !strings.HasPrefix(buf.String(), "VERSION 2\n") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	// A handler error stops setup.
+	handlerErr := errors.New("version 2 not ready")
+	RegisterVersion("2", func(version string) error {
+		return handlerErr
+	})
+	_, err = ClientSetup(nil)
+	if err != handlerErr {
+		t.Errorf("got error %v, expected %v", err, handlerErr)
+	}
+}
+
+func TestRegisterFeature(t *testing.T) {
+	savedVersions := versionHandlers
+	savedFeatures := featureVersions
+	defer func() {
+		versionHandlers = savedVersions
+		featureVersions = savedFeatures
+	}()
+	versionHandlers = map[string]func(string) error{"1": nil, "2": nil, "3": nil}
+	featureVersions = map[string]string{}
+	var buf bytes.Buffer
+	savedStdout := Stdout
+	Stdout = &buf
+	defer func() { Stdout = savedStdout }()
+
+	for _, bad := range [][2]string{{"", "2"}, {"a\nb", "2"}, {"x", ""}, {"x", "1"}} {
+		if RegisterFeature(bad[0], bad[1]) == nil {
+			t.Errorf("RegisterFeature(%q, %q) unexpectedly succeeded", bad[0], bad[1])
+		}
+	}
+	RegisterFeature("zeta", "2")
+	RegisterFeature("alpha", "2")
+	RegisterFeature("future", "3")
+
+	t.Setenv("TOR_PT_CLIENT_TRANSPORTS", "alpha")
+	t.Setenv("TOR_PT_PROXY", "")
+	tests := []struct {
+		offered  string
+		features []string
+		output   string
+	}{
+		{"1", nil, "VERSION 1\n"},
+		{"1,2", []string{"alpha", "zeta"}, "VERSION 2\nFEATURES alpha zeta\n"},
+		{"3", []string{"alpha", "future", "zeta"}, "VERSION 3\nFEATURES alpha future zeta\n"},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		t.Setenv("TOR_PT_MANAGED_TRANSPORT_VER", test.offered)
+		info, err := ClientSetup(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stringSlicesEqual(info.Features, test.features) {
+			t.Errorf("%q: Features is %q, expected %q", test.offered, info.Features, test.features)
+		}
+		if !strings.HasPrefix(buf.String(), test.output) {
+			t.Errorf("%q: unexpected output %q", test.offered, buf.String())
+		}
+	}
+}
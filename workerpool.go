@@ -0,0 +1,135 @@
+package pt
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// What a WorkerPool does with a connection when all its workers are busy and
+// its queue is full.
+type OverloadPolicy int
+
+const (
+	// Close the new connection.
+	OverloadReject OverloadPolicy = iota
+	// Close the connection that has waited longest in the queue, and queue
+	// the new one in its place.
+	OverloadDropOldest
+	// Stop accepting connections until there is room in the queue, leaving
+	// new connections in the kernel's listen backlog.
+	OverloadBlock
+)
+
+// WorkerPool handles accepted connections with a fixed number of goroutines,
+// rather than a goroutine for each connection, so that a flood of connections
+// to a bridge makes a queue of bounded length rather than an unbounded number
+// of goroutines. Connections wait in the queue for a free worker; when the
+// queue is full, Policy decides what happens.
+//
+// Server listeners opened by RunServer, RunServerStandalone, and
+// ServeTransports are served by DefaultWorkerPool, if it is not nil:
+//
+//	pt.DefaultWorkerPool = &pt.WorkerPool{
+//		Workers:     200,
+//		QueueLength: 1000,
+//		Policy:      pt.OverloadDropOldest,
+//	}
+//
+// The workers and queue are shared by all listeners served by the same
+// WorkerPool, and are started by the first call to AcceptLoop. The fields must
+// not be changed after that.
+type WorkerPool struct {
+	// Used atomically; first so that it is 64-bit aligned on 32-bit
+	// platforms.
+	rejected uint64
+
+	// The number of connections handled at once. If not positive, 1.
+	Workers int
+	// The number of accepted connections that may wait for a worker.
+	QueueLength int
+	Policy      OverloadPolicy
+
+	once  sync.Once
+	queue chan workerJob
+}
+
+type workerJob struct {
+	conn    net.Conn
+	handler func(net.Conn)
+}
+
+// If DefaultWorkerPool is not nil, connections accepted by the server listeners
+// of RunServer, RunServerStandalone, and ServeTransports are handled by it.
+var DefaultWorkerPool *WorkerPool
+
+// Start the workers, if they have not been started.
+func (p *WorkerPool) start() {
+	p.once.Do(func() {
+		p.queue = make(chan workerJob, p.QueueLength)
+		workers := p.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			go func() {
+				for job := range p.queue {
+					runHandler(job.conn, job.handler)
+				}
+			}()
+		}
+	})
+}
+
+// Like the AcceptLoop function, but handle connections with the workers of p
+// rather than a goroutine each. Returns nil when ln is closed, or the first
+// non-temporary error from Accept. ln is closed in either case; connections
+// still in the queue are handled.
+func (p *WorkerPool) AcceptLoop(ln net.Listener, handler func(net.Conn)) error {
+	defer ln.Close()
+	startReserveFD()
+	p.start()
+	for {
+		conn, err := acceptRetry(ln)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		p.dispatch(workerJob{conn: conn, handler: handler})
+	}
+}
+
+// Queue job, applying p.Policy if the queue is full.
+func (p *WorkerPool) dispatch(job workerJob) {
+	if p.Policy == OverloadBlock {
+		p.queue <- job
+		return
+	}
+	for {
+		select {
+		case p.queue <- job:
+			return
+		default:
+		}
+		if p.Policy != OverloadDropOldest {
+			job.conn.Close()
+			atomic.AddUint64(&p.rejected, 1)
+			return
+		}
+		select {
+		case old := <-p.queue:
+			old.conn.Close()
+			atomic.AddUint64(&p.rejected, 1)
+		default:
+		}
+	}
+}
+
+// Return the number of connections that have been closed without being
+// handled, because the queue was full.
+func (p *WorkerPool) Rejected() uint64 {
+	return atomic.LoadUint64(&p.rejected)
+}
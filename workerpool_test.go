@@ -0,0 +1,99 @@
+package pt
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testWorkerPoolOverload(policy OverloadPolicy) (conns [3]*closeRecorderConn, handled []net.Conn, rejected uint64) {
+	p := &WorkerPool{Workers: 1, QueueLength: 1, Policy: policy}
+	p.start()
+	started := make(chan net.Conn, 3)
+	unblock := make(chan struct{})
+	handler := func(conn net.Conn) {
+		started <- conn
+		<-unblock
+	}
+	for i := range conns {
+		conns[i] = &closeRecorderConn{}
+	}
+
+	// The first connection occupies the only worker.
+	p.dispatch(workerJob{conns[0], handler})
+	handled = append(handled, <-started)
+	// The second fills the queue, and the third overflows it.
+	p.dispatch(workerJob{conns[1], handler})
+	p.dispatch(workerJob{conns[2], handler})
+	close(unblock)
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case conn := <-started:
+			handled = append(handled, conn)
+			continue
+		case <-timeout:
+		}
+		break
+	}
+	close(p.queue)
+	return conns, handled, p.Rejected()
+}
+
+func TestWorkerPoolOverload(t *testing.T) {
+	conns, handled, rejected := testWorkerPoolOverload(OverloadReject)
+	if len(handled) != 2 || handled[1] != conns[1] || rejected != 1 || atomic.LoadInt32(&conns[2].closed) == 0 {
+		t.Errorf("OverloadReject: handled %v, rejected %d", handled, rejected)
+	}
+
+	conns, handled, rejected = testWorkerPoolOverload(OverloadDropOldest)
+	if len(handled) != 2 || handled[1] != conns[2] || rejected != 1 || atomic.LoadInt32(&conns[1].closed) == 0 {
+		t.Errorf("OverloadDropOldest: handled %v, rejected %d", handled, rejected)
+	}
+}
+
+func TestWorkerPoolAcceptLoop(t *testing.T) {
+	var conns []acceptResult
+	for i := 0; i < 10; i++ {
+		conns = append(conns, acceptResult{&closeRecorderConn{}, nil})
+	}
+	ln := newScriptListener(conns...)
+	p := &WorkerPool{Workers: 3, QueueLength: 10, Policy: OverloadBlock}
+	var count, running, maxRunning int32
+	done := make(chan struct{}, len(conns))
+	go p.AcceptLoop(ln, func(conn net.Conn) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&count, 1)
+		done <- struct{}{}
+	})
+	for range conns {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d connections handled", atomic.LoadInt32(&count))
+		}
+	}
+	ln.Close()
+	if m := atomic.LoadInt32(&maxRunning); m > 3 {
+		t.Errorf("%d handlers ran at once with 3 workers", m)
+	}
+	// Connections are closed just after their handlers return.
+	deadline := time.Now().Add(5 * time.Second)
+	for _, r := range conns {
+		for atomic.LoadInt32(&r.c.(*closeRecorderConn).closed) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("connection not closed after handling")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}